@@ -2,12 +2,15 @@ module github.com/AashishUpadhyay/goatdb
 
 go 1.20
 
-require github.com/gorilla/mux v1.8.1
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/gorilla/mux v1.8.1
+	github.com/stretchr/testify v1.9.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
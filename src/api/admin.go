@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AashishUpadhyay/goatdb/src/db"
+	"github.com/gorilla/mux"
+)
+
+// AdminController serves operations on the database as a whole. Its routes
+// are only registered with -enable-admin.
+type AdminController struct {
+	Logger db.Logger
+	Db     db.DB
+	// MaxBodyBytes is the largest body accepted. Zero means
+	// DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+}
+
+// BackupRequest names the directory, on the server, a backup is written to.
+// It must not exist or be empty.
+type BackupRequest struct {
+	TargetDir string `json:"target_dir"`
+}
+
+func (ac AdminController) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/v1/admin/backup", ac.Backup).Methods(http.MethodPost)
+	r.HandleFunc("/v1/admin/export", ac.Export).Methods(http.MethodGet)
+	r.HandleFunc("/v1/admin/import", ac.Import).Methods(http.MethodPost)
+}
+
+// Backup writes a consistent copy of the database to the target directory and
+// responds with the request once it is complete.
+func (ac AdminController) Backup(w http.ResponseWriter, r *http.Request) {
+	maxBodyBytes := ac.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+	request := BackupRequest{}
+	if status, err := decodeJSON(w, r, maxBodyBytes, &request); err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	if request.TargetDir == "" {
+		writeError(w, http.StatusBadRequest, "target_dir must not be empty")
+		return
+	}
+
+	if err := ac.Db.Backup(request.TargetDir); err != nil {
+		ac.Logger.Errorf("Failed to back up to %s. error : %v", request.TargetDir, err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	responseJson, err := json.Marshal(request)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	ac.Logger.Infof("Backed up to %s", request.TargetDir)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJson)
+}
+
+// Export streams every live key as newline-delimited JSON, see db.Export. The
+// status cannot change once the first line is sent, so a later failure cuts
+// the response short and is only logged.
+func (ac AdminController) Export(w http.ResponseWriter, r *http.Request) {
+	clearDeadlines(w)
+	tracked := &trackingWriter{ResponseWriter: w}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := ac.Db.Export(tracked); err != nil {
+		ac.Logger.Errorf("Failed to export. error : %v", err)
+		if !tracked.written {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	ac.Logger.Infof("Exported the database")
+}
+
+// Import loads newline-delimited JSON written by Export, see db.Import. The
+// records are written as SSTables unless ?use_puts=true asks for normal
+// writes. It responds 204 once every record is stored and 400 for a body that
+// is not valid, in which case the records before the bad line are stored.
+func (ac AdminController) Import(w http.ResponseWriter, r *http.Request) {
+	clearDeadlines(w)
+	opts := db.ImportOptions{}
+	if usePuts := r.URL.Query().Get("use_puts"); usePuts != "" {
+		value, err := strconv.ParseBool(usePuts)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "use_puts must be true or false")
+			return
+		}
+		opts.UsePuts = value
+	}
+
+	if err := ac.Db.Import(r.Body, opts); err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidImport):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, db.ErrReadOnly):
+			writeError(w, http.StatusForbidden, err.Error())
+		default:
+			ac.Logger.Errorf("Failed to import. error : %v", err)
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	ac.Logger.Infof("Imported into the database")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearDeadlines lifts the server's ReadTimeout and WriteTimeout for a request
+// that streams the whole database, which may take longer. Writers that do not
+// support deadlines, such as httptest.ResponseRecorder, are left as they are.
+func clearDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
+
+// trackingWriter records whether anything was written to the response.
+type trackingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (tw *trackingWriter) Write(p []byte) (int, error) {
+	tw.written = true
+	return tw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AashishUpadhyay/goatdb/src/db"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAdminControllerBackup(t *testing.T) {
+	t.Run("test_backup_writes_a_copy", func(t *testing.T) {
+		currentTestDir, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("error getting current test directory: %s", err)
+		}
+		dataDir := filepath.Join(currentTestDir, ".testAdminBackup")
+		backupDir := filepath.Join(currentTestDir, ".testAdminBackupTarget")
+		defer os.RemoveAll(dataDir)
+		defer os.RemoveAll(backupDir)
+
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		sstableMgr, err := db.NewFileManager(dataDir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		database, err := db.NewDb(db.Options{
+			MemtableThreshold: 100,
+			SstableMgr:        sstableMgr,
+			Logger:            logger,
+		})
+		if err != nil {
+			t.Fatalf("error creating db: %v", err)
+		}
+		defer database.Close()
+		if err := database.Put(db.Entry{Key: "a", Value: []byte("1")}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		router := mux.NewRouter()
+		AdminController{Logger: db.NewLogger(logger, db.LevelInfo), Db: database}.RegisterRoutes(router)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodPost, "/v1/admin/backup", strings.NewReader(`{"target_dir":"`+backupDir+`"}`))
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		backupMgr, err := db.NewFileManager(backupDir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		backup, err := db.NewDb(db.Options{SstableMgr: backupMgr, Logger: logger})
+		if err != nil {
+			t.Fatalf("error opening backup: %v", err)
+		}
+		defer backup.Close()
+		if entry, err := backup.Get("a"); err != nil || string(entry.Value) != "1" {
+			t.Errorf("expected the backup to hold a, got %q, %v", entry.Value, err)
+		}
+	})
+
+	t.Run("test_backup_rejects_invalid_requests", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		ac := AdminController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		for _, body := range []string{`{}`, `{"target":"/tmp/backup"}`, `not json`} {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "/v1/admin/backup", strings.NewReader(body))
+			ac.Backup(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+			}
+		}
+		mockDb.AssertNotCalled(t, "Backup", mock.Anything)
+	})
+
+	t.Run("test_backup_reports_errors", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Backup", "/tmp/backup").Return(errors.New("directory /tmp/backup is not empty"))
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		ac := AdminController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodPost, "/v1/admin/backup", strings.NewReader(`{"target_dir":"/tmp/backup"}`))
+		ac.Backup(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+		expectErrorBody(t, w, "directory /tmp/backup is not empty")
+	})
+}
+
+func TestAdminControllerExportImport(t *testing.T) {
+	t.Run("test_export_import_round_trip", func(t *testing.T) {
+		currentTestDir, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("error getting current test directory: %s", err)
+		}
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		open := func(dataDir string) *db.LSM {
+			sstableMgr, err := db.NewFileManager(dataDir, logger)
+			if err != nil {
+				t.Fatalf("error creating file manager: %s", err)
+			}
+			database, err := db.NewDb(db.Options{MemtableThreshold: 10, SstableMgr: sstableMgr, Logger: logger})
+			if err != nil {
+				t.Fatalf("error creating db: %v", err)
+			}
+			return database
+		}
+		sourceDir := filepath.Join(currentTestDir, ".testAdminExport")
+		targetDir := filepath.Join(currentTestDir, ".testAdminImport")
+		defer os.RemoveAll(sourceDir)
+		defer os.RemoveAll(targetDir)
+		source := open(sourceDir)
+		defer source.Close()
+		target := open(targetDir)
+		defer target.Close()
+		for i := 0; i < 25; i++ {
+			if err := source.Put(db.Entry{Key: fmt.Sprintf("key%02d", i), Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+		}
+
+		sourceRouter := mux.NewRouter()
+		AdminController{Logger: db.NewLogger(logger, db.LevelInfo), Db: source}.RegisterRoutes(sourceRouter)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/admin/export", nil)
+		sourceRouter.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+			t.Errorf("expected content type application/x-ndjson, got %s", contentType)
+		}
+
+		targetRouter := mux.NewRouter()
+		AdminController{Logger: db.NewLogger(logger, db.LevelInfo), Db: target}.RegisterRoutes(targetRouter)
+		exported := w.Body.String()
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest(http.MethodPost, "/v1/admin/import", strings.NewReader(exported))
+		targetRouter.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("expected status code %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+		}
+		entries, err := target.Scan("", "", 0)
+		if err != nil || len(entries) != 25 {
+			t.Fatalf("expected 25 imported entries, got %d, %v", len(entries), err)
+		}
+		for i, entry := range entries {
+			if entry.Key != fmt.Sprintf("key%02d", i) || string(entry.Value) != fmt.Sprint(i) {
+				t.Errorf("expected key%02d=%d, got %s=%s", i, i, entry.Key, entry.Value)
+			}
+		}
+
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest(http.MethodPost, "/v1/admin/import", strings.NewReader(`{"key":"a","value":"1"}`))
+		targetRouter.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("test_import_options", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Import", mock.Anything, db.ImportOptions{UsePuts: true}).Return(nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		AdminController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodPost, "/v1/admin/import?use_puts=true", strings.NewReader(""))
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("expected status code %d, got %d", http.StatusNoContent, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest(http.MethodPost, "/v1/admin/import?use_puts=maybe", strings.NewReader(""))
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		expectErrorBody(t, w, "use_puts must be true or false")
+	})
+
+	t.Run("test_export_error", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Export", mock.Anything).Return(db.ErrDBClosed)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		ac := AdminController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/admin/export", nil)
+		ac.Export(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+		expectErrorBody(t, w, db.ErrDBClosed.Error())
+	})
+
+	t.Run("test_export_import_outlast_server_timeouts", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Export", mock.Anything).Run(func(args mock.Arguments) {
+			w := args.Get(0).(io.Writer)
+			fmt.Fprintln(w, "first")
+			time.Sleep(300 * time.Millisecond)
+			fmt.Fprintln(w, "last")
+		}).Return(nil)
+		imported := make(chan string, 1)
+		mockDb.On("Import", mock.Anything, db.ImportOptions{}).Run(func(args mock.Arguments) {
+			data, err := io.ReadAll(args.Get(0).(io.Reader))
+			if err != nil {
+				data = append(data, err.Error()...)
+			}
+			imported <- string(data)
+		}).Return(nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		AdminController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+		server := httptest.NewUnstartedServer(router)
+		server.Config.ReadTimeout = 100 * time.Millisecond
+		server.Config.WriteTimeout = 100 * time.Millisecond
+		server.Start()
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/v1/admin/export")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != "first\nlast\n" {
+			t.Errorf("expected the whole export, got %q, %v", body, err)
+		}
+
+		// The body is sent in two parts, the second after the read timeout
+		reader, writer := io.Pipe()
+		go func() {
+			fmt.Fprintln(writer, "first")
+			time.Sleep(300 * time.Millisecond)
+			fmt.Fprintln(writer, "last")
+			writer.Close()
+		}()
+		resp, err = http.Post(server.URL+"/v1/admin/import", "application/x-ndjson", reader)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("expected status code %d, got %d", http.StatusNoContent, resp.StatusCode)
+		}
+		if data := <-imported; data != "first\nlast\n" {
+			t.Errorf("expected the whole import to be read, got %q", data)
+		}
+	})
+}
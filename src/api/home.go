@@ -1,26 +1,45 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/AashishUpadhyay/goatdb/src/db"
+	"github.com/AashishUpadhyay/goatdb/src/metrics"
 	"github.com/gorilla/mux"
 )
 
 const version = "1.0.0"
 
+// shutdownTimeout bounds how long in-flight requests may take to finish once a
+// shutdown signal is received.
+const shutdownTimeout = 30 * time.Second
+
 type config struct {
 	port              int
 	env               string
 	memtableThreshold int
+	memtableMaxBytes  int64
+	maxL0Files        int
 	dataDir           string
+	enableMetrics     bool
+	enableAdmin       bool
+	logLevel          string
+	maxKeyBytes       int
+	maxBodyBytes      int64
+	durability        string
+	readOnly          bool
+	level             db.Level
 }
 
 var cfg config
@@ -43,6 +62,36 @@ func Index() {
 		defaultMemtableThreshold = "100"
 	}
 
+	defaultMemtableMaxBytes := os.Getenv("MEMTABLE_MAX_BYTES")
+	if defaultMemtableMaxBytes == "" {
+		defaultMemtableMaxBytes = "0"
+	}
+
+	defaultMaxL0Files := os.Getenv("MAX_L0_FILES")
+	if defaultMaxL0Files == "" {
+		defaultMaxL0Files = "0"
+	}
+
+	defaultLogLevel := os.Getenv("LOG_LEVEL")
+	if defaultLogLevel == "" {
+		defaultLogLevel = "info"
+	}
+
+	defaultMaxKeyBytes := os.Getenv("MAX_KEY_BYTES")
+	if defaultMaxKeyBytes == "" {
+		defaultMaxKeyBytes = strconv.Itoa(DefaultMaxKeyBytes)
+	}
+
+	defaultMaxBodyBytes := os.Getenv("MAX_BODY_BYTES")
+	if defaultMaxBodyBytes == "" {
+		defaultMaxBodyBytes = strconv.Itoa(DefaultMaxBodyBytes)
+	}
+
+	defaultDurability := os.Getenv("DURABILITY")
+	if defaultDurability == "" {
+		defaultDurability = "strict"
+	}
+
 	defaultPort := os.Getenv("PORT")
 	if defaultPort == "" {
 		defaultPort = "9999"
@@ -54,11 +103,39 @@ func Index() {
 	memThreshold, _ := strconv.Atoi(defaultMemtableThreshold)
 	flag.IntVar(&cfg.memtableThreshold, "memtable-threshold", memThreshold, "Memtable threshold")
 
+	memMaxBytes, _ := strconv.ParseInt(defaultMemtableMaxBytes, 10, 64)
+	flag.Int64Var(&cfg.memtableMaxBytes, "memtable-max-bytes", memMaxBytes, "Memtable size in bytes that triggers a flush, 0 for no limit")
+
+	maxL0Files, _ := strconv.Atoi(defaultMaxL0Files)
+	flag.IntVar(&cfg.maxL0Files, "max-l0-files", maxL0Files, "SSTable count above which they are compacted in the background, 0 to only compact on request")
+
+	maxKeyBytes, _ := strconv.Atoi(defaultMaxKeyBytes)
+	flag.IntVar(&cfg.maxKeyBytes, "max-key-bytes", maxKeyBytes, "Longest key accepted in a write")
+
+	maxBodyBytes, _ := strconv.ParseInt(defaultMaxBodyBytes, 10, 64)
+	flag.Int64Var(&cfg.maxBodyBytes, "max-body-bytes", maxBodyBytes, "Largest JSON request body accepted by the KV API")
+
 	portNum, _ := strconv.Atoi(defaultPort)
 	flag.IntVar(&cfg.port, "port", portNum, "API Server Port")
+
+	flag.BoolVar(&cfg.enableMetrics, "enable-metrics", os.Getenv("ENABLE_METRICS") == "true", "Serve latency and compaction metrics on /metrics")
+	flag.BoolVar(&cfg.enableAdmin, "enable-admin", os.Getenv("ENABLE_ADMIN") == "true", "Serve the admin API, such as backups, on /v1/admin")
+	flag.StringVar(&cfg.logLevel, "log-level", defaultLogLevel, "Lowest level logged: debug, info, warn or error")
+	flag.BoolVar(&cfg.readOnly, "read-only", os.Getenv("READ_ONLY") == "true", "Serve reads from an existing data directory without ever writing to it")
+	flag.StringVar(&cfg.durability, "durability", defaultDurability, "Whether SSTables are synced to disk: strict, relaxed, or none for tests and bulk loads")
 	flag.Parse()
 
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	stdLogger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	level, err := db.ParseLevel(cfg.logLevel)
+	if err != nil {
+		stdLogger.Fatal(err)
+	}
+	cfg.level = level
+	durability, err := db.ParseDurability(cfg.durability)
+	if err != nil {
+		stdLogger.Fatal(err)
+	}
+	logger := db.NewLogger(stdLogger, level)
 	addr := fmt.Sprintf(":%d", cfg.port)
 
 	router := mux.NewRouter()
@@ -68,19 +145,69 @@ func Index() {
 	// Add this line to serve static files
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
+	// Left nil without -enable-metrics so nothing is measured
+	var dbMetrics db.Metrics
+	var requestMetrics RequestMetrics
+	if cfg.enableMetrics {
+		m := metrics.NewExpvar()
+		dbMetrics, requestMetrics = m, m
+		router.Handle("/metrics", m.Handler()).Methods(http.MethodGet)
+	}
+
+	sstableMgr, err := db.NewFileManagerWithOptions(db.FileManagerOptions{
+		DataDir:  cfg.dataDir,
+		Logger:   stdLogger,
+		LogLevel: level,
+		Metrics:  dbMetrics,
+		ReadOnly: cfg.readOnly,
+	})
+	if err != nil {
+		stdLogger.Fatal(err)
+	}
+
+	database, err := db.NewDb(db.Options{
+		MemtableThreshold: cfg.memtableThreshold,
+		SstableMgr:        sstableMgr,
+		Logger:            stdLogger,
+		LogLevel:          level,
+		MemtableMaxBytes:  cfg.memtableMaxBytes,
+		MaxL0Files:        cfg.maxL0Files,
+		ReadOnly:          cfg.readOnly,
+		Metrics:           dbMetrics,
+		MergeFunc:         db.AddInt64,
+		Durability:        durability,
+	})
+	if err != nil {
+		stdLogger.Fatal(err)
+	}
+
 	kvc := &KVController{
+		Logger:       logger,
+		Db:           database,
+		Metrics:      requestMetrics,
+		MaxKeyBytes:  cfg.maxKeyBytes,
+		MaxBodyBytes: cfg.maxBodyBytes,
+	}
+
+	kvc.RegisterRoutes(router)
+
+	mc := &MetricsController{
 		Logger: logger,
-		Db: db.NewDb(db.Options{
-			MemtableThreshold: cfg.memtableThreshold,
-			SstableMgr: db.SSTableFileSystemManager{
-				DataDir: cfg.dataDir,
-				Logger:  logger,
-			},
+		Db:     database,
+	}
+
+	mc.RegisterRoutes(router)
+
+	// Admin requests act on the server's file system, so they are opt-in
+	if cfg.enableAdmin {
+		ac := &AdminController{
 			Logger: logger,
-		}),
+			Db:     database,
+		}
+		ac.RegisterRoutes(router)
 	}
 
-	kvc.RegisterRoutes(router)
+	router.HandleFunc("/v1/ready", readiness(database)).Methods(http.MethodGet)
 
 	srv := &http.Server{
 		Addr:         addr,
@@ -90,16 +217,40 @@ func Index() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	logger.Printf("starting %s server on %s", cfg.env, addr)
-	err := srv.ListenAndServe()
+	// On SIGINT or SIGTERM stop accepting requests and let in-flight ones
+	// finish, so the database is only closed once nothing is using it
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+		logger.Infof("shutting down server, received %s", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
+	logger.Infof("starting %s server on %s", cfg.env, addr)
+	err = srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		stdLogger.Fatal(err)
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		logger.Errorf("error shutting down server: %v", err)
+	}
+	err = database.Close()
 	if err != nil {
-		logger.Fatal(err)
+		stdLogger.Fatal(err)
 	}
+	logger.Infof("stopped server")
 }
 
 func healthcheck(w http.ResponseWriter, r *http.Request) {
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-	logger.Printf("healthcheck called!")
+	logger := db.NewLogger(log.New(os.Stdout, "", log.Ldate|log.Ltime), cfg.level)
+	logger.Debugf("healthcheck called!")
 
 	if r.Method != http.MethodGet {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
@@ -121,7 +272,36 @@ func healthcheck(w http.ResponseWriter, r *http.Request) {
 	returnValJson = append(returnValJson, '\n')
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(returnValJson)
-	logger.Printf("request successful!")
+	logger.Debugf("request successful!")
+}
+
+// readiness returns a handler reporting whether database can accept writes.
+// Unlike healthcheck, which only shows that the server is up, it responds 503
+// when the database is closed, its last flush failed or its data directory
+// cannot be written.
+func readiness(database db.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := db.NewLogger(log.New(os.Stdout, "", log.Ldate|log.Ltime), cfg.level)
+
+		status := http.StatusOK
+		returnVal := map[string]string{"status": "ready"}
+		if err := database.Ready(); err != nil {
+			logger.Warnf("database is not ready: %v", err)
+			status = http.StatusServiceUnavailable
+			returnVal = map[string]string{"status": "unavailable", "error": err.Error()}
+		}
+
+		returnValJson, err := json.MarshalIndent(returnVal, "", "\t")
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		returnValJson = append(returnValJson, '\n')
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(returnValJson)
+	}
 }
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
@@ -1,9 +1,15 @@
 package api
 
 import (
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/AashishUpadhyay/goatdb/src/db"
 )
 
 func TestHealthcheck(t *testing.T) {
@@ -21,3 +27,50 @@ func TestHealthcheck(t *testing.T) {
 		t.Errorf("expected body %q, got %q", want, w.Body.String())
 	}
 }
+
+func TestReadiness(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testReadiness")
+	defer os.RemoveAll(dataDir)
+
+	logger := log.New(os.Stdout, "API_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	sstableMgr, err := db.NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := db.NewDb(db.Options{
+		MemtableThreshold: 100,
+		SstableMgr:        sstableMgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %s", err)
+	}
+	defer database.Close()
+
+	ready := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/ready", nil)
+		readiness(database)(w, r)
+		return w
+	}
+	if w := ready(); w.Code != http.StatusOK || w.Body.String() != "{\n\t\"status\": \"ready\"\n}\n" {
+		t.Errorf("expected the database to be ready, got %d %q", w.Code, w.Body.String())
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("Skipping the unwritable data directory check as root")
+	}
+	// Without write permission on its data directory the database cannot
+	// write SSTables
+	if err := os.Chmod(dataDir, 0500); err != nil {
+		t.Fatalf("error making data directory read-only: %s", err)
+	}
+	defer os.Chmod(dataDir, 0700)
+	if w := ready(); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "\"status\": \"unavailable\"") {
+		t.Errorf("expected 503 for an unwritable data directory, got %d %q", w.Code, w.Body.String())
+	}
+}
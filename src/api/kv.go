@@ -1,91 +1,860 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/AashishUpadhyay/goatdb/src/db"
 	"github.com/gorilla/mux"
 )
 
 type KVController struct {
-	Logger *log.Logger
+	Logger db.Logger
 	Db     db.DB
+	// Metrics receives the latency of Get and Post requests. Nil records
+	// nothing.
+	Metrics RequestMetrics
+	// MaxKeyBytes is the longest key accepted in a write. Zero means
+	// DefaultMaxKeyBytes.
+	MaxKeyBytes int
+	// MaxBodyBytes is the largest body accepted by Post, PutRaw, PostBatch,
+	// MGet and CompareAndSwap. Zero means DefaultMaxBodyBytes. Bulk imports are
+	// streamed and not limited, but each of their lines is limited to the
+	// longest key plus MaxBodyBytes.
+	MaxBodyBytes int64
+}
+
+const (
+	DefaultMaxKeyBytes  = 1024
+	DefaultMaxBodyBytes = 1 << 20
+)
+
+// ErrorResponse is the body of every failed KV request. Error describes the
+// failure for people and Code, which follows the status, is meant for
+// programs.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// errorCode returns the Code of an ErrorResponse sent with status: its status
+// text in snake case, such as "not_found" or "internal_server_error".
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeError responds with status and message as an ErrorResponse.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: errorCode(status)})
+}
+
+// writeWriteError responds to a write the database failed: 400 for a key and
+// 413 for a value it rejects as too large, 403 when it is read-only, 501 for a
+// merge it has no MergeFunc for, and 500 for anything else, which is logged
+// with the formatted context.
+func (kvc KVController) writeWriteError(w http.ResponseWriter, err error, format string, args ...interface{}) {
+	switch {
+	case errors.Is(err, db.ErrReadOnly):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, db.ErrNoMergeFunc):
+		writeError(w, http.StatusNotImplemented, err.Error())
+	case errors.Is(err, db.ErrKeyTooLarge):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, db.ErrValueTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+	default:
+		kvc.Logger.Errorf("%s. error : %v", fmt.Sprintf(format, args...), err)
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+// writeReadError responds to a read the database failed: 504 when it ran out
+// of time, 503 when the client went away and 500 for anything else, which is
+// logged with the formatted context.
+func (kvc KVController) writeReadError(w http.ResponseWriter, err error, format string, args ...interface{}) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		kvc.Logger.Warnf("%s. error : %v", fmt.Sprintf(format, args...), err)
+		writeError(w, http.StatusGatewayTimeout, "request timed out")
+	case errors.Is(err, context.Canceled):
+		kvc.Logger.Debugf("%s. error : %v", fmt.Sprintf(format, args...), err)
+		writeError(w, http.StatusServiceUnavailable, "request canceled")
+	default:
+		kvc.Logger.Errorf("%s. error : %v", fmt.Sprintf(format, args...), err)
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+// validateKey rejects keys that are empty or longer than MaxKeyBytes.
+func (kvc KVController) validateKey(key string) error {
+	maxKeyBytes := kvc.maxKeyBytes()
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+	if len(key) > maxKeyBytes {
+		return fmt.Errorf("key is longer than %d bytes", maxKeyBytes)
+	}
+	return nil
+}
+
+// entryOf validates a posted KV and converts it to a db.Entry.
+func (kvc KVController) entryOf(kv KV) (db.Entry, error) {
+	if err := kvc.validateKey(kv.Key); err != nil {
+		return db.Entry{}, err
+	}
+	if kv.TTLSeconds < 0 {
+		return db.Entry{}, errors.New("ttl_seconds must not be negative")
+	}
+	return kv.entry()
+}
+
+func (kvc KVController) maxKeyBytes() int {
+	if kvc.MaxKeyBytes <= 0 {
+		return DefaultMaxKeyBytes
+	}
+	return kvc.MaxKeyBytes
+}
+
+func (kvc KVController) maxBodyBytes() int64 {
+	if kvc.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+	return kvc.MaxBodyBytes
+}
+
+// decodeBody decodes a JSON request body of at most MaxBodyBytes into v with
+// decodeJSON.
+func (kvc KVController) decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) (int, error) {
+	return decodeJSON(w, r, kvc.maxBodyBytes(), v)
+}
+
+// decodeJSON decodes a JSON request body of at most maxBodyBytes into v,
+// rejecting unknown fields and trailing data. On failure it returns the status
+// to respond with: 413 for a body over the limit and 400 otherwise.
+func decodeJSON(w http.ResponseWriter, r *http.Request, maxBodyBytes int64, v interface{}) (int, error) {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		if _, err = decoder.Token(); err == io.EOF {
+			return http.StatusOK, nil
+		} else if err == nil {
+			err = errors.New("unexpected data after the JSON value")
+		}
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", maxBodyBytes)
+	}
+	return http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err)
+}
+
+// RequestMetrics records how long requests take, per handler.
+type RequestMetrics interface {
+	ObserveRequest(handler string, d time.Duration)
 }
 
 type KV struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
+	// Encoding is "base64" when Value is base64 encoded, and empty when it is
+	// the value itself. Posted KVs use it to carry binary values; responses
+	// set it for values that are not valid UTF-8 or when ?encoding=base64 is
+	// asked for.
+	Encoding string `json:"encoding,omitempty"`
+	// TTLSeconds makes a posted KV expire after that many seconds. Zero keeps
+	// it until it is deleted.
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+}
+
+// entry converts a posted KV to a db.Entry, decoding its value and turning its
+// TTL into an expiry time.
+func (kv KV) entry() (db.Entry, error) {
+	entry := db.Entry{
+		Key:   kv.Key,
+		Value: []byte(kv.Value),
+	}
+	switch kv.Encoding {
+	case "":
+	case "base64":
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return db.Entry{}, fmt.Errorf("invalid base64 value: %w", err)
+		}
+		entry.Value = value
+	default:
+		return db.Entry{}, fmt.Errorf("unsupported encoding %q", kv.Encoding)
+	}
+	if kv.TTLSeconds > 0 {
+		entry.ExpiresAt = db.ExpiresIn(time.Duration(kv.TTLSeconds) * time.Second)
+	}
+	return entry, nil
+}
+
+// kvOf converts a stored entry to a KV for a JSON response. Values that are
+// not valid UTF-8 would be mangled by JSON, so they are base64 encoded, as are
+// all values when forceBase64 is set.
+func kvOf(entry db.Entry, forceBase64 bool) KV {
+	if forceBase64 || !utf8.Valid(entry.Value) {
+		return KV{Key: entry.Key, Value: base64.StdEncoding.EncodeToString(entry.Value), Encoding: "base64"}
+	}
+	return KV{Key: entry.Key, Value: string(entry.Value)}
+}
+
+// base64Requested reports whether the request asks for every value base64
+// encoded with ?encoding=base64.
+func base64Requested(r *http.Request) (bool, error) {
+	switch encoding := r.URL.Query().Get("encoding"); encoding {
+	case "":
+		return false, nil
+	case "base64":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// acceptsOctetStream reports whether the request asks for a raw value with an
+// Accept header of application/octet-stream.
+func acceptsOctetStream(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(mediaRange)
+			if err == nil && mediaType == "application/octet-stream" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const (
+	DefaultScanLimit = 100
+	MaxScanLimit     = 1000
+	// BulkBatchSize is the number of KVs a bulk import applies per batch.
+	BulkBatchSize = 1000
+	// MaxBulkErrors is the number of skipped lines a bulk import describes;
+	// any further ones are only counted.
+	MaxBulkErrors = 100
+)
+
+// ScanResponse is one page of a range query. When Truncated is set, more keys
+// match and the next page starts at NextStart.
+type ScanResponse struct {
+	Entries   []KV   `json:"entries"`
+	Truncated bool   `json:"truncated"`
+	NextStart string `json:"next_start,omitempty"`
+}
+
+// KeysResponse is a page of keys. Next is set when more keys follow and is
+// passed as after to fetch the next page.
+type KeysResponse struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// MGetResponse holds the KVs found by a multi-get, by key, and the requested
+// keys that have no live record, in order.
+type MGetResponse struct {
+	Found   map[string]KV `json:"found"`
+	Missing []string      `json:"missing"`
+}
+
+// CASRequest is the body of a compare-and-swap. A null or missing Expected
+// only matches a key that does not exist.
+type CASRequest struct {
+	Expected *string `json:"expected"`
+	New      string  `json:"new"`
+}
+
+// IncrRequest is the optional body of an increment. A missing By adds one.
+type IncrRequest struct {
+	By *int64 `json:"by"`
+}
+
+// BulkResponse summarizes a bulk import. Skipped counts the lines that were
+// skipped and Errors names the first MaxBulkErrors of them and why.
+type BulkResponse struct {
+	Inserted int      `json:"inserted"`
+	Skipped  int      `json:"skipped"`
+	Errors   []string `json:"errors"`
 }
 
 func (kvc KVController) RegisterRoutes(r *mux.Router) {
-	r.HandleFunc("/v1/kv/{key-name}", kvc.Get)
-	r.HandleFunc("/v1/kv", kvc.Post)
+	r.HandleFunc("/v1/kv/{key-name}", kvc.observe("get", kvc.Get)).Methods(http.MethodGet)
+	r.HandleFunc("/v1/kv/{key-name}", kvc.Head).Methods(http.MethodHead)
+	r.HandleFunc("/v1/kv/{key-name}", kvc.observe("put", kvc.PutRaw)).Methods(http.MethodPut)
+	r.HandleFunc("/v1/kv/{key-name}", kvc.Delete).Methods(http.MethodDelete)
+	r.HandleFunc("/v1/kv/{key-name}/cas", kvc.CompareAndSwap).Methods(http.MethodPost)
+	r.HandleFunc("/v1/kv/{key-name}/incr", kvc.Incr).Methods(http.MethodPost)
+	r.HandleFunc("/v1/kv/batch", kvc.PostBatch).Methods(http.MethodPost)
+	r.HandleFunc("/v1/kv/mget", kvc.MGet).Methods(http.MethodPost)
+	r.HandleFunc("/v1/kv/bulk", kvc.PostBulk).Methods(http.MethodPost)
+	r.HandleFunc("/v1/kv", kvc.Scan).Methods(http.MethodGet)
+	r.HandleFunc("/v1/keys", kvc.Keys).Methods(http.MethodGet)
+	r.HandleFunc("/v1/kv", kvc.observe("post", kvc.Post))
+}
+
+// observe wraps handler to report its latency to kvc.Metrics, if set.
+func (kvc KVController) observe(name string, handler http.HandlerFunc) http.HandlerFunc {
+	if kvc.Metrics == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler(w, r)
+		kvc.Metrics.ObserveRequest(name, time.Since(start))
+	}
 }
 
 func (kvc KVController) Post(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
+	kv := KV{}
+	if status, err := kvc.decodeBody(w, r, &kv); err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	entry, err := kvc.entryOf(kv)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = kvc.Db.Put(entry)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		kvc.writeWriteError(w, err, "Failed to create the KV with key %s", kv.Key)
+		return
+	}
+
+	kvc.Logger.Debugf("Successfully created the KV with key %s.", kv.Key)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// PutRaw stores the raw request body as the value of the key in the URL. The
+// request must have a Content-Type of application/octet-stream, so binary
+// values need no encoding. The body is limited to MaxBodyBytes.
+func (kvc KVController) PutRaw(w http.ResponseWriter, r *http.Request) {
+	keyName := mux.Vars(r)["key-name"]
+	if err := kvc.validateKey(keyName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/octet-stream" {
+		writeError(w, http.StatusUnsupportedMediaType, "content type must be application/octet-stream")
 		return
 	}
 
-	kv := &KV{}
-	err = json.Unmarshal(body, &kv)
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, kvc.maxBodyBytes()))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", kvc.maxBodyBytes()))
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
+	err = kvc.Db.Put(db.Entry{Key: keyName, Value: value})
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		kvc.writeWriteError(w, err, "Failed to create the KV with key %s", keyName)
 		return
 	}
 
-	err = kvc.Db.Put(db.Entry{
-		Key:   kv.Key,
-		Value: []byte(kv.Value),
-	})
+	kvc.Logger.Debugf("Successfully created the KV with key %s.", keyName)
+	w.WriteHeader(http.StatusCreated)
+}
 
+// PostBatch stores a JSON array of KVs as a single batch.
+func (kvc KVController) PostBatch(w http.ResponseWriter, r *http.Request) {
+	kvs := []KV{}
+	if status, err := kvc.decodeBody(w, r, &kvs); err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	entries := make([]db.Entry, 0, len(kvs))
+	for i, kv := range kvs {
+		entry, err := kvc.entryOf(kv)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("kv %d: %v", i, err))
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	err := kvc.Db.PutBatch(entries)
 	if err != nil {
-		kvc.Logger.Printf("Failed to create the KV with key %s. error : %v", kv.Key, err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		kvc.writeWriteError(w, err, "Failed to create a batch of %d KVs", len(entries))
 		return
 	}
 
-	kvc.Logger.Printf("Successfully created the KV with key %s.", kv.Key)
+	kvc.Logger.Debugf("Successfully created a batch of %d KVs.", len(entries))
 	w.WriteHeader(http.StatusCreated)
 }
 
+// PostBulk imports newline-delimited JSON KVs. The body is read a line at a
+// time and applied in batches of BulkBatchSize, so it is never held in memory
+// as a whole. Lines that are not a valid KV are skipped and reported, as are
+// lines longer than the longest key plus MaxBodyBytes, which are not read into
+// memory.
+func (kvc KVController) PostBulk(w http.ResponseWriter, r *http.Request) {
+	response := BulkResponse{Errors: []string{}}
+	skip := func(lineNumber int, err error) {
+		response.Skipped++
+		if len(response.Errors) < MaxBulkErrors {
+			response.Errors = append(response.Errors, fmt.Sprintf("line %d: %v", lineNumber, err))
+		}
+	}
+	entries := make([]db.Entry, 0, BulkBatchSize)
+	flush := func() error {
+		if len(entries) == 0 {
+			return nil
+		}
+		if err := kvc.Db.PutBatch(entries); err != nil {
+			return err
+		}
+		response.Inserted += len(entries)
+		entries = entries[:0]
+		return nil
+	}
+
+	maxLineBytes := kvc.maxKeyBytes() + int(kvc.maxBodyBytes())
+	reader := bufio.NewReader(r.Body)
+	var line []byte
+	for lineNumber := 1; ; lineNumber++ {
+		var tooLong bool
+		var readErr error
+		line, tooLong, readErr = readLine(reader, line, maxLineBytes)
+		if readErr != nil && readErr != io.EOF {
+			writeError(w, http.StatusBadRequest, readErr.Error())
+			return
+		}
+
+		if tooLong {
+			skip(lineNumber, fmt.Errorf("line is longer than %d bytes", maxLineBytes))
+		} else if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			kv := KV{}
+			decoder := json.NewDecoder(bytes.NewReader(trimmed))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&kv); err != nil {
+				skip(lineNumber, err)
+			} else if entry, err := kvc.entryOf(kv); err != nil {
+				skip(lineNumber, err)
+			} else {
+				entries = append(entries, entry)
+			}
+		}
+
+		if len(entries) == BulkBatchSize || readErr == io.EOF {
+			if err := flush(); err != nil {
+				kvc.writeWriteError(w, err, "Failed to import KVs after %d were inserted", response.Inserted)
+				return
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	kvc.Logger.Debugf("Imported %d KVs, skipped %d lines.", response.Inserted, response.Skipped)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJson)
+}
+
+// readLine reads the next line of reader into line, reusing its memory. A line
+// longer than limit bytes, not counting its newline, is read to its end but not
+// kept, and reported as too long.
+func readLine(reader *bufio.Reader, line []byte, limit int) ([]byte, bool, error) {
+	line = line[:0]
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if len(bytes.TrimSuffix(line, []byte("\n"))) > limit {
+				line, tooLong = line[:0], true
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return line, tooLong, err
+		}
+	}
+}
+
 func (kvc KVController) Get(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	keyName := vars["key-name"]
 
-	retrievedEntry, err := kvc.Db.Get(keyName)
+	forceBase64, err := base64Requested(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	retrievedEntry, err := kvc.Db.GetContext(r.Context(), keyName)
 
 	// Test for errors in retrieving the entry
 	if err != nil {
-		if err.Error() == "entry not found" {
-			kvc.Logger.Printf("Failed to get the key %s. error : %v", keyName, err)
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		if errors.Is(err, db.ErrNotFound) {
+			kvc.Logger.Debugf("Failed to get the key %s. error : %v", keyName, err)
+			writeError(w, http.StatusNotFound, fmt.Sprintf("key %s not found", keyName))
 			return
 		}
-		kvc.Logger.Printf("Failed to get the key %s. error : %v", keyName, err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		kvc.writeReadError(w, err, "Failed to get the key %s", keyName)
 		return
 	}
 
-	kv := &KV{
-		Key:   retrievedEntry.Key,
-		Value: string(retrievedEntry.Value),
+	if acceptsOctetStream(r) {
+		kvc.Logger.Debugf("Found key %s!", retrievedEntry.Key)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(retrievedEntry.Value)
+		return
 	}
 
+	kv := kvOf(retrievedEntry, forceBase64)
 	kvjson, err := json.MarshalIndent(kv, "", "\t")
 	if err != nil {
-		kvc.Logger.Printf("Failed to serialize response!")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		kvc.Logger.Errorf("Failed to serialize response!")
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	kvc.Logger.Printf("Found key %s!", kv.Key)
+	kvc.Logger.Debugf("Found key %s!", kv.Key)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(kvjson)
 }
+
+// MGet looks up a JSON array of at most MaxScanLimit keys in one call.
+func (kvc KVController) MGet(w http.ResponseWriter, r *http.Request) {
+	forceBase64, err := base64Requested(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	keys := []string{}
+	if status, err := kvc.decodeBody(w, r, &keys); err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+	if len(keys) > MaxScanLimit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d keys can be fetched at once", MaxScanLimit))
+		return
+	}
+	for i, key := range keys {
+		if err := kvc.validateKey(key); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("key %d: %v", i, err))
+			return
+		}
+	}
+
+	found, err := kvc.Db.GetMany(keys)
+	if err != nil {
+		kvc.writeReadError(w, err, "Failed to get %d keys", len(keys))
+		return
+	}
+
+	response := MGetResponse{Found: make(map[string]KV, len(found)), Missing: []string{}}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if entry, ok := found[key]; ok {
+			response.Found[key] = kvOf(entry, forceBase64)
+		} else {
+			response.Missing = append(response.Missing, key)
+		}
+	}
+
+	responsejson, err := json.MarshalIndent(response, "", "\t")
+	if err != nil {
+		kvc.Logger.Errorf("Failed to serialize response!")
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	kvc.Logger.Debugf("Found %d of %d keys", len(response.Found), len(keys))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responsejson)
+}
+
+// Head responds 200 if the key exists and 404 if it does not, without a body.
+func (kvc KVController) Head(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyName := vars["key-name"]
+
+	exists, err := kvc.Db.Exists(keyName)
+	if err != nil {
+		kvc.Logger.Errorf("Failed to check the key %s. error : %v", keyName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (kvc KVController) Delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyName := vars["key-name"]
+
+	err := kvc.Db.Delete(keyName)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			kvc.Logger.Debugf("Failed to delete the key %s. error : %v", keyName, err)
+			writeError(w, http.StatusNotFound, fmt.Sprintf("key %s not found", keyName))
+			return
+		}
+		kvc.writeWriteError(w, err, "Failed to delete the key %s", keyName)
+		return
+	}
+
+	kvc.Logger.Debugf("Deleted key %s!", keyName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CompareAndSwap sets a key to New only if its value is still Expected. It
+// responds 200 with the new KV on a swap and 412 if the value did not match.
+func (kvc KVController) CompareAndSwap(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyName := vars["key-name"]
+
+	if err := kvc.validateKey(keyName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	request := CASRequest{}
+	if status, err := kvc.decodeBody(w, r, &request); err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	var expected []byte
+	if request.Expected != nil {
+		expected = []byte(*request.Expected)
+	}
+	swapped, err := kvc.Db.CompareAndSwap(keyName, expected, []byte(request.New))
+	if err != nil {
+		kvc.writeWriteError(w, err, "Failed to swap the key %s", keyName)
+		return
+	}
+	if !swapped {
+		kvc.Logger.Debugf("Value of key %s did not match, not swapped.", keyName)
+		writeError(w, http.StatusPreconditionFailed, http.StatusText(http.StatusPreconditionFailed))
+		return
+	}
+
+	kvjson, err := json.MarshalIndent(KV{Key: keyName, Value: request.New}, "", "\t")
+	if err != nil {
+		kvc.Logger.Errorf("Failed to serialize response!")
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	kvc.Logger.Debugf("Swapped key %s!", keyName)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(kvjson)
+}
+
+// Incr adds By to a counter with a merge, so concurrent increments need no
+// retries, and responds 200 with the counter read back afterwards. That value
+// includes the increment but may also include others made since.
+func (kvc KVController) Incr(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	keyName := vars["key-name"]
+
+	if err := kvc.validateKey(keyName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// An empty or missing body increments by one, also when it is sent
+	// chunked and its length is not known up front
+	request := IncrRequest{}
+	if r.Body != nil {
+		if status, err := kvc.decodeBody(w, r, &request); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, status, err.Error())
+			return
+		}
+	}
+	by := int64(1)
+	if request.By != nil {
+		by = *request.By
+	}
+
+	if err := kvc.Db.Merge(keyName, []byte(strconv.FormatInt(by, 10))); err != nil {
+		kvc.writeWriteError(w, err, "Failed to increment the key %s", keyName)
+		return
+	}
+	entry, err := kvc.Db.GetContext(r.Context(), keyName)
+	if err != nil {
+		kvc.writeReadError(w, err, "Failed to read the key %s after incrementing it", keyName)
+		return
+	}
+
+	kvjson, err := json.MarshalIndent(kvOf(entry, false), "", "\t")
+	if err != nil {
+		kvc.Logger.Errorf("Failed to serialize response!")
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	kvc.Logger.Debugf("Incremented key %s by %d!", keyName, by)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(kvjson)
+}
+
+// Scan returns the keys in [start, end) in key order, or the keys beginning with
+// prefix. At most limit entries are returned; limit defaults to
+// DefaultScanLimit and is capped at MaxScanLimit. start_after, which cannot be
+// combined with start, continues a scan after the last key of a previous page.
+func (kvc KVController) Scan(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	startKey := query.Get("start")
+	endKey := query.Get("end")
+	if query.Has("prefix") {
+		if query.Has("start") || query.Has("end") {
+			writeError(w, http.StatusBadRequest, "prefix cannot be combined with start or end")
+			return
+		}
+		startKey = query.Get("prefix")
+		endKey = db.PrefixEnd(startKey)
+	}
+	if endKey != "" && endKey < startKey {
+		writeError(w, http.StatusBadRequest, "end must not be before start")
+		return
+	}
+	if query.Has("start_after") {
+		if query.Has("start") {
+			writeError(w, http.StatusBadRequest, "start_after cannot be combined with start")
+			return
+		}
+		// The smallest key greater than start_after
+		if after := query.Get("start_after") + "\x00"; after > startKey {
+			startKey = after
+		}
+	}
+
+	forceBase64, err := base64Requested(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := DefaultScanLimit
+	if query.Has("limit") {
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+	if limit > MaxScanLimit {
+		limit = MaxScanLimit
+	}
+
+	// A start_after at or past the end of the range, as when the keys after
+	// the previous page were deleted since, leaves an empty last page
+	var entries []db.Entry
+	if endKey == "" || startKey < endKey {
+		// One extra entry tells whether another page follows
+		entries, err = kvc.Db.ScanContext(r.Context(), startKey, endKey, limit+1)
+		if err != nil {
+			kvc.writeReadError(w, err, "Failed to scan keys from %s to %s", startKey, endKey)
+			return
+		}
+	}
+
+	response := ScanResponse{Entries: []KV{}}
+	if len(entries) > limit {
+		response.Truncated = true
+		response.NextStart = entries[limit].Key
+		entries = entries[:limit]
+	}
+	for _, entry := range entries {
+		response.Entries = append(response.Entries, kvOf(entry, forceBase64))
+	}
+
+	responsejson, err := json.MarshalIndent(response, "", "\t")
+	if err != nil {
+		kvc.Logger.Errorf("Failed to serialize response!")
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	kvc.Logger.Debugf("Scanned %d keys from %s to %s", len(response.Entries), startKey, endKey)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responsejson)
+}
+
+// Keys lists the keys beginning with prefix in key order, without their values.
+// At most limit keys are returned; limit defaults to DefaultScanLimit and is
+// capped at MaxScanLimit. after continues a listing after the Next key of a
+// previous page.
+func (kvc KVController) Keys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	after := query.Get("after")
+
+	limit := DefaultScanLimit
+	if query.Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+	if limit > MaxScanLimit {
+		limit = MaxScanLimit
+	}
+
+	// One extra key tells whether another page follows
+	keys, err := kvc.Db.Keys(prefix, limit+1, after)
+	if err != nil {
+		kvc.Logger.Errorf("Failed to list keys with prefix %s. error : %v", prefix, err)
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	response := KeysResponse{Keys: keys}
+	if len(keys) > limit {
+		response.Keys = keys[:limit]
+		response.Next = keys[limit-1]
+	}
+
+	responsejson, err := json.MarshalIndent(response, "", "\t")
+	if err != nil {
+		kvc.Logger.Errorf("Failed to serialize response!")
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	kvc.Logger.Debugf("Listed %d keys with prefix %s", len(response.Keys), prefix)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responsejson)
+}
@@ -1,15 +1,21 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/AashishUpadhyay/goatdb/src/db"
 	"github.com/gorilla/mux"
@@ -21,7 +27,7 @@ func TestKVController(t *testing.T) {
 		mockDb := new(MockDB)
 		mockDb.On("Put", mock.Anything).Return(nil)
 		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-		kvc := KVController{Logger: logger, Db: mockDb}
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
 
 		url := "v1/kv"
 		reqBody := strings.NewReader("{\"key\":\"asdf\", \"value\":\"asdf\"}")
@@ -35,11 +41,46 @@ func TestKVController(t *testing.T) {
 		}
 	})
 
+	t.Run("test_post_kv_with_ttl", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Put", mock.MatchedBy(func(entry db.Entry) bool {
+			return entry.Key == "asdf" && entry.ExpiresAt > time.Now().UnixNano()
+		})).Return(nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		reqBody := strings.NewReader("{\"key\":\"asdf\", \"value\":\"asdf\", \"ttl_seconds\":60}")
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv", reqBody)
+
+		kvc.Post(w, r)
+		if w.Code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d", http.StatusCreated, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_post_negative_ttl", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		reqBody := strings.NewReader("{\"key\":\"asdf\", \"value\":\"asdf\", \"ttl_seconds\":-1}")
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv", reqBody)
+
+		kvc.Post(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		mockDb.AssertNotCalled(t, "Put", mock.Anything)
+	})
+
 	t.Run("test_post_invalid_json", func(t *testing.T) {
 		mockDb := new(MockDB)
 		mockDb.On("Put", mock.Anything).Return(nil)
 		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-		kvc := KVController{Logger: logger, Db: mockDb}
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
 
 		url := "v1/kv"
 		reqBody := strings.NewReader("{\"key\":\"asdf\", \"value\":\"asdf\"")
@@ -57,7 +98,7 @@ func TestKVController(t *testing.T) {
 		mockDb := new(MockDB)
 		mockDb.On("Put", mock.Anything).Return(nil)
 		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-		kvc := KVController{Logger: logger, Db: mockDb}
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
 
 		url := "v1/kv"
 		reqBody := strings.NewReader("")
@@ -71,13 +112,43 @@ func TestKVController(t *testing.T) {
 		}
 	})
 
+	t.Run("test_post_rejects_invalid_requests", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			body    string
+			status  int
+			message string
+		}{
+			{"empty key", `{"key":"", "value":"asdf"}`, http.StatusBadRequest, "key must not be empty"},
+			{"missing key", `{"value":"asdf"}`, http.StatusBadRequest, "key must not be empty"},
+			{"long key", `{"key":"` + strings.Repeat("k", 17) + `", "value":"asdf"}`, http.StatusBadRequest, "key is longer than 16 bytes"},
+			{"unknown field", `{"key":"asdf", "value":"asdf", "colour":"red"}`, http.StatusBadRequest, `invalid request body: json: unknown field "colour"`},
+			{"trailing data", `{"key":"asdf", "value":"asdf"} {}`, http.StatusBadRequest, "invalid request body: unexpected data after the JSON value"},
+			{"large body", `{"key":"asdf", "value":"` + strings.Repeat("v", 64) + `"}`, http.StatusRequestEntityTooLarge, "request body is larger than 64 bytes"},
+		}
+		for _, test := range tests {
+			mockDb := new(MockDB)
+			logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+			kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb, MaxKeyBytes: 16, MaxBodyBytes: 64}
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "v1/kv", strings.NewReader(test.body))
+			kvc.Post(w, r)
+			if w.Code != test.status {
+				t.Errorf("%s: expected status code %d, got %d", test.name, test.status, w.Code)
+			}
+			expectErrorBody(t, w, test.message)
+			mockDb.AssertNotCalled(t, "Put", mock.Anything)
+		}
+	})
+
 	t.Run("test_post_DB_error", func(t *testing.T) {
 		mockDb := new(MockDB)
 		mockDb.On("Put", mock.Anything).Return(errors.New("failed to save!"))
 
 		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 
-		kvc := KVController{Logger: logger, Db: mockDb}
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
 
 		url := "v1/kv"
 		reqBody := strings.NewReader("{\"key\":\"asdf\", \"value\":\"asdf\"}")
@@ -89,6 +160,31 @@ func TestKVController(t *testing.T) {
 		if w.Code != http.StatusInternalServerError {
 			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
 		}
+		expectErrorBody(t, w, http.StatusText(http.StatusInternalServerError))
+	})
+
+	t.Run("test_post_DB_rejects_size", func(t *testing.T) {
+		for _, test := range []struct {
+			err    error
+			status int
+		}{
+			{fmt.Errorf("%w: 70000 bytes, limit is 65536", db.ErrKeyTooLarge), http.StatusBadRequest},
+			{fmt.Errorf("%w: 20000000 bytes, limit is 16777216", db.ErrValueTooLarge), http.StatusRequestEntityTooLarge},
+			{db.ErrReadOnly, http.StatusForbidden},
+		} {
+			mockDb := new(MockDB)
+			mockDb.On("Put", mock.Anything).Return(test.err)
+			logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+			kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "v1/kv", strings.NewReader(`{"key":"asdf", "value":"asdf"}`))
+			kvc.Post(w, r)
+			if w.Code != test.status {
+				t.Errorf("expected status code %d for %v, got %d", test.status, test.err, w.Code)
+			}
+			expectErrorBody(t, w, test.err.Error())
+		}
 	})
 
 	t.Run("test_get_returns_kv", func(t *testing.T) {
@@ -99,7 +195,7 @@ func TestKVController(t *testing.T) {
 			Value: []byte("asdf"),
 		})
 		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-		kvc := KVController{Logger: logger, Db: mockDb}
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
 		url := fmt.Sprintf("v1/kv/%s", key)
 		r, _ := http.NewRequest(http.MethodGet, url, nil)
 		vars := map[string]string{
@@ -125,12 +221,136 @@ func TestKVController(t *testing.T) {
 		}
 	})
 
+	t.Run("test_get_returns_raw_bytes_for_octet_stream", func(t *testing.T) {
+		key := "binary"
+		value := []byte{0xff, 0xfe, 0x00, 'a', 0xc3, 0x28}
+		mockDb := new(MockDB)
+		mockDb.On("Get", mock.Anything).Return(db.Entry{Key: key, Value: value})
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+		r, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("v1/kv/%s", key), nil)
+		r.Header.Set("Accept", "text/html, application/octet-stream;q=0.9")
+		r = mux.SetURLVars(r, map[string]string{"key-name": key})
+
+		w := httptest.NewRecorder()
+		kvc.Get(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/octet-stream" {
+			t.Errorf("expected content type application/octet-stream, got %s", contentType)
+		}
+		if !bytes.Equal(w.Body.Bytes(), value) {
+			t.Errorf("expected body %v, got %v", value, w.Body.Bytes())
+		}
+	})
+
+	t.Run("test_get_base64_encodes_binary_values_in_json", func(t *testing.T) {
+		key := "binary"
+		value := []byte{0xff, 0xfe, 0x00, 'a', 0xc3, 0x28}
+		mockDb := new(MockDB)
+		mockDb.On("Get", mock.Anything).Return(db.Entry{Key: key, Value: value})
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+		r, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("v1/kv/%s", key), nil)
+		r = mux.SetURLVars(r, map[string]string{"key-name": key})
+
+		w := httptest.NewRecorder()
+		kvc.Get(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		var kv KV
+		if err := json.Unmarshal(w.Body.Bytes(), &kv); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(kv.Value)
+		if kv.Encoding != "base64" || err != nil || !bytes.Equal(decoded, value) {
+			t.Errorf("expected base64 encoded value %v, got %+v", value, kv)
+		}
+	})
+
+	t.Run("test_get_returns_not_found_for_missing_key", func(t *testing.T) {
+		key := "asdf"
+		mockDb := new(MockDB)
+		mockDb.On("Get", mock.Anything).Return(db.ErrNotFound)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+		r, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("v1/kv/%s", key), nil)
+		r = mux.SetURLVars(r, map[string]string{"key-name": key})
+
+		w := httptest.NewRecorder()
+		kvc.Get(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, w.Code)
+		}
+		if want := "{\"error\":\"key asdf not found\",\"code\":\"not_found\"}\n"; w.Body.String() != want {
+			t.Errorf("expected body %q, got %q", want, w.Body.String())
+		}
+		expectErrorBody(t, w, "key asdf not found")
+	})
+
+	t.Run("test_head_reports_whether_key_exists", func(t *testing.T) {
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		mockDb := new(MockDB)
+		mockDb.On("Exists", "present").Return(true, nil)
+		mockDb.On("Exists", "absent").Return(false, nil)
+		mockDb.On("Exists", "broken").Return(false, errors.New("failed to read sstable"))
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		for key, status := range map[string]int{
+			"present": http.StatusOK,
+			"absent":  http.StatusNotFound,
+			"broken":  http.StatusInternalServerError,
+		} {
+			r, _ := http.NewRequest(http.MethodHead, fmt.Sprintf("v1/kv/%s", key), nil)
+			r = mux.SetURLVars(r, map[string]string{"key-name": key})
+			w := httptest.NewRecorder()
+			kvc.Head(w, r)
+			if w.Code != status {
+				t.Errorf("%s: expected status code %d, got %d", key, status, w.Code)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("%s: expected no body, got %q", key, w.Body.String())
+			}
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_get_reports_timeouts_and_cancellations", func(t *testing.T) {
+		key := "asdf"
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+
+		mockDb := new(MockDB)
+		mockDb.On("Get", mock.Anything).Return(context.DeadlineExceeded)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+		r, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("v1/kv/%s", key), nil)
+		r = mux.SetURLVars(r, map[string]string{"key-name": key})
+		w := httptest.NewRecorder()
+		kvc.Get(w, r)
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("expected status code %d, got %d", http.StatusGatewayTimeout, w.Code)
+		}
+		expectErrorBody(t, w, "request timed out")
+
+		// The request context is passed on to the database
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r = r.WithContext(ctx)
+		w = httptest.NewRecorder()
+		kvc.Get(w, r)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+		expectErrorBody(t, w, "request canceled")
+	})
+
 	t.Run("test_get_returns_error_when_failed_to_fetch_value", func(t *testing.T) {
 		key := "asdf"
 		mockDb := new(MockDB)
 		mockDb.On("Get", mock.Anything).Return(errors.New("An error occurred when trying to get the value"))
 		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-		kvc := KVController{Logger: logger, Db: mockDb}
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
 		url := fmt.Sprintf("v1/kv/%s", key)
 		r, _ := http.NewRequest(http.MethodGet, url, nil)
 		vars := map[string]string{
@@ -143,33 +363,1136 @@ func TestKVController(t *testing.T) {
 		if w.Code != http.StatusInternalServerError {
 			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
 		}
+		expectErrorBody(t, w, http.StatusText(http.StatusInternalServerError))
 	})
-}
 
-type MockDB struct {
-	mock.Mock
+	t.Run("test_delete_returns_no_content", func(t *testing.T) {
+		key := "asdf"
+		mockDb := new(MockDB)
+		mockDb.On("Delete", key).Return(nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+		url := fmt.Sprintf("v1/kv/%s", key)
+		r, _ := http.NewRequest(http.MethodDelete, url, nil)
+		vars := map[string]string{
+			"key-name": key,
+		}
+		r = mux.SetURLVars(r, vars)
+
+		w := httptest.NewRecorder()
+		kvc.Delete(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("expected status code %d, got %d", http.StatusNoContent, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_delete_returns_not_found_for_missing_key", func(t *testing.T) {
+		key := "asdf"
+		mockDb := new(MockDB)
+		mockDb.On("Delete", key).Return(db.ErrNotFound)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+		url := fmt.Sprintf("v1/kv/%s", key)
+		r, _ := http.NewRequest(http.MethodDelete, url, nil)
+		vars := map[string]string{
+			"key-name": key,
+		}
+		r = mux.SetURLVars(r, vars)
+
+		w := httptest.NewRecorder()
+		kvc.Delete(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d, got %d", http.StatusNotFound, w.Code)
+		}
+		expectErrorBody(t, w, "key asdf not found")
+	})
+
+	t.Run("test_delete_returns_error_when_failed_to_delete", func(t *testing.T) {
+		key := "asdf"
+		mockDb := new(MockDB)
+		mockDb.On("Delete", key).Return(errors.New("failed to delete!"))
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+		url := fmt.Sprintf("v1/kv/%s", key)
+		r, _ := http.NewRequest(http.MethodDelete, url, nil)
+		vars := map[string]string{
+			"key-name": key,
+		}
+		r = mux.SetURLVars(r, vars)
+
+		w := httptest.NewRecorder()
+		kvc.Delete(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+		expectErrorBody(t, w, http.StatusText(http.StatusInternalServerError))
+	})
+
+	t.Run("test_delete_rejected_when_read_only", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Delete", "asdf").Return(db.ErrReadOnly)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+		r, _ := http.NewRequest(http.MethodDelete, "v1/kv/asdf", nil)
+		r = mux.SetURLVars(r, map[string]string{"key-name": "asdf"})
+
+		w := httptest.NewRecorder()
+		kvc.Delete(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, got %d", http.StatusForbidden, w.Code)
+		}
+		expectErrorBody(t, w, db.ErrReadOnly.Error())
+	})
 }
 
-func (mdb *MockDB) Get(key string) (db.Entry, error) {
-	args := mdb.Called()
-	if kvRetrieved, ok := args.Get(0).(db.Entry); ok {
-		return db.Entry{
-			Key:   kvRetrieved.Key,
-			Value: []byte(kvRetrieved.Value),
-		}, nil
+func TestKVControllerBinaryValues(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	database, err := db.NewDb(db.Options{
+		MemtableThreshold: 100,
+		SstableMgr:        db.NewInMemoryManager(logger),
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
 	}
+	defer database.Close()
+	router := mux.NewRouter()
+	KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: database, MaxBodyBytes: 1024}.RegisterRoutes(router)
 
-	if args.Error(0) != nil {
-		return db.Entry{}, args.Error(0)
+	value := make([]byte, 256)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	getBase64 := func(t *testing.T, url string) []byte {
+		t.Helper()
+		r, _ := http.NewRequest(http.MethodGet, url, nil)
+		w := serve(r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		var kv KV
+		if err := json.Unmarshal(w.Body.Bytes(), &kv); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(kv.Value)
+		if kv.Encoding != "base64" || err != nil {
+			t.Fatalf("expected a base64 encoded value, got %+v", kv)
+		}
+		return decoded
 	}
 
-	return db.Entry{}, nil
+	t.Run("test_put_raw_round_trip", func(t *testing.T) {
+		r, _ := http.NewRequest(http.MethodPut, "/v1/kv/raw", bytes.NewReader(value))
+		r.Header.Set("Content-Type", "application/octet-stream")
+		if w := serve(r); w.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, w.Code)
+		}
+
+		r, _ = http.NewRequest(http.MethodGet, "/v1/kv/raw", nil)
+		r.Header.Set("Accept", "application/octet-stream")
+		w := serve(r)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" {
+			t.Fatalf("expected an octet stream, got %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+		if !bytes.Equal(w.Body.Bytes(), value) {
+			t.Errorf("expected the raw value back, got %v", w.Body.Bytes())
+		}
+		if got := getBase64(t, "/v1/kv/raw"); !bytes.Equal(got, value) {
+			t.Errorf("expected the value back base64 encoded, got %v", got)
+		}
+	})
+
+	t.Run("test_post_base64_round_trip", func(t *testing.T) {
+		body, _ := json.Marshal(KV{Key: "encoded", Value: base64.StdEncoding.EncodeToString(value), Encoding: "base64"})
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv", bytes.NewReader(body))
+		if w := serve(r); w.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, w.Code)
+		}
+
+		entry, err := database.Get("encoded")
+		if err != nil || !bytes.Equal(entry.Value, value) {
+			t.Fatalf("expected the decoded value to be stored, got %v, %v", entry.Value, err)
+		}
+		if got := getBase64(t, "/v1/kv/encoded?encoding=base64"); !bytes.Equal(got, value) {
+			t.Errorf("expected the value back base64 encoded, got %v", got)
+		}
+	})
+
+	t.Run("test_get_encoding_base64_encodes_text", func(t *testing.T) {
+		if err := database.Put(db.Entry{Key: "text", Value: []byte("hello")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		if got := getBase64(t, "/v1/kv/text?encoding=base64"); string(got) != "hello" {
+			t.Errorf("expected hello, got %q", got)
+		}
+
+		r, _ := http.NewRequest(http.MethodGet, "/v1/kv?start=text&encoding=base64", nil)
+		w := serve(r)
+		var response ScanResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(response.Entries) != 1 || response.Entries[0].Value != base64.StdEncoding.EncodeToString([]byte("hello")) {
+			t.Errorf("expected the scanned value base64 encoded, got %+v", response)
+		}
+	})
+
+	t.Run("test_binary_values_reject_invalid_requests", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			request func() *http.Request
+			status  int
+		}{
+			{"put without octet stream", func() *http.Request {
+				r, _ := http.NewRequest(http.MethodPut, "/v1/kv/raw", bytes.NewReader(value))
+				r.Header.Set("Content-Type", "application/json")
+				return r
+			}, http.StatusUnsupportedMediaType},
+			{"put too large", func() *http.Request {
+				r, _ := http.NewRequest(http.MethodPut, "/v1/kv/raw", bytes.NewReader(make([]byte, 1025)))
+				r.Header.Set("Content-Type", "application/octet-stream")
+				return r
+			}, http.StatusRequestEntityTooLarge},
+			{"post invalid base64", func() *http.Request {
+				r, _ := http.NewRequest(http.MethodPost, "/v1/kv", strings.NewReader(`{"key":"a", "value":"not base64!", "encoding":"base64"}`))
+				return r
+			}, http.StatusBadRequest},
+			{"post unknown encoding", func() *http.Request {
+				r, _ := http.NewRequest(http.MethodPost, "/v1/kv", strings.NewReader(`{"key":"a", "value":"00", "encoding":"hex"}`))
+				return r
+			}, http.StatusBadRequest},
+			{"get unknown encoding", func() *http.Request {
+				r, _ := http.NewRequest(http.MethodGet, "/v1/kv/raw?encoding=hex", nil)
+				return r
+			}, http.StatusBadRequest},
+		}
+		for _, test := range tests {
+			if w := serve(test.request()); w.Code != test.status {
+				t.Errorf("%s: expected status code %d, got %d", test.name, test.status, w.Code)
+			}
+		}
+		if entry, err := database.Get("raw"); err != nil || !bytes.Equal(entry.Value, value) {
+			t.Errorf("expected rejected requests to leave the value alone, got %v, %v", entry.Value, err)
+		}
+	})
 }
 
-func (mdb *MockDB) Put(entry db.Entry) error {
-	args := mdb.Called(entry)
-	if args.Error(0) != nil {
-		return args.Error(0)
-	}
-	return nil
+func TestKVControllerPostBatch(t *testing.T) {
+	t.Run("test_post_batch_forwards_entries", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("PutBatch", []db.Entry{
+			{Key: "a", Value: []byte("1")},
+			{Key: "b", Value: []byte("2")},
+		}).Return(nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		reqBody := strings.NewReader(`[{"key":"a", "value":"1"}, {"key":"b", "value":"2"}]`)
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv/batch", reqBody)
+		w := httptest.NewRecorder()
+		kvc.PostBatch(w, r)
+		if w.Code != http.StatusCreated {
+			t.Errorf("expected status code %d, got %d", http.StatusCreated, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_post_batch_invalid_json", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		reqBody := strings.NewReader(`{"key":"a", "value":"1"}`)
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv/batch", reqBody)
+		w := httptest.NewRecorder()
+		kvc.PostBatch(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		mockDb.AssertNotCalled(t, "PutBatch", mock.Anything)
+	})
+
+	t.Run("test_post_batch_rejects_invalid_kvs", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb, MaxBodyBytes: 64}
+
+		reqBody := strings.NewReader(`[{"key":"a", "value":"1"}, {"key":"", "value":"2"}]`)
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv/batch", reqBody)
+		w := httptest.NewRecorder()
+		kvc.PostBatch(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		expectErrorBody(t, w, "kv 1: key must not be empty")
+
+		reqBody = strings.NewReader(`[{"key":"a", "value":"` + strings.Repeat("1", 64) + `"}]`)
+		r, _ = http.NewRequest(http.MethodPost, "v1/kv/batch", reqBody)
+		w = httptest.NewRecorder()
+		kvc.PostBatch(w, r)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected status code %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+		}
+		mockDb.AssertNotCalled(t, "PutBatch", mock.Anything)
+	})
+
+	t.Run("test_post_batch_DB_error", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("PutBatch", mock.Anything).Return(errors.New("failed to save!"))
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		reqBody := strings.NewReader(`[{"key":"a", "value":"1"}]`)
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv/batch", reqBody)
+		w := httptest.NewRecorder()
+		kvc.PostBatch(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+}
+
+func TestKVControllerPostBulk(t *testing.T) {
+	t.Run("test_post_bulk_imports_ndjson", func(t *testing.T) {
+		currentTestDir, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("error getting current test directory: %s", err)
+		}
+		dataDir := filepath.Join(currentTestDir, ".testKVControllerPostBulk")
+		defer os.RemoveAll(dataDir)
+
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		sstableMgr, err := db.NewFileManager(dataDir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		database, err := db.NewDb(db.Options{
+			MemtableThreshold: 300,
+			SstableMgr:        sstableMgr,
+			Logger:            logger,
+		})
+		if err != nil {
+			t.Fatalf("error creating db: %v", err)
+		}
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: database}.RegisterRoutes(router)
+
+		var body strings.Builder
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(&body, "{\"key\":\"bulk%04d\",\"value\":\"value%d\"}\n", i, i)
+		}
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv/bulk", strings.NewReader(body.String()))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var response BulkResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Inserted != 1000 || len(response.Errors) != 0 {
+			t.Fatalf("expected 1000 inserted without errors, got: %+v", response)
+		}
+		for i := 0; i < 1000; i++ {
+			entry, err := database.Get(fmt.Sprintf("bulk%04d", i))
+			if err != nil {
+				t.Fatalf("expected key bulk%04d to be retrievable, got: %v", i, err)
+			}
+			if string(entry.Value) != fmt.Sprintf("value%d", i) {
+				t.Fatalf("expected value%d, got: %s", i, entry.Value)
+			}
+		}
+	})
+
+	t.Run("test_post_bulk_reports_invalid_lines", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("PutBatch", []db.Entry{
+			{Key: "a", Value: []byte("1")},
+			{Key: "c", Value: []byte("3")},
+		}).Return(nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		reqBody := strings.NewReader("{\"key\":\"a\",\"value\":\"1\"}\nnot json\n\n{\"key\":\"b\",\"value\":\"2\",\"ttl_seconds\":-1}\n{\"key\":\"c\",\"value\":\"3\"}\n{\"value\":\"4\"}\n{\"key\":\"e\",\"val\":\"5\"}")
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv/bulk", reqBody)
+		w := httptest.NewRecorder()
+		kvc.PostBulk(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+
+		var response BulkResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Inserted != 2 || response.Skipped != 4 || len(response.Errors) != 4 ||
+			!strings.HasPrefix(response.Errors[0], "line 2:") || !strings.HasPrefix(response.Errors[1], "line 4:") ||
+			response.Errors[2] != "line 6: key must not be empty" || response.Errors[3] != `line 7: json: unknown field "val"` {
+			t.Errorf("expected lines 2, 4, 6 and 7 to be reported, got: %+v", response)
+		}
+	})
+
+	t.Run("test_post_bulk_limits_lines_and_errors", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("PutBatch", []db.Entry{
+			{Key: "a", Value: []byte("1")},
+			{Key: "c", Value: []byte("3")},
+		}).Return(nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb, MaxKeyBytes: 8, MaxBodyBytes: 32}
+
+		var body strings.Builder
+		body.WriteString("{\"key\":\"a\",\"value\":\"1\"}\n")
+		fmt.Fprintf(&body, "{\"key\":\"b\",\"value\":\"%s\"}\n", strings.Repeat("x", 100000))
+		body.WriteString("{\"key\":\"c\",\"value\":\"3\"}\n")
+		for i := 0; i < MaxBulkErrors+50; i++ {
+			body.WriteString("not json\n")
+		}
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv/bulk", strings.NewReader(body.String()))
+		w := httptest.NewRecorder()
+		kvc.PostBulk(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+
+		var response BulkResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if response.Inserted != 2 || response.Skipped != MaxBulkErrors+51 || len(response.Errors) != MaxBulkErrors {
+			t.Fatalf("expected 2 inserted and %d skipped with %d errors, got %d, %d and %d",
+				MaxBulkErrors+51, MaxBulkErrors, response.Inserted, response.Skipped, len(response.Errors))
+		}
+		if response.Errors[0] != "line 2: line is longer than 40 bytes" || !strings.HasPrefix(response.Errors[1], "line 4:") {
+			t.Errorf("expected the long line 2 and line 4 to be reported first, got: %v", response.Errors[:2])
+		}
+	})
+
+	t.Run("test_post_bulk_DB_error", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("PutBatch", mock.Anything).Return(errors.New("failed to save!"))
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		reqBody := strings.NewReader(`{"key":"a","value":"1"}`)
+		r, _ := http.NewRequest(http.MethodPost, "v1/kv/bulk", reqBody)
+		w := httptest.NewRecorder()
+		kvc.PostBulk(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+}
+
+func TestKVControllerCompareAndSwap(t *testing.T) {
+	t.Run("test_cas_swaps", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("CompareAndSwap", "counter", []byte("1"), []byte("2")).Return(true, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+		reqBody := strings.NewReader(`{"expected":"1", "new":"2"}`)
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/cas", reqBody)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+
+		kv := KV{}
+		if err := json.Unmarshal(w.Body.Bytes(), &kv); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if kv.Key != "counter" || kv.Value != "2" {
+			t.Errorf("expected counter=2, got: %+v", kv)
+		}
+	})
+
+	t.Run("test_cas_mismatch", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("CompareAndSwap", "counter", []byte("1"), []byte("2")).Return(false, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+		reqBody := strings.NewReader(`{"expected":"1", "new":"2"}`)
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/cas", reqBody)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected status code %d, got %d", http.StatusPreconditionFailed, w.Code)
+		}
+	})
+
+	t.Run("test_cas_absent_key", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("CompareAndSwap", "counter", []byte(nil), []byte("1")).Return(true, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+		for _, body := range []string{`{"new":"1"}`, `{"expected":null, "new":"1"}`} {
+			r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/cas", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_cas_invalid_json", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/cas", strings.NewReader(`{"new":`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		mockDb.AssertNotCalled(t, "CompareAndSwap", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("test_cas_rejects_invalid_requests", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb, MaxKeyBytes: 4}.RegisterRoutes(router)
+
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/cas", strings.NewReader(`{"new":"1"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		expectErrorBody(t, w, "key is longer than 4 bytes")
+
+		r, _ = http.NewRequest(http.MethodPost, "/v1/kv/cnt/cas", strings.NewReader(`{"new":"1", "old":"0"}`))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		expectErrorBody(t, w, `invalid request body: json: unknown field "old"`)
+		mockDb.AssertNotCalled(t, "CompareAndSwap", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("test_cas_DB_error", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("CompareAndSwap", mock.Anything, mock.Anything, mock.Anything).Return(false, errors.New("failed to save!"))
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/cas", strings.NewReader(`{"new":"1"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+}
+
+func TestKVControllerIncr(t *testing.T) {
+	t.Run("test_incr_merges", func(t *testing.T) {
+		for _, test := range []struct {
+			body    string
+			chunked bool
+			operand string
+		}{
+			{body: "", operand: "1"},
+			{body: "", chunked: true, operand: "1"},
+			{body: `{}`, operand: "1"},
+			{body: `{"by":-5}`, operand: "-5"},
+			{body: `{"by":3}`, chunked: true, operand: "3"},
+		} {
+			mockDb := new(MockDB)
+			mockDb.On("Merge", "counter", []byte(test.operand)).Return(nil)
+			mockDb.On("Get", mock.Anything).Return(db.Entry{Key: "counter", Value: []byte("42")})
+			logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+			router := mux.NewRouter()
+			KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+			r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/incr", strings.NewReader(test.body))
+			if test.chunked {
+				// The length of a chunked body is unknown
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code %d for %q, got %d", http.StatusOK, test.body, w.Code)
+			}
+			mockDb.AssertExpectations(t)
+
+			kv := KV{}
+			if err := json.Unmarshal(w.Body.Bytes(), &kv); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if kv.Key != "counter" || kv.Value != "42" {
+				t.Errorf("expected counter=42, got: %+v", kv)
+			}
+		}
+	})
+
+	t.Run("test_incr_rejects_invalid_requests", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+		for _, body := range []string{`{"by":"1"}`, `{"by":1.5}`, `{"step":1}`} {
+			r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/incr", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+			}
+		}
+		mockDb.AssertNotCalled(t, "Merge", mock.Anything, mock.Anything)
+	})
+
+	t.Run("test_incr_DB_errors", func(t *testing.T) {
+		for _, test := range []struct {
+			err     error
+			status  int
+			message string
+		}{
+			{err: db.ErrNoMergeFunc, status: http.StatusNotImplemented, message: db.ErrNoMergeFunc.Error()},
+			{err: db.ErrReadOnly, status: http.StatusForbidden, message: db.ErrReadOnly.Error()},
+			{err: errors.New("failed to save!"), status: http.StatusInternalServerError, message: http.StatusText(http.StatusInternalServerError)},
+		} {
+			mockDb := new(MockDB)
+			mockDb.On("Merge", "counter", []byte("1")).Return(test.err)
+			logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+			router := mux.NewRouter()
+			KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}.RegisterRoutes(router)
+
+			r, _ := http.NewRequest(http.MethodPost, "/v1/kv/counter/incr", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("expected status code %d, got %d", test.status, w.Code)
+			}
+			expectErrorBody(t, w, test.message)
+			mockDb.AssertNotCalled(t, "Get", mock.Anything)
+		}
+	})
+}
+
+func TestKVControllerScan(t *testing.T) {
+	t.Run("test_scan_forwards_range_and_limit", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Scan", "a", "m", 3).Return([]db.Entry{
+			{Key: "a", Value: []byte("1")},
+			{Key: "b", Value: []byte("2")},
+		}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodGet, "v1/kv?start=a&end=m&limit=2", nil)
+		w := httptest.NewRecorder()
+		kvc.Scan(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+
+		var response ScanResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(response.Entries) != 2 || response.Entries[1].Key != "b" || response.Entries[1].Value != "2" {
+			t.Errorf("unexpected entries: %v", response.Entries)
+		}
+		if response.Truncated {
+			t.Errorf("expected a complete response")
+		}
+	})
+
+	t.Run("test_scan_reports_next_start_when_truncated", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Scan", "", "", 3).Return([]db.Entry{
+			{Key: "a", Value: []byte("1")},
+			{Key: "b", Value: []byte("2")},
+			{Key: "c", Value: []byte("3")},
+		}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodGet, "v1/kv?limit=2", nil)
+		w := httptest.NewRecorder()
+		kvc.Scan(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var response ScanResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(response.Entries) != 2 || !response.Truncated || response.NextStart != "c" {
+			t.Errorf("expected two entries continuing at c, got: %+v", response)
+		}
+	})
+
+	t.Run("test_scan_defaults_and_caps_limit", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Scan", "", "", DefaultScanLimit+1).Return([]db.Entry{}, nil)
+		mockDb.On("Scan", "", "", MaxScanLimit+1).Return([]db.Entry{}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		for _, url := range []string{"v1/kv", fmt.Sprintf("v1/kv?limit=%d", MaxScanLimit*10)} {
+			r, _ := http.NewRequest(http.MethodGet, url, nil)
+			w := httptest.NewRecorder()
+			kvc.Scan(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_scan_prefix", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Scan", "user", "uses", DefaultScanLimit+1).Return([]db.Entry{}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodGet, "v1/kv?prefix=user", nil)
+		w := httptest.NewRecorder()
+		kvc.Scan(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_scan_prefix_pages_with_start_after", func(t *testing.T) {
+		currentTestDir, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("error getting current test directory: %s", err)
+		}
+		dataDir := filepath.Join(currentTestDir, ".testKVControllerScanPrefix")
+		defer os.RemoveAll(dataDir)
+
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		sstableMgr, err := db.NewFileManager(dataDir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		database, err := db.NewDb(db.Options{
+			MemtableThreshold: 4,
+			SstableMgr:        sstableMgr,
+			Logger:            logger,
+		})
+		if err != nil {
+			t.Fatalf("error creating db: %v", err)
+		}
+		defer database.Close()
+		for _, key := range []string{"user:1", "user:2", "user:3", "user:4", "user:5", "users", "admin:1", "user;"} {
+			if err := database.Put(db.Entry{Key: key, Value: []byte(key)}); err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+		}
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: database}.RegisterRoutes(router)
+
+		keys := []string{}
+		url := "/v1/kv?prefix=user:&limit=2"
+		for pages := 0; ; pages++ {
+			if pages > 3 {
+				t.Fatalf("expected 3 pages, got more: %v", keys)
+			}
+			r, _ := http.NewRequest(http.MethodGet, url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+			var response ScanResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(response.Entries) > 2 {
+				t.Fatalf("expected at most 2 entries, got: %v", response.Entries)
+			}
+			for _, kv := range response.Entries {
+				keys = append(keys, kv.Key)
+			}
+			if !response.Truncated {
+				break
+			}
+			url = "/v1/kv?prefix=user:&limit=2&start_after=" + response.Entries[len(response.Entries)-1].Key
+		}
+		if fmt.Sprint(keys) != "[user:1 user:2 user:3 user:4 user:5]" {
+			t.Errorf("expected the keys under user:, got: %v", keys)
+		}
+	})
+
+	t.Run("test_scan_start_after", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Scan", "b\x00", "m", 3).Return([]db.Entry{}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodGet, "v1/kv?start_after=b&end=m&limit=2", nil)
+		w := httptest.NewRecorder()
+		kvc.Scan(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_scan_start_after_past_the_end", func(t *testing.T) {
+		for _, url := range []string{
+			"v1/kv?start_after=z&end=m",
+			"v1/kv?start_after=m&end=m",
+			"v1/kv?prefix=user:&start_after=user%3B",
+		} {
+			mockDb := new(MockDB)
+			logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+			kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+			r, _ := http.NewRequest(http.MethodGet, url, nil)
+			w := httptest.NewRecorder()
+			kvc.Scan(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: expected status code %d, got %d", url, http.StatusOK, w.Code)
+			}
+			var response ScanResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("%s: failed to decode response: %v", url, err)
+			}
+			if len(response.Entries) != 0 || response.Truncated || response.NextStart != "" {
+				t.Errorf("%s: expected an empty last page, got: %+v", url, response)
+			}
+			mockDb.AssertNotCalled(t, "Scan", mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("test_scan_invalid_parameters", func(t *testing.T) {
+		urls := []string{
+			"v1/kv?start=m&end=a",
+			"v1/kv?limit=ten",
+			"v1/kv?limit=0",
+			"v1/kv?prefix=a&start=b",
+			"v1/kv?start=a&start_after=b",
+		}
+		for _, url := range urls {
+			mockDb := new(MockDB)
+			logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+			kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+			r, _ := http.NewRequest(http.MethodGet, url, nil)
+			w := httptest.NewRecorder()
+			kvc.Scan(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code %d, got %d", url, http.StatusBadRequest, w.Code)
+			}
+			mockDb.AssertNotCalled(t, "Scan", mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("test_scan_DB_error", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Scan", "", "", DefaultScanLimit+1).Return(nil, errors.New("failed to scan!"))
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodGet, "v1/kv", nil)
+		w := httptest.NewRecorder()
+		kvc.Scan(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+}
+
+func TestKVControllerMGet(t *testing.T) {
+	t.Run("test_mget_splits_found_and_missing", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("GetMany", []string{"a", "b", "c", "a"}).Return(map[string]db.Entry{
+			"a": {Key: "a", Value: []byte("1")},
+			"c": {Key: "c", Value: []byte{0xff}},
+		}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv/mget", strings.NewReader(`["a","b","c","a"]`))
+		w := httptest.NewRecorder()
+		kvc.MGet(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+
+		var response MGetResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		expected := MGetResponse{
+			Found: map[string]KV{
+				"a": {Key: "a", Value: "1"},
+				"c": {Key: "c", Value: "/w==", Encoding: "base64"},
+			},
+			Missing: []string{"b"},
+		}
+		if fmt.Sprint(response) != fmt.Sprint(expected) {
+			t.Errorf("expected %+v, got %+v", expected, response)
+		}
+	})
+
+	t.Run("test_mget_rejects_invalid_keys", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		tooMany, _ := json.Marshal(make([]string, MaxScanLimit+1))
+		for _, body := range []string{`["a",""]`, `{"keys":["a"]}`, string(tooMany)} {
+			r, _ := http.NewRequest(http.MethodPost, "/v1/kv/mget", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			kvc.MGet(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status code %d for %.20s, got %d", http.StatusBadRequest, body, w.Code)
+			}
+		}
+		mockDb.AssertNotCalled(t, "GetMany", mock.Anything)
+	})
+
+	t.Run("test_mget_reports_errors", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("GetMany", []string{"a"}).Return(nil, errors.New("disk failure"))
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv/mget", strings.NewReader(`["a"]`))
+		w := httptest.NewRecorder()
+		kvc.MGet(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+}
+
+func TestKVControllerKeys(t *testing.T) {
+	t.Run("test_keys_reports_next_when_truncated", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Keys", "user", 3, "user1").Return([]string{"user2", "user3", "user4"}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodGet, "v1/keys?prefix=user&limit=2&after=user1", nil)
+		w := httptest.NewRecorder()
+		kvc.Keys(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		mockDb.AssertExpectations(t)
+
+		var response KeysResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if fmt.Sprint(response.Keys) != "[user2 user3]" || response.Next != "user3" {
+			t.Errorf("expected keys user2 and user3 and next user3, got %+v", response)
+		}
+	})
+
+	t.Run("test_keys_returns_last_page_without_next", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Keys", "", DefaultScanLimit+1, "").Return([]string{"a"}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodGet, "v1/keys", nil)
+		w := httptest.NewRecorder()
+		kvc.Keys(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != "{\n\t\"keys\": [\n\t\t\"a\"\n\t]\n}" {
+			t.Errorf("expected a single page holding a, got %d %q", w.Code, w.Body.String())
+		}
+		mockDb.AssertExpectations(t)
+	})
+
+	t.Run("test_keys_rejects_invalid_limit", func(t *testing.T) {
+		mockDb := new(MockDB)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		kvc := KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		r, _ := http.NewRequest(http.MethodGet, "v1/keys?limit=0", nil)
+		w := httptest.NewRecorder()
+		kvc.Keys(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+		expectErrorBody(t, w, "limit must be a positive integer")
+	})
+}
+
+// expectErrorBody checks that w holds an ErrorResponse with message.
+func expectErrorBody(t *testing.T, w *httptest.ResponseRecorder, message string) {
+	t.Helper()
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected content type application/json, got %s", contentType)
+	}
+	var response ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode error response %q: %v", w.Body.String(), err)
+	}
+	if response.Error != message {
+		t.Errorf("expected error %q, got %q", message, response.Error)
+	}
+	if code := errorCode(w.Code); response.Code != code {
+		t.Errorf("expected code %q, got %q", code, response.Code)
+	}
+}
+
+type MockDB struct {
+	mock.Mock
+}
+
+func (mdb *MockDB) Get(key string) (db.Entry, error) {
+	args := mdb.Called()
+	if kvRetrieved, ok := args.Get(0).(db.Entry); ok {
+		return db.Entry{
+			Key:   kvRetrieved.Key,
+			Value: []byte(kvRetrieved.Value),
+		}, nil
+	}
+
+	if args.Error(0) != nil {
+		return db.Entry{}, args.Error(0)
+	}
+
+	return db.Entry{}, nil
+}
+
+func (mdb *MockDB) GetMany(keys []string) (map[string]db.Entry, error) {
+	args := mdb.Called(keys)
+	if found, ok := args.Get(0).(map[string]db.Entry); ok {
+		return found, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (mdb *MockDB) GetContext(ctx context.Context, key string) (db.Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return db.Entry{}, err
+	}
+	return mdb.Get(key)
+}
+
+func (mdb *MockDB) Exists(key string) (bool, error) {
+	args := mdb.Called(key)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mdb *MockDB) Keys(prefix string, limit int, after string) ([]string, error) {
+	args := mdb.Called(prefix, limit, after)
+	if keys, ok := args.Get(0).([]string); ok {
+		return keys, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (mdb *MockDB) SSTables() ([]db.SSTableInfo, error) {
+	args := mdb.Called()
+	if infos, ok := args.Get(0).([]db.SSTableInfo); ok {
+		return infos, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (mdb *MockDB) Backup(targetDir string) error {
+	args := mdb.Called(targetDir)
+	return args.Error(0)
+}
+
+func (mdb *MockDB) Put(entry db.Entry) error {
+	args := mdb.Called(entry)
+	if args.Error(0) != nil {
+		return args.Error(0)
+	}
+	return nil
+}
+
+func (mdb *MockDB) Delete(key string) error {
+	args := mdb.Called(key)
+	if args.Error(0) != nil {
+		return args.Error(0)
+	}
+	return nil
+}
+
+func (mdb *MockDB) Scan(startKey string, endKey string, limit int) ([]db.Entry, error) {
+	args := mdb.Called(startKey, endKey, limit)
+	if entries, ok := args.Get(0).([]db.Entry); ok {
+		return entries, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (mdb *MockDB) ScanContext(ctx context.Context, startKey string, endKey string, limit int) ([]db.Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return mdb.Scan(startKey, endKey, limit)
+}
+
+func (mdb *MockDB) PutBatch(entries []db.Entry) error {
+	args := mdb.Called(entries)
+	return args.Error(0)
+}
+
+func (mdb *MockDB) CompareAndSwap(key string, expected, newValue []byte) (bool, error) {
+	args := mdb.Called(key, expected, newValue)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mdb *MockDB) Export(w io.Writer) error {
+	args := mdb.Called(w)
+	return args.Error(0)
+}
+
+func (mdb *MockDB) Import(r io.Reader, opts db.ImportOptions) error {
+	args := mdb.Called(r, opts)
+	return args.Error(0)
+}
+
+func (mdb *MockDB) Merge(key string, operand []byte) error {
+	args := mdb.Called(key, operand)
+	return args.Error(0)
+}
+
+func (mdb *MockDB) Close() error {
+	args := mdb.Called()
+	return args.Error(0)
+}
+
+func (mdb *MockDB) Stats() db.Stats {
+	args := mdb.Called()
+	return args.Get(0).(db.Stats)
+}
+
+func (mdb *MockDB) Ready() error {
+	args := mdb.Called()
+	return args.Error(0)
 }
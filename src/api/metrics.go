@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AashishUpadhyay/goatdb/src/db"
+	"github.com/gorilla/mux"
+)
+
+type MetricsController struct {
+	Logger db.Logger
+	Db     db.DB
+}
+
+// MetricsResponse reports database state and the operations served since the
+// database was opened.
+type MetricsResponse struct {
+	MemtableEntries    int    `json:"memtable_entries"`
+	MemtableBytes      int64  `json:"memtable_bytes"`
+	ImmutableMemtables int    `json:"immutable_memtables"`
+	SSTables           int    `json:"sstables"`
+	Puts               uint64 `json:"puts"`
+	Gets               uint64 `json:"gets"`
+	Deletes            uint64 `json:"deletes"`
+	Flushes            uint64 `json:"flushes"`
+}
+
+// StatsResponse is the full database snapshot served by /v1/stats. Unlike
+// MetricsResponse it includes the per-level table counts, the bytes held in
+// SSTables and the cache hit ratios.
+type StatsResponse struct {
+	MemtableEntries    int     `json:"memtable_entries"`
+	MemtableBytes      int64   `json:"memtable_bytes"`
+	ImmutableMemtables int     `json:"immutable_memtables"`
+	SSTables           int     `json:"sstables"`
+	LevelSSTables      []int   `json:"level_sstables"`
+	SSTableBytes       int64   `json:"sstable_bytes"`
+	Puts               uint64  `json:"puts"`
+	Gets               uint64  `json:"gets"`
+	Deletes            uint64  `json:"deletes"`
+	Flushes            uint64  `json:"flushes"`
+	Compactions        uint64  `json:"compactions"`
+	BlockCacheHitRatio float64 `json:"block_cache_hit_ratio"`
+	TableCacheHitRatio float64 `json:"table_cache_hit_ratio"`
+}
+
+// SSTableResponse describes one SSTable in the list served by /v1/sstables.
+type SSTableResponse struct {
+	FileName    string    `json:"file_name"`
+	Level       int       `json:"level"`
+	Version     int32     `json:"version"`
+	EntryCount  uint64    `json:"entry_count"`
+	MinKey      string    `json:"min_key"`
+	MaxKey      string    `json:"max_key"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	Compression string    `json:"compression"`
+}
+
+func (mc MetricsController) RegisterRoutes(r *mux.Router) {
+	r.HandleFunc("/v1/metrics", mc.Get).Methods(http.MethodGet)
+	r.HandleFunc("/v1/stats", mc.Stats).Methods(http.MethodGet)
+	r.HandleFunc("/v1/sstables", mc.SSTables).Methods(http.MethodGet)
+}
+
+func (mc MetricsController) Get(w http.ResponseWriter, r *http.Request) {
+	stats := mc.Db.Stats()
+	response := MetricsResponse{
+		MemtableEntries:    stats.MemtableEntries,
+		MemtableBytes:      stats.MemtableBytes,
+		ImmutableMemtables: stats.ImmutableMemtables,
+		SSTables:           stats.SSTables,
+		Puts:               stats.Puts,
+		Gets:               stats.Gets,
+		Deletes:            stats.Deletes,
+		Flushes:            stats.Flushes,
+	}
+
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		mc.Logger.Errorf("Failed to serialize metrics. error : %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJson)
+}
+
+func (mc MetricsController) Stats(w http.ResponseWriter, r *http.Request) {
+	stats := mc.Db.Stats()
+	response := StatsResponse{
+		MemtableEntries:    stats.MemtableEntries,
+		MemtableBytes:      stats.MemtableBytes,
+		ImmutableMemtables: stats.ImmutableMemtables,
+		SSTables:           stats.SSTables,
+		LevelSSTables:      stats.LevelSSTables,
+		SSTableBytes:       stats.SSTableBytes,
+		Puts:               stats.Puts,
+		Gets:               stats.Gets,
+		Deletes:            stats.Deletes,
+		Flushes:            stats.Flushes,
+		Compactions:        stats.Compactions,
+		BlockCacheHitRatio: stats.BlockCacheHitRatio,
+		TableCacheHitRatio: stats.TableCacheHitRatio,
+	}
+
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		mc.Logger.Errorf("Failed to serialize stats. error : %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJson)
+}
+
+func (mc MetricsController) SSTables(w http.ResponseWriter, r *http.Request) {
+	infos, err := mc.Db.SSTables()
+	if err != nil {
+		mc.Logger.Errorf("Failed to describe sstables. error : %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]SSTableResponse, 0, len(infos))
+	for _, info := range infos {
+		response = append(response, SSTableResponse{
+			FileName:    info.FileName,
+			Level:       info.Level,
+			Version:     info.Version,
+			EntryCount:  info.EntryCount,
+			MinKey:      info.MinKey,
+			MaxKey:      info.MaxKey,
+			Size:        info.Size,
+			CreatedAt:   info.CreatedAt.UTC(),
+			Compression: info.Compression.String(),
+		})
+	}
+
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		mc.Logger.Errorf("Failed to serialize sstables. error : %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJson)
+}
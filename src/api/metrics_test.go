@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AashishUpadhyay/goatdb/src/db"
+	"github.com/AashishUpadhyay/goatdb/src/metrics"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMetricsController(t *testing.T) {
+	t.Run("test_metrics_json_shape", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Stats").Return(db.Stats{MemtableEntries: 3, MemtableBytes: 300, SSTables: 2, Puts: 5, Gets: 4, Deletes: 1, Flushes: 2})
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		mc := MetricsController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/metrics", nil)
+		mc.Get(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		want := `{"memtable_entries":3,"memtable_bytes":300,"immutable_memtables":0,"sstables":2,"puts":5,"gets":4,"deletes":1,"flushes":2}`
+		if w.Body.String() != want {
+			t.Errorf("expected body %s, got %s", want, w.Body.String())
+		}
+	})
+
+	t.Run("test_metrics_count_operations", func(t *testing.T) {
+		currentTestDir, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("error getting current test directory: %s", err)
+		}
+		dataDir := filepath.Join(currentTestDir, ".testMetricsController")
+		defer os.RemoveAll(dataDir)
+
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		sstableMgr, err := db.NewFileManager(dataDir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		database, err := db.NewDb(db.Options{
+			MemtableThreshold: 1000,
+			SstableMgr:        sstableMgr,
+			Logger:            logger,
+		})
+		if err != nil {
+			t.Fatalf("error creating db: %v", err)
+		}
+
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: database}.RegisterRoutes(router)
+		MetricsController{Logger: db.NewLogger(logger, db.LevelInfo), Db: database}.RegisterRoutes(router)
+
+		for _, body := range []string{`{"key":"a","value":"1"}`, `{"key":"b","value":"2"}`} {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "/v1/kv", strings.NewReader(body))
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusCreated {
+				t.Fatalf("expected status code %d, got %d", http.StatusCreated, w.Code)
+			}
+		}
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/kv/a", nil)
+		router.ServeHTTP(w, r)
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest(http.MethodDelete, "/v1/kv/b", nil)
+		router.ServeHTTP(w, r)
+		if err := database.Flush(); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest(http.MethodGet, "/v1/metrics", nil)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var metrics MetricsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+			t.Fatalf("failed to decode metrics: %v", err)
+		}
+		expected := MetricsResponse{SSTables: 1, Puts: 2, Gets: 1, Deletes: 1, Flushes: 1}
+		if metrics != expected {
+			t.Errorf("expected %+v, got %+v", expected, metrics)
+		}
+	})
+	t.Run("test_stats_json_shape", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Stats").Return(db.Stats{
+			MemtableEntries:    3,
+			MemtableBytes:      300,
+			SSTables:           3,
+			LevelSSTables:      []int{1, 2},
+			SSTableBytes:       4096,
+			Puts:               5,
+			Gets:               4,
+			Compactions:        1,
+			BlockCacheHitRatio: 0.5,
+			TableCacheHitRatio: 0.75,
+		})
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		mc := MetricsController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/stats", nil)
+		mc.Stats(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		want := `{"memtable_entries":3,"memtable_bytes":300,"immutable_memtables":0,"sstables":3,"level_sstables":[1,2],"sstable_bytes":4096,"puts":5,"gets":4,"deletes":0,"flushes":0,"compactions":1,"block_cache_hit_ratio":0.5,"table_cache_hit_ratio":0.75}`
+		if w.Body.String() != want {
+			t.Errorf("expected body %s, got %s", want, w.Body.String())
+		}
+	})
+
+	t.Run("test_stats_reports_sstables", func(t *testing.T) {
+		currentTestDir, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("error getting current test directory: %s", err)
+		}
+		dataDir := filepath.Join(currentTestDir, ".testStatsController")
+		defer os.RemoveAll(dataDir)
+
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		sstableMgr, err := db.NewFileManager(dataDir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		database, err := db.NewDb(db.Options{
+			MemtableThreshold: 1000,
+			SstableMgr:        sstableMgr,
+			Logger:            logger,
+		})
+		if err != nil {
+			t.Fatalf("error creating db: %v", err)
+		}
+
+		router := mux.NewRouter()
+		MetricsController{Logger: db.NewLogger(logger, db.LevelInfo), Db: database}.RegisterRoutes(router)
+
+		if err := database.Put(db.Entry{Key: "a", Value: []byte("1")}); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if err := database.Flush(); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if _, err := database.Get("a"); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/stats", nil)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var stats StatsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("failed to decode stats: %v", err)
+		}
+		if stats.SSTables != 1 || len(stats.LevelSSTables) != 1 || stats.LevelSSTables[0] != 1 {
+			t.Errorf("expected one L0 sstable, got %+v", stats)
+		}
+		if stats.SSTableBytes <= 0 {
+			t.Errorf("expected sstable bytes to be reported, got %d", stats.SSTableBytes)
+		}
+		if stats.Puts != 1 || stats.Gets != 1 || stats.Flushes != 1 {
+			t.Errorf("expected one put, get and flush, got %+v", stats)
+		}
+	})
+	t.Run("test_sstables_json_shape", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("SSTables").Return([]db.SSTableInfo{{
+			FileName:    "1.sst",
+			Level:       1,
+			Version:     db.SSTableVersion,
+			EntryCount:  2,
+			MinKey:      "a",
+			MaxKey:      "b",
+			Size:        512,
+			CreatedAt:   time.Unix(0, 0),
+			Compression: db.CompressionGzip,
+		}}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		mc := MetricsController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/sstables", nil)
+		mc.SSTables(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		want := fmt.Sprintf(`[{"file_name":"1.sst","level":1,"version":%d,"entry_count":2,"min_key":"a","max_key":"b","size":512,"created_at":"1970-01-01T00:00:00Z","compression":"gzip"}]`, db.SSTableVersion)
+		if w.Body.String() != want {
+			t.Errorf("expected body %s, got %s", want, w.Body.String())
+		}
+	})
+
+	t.Run("test_sstables_error", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("SSTables").Return(nil, db.ErrDBClosed)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		mc := MetricsController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodGet, "/v1/sstables", nil)
+		mc.SSTables(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+	t.Run("test_request_latencies_recorded", func(t *testing.T) {
+		mockDb := new(MockDB)
+		mockDb.On("Put", mock.Anything).Return(nil)
+		mockDb.On("Get", mock.Anything).Return(db.Entry{Key: "a", Value: []byte("1")}, nil)
+		logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+		requestMetrics := metrics.NewExpvar()
+
+		router := mux.NewRouter()
+		KVController{Logger: db.NewLogger(logger, db.LevelInfo), Db: mockDb, Metrics: requestMetrics}.RegisterRoutes(router)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(http.MethodPost, "/v1/kv", strings.NewReader(`{"key":"a","value":"1"}`))
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status code %d, got %d", http.StatusCreated, w.Code)
+		}
+		for i := 0; i < 2; i++ {
+			w = httptest.NewRecorder()
+			r, _ = http.NewRequest(http.MethodGet, "/v1/kv/a", nil)
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+		}
+
+		if got := requestMetrics.Request("post").Count(); got != 1 {
+			t.Errorf("expected 1 post observed, got %d", got)
+		}
+		if got := requestMetrics.Request("get").Count(); got != 2 {
+			t.Errorf("expected 2 gets observed, got %d", got)
+		}
+	})
+}
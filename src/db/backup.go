@@ -0,0 +1,145 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// backupWriter is implemented by SSTable managers that can copy their tables
+// to another directory, such as SSTableFileSystemManager.
+type backupWriter interface {
+	Backup(levels [][]string, targetDir string) error
+}
+
+// Backup writes a consistent copy of the database to targetDir, which must
+// not exist or be empty. The memtables are flushed first, so the copy holds
+// every write made before Backup was called. The live SSTables are then
+// recorded and pinned under the lock, and copied along with a manifest
+// listing them once it is released, so writes carry on meanwhile. The copy
+// can be opened with NewDb or copied to a new data directory with Restore.
+func (db *LSM) Backup(targetDir string) error {
+	writer, ok := db.sstableMgr.(backupWriter)
+	if !ok {
+		return errors.New("sstable manager does not support backups")
+	}
+
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return ErrDBClosed
+	}
+	if err := db.flushFrozen(); err != nil {
+		db.mu.Unlock()
+		return fmt.Errorf("failed to flush before backup: %w", err)
+	}
+	levels := [][]string{append([]string{}, db.Sstables...)}
+	fileNames := append([]string{}, db.Sstables...)
+	for _, levelFiles := range db.levels {
+		levels = append(levels, append([]string{}, levelFiles...))
+		fileNames = append(fileNames, levelFiles...)
+	}
+	db.pinSSTables(fileNames...)
+	db.mu.Unlock()
+	defer db.unpinSSTables(fileNames...)
+
+	if err := writer.Backup(levels, targetDir); err != nil {
+		db.logger.Errorf("Error in backing up to %s: %v", targetDir, err)
+		return err
+	}
+	db.logger.Infof("Backed up %d sstables to %s", len(fileNames), targetDir)
+	return nil
+}
+
+// Restore copies the backup in sourceDir, written by Backup, to dataDir, which
+// must not exist or be empty. Every SSTable the backup lists is checked with
+// Verify first, and nothing is copied if one of them is damaged. The result
+// can be opened with NewDb.
+func Restore(sourceDir string, dataDir string) error {
+	if _, err := os.Stat(filepath.Join(sourceDir, ManifestFileName)); err != nil {
+		return fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+	mgr, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: sourceDir, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	source := mgr.(*SSTableFileSystemManager)
+	levels, err := source.ReadManifest()
+	if err != nil {
+		return err
+	}
+	for _, fileNames := range levels {
+		for _, fileName := range fileNames {
+			report, err := source.Verify(fileName)
+			if err != nil {
+				return fmt.Errorf("failed to verify %s: %w", fileName, err)
+			}
+			if !report.OK() {
+				return fmt.Errorf("backup is damaged: %s", report)
+			}
+		}
+	}
+	return source.Backup(levels, dataDir)
+}
+
+// Backup copies the SSTables of levels to targetDir, which must not exist or
+// be empty, and writes a manifest listing them there. Tables are hard linked
+// where possible: they are never changed once written, so the link stays a
+// faithful copy even after this manager deletes its own. Otherwise they are
+// copied and synced. Backup does not change DataDir, so it also works on a
+// ReadOnly manager.
+func (ssm SSTableFileSystemManager) Backup(levels [][]string, targetDir string) error {
+	dirEntries, err := os.ReadDir(targetDir)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(targetDir, os.ModePerm)
+	} else if err == nil && len(dirEntries) > 0 {
+		err = fmt.Errorf("directory %s is not empty", targetDir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup directory: %w", err)
+	}
+
+	for _, fileNames := range levels {
+		for _, fileName := range fileNames {
+			if err := ssm.copyTable(fileName, targetDir); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", fileName, err)
+			}
+		}
+	}
+
+	target := ssm
+	target.DataDir = targetDir
+	target.ReadOnly = false
+	return target.WriteManifest(levels)
+}
+
+// copyTable hard links fileName into targetDir, falling back to a synced copy
+// when the two are on different file systems.
+func (ssm SSTableFileSystemManager) copyTable(fileName string, targetDir string) error {
+	sourcePath := filepath.Join(ssm.DataDir, fileName)
+	targetPath := filepath.Join(targetDir, fileName)
+	if err := os.Link(sourcePath, targetPath); err == nil {
+		return nil
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	if err := ssm.syncFile(target); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testBackup")
+	backupDir := filepath.Join(currentTestDir, ".testBackupTarget")
+	restoreDir := filepath.Join(currentTestDir, ".testBackupRestore")
+	for _, dir := range []string{dataDir, backupDir, restoreDir} {
+		defer deleteDirectoryIfExists(dir)
+	}
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 50,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// Some of the keys are still in the memtable when the backup starts
+	const keys = 520
+	for i := 0; i < keys; i++ {
+		if err := database.Put(Entry{Key: fmt.Sprintf("key%04d", i), Value: []byte(fmt.Sprintf("value%d", i))}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+
+	// Writes carry on while the backup is taken
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5000; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := database.Put(Entry{Key: fmt.Sprintf("late%06d", i), Value: []byte("value")}); err != nil {
+				t.Errorf("Failed to put entry: %v", err)
+				return
+			}
+		}
+	}()
+	err = database.Backup(backupDir)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if err := database.Backup(backupDir); err == nil {
+		t.Errorf("expected an error backing up to a directory that is not empty")
+	}
+
+	checkKeys := func(dir string) {
+		t.Helper()
+		mgr, err := NewFileManager(dir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		copied, err := NewDb(Options{MemtableThreshold: 50, SstableMgr: mgr, Logger: logger})
+		if err != nil {
+			t.Fatalf("error opening copy in %s: %v", dir, err)
+		}
+		defer copied.Close()
+		for i := 0; i < keys; i++ {
+			key := fmt.Sprintf("key%04d", i)
+			entry, err := copied.Get(key)
+			if err != nil || string(entry.Value) != fmt.Sprintf("value%d", i) {
+				t.Fatalf("expected value%d for %s in %s, got %q, %v", i, key, dir, entry.Value, err)
+			}
+		}
+	}
+
+	if err := Restore(backupDir, restoreDir); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	checkKeys(restoreDir)
+	checkKeys(backupDir)
+
+	// A damaged backup is rejected before anything is restored. The table is
+	// rewritten rather than changed in place, since it may be a hard link to
+	// one of the database.
+	damagedDir := filepath.Join(restoreDir, "damaged")
+	database.mu.RLock()
+	fileName := database.Sstables[0]
+	database.mu.RUnlock()
+	if err := deleteDirectoryIfExists(backupDir); err != nil {
+		t.Fatalf("Failed to delete backup: %v", err)
+	}
+	if err := database.Backup(backupDir); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	path := filepath.Join(backupDir, fileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading file: %s", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("error removing file: %s", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+	flipByteAt(t, path, int64(len(data))/2)
+	if err := Restore(backupDir, damagedDir); err == nil {
+		t.Errorf("expected an error restoring a damaged backup")
+	}
+	if _, err := os.Stat(damagedDir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected nothing to be restored, got: %v", err)
+	}
+	if _, err := database.Get("key0000"); err != nil {
+		t.Errorf("expected the database to be unaffected by the damaged backup, got: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package db
+
+import "fmt"
+
+type OpType int
+
+const (
+	OpPut OpType = iota
+	OpDelete
+)
+
+// Op is a single write in a batch. Value and ExpiresAt are ignored for deletes.
+type Op struct {
+	Type      OpType
+	Key       string
+	Value     []byte
+	ExpiresAt int64
+}
+
+// WriteBatch applies ops in order as one unit. The batch is validated before
+// anything is written, and it is applied to the memtable under a single lock
+// so readers see either none or all of it. The memtable is flushed at most
+// once, after the whole batch is applied.
+//
+// Unlike Delete, a delete in a batch does not check that the key exists; it
+// always writes a tombstone.
+func (db *LSM) WriteBatch(ops []Op) error {
+	entries := make([]Entry, 0, len(ops))
+	for i, op := range ops {
+		var entry Entry
+		switch op.Type {
+		case OpPut:
+			entry = Entry{Key: op.Key, Value: op.Value, ExpiresAt: op.ExpiresAt}
+		case OpDelete:
+			entry = Entry{Key: op.Key, Tombstone: true}
+		default:
+			return fmt.Errorf("invalid op type %d at index %d", op.Type, i)
+		}
+		if err := db.checkSize(entry.Key, entry.Value); err != nil {
+			return fmt.Errorf("op %d: %w", i, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	for _, entry := range entries {
+		if entry.Tombstone {
+			db.counters.deletes.Add(1)
+		} else {
+			db.counters.puts.Add(1)
+		}
+		entry.SequenceNumber = db.nextSequence()
+		db.Memtable.Put(entry)
+	}
+	db.logger.Debugf("Applied batch of %d ops to memtable", len(entries))
+	if db.memtableFull() {
+		db.freezeMemtable()
+	}
+	return nil
+}
+
+// PutBatch stores entries as one batch; see WriteBatch.
+func (db *LSM) PutBatch(entries []Entry) error {
+	ops := make([]Op, 0, len(entries))
+	for _, entry := range entries {
+		ops = append(ops, Op{Type: OpPut, Key: entry.Key, Value: entry.Value, ExpiresAt: entry.ExpiresAt})
+	}
+	return db.WriteBatch(ops)
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// CountingMockSSTableManager records how many SSTables were written.
+type CountingMockSSTableManager struct {
+	MockSSTableManager
+	writes int
+}
+
+func (m *CountingMockSSTableManager) Write(fileName string, data []Entry) error {
+	m.writes++
+	return m.MockSSTableManager.Write(fileName, data)
+}
+
+func (m *CountingMockSSTableManager) WriteFromIterator(fileName string, it EntryIterator, count int) error {
+	return m.Write(fileName, collectEntries(it))
+}
+
+func TestWriteBatch(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mgr := &CountingMockSSTableManager{}
+	database, err := NewDb(Options{
+		MemtableThreshold: 5,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	err = database.Put(Entry{Key: "existing", Value: []byte("value")})
+	if err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	// The batch crosses the threshold twice over but flushes only once
+	ops := []Op{{Type: OpDelete, Key: "existing"}}
+	for i := 0; i < 10; i++ {
+		ops = append(ops, Op{Type: OpPut, Key: fmt.Sprintf("batch%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+	}
+	err = database.WriteBatch(ops)
+	if err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+
+	waitForFlushes(t, database)
+	if mgr.writes != 1 {
+		t.Fatalf("expected %d flush, got: %d", 1, mgr.writes)
+	}
+	if database.Memtable.Len() != 0 {
+		t.Fatalf("expected %d, got: %d", 0, database.Memtable.Len())
+	}
+	for i := 0; i < 10; i++ {
+		entry, err := database.Get(fmt.Sprintf("batch%d", i))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if string(entry.Value) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("expected value%d, got: %s", i, entry.Value)
+		}
+	}
+	_, err = database.Get("existing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestWriteBatchIsAllOrNothing(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        &MockSSTableManager{},
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	err = database.WriteBatch([]Op{
+		{Type: OpPut, Key: "first", Value: []byte("value")},
+		{Type: OpType(42), Key: "second"},
+	})
+	if err == nil {
+		t.Fatalf("expected an error for an invalid op")
+	}
+	if database.Memtable.Len() != 0 {
+		t.Fatalf("expected nothing to be applied, got %d entries", database.Memtable.Len())
+	}
+}
+
+func BenchmarkPutVsPutBatch(b *testing.B) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		b.Fatalf("error getting current test directory: %s", err)
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	entries := make([]Entry, 10000)
+	for i := range entries {
+		entries[i] = Entry{Key: fmt.Sprintf("key%05d", i), Value: []byte(fmt.Sprintf("value%05d", i))}
+	}
+
+	newDb := func(b *testing.B, dataDir string) *LSM {
+		ssm, err := NewFileManager(dataDir, logger)
+		if err != nil {
+			b.Fatalf("error creating file manager: %s", err)
+		}
+		database, err := NewDb(Options{
+			MemtableThreshold: 1000,
+			SstableMgr:        ssm,
+			Logger:            logger,
+		})
+		if err != nil {
+			b.Fatalf("error creating db: %v", err)
+		}
+		return database
+	}
+
+	b.Run("Put", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dataDir := filepath.Join(currentTestDir, ".benchmarkPut")
+			database := newDb(b, dataDir)
+			for _, entry := range entries {
+				if err := database.Put(entry); err != nil {
+					b.Fatalf("Failed to put entry: %v", err)
+				}
+			}
+			deleteDirectoryIfExists(dataDir)
+		}
+	})
+
+	b.Run("PutBatch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dataDir := filepath.Join(currentTestDir, ".benchmarkPutBatch")
+			database := newDb(b, dataDir)
+			if err := database.PutBatch(entries); err != nil {
+				b.Fatalf("Failed to put batch: %v", err)
+			}
+			deleteDirectoryIfExists(dataDir)
+		}
+	})
+}
@@ -0,0 +1,351 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+const (
+	// entryFlagTombstone marks a block record as a deleted key
+	entryFlagTombstone = 1 << 0
+	// entryFlagExpires marks a block record followed by its expiry time
+	entryFlagExpires = 1 << 1
+	// entryFlagSequence marks a block record followed by its sequence number
+	entryFlagSequence = 1 << 2
+	// entryFlagMerge marks a block record as a merge operand
+	entryFlagMerge = 1 << 3
+)
+
+// writeBlockEntries encodes entries in the current block format. Each record is
+// a uint32 key length, the key, a flags byte, the int64 expiry time and the
+// uint64 sequence number if the flags say so, a uint32 value length and the
+// value.
+func writeBlockEntries(w io.Writer, entries []Entry) error {
+	for _, entry := range entries {
+		var flags uint8
+		if entry.Tombstone {
+			flags |= entryFlagTombstone
+		}
+		if entry.ExpiresAt != 0 {
+			flags |= entryFlagExpires
+		}
+		if entry.SequenceNumber != 0 {
+			flags |= entryFlagSequence
+		}
+		if entry.Merge {
+			flags |= entryFlagMerge
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(len(entry.Key))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, entry.Key); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, flags); err != nil {
+			return err
+		}
+		if entry.ExpiresAt != 0 {
+			if err := binary.Write(w, binary.BigEndian, entry.ExpiresAt); err != nil {
+				return err
+			}
+		}
+		if entry.SequenceNumber != 0 {
+			if err := binary.Write(w, binary.BigEndian, entry.SequenceNumber); err != nil {
+				return err
+			}
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(len(entry.Value))); err != nil {
+			return err
+		}
+		if _, err := w.Write(entry.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodedEntrySize is the number of bytes writeBlockEntries takes to encode
+// entry.
+func encodedEntrySize(entry Entry) int {
+	size := 4 + len(entry.Key) + 1 + 4 + len(entry.Value)
+	if entry.ExpiresAt != 0 {
+		size += 8
+	}
+	if entry.SequenceNumber != 0 {
+		size += 8
+	}
+	return size
+}
+
+// decodeBlock parses the decompressed records of a block written with the given
+// file format version.
+func decodeBlock(data []byte, version int32) ([]Entry, error) {
+	switch {
+	case version < 3:
+		return decodeLineBlock(data)
+	case version == 3:
+		return decodeBase64Block(data)
+	default:
+		return decodeBinaryBlock(data)
+	}
+}
+
+func decodeBinaryBlock(data []byte) ([]Entry, error) {
+	var results []Entry
+	for len(data) > 0 {
+		key, rest, err := readLengthPrefixed(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block record key: %w", err)
+		}
+		if len(rest) < 1 {
+			return nil, fmt.Errorf("failed to read block record flags: %w", io.ErrUnexpectedEOF)
+		}
+		flags := rest[0]
+		rest = rest[1:]
+		var expiresAt int64
+		if flags&entryFlagExpires != 0 {
+			if len(rest) < 8 {
+				return nil, fmt.Errorf("failed to read block record expiry: %w", io.ErrUnexpectedEOF)
+			}
+			expiresAt = int64(binary.BigEndian.Uint64(rest))
+			rest = rest[8:]
+		}
+		var sequenceNumber uint64
+		if flags&entryFlagSequence != 0 {
+			if len(rest) < 8 {
+				return nil, fmt.Errorf("failed to read block record sequence number: %w", io.ErrUnexpectedEOF)
+			}
+			sequenceNumber = binary.BigEndian.Uint64(rest)
+			rest = rest[8:]
+		}
+		value, rest, err := readLengthPrefixed(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block record value: %w", err)
+		}
+		results = append(results, Entry{
+			Key:            string(key),
+			Value:          value,
+			Tombstone:      flags&entryFlagTombstone != 0,
+			ExpiresAt:      expiresAt,
+			SequenceNumber: sequenceNumber,
+			Merge:          flags&entryFlagMerge != 0,
+		})
+		data = rest
+	}
+	return results, nil
+}
+
+// decodeBase64Block parses version 3 blocks, whose records are a length
+// prefixed key followed by a length prefixed base64 JSON payload.
+func decodeBase64Block(data []byte) ([]Entry, error) {
+	var results []Entry
+	for len(data) > 0 {
+		_, rest, err := readLengthPrefixed(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block record key: %w", err)
+		}
+		payload, rest, err := readLengthPrefixed(rest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block record payload: %w", err)
+		}
+		entry, err := deserializeFromBase64(string(payload))
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize entry: %w", err)
+		}
+		results = append(results, entry)
+		data = rest
+	}
+	return results, nil
+}
+
+// decodeLineBlock parses version 1 and 2 blocks, which hold one
+// "key,base64payload" line per entry. The key is repeated inside the payload,
+// so only the part after the last comma is needed.
+func decodeLineBlock(data []byte) ([]Entry, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	var results []Entry
+	for scanner.Scan() {
+		line := scanner.Text()
+		separator := strings.LastIndex(line, ",")
+		if separator < 0 {
+			return nil, fmt.Errorf("malformed block record: %q", line)
+		}
+		entry, err := deserializeFromBase64(line[separator+1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize entry: %w", err)
+		}
+		results = append(results, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+	return results, nil
+}
+
+// readLengthPrefixed splits a uint32 length prefixed field off the front of data.
+func readLengthPrefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(len(data)) < uint64(length) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	return data[:length], data[length:], nil
+}
+
+// serializeToBase64 produces the JSON+base64 payload used by files before
+// version 4. It is only kept to test reading them.
+func serializeToBase64(entry Entry) (string, error) {
+	// Marshal the Entry struct to JSON
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	// Encode the JSON bytes to base64
+	base64Str := base64.StdEncoding.EncodeToString(jsonBytes)
+
+	return base64Str, nil
+}
+
+func deserializeFromBase64(base64Str string) (Entry, error) {
+	// Decode the base64-encoded string
+	jsonBytes, err := base64.StdEncoding.DecodeString(base64Str)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	// Unmarshal the JSON bytes into an Entry struct
+	var entry Entry
+	err = json.Unmarshal(jsonBytes, &entry)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return entry, nil
+}
+
+// blockIndexInterval is the number of records in each chunk of a version 8
+// block. Every chunk is compressed on its own and its first key is kept in the
+// block's index, so a lookup only decompresses the one chunk that can hold its
+// key.
+const blockIndexInterval = 32
+
+const (
+	// chunkFlagUncompressed marks a chunk stored as is, without the file's
+	// codec. It is set from version 10 on for chunks compression does not pay
+	// off for.
+	chunkFlagUncompressed = 1 << 0
+	// DefaultMinCompressSize is the encoded size below which chunks are not
+	// compressed at all.
+	DefaultMinCompressSize = 128
+	// maxCompressedChunkRatio is the largest compressed to encoded size ratio
+	// for which a chunk is stored compressed. Chunks that shrink less are
+	// stored uncompressed, which saves decompressing them on every read.
+	maxCompressedChunkRatio = 0.9
+)
+
+// blockChunk locates one chunk of a version 8 block.
+type blockChunk struct {
+	firstKey string
+	offset   uint64 // from the start of the file
+	size     uint32
+	checksum uint32
+	// uncompressed is set for chunks stored without the file's codec
+	uncompressed bool
+}
+
+// encodeBlock builds the body of a version 10 block: a uint32 index size, the
+// index and the chunks. The index is a uint32 chunk count followed by the
+// length prefixed first key, size, CRC32 and flags byte of every chunk. It
+// returns the body and the CRC32 of the index, which goes in the block header.
+// Chunks that encode to fewer than minCompressSize bytes are stored
+// uncompressed; the others are compressed with codec at level.
+func encodeBlock(entries []Entry, codec CompressionCodec, level int, minCompressSize int) ([]byte, uint32, error) {
+	var index, chunks bytes.Buffer
+	chunkCount := (len(entries) + blockIndexInterval - 1) / blockIndexInterval
+	binary.Write(&index, binary.BigEndian, uint32(chunkCount))
+	for start := 0; start < len(entries); start += blockIndexInterval {
+		end := start + blockIndexInterval
+		if end > len(entries) {
+			end = len(entries)
+		}
+		var encoded bytes.Buffer
+		if err := writeBlockEntries(&encoded, entries[start:end]); err != nil {
+			return nil, 0, err
+		}
+		stored, flags := encoded.Bytes(), uint8(chunkFlagUncompressed)
+		if codec != CompressionNone && encoded.Len() >= minCompressSize {
+			compressed, err := compressBlock(codec, level, encoded.Bytes())
+			if err != nil {
+				return nil, 0, err
+			}
+			if float64(len(compressed)) <= maxCompressedChunkRatio*float64(encoded.Len()) {
+				stored, flags = compressed, 0
+			}
+		}
+		chunks.Write(stored)
+
+		binary.Write(&index, binary.BigEndian, uint32(len(entries[start].Key)))
+		index.WriteString(entries[start].Key)
+		binary.Write(&index, binary.BigEndian, uint32(len(stored)))
+		binary.Write(&index, binary.BigEndian, crc32.ChecksumIEEE(stored))
+		index.WriteByte(flags)
+	}
+
+	body := make([]byte, 4, 4+index.Len()+chunks.Len())
+	binary.BigEndian.PutUint32(body, uint32(index.Len()))
+	body = append(body, index.Bytes()...)
+	body = append(body, chunks.Bytes()...)
+	return body, crc32.ChecksumIEEE(index.Bytes()), nil
+}
+
+// decodeBlockIndex parses the index of a block written with the given file
+// format version, at least 8, whose first chunk starts at offset chunksOffset
+// in the file. Before version 10 index entries have no flags and every chunk is
+// compressed.
+func decodeBlockIndex(data []byte, chunksOffset uint64, version int32) ([]blockChunk, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("failed to read block index count: %w", io.ErrUnexpectedEOF)
+	}
+	count := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	chunks := make([]blockChunk, 0, count)
+	offset := chunksOffset
+	for i := uint32(0); i < count; i++ {
+		key, rest, err := readLengthPrefixed(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block index key: %w", err)
+		}
+		if len(rest) < 8 {
+			return nil, fmt.Errorf("failed to read block index entry: %w", io.ErrUnexpectedEOF)
+		}
+		chunk := blockChunk{
+			firstKey: string(key),
+			offset:   offset,
+			size:     binary.BigEndian.Uint32(rest),
+			checksum: binary.BigEndian.Uint32(rest[4:]),
+		}
+		rest = rest[8:]
+		if version >= 10 {
+			if len(rest) < 1 {
+				return nil, fmt.Errorf("failed to read block index entry: %w", io.ErrUnexpectedEOF)
+			}
+			chunk.uncompressed = rest[0]&chunkFlagUncompressed != 0
+			rest = rest[1:]
+		}
+		chunks = append(chunks, chunk)
+		offset += uint64(chunk.size)
+		data = rest
+	}
+	return chunks, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultBlockCacheSize = 32 << 20 // 32MB
+
+// blockCacheEntryOverhead approximates the memory an Entry takes beyond its key
+// and value bytes.
+const blockCacheEntryOverhead = 48
+
+type blockCacheKey struct {
+	fileName string
+	offset   uint64
+}
+
+// blockCache keeps decoded data blocks in memory so hot blocks are not read and
+// decompressed on every lookup. It holds at most capacity bytes of entries and
+// evicts the least recently used block when full. Cached entries are shared
+// with callers, who must not modify their values.
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	blocks   map[blockCacheKey]*list.Element
+	// files tracks the cached offsets of each file for invalidation
+	files  map[string]map[uint64]bool
+	lru    *list.List
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type blockCacheEntry struct {
+	key     blockCacheKey
+	entries []Entry
+	size    int64
+}
+
+// newBlockCache returns a cache holding up to capacity bytes, or nil when
+// capacity is not positive, which disables caching.
+func newBlockCache(capacity int64) *blockCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &blockCache{
+		capacity: capacity,
+		blocks:   make(map[blockCacheKey]*list.Element),
+		files:    make(map[string]map[uint64]bool),
+		lru:      list.New(),
+	}
+}
+
+// Like the other caches, a nil blockCache is valid and never holds anything.
+func (c *blockCache) get(fileName string, offset uint64) ([]Entry, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.blocks[blockCacheKey{fileName, offset}]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*blockCacheEntry).entries, true
+}
+
+func (c *blockCache) put(fileName string, offset uint64, entries []Entry) {
+	if c == nil {
+		return
+	}
+	size := int64(0)
+	for _, entry := range entries {
+		size += int64(len(entry.Key)+len(entry.Value)) + blockCacheEntryOverhead
+	}
+	if size > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := blockCacheKey{fileName, offset}
+	if elem, ok := c.blocks[key]; ok {
+		c.removeElement(elem)
+	}
+	c.blocks[key] = c.lru.PushFront(&blockCacheEntry{key: key, entries: entries, size: size})
+	if c.files[fileName] == nil {
+		c.files[fileName] = make(map[uint64]bool)
+	}
+	c.files[fileName][offset] = true
+	c.size += size
+	for c.size > c.capacity {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// removeFile drops every cached block of fileName.
+func (c *blockCache) removeFile(fileName string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for offset := range c.files[fileName] {
+		c.removeElement(c.blocks[blockCacheKey{fileName, offset}])
+	}
+}
+
+// removeElement unlinks a cached block. The caller must hold c.mu.
+func (c *blockCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*blockCacheEntry)
+	c.lru.Remove(elem)
+	delete(c.blocks, entry.key)
+	delete(c.files[entry.key.fileName], entry.key.offset)
+	if len(c.files[entry.key.fileName]) == 0 {
+		delete(c.files, entry.key.fileName)
+	}
+	c.size -= entry.size
+}
+
+// BlockCacheStats reports how many block reads were served from the block
+// cache and how many had to go to disk.
+func (ssm SSTableFileSystemManager) BlockCacheStats() (hits uint64, misses uint64) {
+	if ssm.blocks == nil {
+		return 0, 0
+	}
+	return ssm.blocks.hits.Load(), ssm.blocks.misses.Load()
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockCacheEvictsByBytes(t *testing.T) {
+	// Each block below takes 2 * (2 + 8 + overhead) bytes; three do not fit
+	block := []Entry{{Key: "k1", Value: make([]byte, 8)}, {Key: "k2", Value: make([]byte, 8)}}
+	blockSize := int64(2 * (2 + 8 + blockCacheEntryOverhead))
+	cache := newBlockCache(2*blockSize + 1)
+
+	cache.put("a.sst", 10, block)
+	cache.put("a.sst", 20, block)
+	if _, ok := cache.get("a.sst", 10); !ok {
+		t.Fatalf("expected block 10 to be cached")
+	}
+	cache.put("b.sst", 10, block)
+	if _, ok := cache.get("a.sst", 20); ok {
+		t.Fatalf("expected the least recently used block to be evicted")
+	}
+	if cache.size != 2*blockSize {
+		t.Fatalf("expected size %d, got: %d", 2*blockSize, cache.size)
+	}
+
+	cache.removeFile("a.sst")
+	if _, ok := cache.get("a.sst", 10); ok {
+		t.Fatalf("expected blocks of a.sst to be removed")
+	}
+	if _, ok := cache.get("b.sst", 10); !ok {
+		t.Fatalf("expected blocks of b.sst to stay cached")
+	}
+	if cache.size != blockSize {
+		t.Fatalf("expected size %d, got: %d", blockSize, cache.size)
+	}
+}
+
+func TestBlockCacheStats(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testBlockCacheStats")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mgr, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	ssm := mgr.(*SSTableFileSystemManager)
+
+	data := make([]Entry, 300)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%03d", i), Value: []byte(fmt.Sprintf("value_%03d", i))}
+	}
+	fileName := "stats.sst"
+	if err := ssm.Write(fileName, data); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	// Two lookups in the first block and one in the second
+	for _, key := range []string{"data_010", "data_020", "data_150"} {
+		if _, err := ssm.FindKey(fileName, key); err != nil {
+			t.Fatalf("error finding key %s: %s", key, err)
+		}
+	}
+	hits, misses := ssm.BlockCacheStats()
+	if hits != 1 || misses != 2 {
+		t.Fatalf("expected 1 hit and 2 misses, got: %d hits and %d misses", hits, misses)
+	}
+
+	// Rewriting the file drops its blocks
+	if err := ssm.Write(fileName, data); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+	if _, err := ssm.FindKey(fileName, "data_010"); err != nil {
+		t.Fatalf("error finding key: %s", err)
+	}
+	hits, misses = ssm.BlockCacheStats()
+	if hits != 1 || misses != 3 {
+		t.Fatalf("expected 1 hit and 3 misses, got: %d hits and %d misses", hits, misses)
+	}
+}
+
+func TestSecondLookupServedFromBlockCache(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testSecondLookupServedFromBlockCache")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	data := []Entry{{Key: "key", Value: []byte("value")}}
+	fileName := "cached.sst"
+	if err := ssm.Write(fileName, data); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+	if _, err := ssm.FindKey(fileName, "key"); err != nil {
+		t.Fatalf("error finding key: %s", err)
+	}
+
+	// Clobber the only block on disk. Reading it again would fail its checksum.
+	file, err := os.OpenFile(filepath.Join(dataDir, fileName), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	defer file.Close()
+	_, err = file.WriteAt(make([]byte, 8), fileHeaderSize(SSTableVersion)+BlockHeaderSize)
+	if err != nil {
+		t.Fatalf("error corrupting block: %s", err)
+	}
+
+	entry, err := ssm.FindKey(fileName, "key")
+	if err != nil {
+		t.Fatalf("expected the block to come from the cache, got: %s", err)
+	}
+	if string(entry.Value) != "value" {
+		t.Fatalf("expected value, got: %s", entry.Value)
+	}
+
+	uncached, err := NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:        dataDir,
+		Logger:         logger,
+		BlockCacheSize: -1,
+	})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	if _, err := uncached.FindKey(fileName, "key"); err == nil {
+		t.Fatalf("expected reading the corrupted block from disk to fail")
+	}
+}
+
+func BenchmarkFindKeyZipfian(b *testing.B) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		b.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".benchmarkFindKeyZipfian")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(io.Discard, "", 0)
+
+	data := make([]Entry, 100000)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%06d", i), Value: []byte(fmt.Sprintf("value_%06d", i))}
+	}
+
+	for _, bm := range []struct {
+		name           string
+		blockCacheSize int64
+	}{
+		{"uncached", -1},
+		{"cached", DefaultBlockCacheSize},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+				DataDir:        dataDir,
+				Logger:         logger,
+				BlockCacheSize: bm.blockCacheSize,
+			})
+			if err != nil {
+				b.Fatalf("error creating file manager: %s", err)
+			}
+			fileName := "zipfian.sst"
+			if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+				b.Fatalf("error writing file: %s", err)
+			}
+
+			zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, uint64(len(data)-1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ssm.FindKey(fileName, data[zipf.Uint64()].Key); err != nil {
+					b.Fatalf("error finding key: %s", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func binaryTestEntries() []Entry {
+	allBytes := make([]byte, 256)
+	for i := range allBytes {
+		allBytes[i] = byte(i)
+	}
+	return []Entry{
+		{Key: "comma,key", Value: []byte("value1")},
+		{Key: "newline\nkey", Value: allBytes},
+		{Key: "tombstone", Tombstone: true},
+		{Key: "ttl", Value: []byte("expiring"), ExpiresAt: 1700000000123456789, SequenceNumber: 42},
+		{Key: "zero\x00byte", Value: []byte{0, 0, 0}},
+		{Key: "ünïcødé ключ 键", Value: []byte("value with ünïcødé")},
+	}
+}
+
+func TestBinaryBlockRoundTrip(t *testing.T) {
+	entries := binaryTestEntries()
+
+	var buf bytes.Buffer
+	if err := writeBlockEntries(&buf, entries); err != nil {
+		t.Fatalf("error writing block: %v", err)
+	}
+
+	decoded, err := decodeBlock(buf.Bytes(), SSTableVersion)
+	if err != nil {
+		t.Fatalf("error decoding block: %v", err)
+	}
+	if len(decoded) != len(entries) {
+		t.Fatalf("expected %d entries, got: %d", len(entries), len(decoded))
+	}
+	for i, entry := range decoded {
+		if entry.Key != entries[i].Key || !bytes.Equal(entry.Value, entries[i].Value) || entry.Tombstone != entries[i].Tombstone || entry.ExpiresAt != entries[i].ExpiresAt || entry.SequenceNumber != entries[i].SequenceNumber {
+			t.Fatalf("mismatch at index %d: expected %v, got %v", i, entries[i], entry)
+		}
+	}
+
+	// A truncated block must not decode
+	_, err = decodeBlock(buf.Bytes()[:buf.Len()-1], SSTableVersion)
+	if err == nil {
+		t.Fatalf("expected error decoding a truncated block, got nil")
+	}
+}
+
+func TestDecodeLegacyBlocks(t *testing.T) {
+	entries := binaryTestEntries()
+
+	// Version 3: length-prefixed key and base64 JSON payload
+	var v3 bytes.Buffer
+	for _, entry := range entries {
+		payload, err := serializeToBase64(entry)
+		if err != nil {
+			t.Fatalf("error serializing entry: %v", err)
+		}
+		binary.Write(&v3, binary.BigEndian, uint32(len(entry.Key)))
+		v3.WriteString(entry.Key)
+		binary.Write(&v3, binary.BigEndian, uint32(len(payload)))
+		v3.WriteString(payload)
+	}
+
+	// Versions 1 and 2: one "key,payload" line per entry, which only works for
+	// keys without newlines
+	var v1 bytes.Buffer
+	for _, entry := range entries {
+		if bytes.ContainsRune([]byte(entry.Key), '\n') {
+			continue
+		}
+		payload, err := serializeToBase64(entry)
+		if err != nil {
+			t.Fatalf("error serializing entry: %v", err)
+		}
+		v1.WriteString(fmt.Sprintf("%s,%s\n", entry.Key, payload))
+	}
+
+	for _, tc := range []struct {
+		version  int32
+		data     []byte
+		expected int
+	}{
+		{version: 3, data: v3.Bytes(), expected: len(entries)},
+		{version: 1, data: v1.Bytes(), expected: len(entries) - 1},
+	} {
+		decoded, err := decodeBlock(tc.data, tc.version)
+		if err != nil {
+			t.Fatalf("error decoding version %d block: %v", tc.version, err)
+		}
+		if len(decoded) != tc.expected {
+			t.Fatalf("expected %d entries in version %d block, got: %d", tc.expected, tc.version, len(decoded))
+		}
+		for _, entry := range decoded {
+			found := false
+			for _, original := range entries {
+				if entry.Key == original.Key && bytes.Equal(entry.Value, original.Value) && entry.Tombstone == original.Tombstone {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("unexpected entry in version %d block: %v", tc.version, entry)
+			}
+		}
+	}
+}
+
+func TestDecodeBlockIndexBeforeChunkFlags(t *testing.T) {
+	// A version 9 index entry is the first key, size and checksum, without flags
+	var index bytes.Buffer
+	binary.Write(&index, binary.BigEndian, uint32(1))
+	binary.Write(&index, binary.BigEndian, uint32(len("key")))
+	index.WriteString("key")
+	binary.Write(&index, binary.BigEndian, uint32(10))
+	binary.Write(&index, binary.BigEndian, uint32(1234))
+
+	chunks, err := decodeBlockIndex(index.Bytes(), 100, 9)
+	if err != nil {
+		t.Fatalf("error decoding block index: %v", err)
+	}
+	expected := blockChunk{firstKey: "key", offset: 100, size: 10, checksum: 1234}
+	if len(chunks) != 1 || chunks[0] != expected {
+		t.Fatalf("expected %+v, got: %+v", expected, chunks)
+	}
+}
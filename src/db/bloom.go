@@ -0,0 +1,102 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+const DefaultBloomFalsePositiveRate = 0.01
+
+// bloomFilter is a fixed size Bloom filter over SSTable keys. It never reports
+// a present key as absent, so a negative answer lets a lookup skip the file.
+type bloomFilter struct {
+	bits      []byte
+	hashCount uint32
+}
+
+// newBloomFilter sizes a filter for expectedKeys keys at the given false
+// positive rate.
+func newBloomFilter(expectedKeys int, falsePositiveRate float64) *bloomFilter {
+	if expectedKeys < 1 {
+		expectedKeys = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = DefaultBloomFalsePositiveRate
+	}
+
+	bitCount := math.Ceil(-float64(expectedKeys) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashCount := math.Round(bitCount / float64(expectedKeys) * math.Ln2)
+	if hashCount < 1 {
+		hashCount = 1
+	}
+
+	return &bloomFilter{
+		bits:      make([]byte, (int(bitCount)+7)/8),
+		hashCount: uint32(hashCount),
+	}
+}
+
+func (bf *bloomFilter) add(key string) {
+	h1, h2 := bloomHashes(key)
+	bitCount := uint32(len(bf.bits) * 8)
+	for i := uint32(0); i < bf.hashCount; i++ {
+		bit := (h1 + i*h2) % bitCount
+		bf.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (bf *bloomFilter) mayContain(key string) bool {
+	h1, h2 := bloomHashes(key)
+	bitCount := uint32(len(bf.bits) * 8)
+	for i := uint32(0); i < bf.hashCount; i++ {
+		bit := (h1 + i*h2) % bitCount
+		if bf.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes derives the two base hashes used for double hashing.
+func bloomHashes(key string) (uint32, uint32) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	sum := hasher.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// writeTo serializes the filter as hash count, bit array length and bit array.
+func (bf *bloomFilter) writeTo(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, bf.hashCount); err != nil {
+		return fmt.Errorf("failed to write bloom filter hash count: %w", err)
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(bf.bits))); err != nil {
+		return fmt.Errorf("failed to write bloom filter length: %w", err)
+	}
+	if _, err := w.Write(bf.bits); err != nil {
+		return fmt.Errorf("failed to write bloom filter: %w", err)
+	}
+	return nil
+}
+
+func readBloomFilter(r io.Reader) (*bloomFilter, error) {
+	bf := &bloomFilter{}
+	if err := binary.Read(r, binary.BigEndian, &bf.hashCount); err != nil {
+		return nil, fmt.Errorf("failed to read bloom filter hash count: %w", err)
+	}
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("failed to read bloom filter length: %w", err)
+	}
+	if bf.hashCount == 0 || length == 0 {
+		return nil, fmt.Errorf("invalid bloom filter")
+	}
+	bf.bits = make([]byte, length)
+	if _, err := io.ReadFull(r, bf.bits); err != nil {
+		return nil, fmt.Errorf("failed to read bloom filter: %w", err)
+	}
+	return bf, nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilterHasNoFalseNegatives(t *testing.T) {
+	filter := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		filter.add(fmt.Sprintf("key%d", i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !filter.mayContain(fmt.Sprintf("key%d", i)) {
+			t.Fatalf("expected filter to contain key%d", i)
+		}
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	filter := newBloomFilter(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		filter.add(fmt.Sprintf("key%d", i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.mayContain(fmt.Sprintf("missing%d", i)) {
+			falsePositives++
+		}
+	}
+
+	// Allow some slack over the configured 1% rate
+	if falsePositives > 300 {
+		t.Fatalf("expected at most %d false positives, got: %d", 300, falsePositives)
+	}
+}
+
+func TestBloomFilterSerialization(t *testing.T) {
+	filter := newBloomFilter(100, 0.01)
+	for i := 0; i < 100; i++ {
+		filter.add(fmt.Sprintf("key%d", i))
+	}
+
+	var buf bytes.Buffer
+	if err := filter.writeTo(&buf); err != nil {
+		t.Fatalf("error writing bloom filter: %v", err)
+	}
+
+	decoded, err := readBloomFilter(&buf)
+	if err != nil {
+		t.Fatalf("error reading bloom filter: %v", err)
+	}
+
+	if decoded.hashCount != filter.hashCount || !bytes.Equal(decoded.bits, filter.bits) {
+		t.Fatalf("expected decoded filter to match the original")
+	}
+}
@@ -0,0 +1,259 @@
+package db
+
+import "sort"
+
+const (
+	// DefaultCompactionMinThreshold is the number of similarly sized SSTables
+	// that make up a tier worth compacting.
+	DefaultCompactionMinThreshold = 4
+	// compactionSmallTableSize puts every SSTable smaller than this in the same
+	// tier, so freshly flushed tables are merged despite small size differences.
+	compactionSmallTableSize = 1 << 20
+	// A table belongs to a tier while its size is within these bounds of the
+	// tier's average size.
+	compactionTierLow  = 0.5
+	compactionTierHigh = 1.5
+)
+
+// Compact runs one round of size-tiered compaction. It looks for the oldest run
+// of adjacent, similarly sized SSTables that is at least the configured minimum
+// threshold long and merges it into a single table that keeps only the newest
+// record of each key. Merging only adjacent tables keeps the newest-first
+// lookup order intact. Nothing is done if no tier is large enough.
+//
+// The merge runs without holding db.mu so Puts and Gets continue meanwhile;
+// the lock is only taken to pick the inputs and to swap in the result.
+//
+// Compact returns ErrReadOnly on a ReadOnly database. With LeveledCompaction,
+// Compact instead runs one round of leveled
+// compaction on the level furthest over its target; see compactLevel.
+func (db *LSM) Compact() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if db.leveling.enabled {
+		_, err := db.compactNextLevel()
+		return err
+	}
+
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+
+	start, end, _, err := db.sizeTier()
+	if err != nil || end-start < 2 {
+		return err
+	}
+	return db.compactRange(start, end)
+}
+
+// compactL0Overflow runs one round of compaction if L0 holds more than
+// MaxL0Files tables. It merges the tier Compact would pick or, when no tier is
+// large enough, every L0 table, so that L0 shrinks either way. It reports
+// whether anything was compacted.
+func (db *LSM) compactL0Overflow() (bool, error) {
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+
+	start, end, count, err := db.sizeTier()
+	if err != nil || count <= db.leveling.maxL0Files {
+		return false, err
+	}
+	if end-start < 2 {
+		start, end = 0, count
+	}
+	if err := db.compactRange(start, end); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sizeTier returns the bounds in db.Sstables of the oldest run of similarly
+// sized tables that is at least db.compactionMinThreshold long, or an empty
+// range if there is none, along with the number of L0 tables. The caller must
+// hold db.compactionMu.
+func (db *LSM) sizeTier() (int, int, int, error) {
+	// Only compactions remove SSTables from the list, so it can be read
+	// outside db.mu while compactionMu is held
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return 0, 0, 0, ErrDBClosed
+	}
+	sstables := append([]string{}, db.Sstables...)
+	db.mu.RUnlock()
+
+	sizes := make([]int64, len(sstables))
+	for i, fileName := range sstables {
+		size, err := db.sstableMgr.Size(fileName)
+		if err != nil {
+			db.logger.Errorf("Error in reading size of sstable %s: %v", fileName, err)
+			return 0, 0, 0, err
+		}
+		sizes[i] = size
+	}
+
+	start, end := selectSizeTier(sizes, db.compactionMinThreshold)
+	return start, end, len(sstables), nil
+}
+
+// compactRange merges db.Sstables[start:end] into one SSTable. Expired entries
+// become tombstones, and tombstones are dropped unless an older SSTable, in L0
+// or a deeper level, may still hold a record they shadow. Merge operands are
+// combined with the records they apply to, or turned into values when no
+// older SSTable may hold one. The caller must hold db.compactionMu.
+func (db *LSM) compactRange(start int, end int) error {
+	db.mu.Lock()
+	older := []string{}
+	for _, fileNames := range db.levels {
+		older = append(older, fileNames...)
+	}
+	older = append(older, db.Sstables[:start]...)
+	inputs := append([]string{}, db.Sstables[start:end]...)
+	output := db.newSSTableName()
+	pinned := append(append([]string{output}, older...), inputs...)
+	db.pinSSTables(pinned...)
+	db.mu.Unlock()
+	defer db.unpinSSTables(pinned...)
+
+	db.logger.Infof("Compacting %d sstables into %s", len(inputs), output)
+	tables := make([][]Entry, 0, len(inputs))
+	for _, fileName := range inputs {
+		entries, err := db.sstableMgr.ReadAll(fileName)
+		if err != nil {
+			db.logger.Errorf("Error in reading sstable %s for compaction: %v", fileName, err)
+			return err
+		}
+		tables = append(tables, entries)
+	}
+
+	merged := []Entry{}
+	m := db.merger(db.now())
+	for _, entry := range expireEntries(m.mergeEntries(tables, false), m.now) {
+		switch {
+		case entry.Tombstone && !db.mayShadowOlderData(older, entry.Key):
+			continue
+		case entry.Merge && !db.mayShadowOlderData(older, entry.Key):
+			// No older record is left for the operand to apply to
+			entry = m.resolve(entry)
+		}
+		merged = append(merged, entry)
+	}
+
+	var outputs []string
+	if len(merged) > 0 {
+		err := db.sstableMgr.Write(output, merged)
+		if err != nil {
+			db.logger.Errorf("Error in writing compacted sstable %s: %v", output, err)
+			return err
+		}
+		outputs = append(outputs, output)
+	}
+
+	// Flushes that happened during the merge were appended after the inputs
+	db.mu.Lock()
+	sstables := append(append(append([]string{}, db.Sstables[:start]...), outputs...), db.Sstables[end:]...)
+	err := db.sstableMgr.WriteManifest(append([][]string{sstables}, db.levels...))
+	if err != nil {
+		db.mu.Unlock()
+		db.logger.Errorf("Error in writing manifest: %v", err)
+		return err
+	}
+	db.Sstables = sstables
+	for _, fileName := range inputs {
+		delete(db.tableInfo, fileName)
+	}
+	if len(outputs) > 0 {
+		db.tableInfo[output] = tableInfoOf(merged)
+	}
+	db.mu.Unlock()
+
+	// The inputs are deleted once this compaction and any other reader unpin them
+	for _, fileName := range inputs {
+		db.removeSSTable(fileName)
+	}
+	db.counters.compactions.Add(1)
+	if db.metrics != nil {
+		db.metrics.AddCompactionBytes(db.tableBytes(inputs), db.tableBytes(outputs))
+	}
+	db.logger.Infof("Compacted %d sstables into %s", len(inputs), output)
+	return nil
+}
+
+// mayShadowOlderData reports whether a tombstone for key may still hide a
+// record in one of the older SSTables and so has to be kept.
+func (db *LSM) mayShadowOlderData(older []string, key string) bool {
+	for _, fileName := range older {
+		mayContain, err := db.sstableMgr.MayContain(fileName, key)
+		if err != nil || mayContain {
+			return true
+		}
+	}
+	return false
+}
+
+// selectSizeTier returns the bounds of the oldest run of adjacent SSTables
+// whose sizes fall in the same tier and which holds at least minThreshold
+// tables. start == end when there is no such run.
+func selectSizeTier(sizes []int64, minThreshold int) (int, int) {
+	for start := 0; start < len(sizes); start++ {
+		end := start
+		var total int64
+		for end < len(sizes) && (end == start || sameSizeTier(total/int64(end-start), sizes[end])) {
+			total += sizes[end]
+			end++
+		}
+		if end-start >= minThreshold {
+			return start, end
+		}
+	}
+	return 0, 0
+}
+
+func sameSizeTier(average int64, size int64) bool {
+	if average < compactionSmallTableSize && size < compactionSmallTableSize {
+		return true
+	}
+	return float64(size) >= compactionTierLow*float64(average) &&
+		float64(size) <= compactionTierHigh*float64(average)
+}
+
+// mergeEntries combines tables ordered oldest first, keeping the newest record
+// of every key: the one with the highest sequence number or, between equal
+// numbers, the one from the later table. Merge operands are combined with the
+// records before them, and stay operands if there are none. Tombstones are
+// removed when dropTombstones is set. The result is sorted by key.
+func (m merger) mergeEntries(tables [][]Entry, dropTombstones bool) []Entry {
+	newest := make(map[string]Entry)
+	// Every record of a key with merge operands is kept, to be folded once
+	// all of them are known
+	history := make(map[string][]Entry)
+	for _, table := range tables {
+		for _, entry := range table {
+			existing, ok := newest[entry.Key]
+			if ok && (entry.Merge || existing.Merge || history[entry.Key] != nil) {
+				if history[entry.Key] == nil {
+					history[entry.Key] = []Entry{existing}
+				}
+				history[entry.Key] = append(history[entry.Key], entry)
+			}
+			if !ok || entry.SequenceNumber >= existing.SequenceNumber {
+				newest[entry.Key] = entry
+			}
+		}
+	}
+	for key, records := range history {
+		newest[key] = m.fold(records)
+	}
+
+	merged := make([]Entry, 0, len(newest))
+	for _, entry := range newest {
+		if dropTombstones && entry.Tombstone {
+			continue
+		}
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Key < merged[j].Key
+	})
+	return merged
+}
@@ -0,0 +1,465 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testCompact")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// Every flush holds a new version of "counter" plus one other key
+	for i := 0; i < 3; i++ {
+		err := database.Put(Entry{Key: "counter", Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		err = database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+
+	// The tombstone for key0 lands in a fourth SSTable
+	err = database.Delete("key0")
+	if err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	err = database.Put(Entry{Key: "key3", Value: []byte("value3")})
+	if err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	waitForFlushes(t, database)
+	obsolete := append([]string{}, database.Sstables...)
+	if len(obsolete) != 4 {
+		t.Fatalf("expected %d, got: %d", 4, len(obsolete))
+	}
+
+	err = database.Compact()
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+
+	if len(database.Sstables) != 1 {
+		t.Fatalf("expected %d, got: %d", 1, len(database.Sstables))
+	}
+	for _, fileName := range obsolete {
+		if _, err := os.Stat(filepath.Join(dataDir, fileName)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be deleted, got: %v", fileName, err)
+		}
+	}
+
+	entry, err := database.Get("counter")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if string(entry.Value) != "value2" {
+		t.Fatalf("Expected value2, got %s", string(entry.Value))
+	}
+
+	_, err = database.Get("key0")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	entries, err := ssm.ReadAll(database.Sstables[0])
+	if err != nil {
+		t.Fatalf("error reading compacted sstable: %s", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected %d entries without the tombstone, got: %d", 4, len(entries))
+	}
+
+	// A flush after compaction must not reuse an existing file name
+	for i := 4; i < 6; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+	if len(database.Sstables) != 2 || database.Sstables[0] == database.Sstables[1] {
+		t.Fatalf("expected two distinct sstables, got: %v", database.Sstables)
+	}
+
+	manifest, err := ssm.ReadManifest()
+	if err != nil {
+		t.Fatalf("error reading manifest: %s", err)
+	}
+	if len(manifest) != 1 || len(manifest[0]) != 2 || manifest[0][0] != database.Sstables[0] || manifest[0][1] != database.Sstables[1] {
+		t.Fatalf("expected manifest %v, got: %v", database.Sstables, manifest)
+	}
+}
+
+func TestMergeEntries(t *testing.T) {
+	tables := [][]Entry{
+		{{Key: "a", Value: []byte("old")}, {Key: "b", Value: []byte("old")}},
+		{{Key: "a", Value: []byte("new")}, {Key: "c", Value: []byte("new")}},
+		{{Key: "b", Tombstone: true}},
+	}
+
+	merged := merger{}.mergeEntries(tables, false)
+	if len(merged) != 3 {
+		t.Fatalf("expected %d, got: %d", 3, len(merged))
+	}
+	if merged[0].Key != "a" || string(merged[0].Value) != "new" {
+		t.Fatalf("expected newest value for a, got: %v", merged[0])
+	}
+	if merged[1].Key != "b" || !merged[1].Tombstone {
+		t.Fatalf("expected tombstone for b, got: %v", merged[1])
+	}
+
+	merged = merger{}.mergeEntries(tables, true)
+	if len(merged) != 2 || merged[0].Key != "a" || merged[1].Key != "c" {
+		t.Fatalf("expected a and c without the tombstone, got: %v", merged)
+	}
+}
+
+func TestMergeEntriesPrefersHigherSequenceNumber(t *testing.T) {
+	// The first table holds the newer write of a despite coming first
+	tables := [][]Entry{
+		{{Key: "a", Value: []byte("new"), SequenceNumber: 5}},
+		{{Key: "a", Value: []byte("old"), SequenceNumber: 2}, {Key: "b", Value: []byte("b"), SequenceNumber: 3}},
+	}
+
+	merged := merger{}.mergeEntries(tables, false)
+	if len(merged) != 2 {
+		t.Fatalf("expected %d, got: %d", 2, len(merged))
+	}
+	if string(merged[0].Value) != "new" || merged[0].SequenceNumber != 5 {
+		t.Fatalf("expected the write with sequence number 5, got: %v", merged[0])
+	}
+}
+
+func TestSelectSizeTier(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name          string
+		sizes         []int64
+		expectedStart int
+		expectedEnd   int
+	}{
+		{"too few tables", []int64{10, 10, 10}, 0, 0},
+		{"small tables form one tier", []int64{10, 500, 20, 1000}, 0, 4},
+		{"large table ends the tier", []int64{10, 20, 30, 40, 100 * mb}, 0, 4},
+		{"oldest qualifying tier wins", []int64{100 * mb, 10, 20, 30, 40}, 1, 5},
+		{"similar large tables", []int64{10 * mb, 12 * mb, 9 * mb, 11 * mb, 40 * mb}, 0, 4},
+		{"dissimilar large tables", []int64{10 * mb, 30 * mb, 10 * mb, 30 * mb}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := selectSizeTier(tt.sizes, 4)
+			if start != tt.expectedStart || end != tt.expectedEnd {
+				t.Fatalf("expected [%d, %d), got: [%d, %d)", tt.expectedStart, tt.expectedEnd, start, end)
+			}
+		})
+	}
+}
+
+func TestCompactWaitsForMinThreshold(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testCompactWaitsForMinThreshold")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 1,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < DefaultCompactionMinThreshold-1; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+
+	waitForFlushes(t, database)
+	err = database.Compact()
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if len(database.Sstables) != DefaultCompactionMinThreshold-1 {
+		t.Fatalf("expected %d, got: %d", DefaultCompactionMinThreshold-1, len(database.Sstables))
+	}
+}
+
+func TestCompactRangeKeepsTombstonesForOlderData(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testCompactRangeKeepsTombstonesForOlderData")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 1,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// sstable 0 holds "deleted", sstable 1 its tombstone and sstable 2 another key
+	err = database.Put(Entry{Key: "deleted", Value: []byte("value")})
+	if err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	err = database.Delete("deleted")
+	if err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	err = database.Put(Entry{Key: "other", Value: []byte("value")})
+	if err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	waitForFlushes(t, database)
+	oldest := database.Sstables[0]
+	database.compactionMu.Lock()
+	err = database.compactRange(1, 3)
+	database.compactionMu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+
+	if len(database.Sstables) != 2 || database.Sstables[0] != oldest {
+		t.Fatalf("expected the oldest sstable to survive, got: %v", database.Sstables)
+	}
+	entries, err := ssm.ReadAll(database.Sstables[1])
+	if err != nil {
+		t.Fatalf("error reading compacted sstable: %s", err)
+	}
+	if len(entries) != 2 || entries[0].Key != "deleted" || !entries[0].Tombstone {
+		t.Fatalf("expected the tombstone to be kept, got: %v", entries)
+	}
+
+	_, err = database.Get("deleted")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+// CrashingManifestManager fails every manifest write while crashed is set,
+// like a process dying right after writing a new SSTable.
+type CrashingManifestManager struct {
+	SSTableManager
+	crashed bool
+}
+
+func (m *CrashingManifestManager) WriteManifest(levels [][]string) error {
+	if m.crashed {
+		return errors.New("simulated crash")
+	}
+	return m.SSTableManager.WriteManifest(levels)
+}
+
+func TestRecoverFromCrashBeforeManifestUpdate(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testRecoverFromCrashBeforeManifestUpdate")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	crashing := &CrashingManifestManager{SSTableManager: ssm}
+	database, err := NewDb(Options{
+		MemtableThreshold:      2,
+		SstableMgr:             crashing,
+		Logger:                 logger,
+		CompactionMinThreshold: 2,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+	live := append([]string{}, database.Sstables...)
+
+	// Both the compaction output and the next flush reach the disk, but the
+	// manifest is never updated to list them
+	crashing.crashed = true
+	if err := database.Compact(); err == nil {
+		t.Fatalf("expected compaction to fail")
+	}
+	if err := database.Put(Entry{Key: "key4", Value: []byte("value4")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if err := database.Flush(); err == nil {
+		t.Fatalf("expected flush to fail")
+	}
+	fileNames, err := ssm.ListFiles()
+	if err != nil {
+		t.Fatalf("error listing files: %v", err)
+	}
+	if len(fileNames) != len(live)+2 {
+		t.Fatalf("expected %d files on disk, got: %v", len(live)+2, fileNames)
+	}
+
+	ssm, err = NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	reopened, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	if fmt.Sprint(reopened.Sstables) != fmt.Sprint(live) {
+		t.Fatalf("expected %v, got: %v", live, reopened.Sstables)
+	}
+	for i := 0; i < 4; i++ {
+		entry, err := reopened.Get(fmt.Sprintf("key%d", i))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if string(entry.Value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Expected value%d, got %s", i, string(entry.Value))
+		}
+	}
+
+	// The files the manifest never listed are garbage
+	if err := reopened.RunGC(); err != nil {
+		t.Fatalf("Failed to run gc: %v", err)
+	}
+	fileNames, err = ssm.ListFiles()
+	if err != nil {
+		t.Fatalf("error listing files: %v", err)
+	}
+	if len(fileNames) != len(live) {
+		t.Fatalf("expected %d files on disk, got: %v", len(live), fileNames)
+	}
+}
+
+func TestBackgroundCompaction(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testBackgroundCompaction")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	const maxL0Files = 3
+	database, err := NewDb(Options{
+		MemtableThreshold: 10,
+		SstableMgr:        ssm,
+		Logger:            logger,
+		MaxL0Files:        maxL0Files,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// Twenty flushes, each overwriting some keys of the one before
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key%03d", i%150)
+		if err := database.Put(Entry{Key: key, Value: []byte(fmt.Sprintf("value%d", i))}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		database.mu.RLock()
+		count := len(database.Sstables)
+		database.mu.RUnlock()
+		if count <= maxL0Files {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected at most %d sstables, still have %d", maxL0Files, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := database.LastCompactionError(); err != nil {
+		t.Fatalf("expected background compactions to succeed, got: %v", err)
+	}
+	if database.Stats().Compactions == 0 {
+		t.Errorf("expected background compactions to be counted")
+	}
+
+	for i := 50; i < 200; i++ {
+		key := fmt.Sprintf("key%03d", i%150)
+		entry, err := database.Get(key)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", key, err)
+		}
+		if want := fmt.Sprintf("value%d", i); string(entry.Value) != want {
+			t.Errorf("expected %s for %s, got %s", want, key, entry.Value)
+		}
+	}
+}
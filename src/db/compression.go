@@ -0,0 +1,88 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+// CompressionCodec identifies how the data blocks of an SSTable are compressed.
+// It is stored in the file header so a file always reads back with the codec
+// it was written with. The zero value is gzip, which every file before
+// version 5 used.
+type CompressionCodec uint8
+
+const (
+	CompressionGzip CompressionCodec = iota
+	CompressionNone
+	CompressionSnappy
+)
+
+func (c CompressionCodec) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+// validGzipLevel reports whether level can be passed to gzip.NewWriterLevel.
+func validGzipLevel(level int) bool {
+	return level >= gzip.HuffmanOnly && level <= gzip.BestCompression
+}
+
+// compressBlock compresses an encoded block with the given codec. The level
+// only applies to gzip; zero means gzip.DefaultCompression.
+func compressBlock(codec CompressionCodec, level int, data []byte) ([]byte, error) {
+	switch codec {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		var compressed bytes.Buffer
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		compressor, err := gzip.NewWriterLevel(&compressed, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := compressor.Write(data); err != nil {
+			return nil, err
+		}
+		if err := compressor.Close(); err != nil {
+			return nil, err
+		}
+		return compressed.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %s", codec)
+	}
+}
+
+// decompressBlock reverses compressBlock.
+func decompressBlock(codec CompressionCodec, data []byte) ([]byte, error) {
+	switch codec {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case CompressionSnappy:
+		return snappy.Decode(nil, data)
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %s", codec)
+	}
+}
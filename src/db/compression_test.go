@@ -0,0 +1,421 @@
+package db
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func compressionTestEntries() []Entry {
+	var data []Entry
+	for i := 0; i < 250; i++ {
+		data = append(data, Entry{
+			Key:   fmt.Sprintf("key%03d", i),
+			Value: []byte(fmt.Sprintf("value%03d", i)),
+		})
+	}
+	return data
+}
+
+func TestCompressionCodecRoundTrip(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	for _, codec := range []CompressionCodec{CompressionNone, CompressionGzip, CompressionSnappy} {
+		t.Run(codec.String(), func(t *testing.T) {
+			dataDir := filepath.Join(currentTestDir, ".testCompressionCodecRoundTrip"+codec.String())
+			defer deleteDirectoryIfExists(dataDir)
+
+			ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+				DataDir:          dataDir,
+				Logger:           logger,
+				CompressionCodec: codec,
+			})
+			if err != nil {
+				t.Fatalf("error creating file manager: %s", err)
+			}
+
+			data := compressionTestEntries()
+			fileName := "compressed.sst"
+			if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+				t.Fatalf("error writing file: %s", err)
+			}
+
+			file, err := os.Open(filepath.Join(dataDir, fileName))
+			if err != nil {
+				t.Fatalf("error opening file: %s", err)
+			}
+			header, err := readFileHeader(file)
+			file.Close()
+			if err != nil {
+				t.Fatalf("error reading header: %s", err)
+			}
+			if header.Compression != codec {
+				t.Fatalf("expected header codec %s, got: %s", codec, header.Compression)
+			}
+
+			dataRead, err := ssm.ReadAll(fileName)
+			if err != nil {
+				t.Fatalf("error reading file: %s", err)
+			}
+			if len(dataRead) != len(data) {
+				t.Fatalf("expected data length %d, got: %d", len(data), len(dataRead))
+			}
+			for i, item := range dataRead {
+				if item.Key != data[i].Key || string(item.Value) != string(data[i].Value) {
+					t.Fatalf("mismatch at index %d: expected %v, got %v", i, data[i], item)
+				}
+			}
+
+			entry, err := ssm.FindKey(fileName, "key123")
+			if err != nil {
+				t.Fatalf("error finding key: %s", err)
+			}
+			if string(entry.Value) != "value123" {
+				t.Fatalf("expected value123, got: %s", entry.Value)
+			}
+		})
+	}
+}
+
+func TestReadFileWrittenWithOtherCodec(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testReadFileWrittenWithOtherCodec")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	writer, err := NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:          dataDir,
+		Logger:           logger,
+		CompressionCodec: CompressionSnappy,
+	})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	data := compressionTestEntries()
+	fileName := "snappy.sst"
+	if err := writer.Write(fileName, append([]Entry{}, data...)); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	for _, codec := range []CompressionCodec{CompressionNone, CompressionGzip} {
+		reader, err := NewFileManagerWithOptions(FileManagerOptions{
+			DataDir:          dataDir,
+			Logger:           logger,
+			CompressionCodec: codec,
+		})
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		dataRead, err := reader.ReadAll(fileName)
+		if err != nil {
+			t.Fatalf("error reading file with %s manager: %s", codec, err)
+		}
+		if len(dataRead) != len(data) {
+			t.Fatalf("expected data length %d, got: %d", len(data), len(dataRead))
+		}
+		entry, err := reader.FindKey(fileName, "key042")
+		if err != nil {
+			t.Fatalf("error finding key with %s manager: %s", codec, err)
+		}
+		if string(entry.Value) != "value042" {
+			t.Fatalf("expected value042, got: %s", entry.Value)
+		}
+	}
+}
+
+func TestUnsupportedCompressionCodec(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testUnsupportedCompressionCodec")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	_, err = NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:          dataDir,
+		Logger:           logger,
+		CompressionCodec: CompressionCodec(99),
+	})
+	if err == nil {
+		t.Fatalf("expected an error for an unsupported codec")
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	// Values drawn from a small vocabulary compress, but not so trivially that
+	// every level finds the same encoding
+	random := rand.New(rand.NewSource(1))
+	words := []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipiscing", "elit"}
+	var data []Entry
+	for i := 0; i < 5000; i++ {
+		value := ""
+		for j := 0; j < 20; j++ {
+			value += words[random.Intn(len(words))] + " "
+		}
+		data = append(data, Entry{Key: fmt.Sprintf("key%05d", i), Value: []byte(value)})
+	}
+
+	sizes := make(map[int]int64)
+	for _, level := range []int{gzip.BestSpeed, gzip.BestCompression} {
+		dataDir := filepath.Join(currentTestDir, fmt.Sprintf(".testCompressionLevel%d", level))
+		defer deleteDirectoryIfExists(dataDir)
+
+		ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+			DataDir:          dataDir,
+			Logger:           logger,
+			CompressionCodec: CompressionGzip,
+			CompressionLevel: level,
+		})
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		fileName := "level.sst"
+		if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+			t.Fatalf("error writing file: %s", err)
+		}
+		dataRead, err := ssm.ReadAll(fileName)
+		if err != nil {
+			t.Fatalf("error reading file: %s", err)
+		}
+		if len(dataRead) != len(data) {
+			t.Fatalf("level %d: expected data length %d, got: %d", level, len(data), len(dataRead))
+		}
+		for i, item := range dataRead {
+			if item.Key != data[i].Key || string(item.Value) != string(data[i].Value) {
+				t.Fatalf("level %d: mismatch at index %d: expected %v, got %v", level, i, data[i], item)
+			}
+		}
+		if sizes[level], err = ssm.Size(fileName); err != nil {
+			t.Fatalf("error reading size: %s", err)
+		}
+	}
+	if sizes[gzip.BestCompression] >= sizes[gzip.BestSpeed] {
+		t.Errorf("expected BestCompression to write a smaller file than BestSpeed, got %v", sizes)
+	}
+
+	for _, level := range []int{gzip.HuffmanOnly - 1, gzip.BestCompression + 1} {
+		_, err := NewFileManagerWithOptions(FileManagerOptions{
+			DataDir:          filepath.Join(currentTestDir, ".testCompressionLevelInvalid"),
+			Logger:           logger,
+			CompressionLevel: level,
+		})
+		if err == nil {
+			t.Errorf("expected an error for compression level %d", level)
+		}
+	}
+}
+
+// randomValueEntries returns count entries whose values do not compress.
+func randomValueEntries(count int) []Entry {
+	random := rand.New(rand.NewSource(1))
+	var data []Entry
+	for i := 0; i < count; i++ {
+		value := make([]byte, 64)
+		random.Read(value)
+		data = append(data, Entry{Key: fmt.Sprintf("key%03d", i), Value: value})
+	}
+	return data
+}
+
+func TestChunksStoredUncompressedWhenCompressionDoesNotPayOff(t *testing.T) {
+	tests := []struct {
+		name         string
+		entries      []Entry
+		uncompressed bool
+	}{
+		{"small", []Entry{{Key: "key", Value: []byte("value")}}, true},
+		{"incompressible", randomValueEntries(blockIndexInterval), true},
+		{"compressible", compressionTestEntries()[:blockIndexInterval], false},
+	}
+	for _, codec := range []CompressionCodec{CompressionGzip, CompressionSnappy} {
+		for _, test := range tests {
+			body, _, err := encodeBlock(test.entries, codec, 0, DefaultMinCompressSize)
+			if err != nil {
+				t.Fatalf("error encoding block: %v", err)
+			}
+			indexSize := binary.BigEndian.Uint32(body)
+			chunks, err := decodeBlockIndex(body[4:4+indexSize], 0, SSTableVersion)
+			if err != nil {
+				t.Fatalf("error decoding block index: %v", err)
+			}
+			if len(chunks) != 1 || chunks[0].uncompressed != test.uncompressed {
+				t.Errorf("%s %s: expected one chunk stored uncompressed=%v, got: %+v", codec, test.name, test.uncompressed, chunks)
+			}
+		}
+	}
+}
+
+func TestReadUncompressedChunks(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testReadUncompressedChunks")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:          dataDir,
+		Logger:           logger,
+		CompressionCodec: CompressionGzip,
+		BlockCacheSize:   -1,
+	})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	// Compressible and incompressible chunks mixed in one file
+	data := append(compressionTestEntries()[:100], randomValueEntries(250)[100:]...)
+	fileName := "mixed.sst"
+	if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	dataRead, err := ssm.ReadAll(fileName)
+	if err != nil {
+		t.Fatalf("error reading file: %s", err)
+	}
+	if len(dataRead) != len(data) {
+		t.Fatalf("expected data length %d, got: %d", len(data), len(dataRead))
+	}
+	for _, i := range []int{0, 99, 100, 249} {
+		entry, err := ssm.FindKey(fileName, data[i].Key)
+		if err != nil {
+			t.Fatalf("error finding key %s: %s", data[i].Key, err)
+		}
+		if string(entry.Value) != string(data[i].Value) {
+			t.Fatalf("unexpected value for key %s: %v", data[i].Key, entry.Value)
+		}
+	}
+}
+
+func TestMinCompressSize(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testMinCompressSize")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	data := compressionTestEntries()
+	for _, test := range []struct {
+		minCompressSize int
+		uncompressed    bool
+	}{
+		{0, false},
+		// No chunk of the file is this large, so even compressible ones are
+		// stored as is
+		{1 << 20, true},
+	} {
+		ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+			DataDir:          dataDir,
+			Logger:           logger,
+			CompressionCodec: CompressionGzip,
+			MinCompressSize:  test.minCompressSize,
+			BlockCacheSize:   -1,
+		})
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		fileName := fmt.Sprintf("min_%d.sst", test.minCompressSize)
+		if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+			t.Fatalf("error writing file: %s", err)
+		}
+
+		file, err := os.Open(filepath.Join(dataDir, fileName))
+		if err != nil {
+			t.Fatalf("error opening file: %s", err)
+		}
+		chunks, err := readBlockChunks(file, uint64(fileHeaderSize(SSTableVersion)), SSTableVersion)
+		file.Close()
+		if err != nil {
+			t.Fatalf("error reading block index: %s", err)
+		}
+		// The short chunk at the end of a block is below either minimum
+		if chunks[0].uncompressed != test.uncompressed {
+			t.Fatalf("minimum %d: expected chunks stored uncompressed=%v, got: %+v", test.minCompressSize, test.uncompressed, chunks)
+		}
+
+		dataRead, err := ssm.ReadAll(fileName)
+		if err != nil {
+			t.Fatalf("error reading file: %s", err)
+		}
+		if len(dataRead) != len(data) {
+			t.Fatalf("expected data length %d, got: %d", len(data), len(dataRead))
+		}
+		entry, err := ssm.FindKey(fileName, data[42].Key)
+		if err != nil || string(entry.Value) != string(data[42].Value) {
+			t.Fatalf("expected %s for key %s, got: %s, %v", data[42].Value, data[42].Key, entry.Value, err)
+		}
+	}
+}
+
+// BenchmarkReadWithCodec compares reading every block of a file written with
+// each codec. The block cache is disabled so every read decompresses.
+func BenchmarkReadWithCodec(b *testing.B) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		b.Fatalf("error getting current test directory: %s", err)
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	data := make([]Entry, 10000)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("key%05d", i), Value: []byte(fmt.Sprintf("value-%05d-%s", i, "payload"))}
+	}
+	var size int64
+	for _, entry := range data {
+		size += int64(len(entry.Key) + len(entry.Value))
+	}
+
+	for _, codec := range []CompressionCodec{CompressionNone, CompressionGzip, CompressionSnappy} {
+		b.Run(codec.String(), func(b *testing.B) {
+			dataDir := filepath.Join(currentTestDir, ".benchmarkReadWithCodec"+codec.String())
+			defer deleteDirectoryIfExists(dataDir)
+
+			ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+				DataDir:          dataDir,
+				Logger:           logger,
+				CompressionCodec: codec,
+				BlockCacheSize:   -1,
+			})
+			if err != nil {
+				b.Fatalf("error creating file manager: %s", err)
+			}
+			if err := ssm.Write("bench.sst", append([]Entry{}, data...)); err != nil {
+				b.Fatalf("error writing file: %s", err)
+			}
+
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ssm.ReadAll("bench.sst"); err != nil {
+					b.Fatalf("error reading file: %s", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// SlowFindMockSSTableManager delays every FindKey, either by delay or, when
+// finding is set, until release is closed.
+type SlowFindMockSSTableManager struct {
+	MockSSTableManager
+	delay   time.Duration
+	finding chan struct{}
+	release chan struct{}
+}
+
+func (m *SlowFindMockSSTableManager) FindKey(fileName string, key string) (Entry, error) {
+	if m.finding != nil {
+		m.finding <- struct{}{}
+		<-m.release
+	}
+	time.Sleep(m.delay)
+	return m.MockSSTableManager.FindKey(fileName, key)
+}
+
+func TestGetDoesNotBlockWriters(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	sstablemockstore = []Entry{}
+
+	mgr := &SlowFindMockSSTableManager{}
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("flushed%d", i), Value: []byte("on disk")})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+
+	mgr.finding = make(chan struct{}, 1)
+	mgr.release = make(chan struct{})
+	got := make(chan error)
+	go func() {
+		entry, err := database.Get("flushed0")
+		if err == nil && string(entry.Value) != "on disk" {
+			err = fmt.Errorf("expected value on disk, got %s", entry.Value)
+		}
+		got <- err
+	}()
+	select {
+	case <-mgr.finding:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the get to reach the sstable")
+	}
+
+	// The get is stuck reading the SSTable; writes and memtable reads must
+	// not wait for it
+	done := make(chan error)
+	go func() {
+		if err := database.Put(Entry{Key: "active", Value: []byte("memtable")}); err != nil {
+			done <- err
+			return
+		}
+		entry, err := database.Get("active")
+		if err == nil && string(entry.Value) != "memtable" {
+			err = fmt.Errorf("expected value memtable, got %s", entry.Value)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("write during slow get failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writes blocked behind a get reading an sstable")
+	}
+
+	close(mgr.release)
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("slow get failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the slow get to finish once released")
+	}
+}
+
+func TestConcurrentGetsAndPuts(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testConcurrentGetsAndPuts")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 10,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// Every worker reads back its own keys while the others write and flush
+	// underneath it, so each read crosses memtables and SSTables
+	const workers, keys = 4, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprintf("worker%d-key%03d", w, i)
+				if err := database.Put(Entry{Key: key, Value: []byte(key)}); err != nil {
+					errs <- err
+					return
+				}
+				for j := 0; j <= i; j += 7 {
+					want := fmt.Sprintf("worker%d-key%03d", w, j)
+					entry, err := database.Get(want)
+					if err != nil {
+						errs <- fmt.Errorf("get %s: %w", want, err)
+						return
+					}
+					if string(entry.Value) != want {
+						errs <- fmt.Errorf("get %s: got %s", want, entry.Value)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent get failed: %v", err)
+	}
+}
+
+// BenchmarkGetParallel reads keys held in SSTables from many goroutines with
+// a FindKey that sleeps, so gets that serialized on the LSM lock would show
+// up as a throughput that does not grow with -cpu.
+func BenchmarkGetParallel(b *testing.B) {
+	logger := log.New(io.Discard, "", 0)
+	sstablemockstore = []Entry{}
+
+	mgr := &SlowFindMockSSTableManager{delay: 100 * time.Microsecond}
+	database, err := NewDb(Options{
+		MemtableThreshold: 100,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		b.Fatalf("error creating db: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%03d", i), Value: []byte("value")})
+		if err != nil {
+			b.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	database.mu.Lock()
+	err = database.waitForFlushes()
+	database.mu.Unlock()
+	if err != nil {
+		b.Fatalf("background flush failed: %v", err)
+	}
+
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := database.Get(fmt.Sprintf("key%03d", i%100)); err != nil {
+				b.Errorf("Failed to get key: %v", err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+func TestGetContextCancellation(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	sstablemockstore = []Entry{}
+
+	mgr := &SlowFindMockSSTableManager{}
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        mgr,
+		Logger:            logger,
+		OperationTimeout:  20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	// Five SSTables that all cover [a, z]
+	for i := 0; i < 5; i++ {
+		for _, key := range []string{"a", "z"} {
+			if err := database.Put(Entry{Key: key, Value: []byte("value")}); err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+		}
+	}
+	waitForFlushes(t, database)
+
+	// Canceled while the first SSTable is searched, Get returns before the
+	// next one
+	mgr.finding = make(chan struct{}, 1)
+	mgr.release = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan error)
+	go func() {
+		_, err := database.GetContext(ctx, "m")
+		got <- err
+	}()
+	<-mgr.finding
+	cancel()
+	close(mgr.release)
+	select {
+	case err := <-got:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Get to return once its context was canceled")
+	}
+	if len(mgr.finding) != 0 {
+		t.Errorf("expected no SSTable to be searched after the cancellation")
+	}
+
+	// Without a deadline of its own, Get runs out of OperationTimeout
+	mgr.finding = nil
+	mgr.delay = 50 * time.Millisecond
+	start := time.Now()
+	if _, err := database.Get("m"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected Get to stop after the first SSTable, took %v", elapsed)
+	}
+
+	// A deadline set by the caller takes precedence over OperationTimeout
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := database.GetContext(ctx, "m"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCompareAndSwapRace(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testCompareAndSwapRace")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(io.Discard, "", 0)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// The first swaps read the current value from an SSTable
+	if err := database.Put(Entry{Key: "counter", Value: []byte("0")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	const writers = 8
+	var wg sync.WaitGroup
+	wins := make(chan int, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			swapped, err := database.CompareAndSwap("counter", []byte("0"), []byte(fmt.Sprintf("writer%d", w)))
+			if err != nil {
+				t.Errorf("Failed to swap: %v", err)
+			}
+			if swapped {
+				wins <- w
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(wins)
+	if len(wins) != 1 {
+		t.Fatalf("expected exactly one swap to win, got %d", len(wins))
+	}
+	winner := <-wins
+	entry, err := database.Get("counter")
+	if err != nil || string(entry.Value) != fmt.Sprintf("writer%d", winner) {
+		t.Fatalf("expected the value of writer%d, got %q, %v", winner, entry.Value, err)
+	}
+
+	// Increments retried until their swap wins are never lost
+	if err := database.Put(Entry{Key: "counter", Value: []byte("0")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	const increments = 50
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				current, err := database.Get("counter")
+				if err != nil {
+					t.Errorf("Failed to get counter: %v", err)
+					return
+				}
+				var n int
+				fmt.Sscan(string(current.Value), &n)
+				swapped, err := database.CompareAndSwap("counter", current.Value, []byte(fmt.Sprint(n+1)))
+				if err != nil {
+					t.Errorf("Failed to swap: %v", err)
+					return
+				}
+				if swapped {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	entry, err = database.Get("counter")
+	if err != nil || string(entry.Value) != fmt.Sprint(writers*increments) {
+		t.Fatalf("expected the counter to reach %d, got %q, %v", writers*increments, entry.Value, err)
+	}
+}
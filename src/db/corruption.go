@@ -0,0 +1,64 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorruptSSTable matches, through errors.Is, every CorruptionError.
+var ErrCorruptSSTable = errors.New("corrupt sstable")
+
+// ErrNotSSTable is returned when reading a file that does not start with an
+// SSTable header.
+var ErrNotSSTable = errors.New("not a goatdb sstable")
+
+// ErrUnsupportedSSTableVersion is returned, with the version appended, when
+// reading an SSTable written in a newer format than this package knows.
+var ErrUnsupportedSSTableVersion = errors.New("unsupported sstable version")
+
+// CorruptionError reports a section of an SSTable that failed its checksum
+// or could not be parsed. Section is "header", "index", "block" or "footer",
+// and Offset is the position of the section in the file. FileName is set once
+// the error leaves the SSTableFileSystemManager.
+type CorruptionError struct {
+	FileName string
+	Section  string
+	Offset   int64
+	Err      error
+}
+
+// CorruptSSTableError is the former name of CorruptionError.
+//
+// Deprecated: use CorruptionError.
+type CorruptSSTableError = CorruptionError
+
+func (e *CorruptionError) Error() string {
+	if e.FileName != "" {
+		return fmt.Sprintf("corrupt sstable %s: %s at offset %d: %v", e.FileName, e.Section, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("corrupt sstable %s at offset %d: %v", e.Section, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruptSSTable
+}
+
+// corruptionf returns a CorruptionError for section with a formatted
+// description of the problem.
+func corruptionf(section string, offset int64, format string, args ...interface{}) error {
+	return &CorruptionError{Section: section, Offset: offset, Err: fmt.Errorf(format, args...)}
+}
+
+// withFileName records fileName on the CorruptionError in err, if there is
+// one, and returns err.
+func withFileName(err error, fileName string) error {
+	var corrupt *CorruptionError
+	if errors.As(err, &corrupt) && corrupt.FileName == "" {
+		corrupt.FileName = fileName
+	}
+	return err
+}
@@ -1,98 +1,805 @@
 package db
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"sort"
 	"sync"
+	"time"
 )
 
 type Options struct {
+	// MemtableThreshold is the number of entries at which the memtable is
+	// flushed. Zero disables the limit.
 	MemtableThreshold int
 	SstableMgr        SSTableManager
 	Logger            *log.Logger
+	// MemtableMaxBytes is the approximate size in bytes, keys and values plus
+	// a per entry overhead, at which the memtable is flushed. Zero disables
+	// the limit.
+	MemtableMaxBytes int64
+	// CompactionMinThreshold is the number of similarly sized SSTables Compact
+	// waits for before merging them, or under leveled compaction the number of
+	// L0 tables that are merged into L1. Values below 2 mean
+	// DefaultCompactionMinThreshold.
+	CompactionMinThreshold int
+	// LeveledCompaction organizes SSTables into levels. Flushes add tables to
+	// L0 and a background goroutine merges them into deeper levels, whose
+	// tables do not overlap. Without it every SSTable stays in L0.
+	LeveledCompaction bool
+	// MaxL0Files is the number of SSTables above which, without leveled
+	// compaction, a background goroutine compacts them as Compact does, or
+	// merges them all if no tier is large enough, until at most MaxL0Files
+	// remain. Zero leaves compaction to explicit Compact calls.
+	MaxL0Files int
+	// LevelBaseSize is the target size in bytes of L1 under leveled
+	// compaction. Zero means DefaultLevelBaseSize.
+	LevelBaseSize int64
+	// LevelSizeMultiplier is how many times larger the target size of each
+	// level is than that of the level above. Values below 2 mean
+	// DefaultLevelSizeMultiplier.
+	LevelSizeMultiplier int
+	// TargetFileSize is the approximate size in bytes of the SSTables written
+	// by leveled compactions. Zero means DefaultTargetFileSize.
+	TargetFileSize int64
+	// MaxImmutableMemtables bounds the number of frozen memtables held in
+	// memory, including the one being flushed. A write that fills the active
+	// memtable while the limit is reached blocks until a flush finishes. Zero
+	// queues every frozen memtable without blocking.
+	MaxImmutableMemtables int
+	// SkipFlushOnClose makes Close drop the memtable instead of flushing it to
+	// an SSTable, losing writes that were not flushed yet.
+	SkipFlushOnClose bool
+	// Clock is the time source entries are checked for expiry against. Nil
+	// means time.Now.
+	Clock func() time.Time
+	// Metrics receives flush and compaction measurements. Nil records
+	// nothing.
+	Metrics Metrics
+	// LogLevel is the lowest level of the messages written to Logger. The
+	// zero value is LevelInfo, which leaves out the details of every
+	// operation.
+	LogLevel Level
+	// MaxKeySize and MaxValueSize are the largest key and value in bytes a
+	// write accepts. Zero means DefaultMaxKeySize and DefaultMaxValueSize.
+	MaxKeySize   int
+	MaxValueSize int
+	// OperationTimeout bounds reads whose context has no deadline, including
+	// Get and Scan. Zero means no limit.
+	OperationTimeout time.Duration
+	// ReadOnly opens the database for reads only, as of the manifest when it
+	// is opened. Writes, flushes, compactions and GC return ErrReadOnly and
+	// nothing in the data directory is changed, so a backup or inspection job
+	// can share it with a writer. Pair it with a read-only SSTableManager,
+	// see FileManagerOptions.ReadOnly. Tables the writer compacts away after
+	// opening can no longer be read.
+	ReadOnly bool
+	// MergeFunc combines the operands written with Merge with the values they
+	// apply to, see AddInt64. Nil makes Merge return ErrNoMergeFunc.
+	MergeFunc MergeFunc
+	// Durability controls whether flushes and compactions sync what they
+	// write, for SSTable managers that support it. The zero value,
+	// DurabilityStrict, keeps the durability the manager was created with,
+	// which is strict unless FileManagerOptions.Durability says otherwise.
+	Durability DurabilityMode
 }
 
+const (
+	DefaultMaxKeySize   = 64 << 10
+	DefaultMaxValueSize = 16 << 20
+)
+
+// ErrDBClosed is returned by operations on a database after Close.
+var ErrDBClosed = errors.New("database is closed")
+
+// ErrClosed is the former name of ErrDBClosed.
+//
+// Deprecated: use ErrDBClosed.
+var ErrClosed = ErrDBClosed
+
+// ErrReadOnly is returned by writes, flushes and compactions of a database
+// opened with ReadOnly, and by writes of a read-only SSTableManager.
+var ErrReadOnly = errors.New("database is read-only")
+
+// ErrNoMergeFunc is returned by Merge on a database opened without a
+// MergeFunc.
+var ErrNoMergeFunc = errors.New("no merge function configured")
+
+// ErrNotFound is returned for keys that were never written, were deleted or
+// have expired.
+var ErrNotFound = errors.New("entry not found")
+
+// ErrKeyTooLarge and ErrValueTooLarge are returned, wrapped with the sizes
+// involved, by writes of a key longer than MaxKeySize or a value longer than
+// MaxValueSize.
+var (
+	ErrKeyTooLarge   = errors.New("key too large")
+	ErrValueTooLarge = errors.New("value too large")
+)
+
 type DB interface {
 	Put(entry Entry) error
 	Get(key string) (Entry, error)
+	GetMany(keys []string) (map[string]Entry, error)
+	Delete(key string) error
+	PutBatch(entries []Entry) error
+	CompareAndSwap(key string, expected, newValue []byte) (bool, error)
+	Merge(key string, operand []byte) error
+	Scan(startKey string, endKey string, limit int) ([]Entry, error)
+	GetContext(ctx context.Context, key string) (Entry, error)
+	Exists(key string) (bool, error)
+	Keys(prefix string, limit int, after string) ([]string, error)
+	SSTables() ([]SSTableInfo, error)
+	Backup(targetDir string) error
+	Export(w io.Writer) error
+	Import(r io.Reader, opts ImportOptions) error
+	ScanContext(ctx context.Context, startKey string, endKey string, limit int) ([]Entry, error)
+	Close() error
+	Stats() Stats
+	Ready() error
 }
 
 type LSM struct {
-	Memtable   map[string]Entry
-	Sstables   []string
-	threshold  int
-	mu         sync.RWMutex
-	sstableMgr SSTableManager
-	logger     *log.Logger
+	Memtable *Memtable
+	// immutables are frozen memtables waiting to be flushed, oldest first
+	immutables []*Memtable
+	// maxImmutables is MaxImmutableMemtables
+	maxImmutables int
+	// flushing is set while a background flush runs; flushDone is signalled
+	// after each memtable it flushes and when it stops, and flushErr holds its
+	// error
+	flushing  bool
+	flushDone *sync.Cond
+	flushErr  error
+	// importing are the SSTables imports are writing outside mu, in the order
+	// their sequence numbers were assigned. flushDone is also signalled when
+	// one of them is done.
+	importing []string
+	// Sstables are the L0 tables, oldest first. Their key ranges may overlap.
+	Sstables []string
+	// levels[i] holds the tables of level i+1 in key order. Within a level
+	// key ranges do not overlap.
+	levels [][]string
+	// tableInfo holds the key range of every live SSTable so lookups can skip
+	// the tables that cannot hold a key
+	tableInfo     map[string]TableInfo
+	threshold     int
+	maxBytes      int64
+	mu            sync.RWMutex
+	sstableMgr    SSTableManager
+	logger        Logger
+	nextSSTableID int
+	// compactionMu serializes compactions, which run mostly outside mu
+	compactionMu           sync.Mutex
+	compactionMinThreshold int
+	leveling               leveling
+	// refMu guards the pins that keep SSTables alive while read outside mu
+	refMu    sync.Mutex
+	refs     map[string]int
+	obsolete map[string]bool
+	// closed is set by Close; flushOnClose is the inverse of SkipFlushOnClose
+	closed       bool
+	flushOnClose bool
+	counters     counters
+	metrics      Metrics
+	// now is the clock entries are checked for expiry against
+	now func() time.Time
+	// lastSequence is the sequence number of the latest write
+	lastSequence uint64
+	maxKeySize   int
+	maxValueSize int
+	// operationTimeout is OperationTimeout
+	operationTimeout time.Duration
+	readOnly         bool
+	mergeFunc        MergeFunc
+	// unlock releases the data directory, see dataDirLocker
+	unlock func() error
+}
+
+// NewDb creates an LSM and restores the SSTables of every level written by a
+// previous instance from the manifest kept by the SSTableManager. With
+// LeveledCompaction it also starts the background compactor, which Close
+// stops. Unless ReadOnly is set, the data directory of an SSTableManager that
+// supports it is locked until Close, so a second NewDb over it fails with
+// ErrDataDirLocked. Temporary files left by writes that crashed are removed
+// once it is locked.
+func NewDb(opts Options) (_ *LSM, err error) {
+	logger := NewLogger(opts.Logger, opts.LogLevel)
+	if !validDurability(opts.Durability) {
+		return nil, fmt.Errorf("unsupported durability %s", opts.Durability)
+	}
+	if setter, ok := opts.SstableMgr.(durabilitySetter); ok && opts.Durability != DurabilityStrict {
+		setter.SetDurability(opts.Durability)
+	}
+	unlock := func() error { return nil }
+	if locker, ok := opts.SstableMgr.(dataDirLocker); ok && !opts.ReadOnly {
+		if unlock, err = locker.LockDataDir(); err != nil {
+			logger.Errorf("Error in locking data directory: %v", err)
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				unlock()
+			}
+		}()
+	}
+	if remover, ok := opts.SstableMgr.(tempFileRemover); ok && !opts.ReadOnly {
+		if err := remover.RemoveTempFiles(); err != nil {
+			logger.Errorf("Error in removing temporary files: %v", err)
+			return nil, err
+		}
+	}
+	levels, err := opts.SstableMgr.ReadManifest()
+	if err != nil {
+		logger.Errorf("Error in reading manifest: %v", err)
+		return nil, err
+	}
+	sstables := []string{}
+	for _, fileNames := range levels {
+		sstables = append(sstables, fileNames...)
+	}
+	logger.Infof("Loaded %d sstables from manifest", len(sstables))
+	// Files on disk that the manifest does not list, such as the output of a
+	// flush that crashed before updating it, must not have their names reused
+	fileNames, err := opts.SstableMgr.ListFiles()
+	if err != nil {
+		logger.Errorf("Error in listing sstables: %v", err)
+		return nil, err
+	}
+	// Sequence numbers continue after the highest one already flushed
+	tableInfo := make(map[string]TableInfo, len(sstables))
+	var lastSequence uint64
+	for _, fileName := range sstables {
+		info, err := opts.SstableMgr.TableInfo(fileName)
+		if err != nil {
+			logger.Errorf("Error in reading info of sstable %s: %v", fileName, err)
+			return nil, err
+		}
+		tableInfo[fileName] = info
+		if info.MaxSequence > lastSequence {
+			lastSequence = info.MaxSequence
+		}
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	maxKeySize := opts.MaxKeySize
+	if maxKeySize <= 0 {
+		maxKeySize = DefaultMaxKeySize
+	}
+	maxValueSize := opts.MaxValueSize
+	if maxValueSize <= 0 {
+		maxValueSize = DefaultMaxValueSize
+	}
+	compactionMinThreshold := opts.CompactionMinThreshold
+	if compactionMinThreshold < 2 {
+		compactionMinThreshold = DefaultCompactionMinThreshold
+	}
+	if len(levels) == 0 {
+		levels = [][]string{{}}
+	}
+	db := &LSM{
+		Memtable:               NewMemtable(),
+		threshold:              opts.MemtableThreshold,
+		maxBytes:               opts.MemtableMaxBytes,
+		maxImmutables:          opts.MaxImmutableMemtables,
+		Sstables:               levels[0],
+		levels:                 levels[1:],
+		tableInfo:              tableInfo,
+		sstableMgr:             opts.SstableMgr,
+		logger:                 logger,
+		nextSSTableID:          nextSSTableID(append(fileNames, sstables...)),
+		compactionMinThreshold: compactionMinThreshold,
+		leveling:               newLeveling(opts),
+		refs:                   make(map[string]int),
+		obsolete:               make(map[string]bool),
+		flushOnClose:           !opts.SkipFlushOnClose && !opts.ReadOnly,
+		now:                    clock,
+		lastSequence:           lastSequence,
+		metrics:                opts.Metrics,
+		maxKeySize:             maxKeySize,
+		maxValueSize:           maxValueSize,
+		operationTimeout:       opts.OperationTimeout,
+		readOnly:               opts.ReadOnly,
+		mergeFunc:              opts.MergeFunc,
+		unlock:                 unlock,
+	}
+	db.flushDone = sync.NewCond(&db.mu)
+	if !opts.ReadOnly && (opts.LeveledCompaction || opts.MaxL0Files > 0) {
+		db.startCompactions()
+	}
+	return db, nil
 }
 
-func NewDb(opts Options) *LSM {
-	return &LSM{
-		Memtable:   make(map[string]Entry),
-		threshold:  opts.MemtableThreshold,
-		Sstables:   []string{},
-		sstableMgr: opts.SstableMgr,
-		logger:     opts.Logger,
+// writabilityChecker is implemented by SSTable managers that can check that
+// new SSTables can be written, such as SSTableFileSystemManager.
+type writabilityChecker interface {
+	CheckWritable() error
+}
+
+// Ready reports whether the database can accept writes: it is open, the last
+// flush succeeded and, if the SSTable manager can tell, new SSTables can be
+// written. A ReadOnly database is ready as long as it is open.
+func (db *LSM) Ready() error {
+	db.mu.RLock()
+	closed, flushErr := db.closed, db.flushErr
+	db.mu.RUnlock()
+	if closed {
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		return nil
 	}
+	if flushErr != nil {
+		return fmt.Errorf("last flush failed: %w", flushErr)
+	}
+	if checker, ok := db.sstableMgr.(writabilityChecker); ok {
+		if err := checker.CheckWritable(); err != nil {
+			return fmt.Errorf("sstables cannot be written: %w", err)
+		}
+	}
+	return nil
 }
 
-func (db *LSM) Put(entry Entry) error {
+// Close stops background compactions, flushes the memtable, unless
+// SkipFlushOnClose is set, and waits for running flushes and compactions to
+// finish. Every later call returns ErrDBClosed. If the flush fails the database
+// stays open so Close can be retried, but compactions only run again through
+// Compact. Closing a closed database does nothing.
+func (db *LSM) Close() error {
+	db.stopCompactions()
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	db.Memtable[entry.Key] = entry
-	db.logger.Printf("Added entry with key: %s to memtable", entry.Key)
-	if len(db.Memtable) > db.threshold-1 {
-		return db.flushMemtableToDisk()
+	if db.closed {
+		return nil
 	}
+
+	if db.flushOnClose {
+		db.freezeMemtable()
+	}
+	// Without flushOnClose unflushed writes are dropped anyway, so a failed
+	// flush does not keep the database open
+	if err := db.waitForFlushes(); err != nil && db.flushOnClose {
+		db.logger.Errorf("Error in flushing memtable on close: %v", err)
+		return err
+	}
+	db.closed = true
+	if err := db.unlock(); err != nil {
+		db.logger.Errorf("Error in unlocking data directory: %v", err)
+	}
+	db.logger.Infof("Closed database")
 	return nil
 }
 
-func (db *LSM) flushMemtableToDisk() error {
-	filename := fmt.Sprintf("sstable_%d.sst", len(db.Sstables))
-	data := []Entry{}
-	for _, value := range db.Memtable {
-		data = append(data, value)
+func (db *LSM) Put(entry Entry) error {
+	if err := db.checkSize(entry.Key, entry.Value); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
 	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.counters.puts.Add(1)
+	entry.SequenceNumber = db.nextSequence()
+	db.Memtable.Put(entry)
+	db.logger.Debugf("Added entry with key: %s to memtable", entry.Key)
+	if db.memtableFull() {
+		db.freezeMemtable()
+	}
+	return nil
+}
 
-	err := db.sstableMgr.Write(filename, data)
-	if err != nil {
-		db.logger.Printf("Error in writing sstable to disk: %v", err)
+// Delete removes a key by writing a tombstone to the memtable. The tombstone is
+// flushed to SSTables like any other entry so it shadows older values on disk.
+// It returns ErrNotFound if key has no live record. The key is looked up like
+// Get, before the write lock is taken, so searching SSTables holds up no other
+// reads or writes.
+func (db *LSM) Delete(key string) error {
+	if err := db.checkSize(key, nil); err != nil {
 		return err
 	}
-	db.Memtable = make(map[string]Entry) // Clear the memtable
-	db.Sstables = append(db.Sstables, filename)
-	db.logger.Printf("Flushed to disk: %s", filename)
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		db.mu.RUnlock()
+		return ErrReadOnly
+	}
+	if _, err := db.getAndRUnlock(context.Background(), key); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	db.Memtable.Put(Entry{Key: key, Tombstone: true, SequenceNumber: db.nextSequence()})
+	db.counters.deletes.Add(1)
+	db.logger.Debugf("Added tombstone for key: %s to memtable", key)
+	if db.memtableFull() {
+		db.freezeMemtable()
+	}
 	return nil
 }
 
-func (db *LSM) Get(key string) (Entry, error) {
+// CompareAndSwap writes newValue for key only if the current value of key
+// equals expected, and reports whether it did. A nil expected matches a key
+// that is absent, deleted or expired, and nothing else. The value is read and
+// written under one lock so no other write can come in between. Unlike Get,
+// that includes searching the SSTables when key is not in a memtable, so a
+// swap of a flushed key holds up other writes, and reads, for one disk
+// lookup.
+func (db *LSM) CompareAndSwap(key string, expected, newValue []byte) (bool, error) {
+	if err := db.checkSize(key, newValue); err != nil {
+		return false, err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	entry, exists := db.Memtable[key]
+	if db.closed {
+		return false, ErrDBClosed
+	}
+	if db.readOnly {
+		return false, ErrReadOnly
+	}
+	current, err := db.get(key)
+	if exists := err == nil; exists != (expected != nil) || !bytes.Equal(current.Value, expected) {
+		db.logger.Debugf("Value of key: %s does not match, not swapping", key)
+		return false, nil
+	}
+
+	db.counters.puts.Add(1)
+	db.Memtable.Put(Entry{Key: key, Value: newValue, SequenceNumber: db.nextSequence()})
+	db.logger.Debugf("Swapped value of key: %s in memtable", key)
+	if db.memtableFull() {
+		db.freezeMemtable()
+	}
+	return true, nil
+}
+
+// Get returns the newest live record for key. Only the memtables are read
+// under the read lock; the SSTables that may hold key are pinned and searched
+// after it is released so slow disk reads do not hold up writers or flushes.
+func (db *LSM) Get(key string) (Entry, error) {
+	return db.GetContext(context.Background(), key)
+}
+
+// GetContext is Get that gives up with the error of ctx once it is done. The
+// context is checked before each SSTable is searched.
+func (db *LSM) GetContext(ctx context.Context, key string) (Entry, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return Entry{}, ErrDBClosed
+	}
+	db.counters.gets.Add(1)
+	return db.getAndRUnlock(ctx, key)
+}
+
+// getAndRUnlock looks key up in the memtables under the read lock the caller
+// holds, then releases it and searches the SSTables, which stay pinned
+// meanwhile.
+func (db *LSM) getAndRUnlock(ctx context.Context, key string) (Entry, error) {
+	entry, exists := db.getFromMemtables(key)
+	if exists && !entry.Merge {
+		db.mu.RUnlock()
+		return liveEntry(entry, db.now())
+	}
+	fileNames := db.tableCandidates(key)
+	db.pinSSTables(fileNames...)
+	db.mu.RUnlock()
+	defer db.unpinSSTables(fileNames...)
+	return db.getFromSSTables(ctx, fileNames, key, entry, db.now())
+}
+
+// Exists reports whether key has a live record. Like Get it skips the SSTables
+// whose key range or Bloom filter rule key out, so a missing key is mostly
+// answered without reading a block.
+func (db *LSM) Exists(key string) (bool, error) {
+	_, err := db.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// withTimeout applies OperationTimeout to ctx unless it already has a
+// deadline.
+func (db *LSM) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || db.operationTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, db.operationTimeout)
+}
+
+// get looks up the newest record for key. The caller must hold db.mu.
+func (db *LSM) get(key string) (Entry, error) {
+	entry, exists := db.getFromMemtables(key)
+	if exists && !entry.Merge {
+		return liveEntry(entry, db.now())
+	}
+	return db.getFromSSTables(context.Background(), db.tableCandidates(key), key, entry, db.now())
+}
+
+// getFromMemtables looks key up in the active memtable and then the immutable
+// ones, newest first. Merge operands are combined with the records they apply
+// to; the result is still an operand if the memtables hold nothing else for
+// key. The caller must hold db.mu.
+func (db *LSM) getFromMemtables(key string) (Entry, bool) {
+	found, exists := db.Memtable.Get(key)
 	if exists {
-		db.logger.Printf("Found entry with key: %s in memtable", key)
-		return entry, nil
+		db.logger.Debugf("Found entry with key: %s in memtable", key)
+		if !found.Merge {
+			return found, true
+		}
 	}
 
-	for i := len(db.Sstables) - 1; i >= 0; i-- {
-		entry, exists = db.searchInSSTable(i, key)
+	m := db.merger(db.now())
+	for i := len(db.immutables) - 1; i >= 0; i-- {
+		entry, ok := db.immutables[i].Get(key)
+		if !ok {
+			continue
+		}
+		db.logger.Debugf("Found entry with key: %s in immutable memtable", key)
 		if exists {
-			db.logger.Printf("Found entry with key: %s in SSTable %d", key, i)
-			return entry, nil
+			entry = m.combine(entry, found)
+		}
+		found, exists = entry, true
+		if !found.Merge {
+			break
+		}
+	}
+	return found, exists
+}
+
+// tableCandidates lists the SSTables whose key range covers key, newest first.
+// The caller must hold db.mu.
+func (db *LSM) tableCandidates(key string) []string {
+	return candidateTables(db.Sstables, db.levels, db.tableInfo, key)
+}
+
+// candidateTables lists the tables among l0 and levels whose key range covers
+// key, newest first: the L0 tables and then at most one table from each level.
+func candidateTables(l0 []string, levels [][]string, infos map[string]TableInfo, key string) []string {
+	fileNames := []string{}
+	for i := len(l0) - 1; i >= 0; i-- {
+		if info, ok := infos[l0[i]]; ok && !info.covers(key) {
+			continue
+		}
+		fileNames = append(fileNames, l0[i])
+	}
+
+	for _, levelFiles := range levels {
+		// Tables in a level do not overlap, so only the first one ending at or
+		// after key can hold it
+		i := sort.Search(len(levelFiles), func(i int) bool {
+			return infos[levelFiles[i]].MaxKey >= key
+		})
+		if i == len(levelFiles) {
+			continue
+		}
+		if info, ok := infos[levelFiles[i]]; ok && !info.covers(key) {
+			continue
+		}
+		fileNames = append(fileNames, levelFiles[i])
+	}
+	return fileNames
+}
+
+// getFromSSTables returns the record for key from the first of fileNames that
+// holds it. newer is the merge operand the memtables hold for key, if any,
+// which is combined with the records of fileNames until one that is not an
+// operand is found. It reads no LSM state, so it may run without db.mu as long
+// as the files are pinned.
+func (db *LSM) getFromSSTables(ctx context.Context, fileNames []string, key string, newer Entry, now time.Time) (Entry, error) {
+	m := db.merger(now)
+	found := newer
+	for _, fileName := range fileNames {
+		if err := ctx.Err(); err != nil {
+			return Entry{}, err
+		}
+		entry, exists, err := db.searchInSSTable(fileName, key)
+		if err != nil {
+			return Entry{}, err
+		}
+		if !exists {
+			continue
+		}
+		db.logger.Debugf("Found entry with key: %s in SSTable %s", key, fileName)
+		if found.Merge {
+			entry = m.combine(entry, found)
+		}
+		found = entry
+		if !found.Merge {
+			return liveEntry(found, now)
+		}
+	}
+	if found.Merge {
+		return liveEntry(m.resolve(found), now)
+	}
+
+	db.logger.Debugf("Entry with key: %s not found", key)
+	return Entry{}, ErrNotFound
+}
+
+// Scan returns the live entries with startKey <= key < endKey in key order. An
+// empty endKey scans to the last key and a limit of zero or less returns every
+// matching entry. Like Get, the memtable takes precedence over SSTables and
+// newer SSTables over older ones, and deleted and expired keys are left out.
+func (db *LSM) Scan(startKey string, endKey string, limit int) ([]Entry, error) {
+	return db.ScanContext(context.Background(), startKey, endKey, limit)
+}
+
+// ScanContext is Scan that gives up with the error of ctx once it is done. The
+// context is checked before each SSTable is read.
+func (db *LSM) ScanContext(ctx context.Context, startKey string, endKey string, limit int) ([]Entry, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	memtables := append(db.immutables[:len(db.immutables):len(db.immutables)], db.Memtable)
+	sources, err := db.scanSources(ctx, scanOrder(db.Sstables, db.levels), db.tableInfo, memtables, startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return db.merger(db.now()).liveEntries(sources, limit), nil
+}
+
+// scanOrder lists the tables among l0 and levels oldest first, the order
+// scanSources expects. Deeper levels hold older data than the levels above
+// them.
+func scanOrder(l0 []string, levels [][]string) []string {
+	fileNames := []string{}
+	for level := len(levels) - 1; level >= 0; level-- {
+		fileNames = append(fileNames, levels[level]...)
+	}
+	return append(fileNames, l0...)
+}
+
+// scanSources reads the entries with startKey <= key < endKey from fileNames
+// and then memtables, both oldest first, so mergeEntries keeps the newest
+// record. Tables whose key range does not overlap the scan are skipped.
+func (db *LSM) scanSources(ctx context.Context, fileNames []string, infos map[string]TableInfo, memtables []*Memtable, startKey, endKey string) ([][]Entry, error) {
+	sources := make([][]Entry, 0, len(fileNames)+len(memtables))
+	for _, fileName := range fileNames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if info, ok := infos[fileName]; ok && !info.overlaps(startKey, endKey) {
+			continue
+		}
+		entries, err := db.sstableMgr.Scan(fileName, startKey, endKey)
+		if err != nil {
+			db.logger.Errorf("Error in scanning sstable %s: %v", fileName, err)
+			return nil, err
+		}
+		sources = append(sources, entries)
+	}
+
+	for _, memtable := range memtables {
+		memtableEntries := []Entry{}
+		it := memtable.Iterator()
+		for it.Seek(startKey); it.Valid(); it.Next() {
+			entry := it.Entry()
+			if endKey != "" && entry.Key >= endKey {
+				break
+			}
+			memtableEntries = append(memtableEntries, entry)
+		}
+		sources = append(sources, memtableEntries)
+	}
+	return sources, nil
+}
+
+// liveEntries merges sources, drops deleted and expired entries and returns at
+// most limit of the rest. Sources must go back to the oldest record of every
+// key, since merge operands left over are turned into values.
+func (m merger) liveEntries(sources [][]Entry, limit int) []Entry {
+	merged := []Entry{}
+	for _, entry := range m.mergeEntries(sources, true) {
+		if entry = m.resolve(entry); !entry.expired(m.now) {
+			merged = append(merged, entry)
 		}
 	}
+	if limit > 0 && len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged
+}
 
-	db.logger.Printf("Entry with key: %s not found", key)
-	return Entry{}, errors.New("entry not found")
+// liveEntry hides tombstones and expired entries from callers, reporting them
+// as missing keys.
+func liveEntry(entry Entry, now time.Time) (Entry, error) {
+	if entry.Tombstone || entry.expired(now) {
+		return Entry{}, ErrNotFound
+	}
+	return entry, nil
 }
 
-func (db *LSM) searchInSSTable(idx int, key string) (Entry, bool) {
-	filename := fmt.Sprintf("sstable_%d.sst", idx)
+// searchInSSTable looks key up in filename and reports whether the table holds
+// a record for it. Any error but ErrNotFound, such as a CorruptionError, is
+// returned rather than treated as a miss, which would serve an older record or
+// none in place of the one the table cannot be read for.
+func (db *LSM) searchInSSTable(filename string, key string) (Entry, bool, error) {
+	mayContain, err := db.sstableMgr.MayContain(filename, key)
+	if err != nil {
+		db.logger.Errorf("Error in reading bloom filter of sstable %s: %v", filename, err)
+	} else if !mayContain {
+		return Entry{}, false, nil
+	}
+
 	entry, err := db.sstableMgr.FindKey(filename, key)
+	// Misses the Bloom filter let through are only worth a debug message
+	if errors.Is(err, ErrNotFound) {
+		db.logger.Debugf("Entry with key: %s not in sstable %s", key, filename)
+		return Entry{}, false, nil
+	}
 	if err != nil {
-		db.logger.Printf("Error in reading sstable %s: %v", filename, err)
-		return Entry{}, false
+		db.logger.Errorf("Error in reading sstable %s: %v", filename, err)
+		return Entry{}, false, err
+	}
+	return entry, true, nil
+}
+
+// checkSize rejects keys and values larger than MaxKeySize and MaxValueSize.
+func (db *LSM) checkSize(key string, value []byte) error {
+	if len(key) > db.maxKeySize {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrKeyTooLarge, len(key), db.maxKeySize)
+	}
+	if len(value) > db.maxValueSize {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrValueTooLarge, len(value), db.maxValueSize)
+	}
+	return nil
+}
+
+// nextSequence allocates the sequence number of a new write. The caller must
+// hold db.mu.
+func (db *LSM) nextSequence() uint64 {
+	db.lastSequence++
+	return db.lastSequence
+}
+
+// newSSTableName allocates a file name no other SSTable has used. The caller
+// must hold db.mu.
+func (db *LSM) newSSTableName() string {
+	filename := fmt.Sprintf("sstable_%d.sst", db.nextSSTableID)
+	db.nextSSTableID++
+	return filename
+}
+
+// nextSSTableID returns one past the highest id among the given SSTable names.
+func nextSSTableID(fileNames []string) int {
+	next := 0
+	for _, fileName := range fileNames {
+		if id, ok := sstableID(fileName); ok && id >= next {
+			next = id + 1
+		}
 	}
-	return entry, true
+	return next
+}
+
+// sstableID parses the id out of an SSTable name made by newSSTableName.
+func sstableID(fileName string) (int, bool) {
+	var id int
+	_, err := fmt.Sscanf(fileName, "sstable_%d.sst", &id)
+	return id, err == nil
 }
@@ -2,12 +2,16 @@ package db
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -19,11 +23,14 @@ func TestPutAndGet(t *testing.T) {
 	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
 
 	// Create a new instance of the Db
-	database := NewDb(Options{
+	database, err := NewDb(Options{
 		MemtableThreshold: 1000,
 		SstableMgr:        &MockSSTableManager{},
 		Logger:            logger,
 	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
 
 	// Test data to put into the database
 	key := "user1"
@@ -62,23 +69,25 @@ func TestGetNonExistentKey(t *testing.T) {
 	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
 
 	// Create a new instance of the Db
-	database := NewDb(Options{
+	database, err := NewDb(Options{
 		MemtableThreshold: 1000,
 		SstableMgr:        &MockSSTableManager{},
 		Logger:            logger,
 	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
 
 	// Try to get an entry that does not exist
-	_, err := database.Get("nonexistent")
+	_, err = database.Get("nonexistent")
 
 	// Expecting an error for a missing key
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
 
-	expectedError := "entry not found"
-	if err.Error() != expectedError {
-		t.Errorf("expected error message: %s, got: %s", expectedError, err.Error())
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
 	}
 }
 
@@ -87,11 +96,14 @@ func TestConcurrency(t *testing.T) {
 	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
 
 	// Create a new instance of the Db
-	var database *LSM = NewDb(Options{
+	database, err := NewDb(Options{
 		MemtableThreshold: 10,
 		SstableMgr:        &MockSSTableManager{},
 		Logger:            logger,
 	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
 	const iterations = 100
 	var wg sync.WaitGroup
 	wg.Add(iterations)
@@ -108,12 +120,13 @@ func TestConcurrency(t *testing.T) {
 	}
 	wg.Wait()
 
+	waitForFlushes(t, database)
 	if len(database.Sstables) != 10 {
 		t.Fatalf("expected %d, got: %d", 10, len(database.Sstables))
 	}
 
-	if len(database.Memtable) != 0 {
-		t.Fatalf("expected %d, got: %d", 0, len(database.Memtable))
+	if database.Memtable.Len() != 0 {
+		t.Fatalf("expected %d, got: %d", 0, database.Memtable.Len())
 	}
 
 	for i := 0; i < iterations; i++ {
@@ -132,11 +145,14 @@ func TestConcurrency(t *testing.T) {
 func TestFlushMemtableToDisk(t *testing.T) {
 	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
 
-	database := NewDb(Options{
+	database, err := NewDb(Options{
 		MemtableThreshold: 3,
 		SstableMgr:        &MockSSTableManager{},
 		Logger:            logger,
 	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
 
 	// Add entries to trigger flush
 	for i := 0; i < 3; i++ {
@@ -147,216 +163,1793 @@ func TestFlushMemtableToDisk(t *testing.T) {
 	}
 
 	// Check if memtable was flushed
-	if len(database.Memtable) != 0 {
-		t.Errorf("Expected empty memtable, got %d entries", len(database.Memtable))
+	if database.Memtable.Len() != 0 {
+		t.Errorf("Expected empty memtable, got %d entries", database.Memtable.Len())
 	}
 
+	waitForFlushes(t, database)
 	// Check if SSTable was created
 	if len(database.Sstables) != 1 {
 		t.Errorf("Expected 1 SSTable, got %d", len(database.Sstables))
 	}
 
 	// Add one more entry to check if new memtable works
-	err := database.Put(Entry{Key: "key3", Value: []byte("value3")})
+	err = database.Put(Entry{Key: "key3", Value: []byte("value3")})
 	if err != nil {
 		t.Fatalf("Failed to put entry after flush: %v", err)
 	}
 
-	if len(database.Memtable) != 1 {
-		t.Errorf("Expected 1 entry in memtable after flush, got %d", len(database.Memtable))
+	if database.Memtable.Len() != 1 {
+		t.Errorf("Expected 1 entry in memtable after flush, got %d", database.Memtable.Len())
 	}
 }
 
-func convertToBytes(num int16) []byte {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.BigEndian, num)
+func TestDelete(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        &MockSSTableManager{},
+		Logger:            logger,
+	})
 	if err != nil {
-		fmt.Println("binary.Write failed:", err)
+		t.Fatalf("error creating db: %v", err)
 	}
-	return buf.Bytes()
-}
-
-func convertBytesToInt(buf []byte) int16 {
-	var retVal int16
-	reader := bytes.NewReader(buf)
-	binary.Read(reader, binary.BigEndian, &retVal)
-	return retVal
-}
 
-type MockSSTableManager struct {
-}
+	err = database.Put(Entry{Key: "user1", Value: []byte("Hello, World!")})
+	if err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
 
-func (ffd *MockSSTableManager) Write(fileName string, data []Entry) error {
-	sstablemockstore = append(sstablemockstore, data...)
-	return nil
-}
+	err = database.Delete("user1")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
 
-func (ffd *MockSSTableManager) ReadAll(fileName string) ([]Entry, error) {
-	return sstablemockstore, nil
-}
+	_, err = database.Get("user1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
 
-func (ffd *MockSSTableManager) ReadBlock(fileName string, offset uint64) ([]Entry, error) {
-	return nil, nil
+	// Deleting a key that no longer exists reports it as missing
+	err = database.Delete("user1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
 }
 
-func (ffd *MockSSTableManager) FindKey(fileName string, key string) (Entry, error) {
-	for _, entry := range sstablemockstore {
-		if entry.Key == key {
-			return entry, nil
-		}
+func TestDeleteKeyInSSTable(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
 	}
-	return Entry{}, errors.New("entry not found")
-}
+	dataDir := filepath.Join(currentTestDir, ".testDeleteKeyInSSTable")
+	defer deleteDirectoryIfExists(dataDir)
 
-func TestSerializeDeserialize(t *testing.T) {
-	originalEntry := Entry{
-		Key:   "testKey",
-		Value: []byte("testValue"),
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
 	}
 
-	serialized, err := serializeToBase64(originalEntry)
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
 	if err != nil {
-		t.Fatalf("Failed to serialize: %v", err)
+		t.Fatalf("error creating db: %v", err)
 	}
 
-	deserialized, err := deserializeFromBase64(serialized)
+	// Both keys are flushed to sstable_0.sst
+	for i := 0; i < 2; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	if database.Memtable.Len() != 0 {
+		t.Fatalf("expected %d, got: %d", 0, database.Memtable.Len())
+	}
+
+	err = database.Delete("key0")
 	if err != nil {
-		t.Fatalf("Failed to deserialize: %v", err)
+		t.Fatalf("expected no error, got: %v", err)
 	}
 
-	if deserialized.Key != originalEntry.Key {
-		t.Errorf("Expected key %s, got %s", originalEntry.Key, deserialized.Key)
+	_, err = database.Get("key0")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
 	}
 
-	if !bytes.Equal(deserialized.Value, originalEntry.Value) {
-		t.Errorf("Expected value %v, got %v", originalEntry.Value, deserialized.Value)
+	entry, err := database.Get("key1")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if string(entry.Value) != "value1" {
+		t.Errorf("Expected value1, got %s", string(entry.Value))
 	}
 }
 
-func TestSearchInSSTable(t *testing.T) {
+func TestDeleteSurvivesFlush(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testDeleteSurvivesFlush")
+	defer deleteDirectoryIfExists(dataDir)
+
 	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
 
-	mockSSTableMgr := &MockSSTableManager{}
-	database := NewDb(Options{
-		MemtableThreshold: 3,
-		SstableMgr:        mockSSTableMgr,
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
 		Logger:            logger,
 	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
 
-	// Add entries to trigger flush
-	for i := 0; i < 3; i++ {
+	// key0 and key1 are flushed to the first SSTable
+	for i := 0; i < 2; i++ {
 		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
 		if err != nil {
 			t.Fatalf("Failed to put entry: %v", err)
 		}
 	}
 
-	// Search for existing key
-	entry, exists := database.searchInSSTable(0, "key1")
-	if !exists {
-		t.Errorf("Expected to find key1 in SSTable")
+	// The tombstone for key0 and key2 are flushed to the second SSTable
+	err = database.Delete("key0")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	err = database.Put(Entry{Key: "key2", Value: []byte("value2")})
+	if err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	waitForFlushes(t, database)
+	if len(database.Sstables) != 2 {
+		t.Fatalf("expected %d, got: %d", 2, len(database.Sstables))
+	}
+	if database.Memtable.Len() != 0 {
+		t.Fatalf("expected %d, got: %d", 0, database.Memtable.Len())
+	}
+
+	flushed, err := ssm.FindKey(database.Sstables[1], "key0")
+	if err != nil {
+		t.Fatalf("expected tombstone in SSTable, got: %v", err)
+	}
+	if !flushed.Tombstone {
+		t.Fatalf("expected tombstone for key0, got: %v", flushed)
+	}
+
+	_, err = database.Get("key0")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	entry, err := database.Get("key1")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
 	}
 	if string(entry.Value) != "value1" {
 		t.Errorf("Expected value1, got %s", string(entry.Value))
 	}
+}
 
-	// Search for non-existing key
-	_, exists = database.searchInSSTable(0, "nonexistent")
-	if exists {
-		t.Errorf("Expected not to find nonexistent key in SSTable")
+func TestReopenRestoresSstables(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
 	}
-}
+	dataDir := filepath.Join(currentTestDir, ".testReopenRestoresSstables")
+	defer deleteDirectoryIfExists(dataDir)
 
-func TestConcurrentGet(t *testing.T) {
 	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
 
-	database := NewDb(Options{
-		MemtableThreshold: 1000,
-		SstableMgr:        &MockSSTableManager{},
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
 		Logger:            logger,
 	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
 
-	// Add some entries
-	for i := 0; i < 100; i++ {
+	// Three flushes, with key0 overwritten in the last one
+	for i := 0; i < 5; i++ {
 		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
 		if err != nil {
 			t.Fatalf("Failed to put entry: %v", err)
 		}
 	}
+	err = database.Put(Entry{Key: "key0", Value: []byte("updated")})
+	if err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	waitForFlushes(t, database)
+	if len(database.Sstables) != 3 {
+		t.Fatalf("expected %d, got: %d", 3, len(database.Sstables))
+	}
+	// The data directory is locked until the first instance is closed
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < 100; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			key := fmt.Sprintf("key%d", i)
-			entry, err := database.Get(key)
-			if err != nil {
-				t.Errorf("Failed to get key %s: %v", key, err)
-			}
-			if string(entry.Value) != fmt.Sprintf("value%d", i) {
-				t.Errorf("Expected value%d, got %s", i, string(entry.Value))
-			}
-		}(i)
+	ssm, err = NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	reopened, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	if len(reopened.Sstables) != 3 {
+		t.Fatalf("expected %d, got: %d", 3, len(reopened.Sstables))
+	}
+	for i, fileName := range database.Sstables {
+		if reopened.Sstables[i] != fileName {
+			t.Fatalf("expected %s at position %d, got: %s", fileName, i, reopened.Sstables[i])
+		}
+	}
+
+	for i := 1; i < 5; i++ {
+		entry, err := reopened.Get(fmt.Sprintf("key%d", i))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if string(entry.Value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Expected value%d, got %s", i, string(entry.Value))
+		}
+	}
+
+	entry, err := reopened.Get("key0")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if string(entry.Value) != "updated" {
+		t.Errorf("Expected updated, got %s", string(entry.Value))
 	}
-	wg.Wait()
 }
 
-func TestErrorHandling(t *testing.T) {
+func TestReopenWithoutManifest(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testReopenWithoutManifest")
+	defer deleteDirectoryIfExists(dataDir)
+
 	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
 
-	// Test SSTableManager write error
-	errorMgr := &ErrorMockSSTableManager{writeError: fmt.Errorf("write error")}
-	database := NewDb(Options{
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
 		MemtableThreshold: 2,
-		SstableMgr:        errorMgr,
+		SstableMgr:        ssm,
 		Logger:            logger,
 	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
 
-	err := database.Put(Entry{Key: "key1", Value: []byte("value1")})
+	// Three flushes, with key0 overwritten in the last one
+	for i := 0; i < 5; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	err = database.Put(Entry{Key: "key0", Value: []byte("updated")})
 	if err != nil {
-		t.Fatalf("Failed to put first entry: %v", err)
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
 	}
 
-	err = database.Put(Entry{Key: "key2", Value: []byte("value2")})
-	if err == nil {
-		t.Errorf("Expected error on second put, got nil")
+	// A directory from before the manifest existed, with a file a crash left
+	// half written
+	if err := os.Remove(filepath.Join(dataDir, ManifestFileName)); err != nil {
+		t.Fatalf("error removing manifest: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "sstable_9.sst"), []byte("partial"), 0644); err != nil {
+		t.Fatalf("error writing partial sstable: %v", err)
 	}
 
-	// Test SSTableManager read error
-	errorMgr = &ErrorMockSSTableManager{readError: fmt.Errorf("read error")}
-	database = NewDb(Options{
+	ssm, err = NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	reopened, err := NewDb(Options{
 		MemtableThreshold: 2,
-		SstableMgr:        errorMgr,
+		SstableMgr:        ssm,
 		Logger:            logger,
 	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
 
-	database.Put(Entry{Key: "key1", Value: []byte("value1")})
-	database.Put(Entry{Key: "key2", Value: []byte("value2")})
+	expected := []string{"sstable_0.sst", "sstable_1.sst", "sstable_2.sst"}
+	if fmt.Sprint(reopened.Sstables) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got: %v", expected, reopened.Sstables)
+	}
+	for i := 1; i < 5; i++ {
+		entry, err := reopened.Get(fmt.Sprintf("key%d", i))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if string(entry.Value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Expected value%d, got %s", i, string(entry.Value))
+		}
+	}
+	entry, err := reopened.Get("key0")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if string(entry.Value) != "updated" {
+		t.Errorf("Expected updated, got %s", string(entry.Value))
+	}
 
-	_, err = database.Get("key1")
-	if err == nil {
-		t.Errorf("Expected error on get, got nil")
+	// The next flush must not reuse the name of any file on disk
+	if err := reopened.Put(Entry{Key: "key5", Value: []byte("value5")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if err := reopened.Flush(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if last := reopened.Sstables[len(reopened.Sstables)-1]; last != "sstable_10.sst" {
+		t.Fatalf("expected %s, got: %s", "sstable_10.sst", last)
 	}
 }
 
-// ErrorMockSSTableManager is a mock SSTableManager that can return errors
-type ErrorMockSSTableManager struct {
-	MockSSTableManager
-	writeError error
-	readError  error
-}
+func TestFlushAfterReopenDoesNotOverwriteSstables(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testFlushAfterReopenDoesNotOverwriteSstables")
+	defer deleteDirectoryIfExists(dataDir)
 
-func (m *ErrorMockSSTableManager) Write(fileName string, data []Entry) error {
-	if m.writeError != nil {
-		return m.writeError
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold:      2,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+		CompactionMinThreshold: 2,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
 	}
-	return m.MockSSTableManager.Write(fileName, data)
-}
 
-func (m *ErrorMockSSTableManager) FindKey(fileName string, key string) (Entry, error) {
-	if m.readError != nil {
-		return Entry{}, m.readError
+	// Three flushes compacted into one table leave a single, higher numbered
+	// file where len(Sstables) would have suggested sstable_1.sst
+	for i := 0; i < 6; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+	if err := database.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	existing := map[string][]byte{}
+	fileNames, err := ssm.ListFiles()
+	if err != nil {
+		t.Fatalf("error listing files: %v", err)
+	}
+	for _, fileName := range fileNames {
+		contents, err := os.ReadFile(filepath.Join(dataDir, fileName))
+		if err != nil {
+			t.Fatalf("error reading %s: %v", fileName, err)
+		}
+		existing[fileName] = contents
+	}
+
+	ssm, err = NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	reopened, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	for i := 6; i < 10; i++ {
+		err := reopened.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, reopened)
+
+	for fileName, contents := range existing {
+		current, err := os.ReadFile(filepath.Join(dataDir, fileName))
+		if err != nil {
+			t.Fatalf("error reading %s: %v", fileName, err)
+		}
+		if !bytes.Equal(current, contents) {
+			t.Fatalf("expected %s to be left untouched", fileName)
+		}
+	}
+	for _, fileName := range reopened.Sstables[len(existing):] {
+		if _, ok := existing[fileName]; ok {
+			t.Fatalf("expected a new file name, got: %s", fileName)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		entry, err := reopened.Get(fmt.Sprintf("key%d", i))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if string(entry.Value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Expected value%d, got %s", i, string(entry.Value))
+		}
+	}
+}
+
+func TestSequenceNumbersContinueAfterReopen(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testSequenceNumbersContinueAfterReopen")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err := database.Put(Entry{Key: "key", Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	if err := database.Delete("key"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	// The memtable keeps only the tombstone, the fourth write
+	info, err := ssm.TableInfo(database.Sstables[0])
+	if err != nil {
+		t.Fatalf("error reading table info: %v", err)
+	}
+	if info.MinSequence != 4 || info.MaxSequence != 4 {
+		t.Fatalf("expected sequence range [4, 4], got: [%d, %d]", info.MinSequence, info.MaxSequence)
+	}
+
+	ssm, err = NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	reopened, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	if err := reopened.Put(Entry{Key: "key", Value: []byte("again")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	entry, err := reopened.Get("key")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if entry.SequenceNumber != 5 {
+		t.Fatalf("expected sequence number %d, got: %d", 5, entry.SequenceNumber)
+	}
+}
+
+func TestCloseFlushesMemtable(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testCloseFlushesMemtable")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if err := database.Put(Entry{Key: "key5", Value: []byte("value5")}); !errors.Is(err, ErrDBClosed) {
+		t.Fatalf("expected %v, got: %v", ErrDBClosed, err)
+	}
+	if _, err := database.Get("key0"); !errors.Is(err, ErrDBClosed) {
+		t.Fatalf("expected %v, got: %v", ErrDBClosed, err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("expected closing twice to succeed, got: %v", err)
+	}
+
+	ssm, err = NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	reopened, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		entry, err := reopened.Get(fmt.Sprintf("key%d", i))
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if string(entry.Value) != fmt.Sprintf("value%d", i) {
+			t.Errorf("Expected value%d, got %s", i, string(entry.Value))
+		}
+	}
+}
+
+func TestCloseWithoutFlush(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        &MockSSTableManager{},
+		Logger:            logger,
+		SkipFlushOnClose:  true,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	if err := database.Put(Entry{Key: "key1", Value: []byte("value1")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(database.Sstables) != 0 {
+		t.Fatalf("expected %d, got: %d", 0, len(database.Sstables))
+	}
+	if err := database.Delete("key1"); !errors.Is(err, ErrDBClosed) {
+		t.Fatalf("expected %v, got: %v", ErrDBClosed, err)
+	}
+	if _, err := database.Scan("", "", 0); !errors.Is(err, ErrDBClosed) {
+		t.Fatalf("expected %v, got: %v", ErrDBClosed, err)
+	}
+}
+
+func TestScanMergesMemtableAndSstables(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testScanDb")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 3,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// Two SSTables and the memtable each hold a version of "shared"
+	puts := []Entry{
+		{Key: "a", Value: []byte("a0")},
+		{Key: "shared", Value: []byte("sstable0")},
+		{Key: "z", Value: []byte("z0")},
+		{Key: "b", Value: []byte("b1")},
+		{Key: "shared", Value: []byte("sstable1")},
+		{Key: "c", Value: []byte("c1")},
+		{Key: "shared", Value: []byte("memtable")},
+	}
+	for _, entry := range puts {
+		if err := database.Put(entry); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	if err := database.Delete("b"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	waitForFlushes(t, database)
+	if len(database.Sstables) != 2 {
+		t.Fatalf("expected %d, got: %d", 2, len(database.Sstables))
+	}
+
+	entries, err := database.Scan("a", "z", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	expected := []Entry{
+		{Key: "a", Value: []byte("a0")},
+		{Key: "c", Value: []byte("c1")},
+		{Key: "shared", Value: []byte("memtable")},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got: %v", len(expected), entries)
+	}
+	for i, entry := range entries {
+		if entry.Key != expected[i].Key || !bytes.Equal(entry.Value, expected[i].Value) {
+			t.Fatalf("mismatch at index %d: expected %v, got %v", i, expected[i], entry)
+		}
+	}
+
+	entries, err = database.Scan("d", "", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Key != "shared" || entries[1].Key != "z" {
+		t.Fatalf("expected shared and z, got: %v", entries)
+	}
+}
+
+func TestScanWithLimitAcrossBlocks(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testScanWithLimitAcrossBlocks")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 300,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// 300 keys fill one SSTable with three blocks, then every tenth key is
+	// overwritten in the memtable
+	for i := 0; i < 300; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%03d", i), Value: []byte("sstable")})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	for i := 0; i < 300; i += 10 {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%03d", i), Value: []byte("memtable")})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+	if len(database.Sstables) != 1 {
+		t.Fatalf("expected %d, got: %d", 1, len(database.Sstables))
+	}
+
+	entries, err := database.Scan("key095", "key215", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(entries) != 120 {
+		t.Fatalf("expected %d entries, got: %d", 120, len(entries))
+	}
+	for i, entry := range entries {
+		expectedKey := fmt.Sprintf("key%03d", i+95)
+		expectedValue := "sstable"
+		if (i+95)%10 == 0 {
+			expectedValue = "memtable"
+		}
+		if entry.Key != expectedKey || string(entry.Value) != expectedValue {
+			t.Fatalf("expected %s=%s, got: %v", expectedKey, expectedValue, entry)
+		}
+	}
+
+	entries, err = database.Scan("key095", "", 10)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(entries) != 10 || entries[0].Key != "key095" || entries[9].Key != "key104" {
+		t.Fatalf("expected key095 to key104, got: %v", entries)
+	}
+	if string(entries[5].Value) != "memtable" {
+		t.Fatalf("expected the memtable value for key100, got: %s", entries[5].Value)
+	}
+}
+
+func convertToBytes(num int16) []byte {
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.BigEndian, num)
+	if err != nil {
+		fmt.Println("binary.Write failed:", err)
+	}
+	return buf.Bytes()
+}
+
+func convertBytesToInt(buf []byte) int16 {
+	var retVal int16
+	reader := bytes.NewReader(buf)
+	binary.Read(reader, binary.BigEndian, &retVal)
+	return retVal
+}
+
+// waitForFlushes blocks until the background flushes of database are done.
+func waitForFlushes(t *testing.T, database *LSM) {
+	database.mu.Lock()
+	err := database.waitForFlushes()
+	database.mu.Unlock()
+	if err != nil {
+		t.Fatalf("background flush failed: %v", err)
+	}
+}
+
+type MockSSTableManager struct {
+	manifest [][]string
+}
+
+// The test managers must keep up with the real interface.
+var (
+	_ SSTableManager = (*MockSSTableManager)(nil)
+	_ SSTableManager = (*ErrorMockSSTableManager)(nil)
+	_ SSTableManager = (*BloomMockSSTableManager)(nil)
+	_ SSTableManager = (*RangeMockSSTableManager)(nil)
+	_ SSTableManager = (*CountingMockSSTableManager)(nil)
+	_ SSTableManager = (*BlockingMockSSTableManager)(nil)
+	_ SSTableManager = (*SlowFindMockSSTableManager)(nil)
+)
+
+func (ffd *MockSSTableManager) Write(fileName string, data []Entry) error {
+	sstablemockstore = append(sstablemockstore, data...)
+	return nil
+}
+
+// collectEntries drains it into a slice, for mocks that implement
+// WriteFromIterator with their Write.
+func collectEntries(it EntryIterator) []Entry {
+	entries := []Entry{}
+	for ; it.Valid(); it.Next() {
+		entries = append(entries, it.Entry())
+	}
+	return entries
+}
+
+func (ffd *MockSSTableManager) WriteFromIterator(fileName string, it EntryIterator, count int) error {
+	return ffd.Write(fileName, collectEntries(it))
+}
+
+func (ffd *MockSSTableManager) ReadAll(fileName string) ([]Entry, error) {
+	return sstablemockstore, nil
+}
+
+func (ffd *MockSSTableManager) ReadBlock(fileName string, offset uint64) ([]Entry, error) {
+	return nil, nil
+}
+
+func (ffd *MockSSTableManager) FindKey(fileName string, key string) (Entry, error) {
+	found := false
+	var newest Entry
+	for _, entry := range sstablemockstore {
+		if entry.Key == key && (!found || entry.SequenceNumber >= newest.SequenceNumber) {
+			newest = entry
+			found = true
+		}
+	}
+	if !found {
+		return Entry{}, ErrNotFound
+	}
+	return newest, nil
+}
+
+func (ffd *MockSSTableManager) MayContain(fileName string, key string) (bool, error) {
+	return true, nil
+}
+
+func (ffd *MockSSTableManager) Scan(fileName string, startKey string, endKey string) ([]Entry, error) {
+	results := []Entry{}
+	for _, entry := range sstablemockstore {
+		if entry.Key >= startKey && (endKey == "" || entry.Key < endKey) {
+			results = append(results, entry)
+		}
+	}
+	return results, nil
+}
+
+func (ffd *MockSSTableManager) Delete(fileName string) error {
+	return nil
+}
+
+func (ffd *MockSSTableManager) Size(fileName string) (int64, error) {
+	return int64(len(sstablemockstore)), nil
+}
+
+func (ffd *MockSSTableManager) ListFiles() ([]string, error) {
+	fileNames := []string{}
+	for _, level := range ffd.manifest {
+		fileNames = append(fileNames, level...)
+	}
+	return fileNames, nil
+}
+
+func (ffd *MockSSTableManager) WriteManifest(levels [][]string) error {
+	ffd.manifest = levels
+	return nil
+}
+
+func (ffd *MockSSTableManager) ReadManifest() ([][]string, error) {
+	return append([][]string{}, ffd.manifest...), nil
+}
+
+func (ffd *MockSSTableManager) Verify(fileName string) (VerifyReport, error) {
+	return VerifyReport{FileName: fileName}, nil
+}
+
+func (ffd *MockSSTableManager) RepairTruncate(fileName string) (VerifyReport, error) {
+	return VerifyReport{FileName: fileName}, nil
+}
+
+func (ffd *MockSSTableManager) Describe(fileName string) (SSTableInfo, error) {
+	return SSTableInfo{FileName: fileName, EntryCount: uint64(len(sstablemockstore))}, nil
+}
+
+func (ffd *MockSSTableManager) TableInfo(fileName string) (TableInfo, error) {
+	entries := append([]Entry{}, sstablemockstore...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return tableInfoOf(entries), nil
+}
+
+func TestSerializeDeserialize(t *testing.T) {
+	originalEntry := Entry{
+		Key:   "testKey",
+		Value: []byte("testValue"),
+	}
+
+	serialized, err := serializeToBase64(originalEntry)
+	if err != nil {
+		t.Fatalf("Failed to serialize: %v", err)
+	}
+
+	deserialized, err := deserializeFromBase64(serialized)
+	if err != nil {
+		t.Fatalf("Failed to deserialize: %v", err)
+	}
+
+	if deserialized.Key != originalEntry.Key {
+		t.Errorf("Expected key %s, got %s", originalEntry.Key, deserialized.Key)
+	}
+
+	if !bytes.Equal(deserialized.Value, originalEntry.Value) {
+		t.Errorf("Expected value %v, got %v", originalEntry.Value, deserialized.Value)
+	}
+}
+
+func TestSearchInSSTable(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mockSSTableMgr := &MockSSTableManager{}
+	database, err := NewDb(Options{
+		MemtableThreshold: 3,
+		SstableMgr:        mockSSTableMgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// Add entries to trigger flush
+	for i := 0; i < 3; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+
+	waitForFlushes(t, database)
+
+	// Search for existing key
+	entry, exists, err := database.searchInSSTable(database.Sstables[0], "key1")
+	if err != nil || !exists {
+		t.Errorf("Expected to find key1 in SSTable, got: %v", err)
+	}
+	if string(entry.Value) != "value1" {
+		t.Errorf("Expected value1, got %s", string(entry.Value))
+	}
+
+	// Search for non-existing key
+	_, exists, err = database.searchInSSTable(database.Sstables[0], "nonexistent")
+	if err != nil || exists {
+		t.Errorf("Expected not to find nonexistent key in SSTable, got: %v", err)
+	}
+}
+
+func TestConcurrentGet(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        &MockSSTableManager{},
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// Add some entries
+	for i := 0; i < 100; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			entry, err := database.Get(key)
+			if err != nil {
+				t.Errorf("Failed to get key %s: %v", key, err)
+			}
+			if string(entry.Value) != fmt.Sprintf("value%d", i) {
+				t.Errorf("Expected value%d, got %s", i, string(entry.Value))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestErrorHandling(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	// Test SSTableManager write error
+	errorMgr := &ErrorMockSSTableManager{writeError: fmt.Errorf("write error")}
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        errorMgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	err = database.Put(Entry{Key: "key1", Value: []byte("value1")})
+	if err != nil {
+		t.Fatalf("Failed to put first entry: %v", err)
+	}
+
+	// The second put freezes the memtable; its flush fails in the background
+	err = database.Put(Entry{Key: "key2", Value: []byte("value2")})
+	if err != nil {
+		t.Fatalf("Failed to put second entry: %v", err)
+	}
+	err = database.Flush()
+	if err == nil {
+		t.Errorf("Expected error on flush, got nil")
+	}
+
+	// The unflushed entries stay readable
+	entry, err := database.Get("key2")
+	if err != nil || string(entry.Value) != "value2" {
+		t.Errorf("Expected value2 after a failed flush, got: %v, %v", entry, err)
+	}
+
+	// Test SSTableManager read error
+	errorMgr = &ErrorMockSSTableManager{readError: corruptionf("block", 0, "read error")}
+	database, err = NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        errorMgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	database.Put(Entry{Key: "key1", Value: []byte("value1")})
+	database.Put(Entry{Key: "key2", Value: []byte("value2")})
+	waitForFlushes(t, database)
+
+	// An unreadable table is an error, not a miss
+	_, err = database.Get("key1")
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) {
+		t.Errorf("Expected a corruption error on get, got: %v", err)
+	}
+}
+
+func TestGetReportsCorruptSSTable(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testGetReportsCorruptSSTable")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, BlockCacheSize: -1})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 100,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	for _, value := range []string{"old", "new"} {
+		if err := database.Put(Entry{Key: "key", Value: []byte(value)}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		if err := database.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+
+	// Clobber the only block of the newer table
+	file, err := os.OpenFile(filepath.Join(dataDir, database.Sstables[1]), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt(make([]byte, 8), fileHeaderSize(SSTableVersion)+BlockHeaderSize); err != nil {
+		t.Fatalf("error corrupting block: %s", err)
+	}
+
+	// The older value must not be served in place of the unreadable one
+	var corruption *CorruptionError
+	if entry, err := database.Get("key"); !errors.As(err, &corruption) || corruption.FileName != database.Sstables[1] {
+		t.Errorf("expected a corruption error for %s, got %q, %v", database.Sstables[1], entry.Value, err)
+	}
+	snapshot, err := database.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	defer snapshot.Release()
+	if entry, err := snapshot.Get("key"); !errors.As(err, &corruption) {
+		t.Errorf("expected a corruption error from the snapshot, got %q, %v", entry.Value, err)
+	}
+}
+
+func TestReadyAfterClose(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        &MockSSTableManager{},
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	if err := database.Ready(); err != nil {
+		t.Fatalf("expected an open database to be ready, got: %v", err)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatalf("error closing db: %v", err)
+	}
+	if err := database.Ready(); !errors.Is(err, ErrDBClosed) {
+		t.Errorf("expected ErrDBClosed after Close, got: %v", err)
+	}
+}
+
+func TestReadyAfterFailedFlush(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	writeErr := fmt.Errorf("write error")
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        &ErrorMockSSTableManager{writeError: writeErr},
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte("value")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	if err := database.Flush(); err == nil {
+		t.Fatalf("Expected error on flush, got nil")
+	}
+
+	err = database.Ready()
+	if !errors.Is(err, writeErr) || !strings.Contains(err.Error(), "last flush failed") {
+		t.Errorf("expected the failed flush to be reported, got: %v", err)
+	}
+}
+
+func TestReadyWithUnwritableDataDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Skipping this test as root, which can write to any directory")
+	}
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testReadyWithUnwritableDataDir")
+	defer deleteDirectoryIfExists(dataDir)
+
+	sstableMgr, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        sstableMgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+	if err := database.Ready(); err != nil {
+		t.Fatalf("expected the database to be ready, got: %v", err)
+	}
+
+	if err := os.Chmod(dataDir, 0500); err != nil {
+		t.Fatalf("error making data directory read-only: %s", err)
+	}
+	defer os.Chmod(dataDir, 0700)
+	err = database.Ready()
+	if err == nil || !strings.Contains(err.Error(), "sstables cannot be written") {
+		t.Errorf("expected an unwritable data directory to be reported, got: %v", err)
+	}
+}
+
+// ErrorMockSSTableManager is a mock SSTableManager that can return errors
+type ErrorMockSSTableManager struct {
+	MockSSTableManager
+	writeError error
+	readError  error
+}
+
+func (m *ErrorMockSSTableManager) Write(fileName string, data []Entry) error {
+	if m.writeError != nil {
+		return m.writeError
+	}
+	return m.MockSSTableManager.Write(fileName, data)
+}
+
+func (m *ErrorMockSSTableManager) WriteFromIterator(fileName string, it EntryIterator, count int) error {
+	return m.Write(fileName, collectEntries(it))
+}
+
+func (m *ErrorMockSSTableManager) FindKey(fileName string, key string) (Entry, error) {
+	if m.readError != nil {
+		return Entry{}, m.readError
+	}
+	return m.MockSSTableManager.FindKey(fileName, key)
+}
+
+func TestGetSkipsSstablesRuledOutByBloomFilter(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mgr := &BloomMockSSTableManager{keys: map[string]bool{"key0": true}}
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+
+	waitForFlushes(t, database)
+	_, err = database.Get("missing")
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if mgr.findKeyCalls != 0 {
+		t.Fatalf("expected no FindKey calls for a key ruled out by the filter, got: %d", mgr.findKeyCalls)
+	}
+
+	_, err = database.Get("key0")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if mgr.findKeyCalls != 1 {
+		t.Fatalf("expected %d FindKey call, got: %d", 1, mgr.findKeyCalls)
+	}
+}
+
+// BloomMockSSTableManager answers MayContain from a fixed key set and counts
+// how often the index has to be searched
+type BloomMockSSTableManager struct {
+	MockSSTableManager
+	keys         map[string]bool
+	findKeyCalls int
+}
+
+func (m *BloomMockSSTableManager) MayContain(fileName string, key string) (bool, error) {
+	return m.keys[key], nil
+}
+
+func (m *BloomMockSSTableManager) FindKey(fileName string, key string) (Entry, error) {
+	m.findKeyCalls++
+	return m.MockSSTableManager.FindKey(fileName, key)
+}
+
+// RangeMockSSTableManager keeps the entries of each SSTable apart and counts
+// the FindKey and Scan calls per file
+type RangeMockSSTableManager struct {
+	MockSSTableManager
+	files        map[string][]Entry
+	findKeyCalls map[string]int
+	scanCalls    map[string]int
+}
+
+func NewRangeMockSSTableManager() *RangeMockSSTableManager {
+	return &RangeMockSSTableManager{
+		files:        make(map[string][]Entry),
+		findKeyCalls: make(map[string]int),
+		scanCalls:    make(map[string]int),
+	}
+}
+
+func (m *RangeMockSSTableManager) Write(fileName string, data []Entry) error {
+	m.files[fileName] = append([]Entry{}, data...)
+	return nil
+}
+
+func (m *RangeMockSSTableManager) WriteFromIterator(fileName string, it EntryIterator, count int) error {
+	return m.Write(fileName, collectEntries(it))
+}
+
+func (m *RangeMockSSTableManager) FindKey(fileName string, key string) (Entry, error) {
+	m.findKeyCalls[fileName]++
+	for _, entry := range m.files[fileName] {
+		if entry.Key == key {
+			return entry, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+func (m *RangeMockSSTableManager) Scan(fileName string, startKey string, endKey string) ([]Entry, error) {
+	m.scanCalls[fileName]++
+	results := []Entry{}
+	for _, entry := range m.files[fileName] {
+		if entry.Key >= startKey && (endKey == "" || entry.Key < endKey) {
+			results = append(results, entry)
+		}
+	}
+	return results, nil
+}
+
+func (m *RangeMockSSTableManager) TableInfo(fileName string) (TableInfo, error) {
+	return tableInfoOf(m.files[fileName]), nil
+}
+
+func TestLookupsSkipSstablesOutsideKeyRange(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mgr := NewRangeMockSSTableManager()
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// Three SSTables covering [a, b], [c, d] and [e, f]
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := database.Put(Entry{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+	if len(database.Sstables) != 3 {
+		t.Fatalf("expected %d, got: %d", 3, len(database.Sstables))
+	}
+
+	entry, err := database.Get("c")
+	if err != nil || string(entry.Value) != "c" {
+		t.Fatalf("expected c, got: %v, %v", entry, err)
+	}
+	if _, err := database.Get("bb"); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	for i, expected := range []int{0, 1, 0} {
+		if calls := mgr.findKeyCalls[database.Sstables[i]]; calls != expected {
+			t.Fatalf("expected %d FindKey calls on %s, got: %d", expected, database.Sstables[i], calls)
+		}
+	}
+
+	entries, err := database.Scan("b", "d", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Key != "b" || entries[1].Key != "c" {
+		t.Fatalf("expected b and c, got: %v", entries)
+	}
+	for i, expected := range []int{1, 1, 0} {
+		if calls := mgr.scanCalls[database.Sstables[i]]; calls != expected {
+			t.Fatalf("expected %d Scan calls on %s, got: %d", expected, database.Sstables[i], calls)
+		}
+	}
+
+	// Ranges are restored from the SSTables when the database is reopened
+	reopened, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	if _, err := reopened.Get("e"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if calls := mgr.findKeyCalls[database.Sstables[2]]; calls != 1 {
+		t.Fatalf("expected %d FindKey call on %s, got: %d", 1, database.Sstables[2], calls)
+	}
+	if calls := mgr.findKeyCalls[database.Sstables[0]] + mgr.findKeyCalls[database.Sstables[1]]; calls != 1 {
+		t.Fatalf("expected no FindKey calls on the other SSTables, got: %d", calls-1)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testCompareAndSwap")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// An absent key only matches a nil expected value
+	swapped, err := database.CompareAndSwap("counter", []byte(""), []byte("1"))
+	if err != nil || swapped {
+		t.Fatalf("expected no swap of an absent key against an empty value, got: %v, %v", swapped, err)
+	}
+	swapped, err = database.CompareAndSwap("counter", nil, []byte("1"))
+	if err != nil || !swapped {
+		t.Fatalf("expected the absent key to be swapped, got: %v, %v", swapped, err)
+	}
+
+	// The current value is read from SSTables as well as the memtable
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	swapped, err = database.CompareAndSwap("counter", nil, []byte("2"))
+	if err != nil || swapped {
+		t.Fatalf("expected no swap of an existing key against nil, got: %v, %v", swapped, err)
+	}
+	swapped, err = database.CompareAndSwap("counter", []byte("0"), []byte("2"))
+	if err != nil || swapped {
+		t.Fatalf("expected no swap on a mismatch, got: %v, %v", swapped, err)
+	}
+	swapped, err = database.CompareAndSwap("counter", []byte("1"), []byte("2"))
+	if err != nil || !swapped {
+		t.Fatalf("expected a swap on a match, got: %v, %v", swapped, err)
+	}
+
+	entry, err := database.Get("counter")
+	if err != nil || string(entry.Value) != "2" {
+		t.Fatalf("expected value 2, got: %s, %v", entry.Value, err)
+	}
+
+	// A deleted key counts as absent again
+	if err := database.Delete("counter"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	swapped, err = database.CompareAndSwap("counter", nil, []byte("3"))
+	if err != nil || !swapped {
+		t.Fatalf("expected the deleted key to be swapped, got: %v, %v", swapped, err)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testSizeLimits")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+		MaxKeySize:        8,
+		MaxValueSize:      16,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %s", err)
+	}
+	defer database.Close()
+
+	if err := database.Put(Entry{Key: "key0", Value: []byte("value")}); err != nil {
+		t.Fatalf("expected an entry within the limits to be written, got %v", err)
+	}
+	if err := database.Put(Entry{Key: "a-very-long-key", Value: []byte("value")}); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := database.Put(Entry{Key: "key1", Value: make([]byte, 17)}); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	if err := database.Delete("a-very-long-key"); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge from delete, got %v", err)
+	}
+	if _, err := database.CompareAndSwap("key0", []byte("value"), make([]byte, 17)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge from compare and swap, got %v", err)
+	}
+	err = database.WriteBatch([]Op{
+		{Type: OpPut, Key: "key2", Value: []byte("ok")},
+		{Type: OpPut, Key: "key3", Value: make([]byte, 17)},
+	})
+	if !errors.Is(err, ErrValueTooLarge) || !strings.HasPrefix(err.Error(), "op 1:") {
+		t.Errorf("expected ErrValueTooLarge for op 1, got %v", err)
+	}
+	if _, err := database.Get("key2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the rejected batch not to be applied, got %v", err)
+	}
+	if ErrClosed != ErrDBClosed {
+		t.Errorf("expected ErrClosed to alias ErrDBClosed")
+	}
+}
+
+func TestKeyRangesRestoredFromFooters(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testKeyRangesRestoredFromFooters")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	open := func() *LSM {
+		t.Helper()
+		ssm, err := NewFileManager(dataDir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		database, err := NewDb(Options{
+			MemtableThreshold: 1000,
+			SstableMgr:        ssm,
+			Logger:            logger,
+		})
+		if err != nil {
+			t.Fatalf("error creating db: %s", err)
+		}
+		return database
+	}
+
+	// Three SSTables covering [a0, a9], [m0, m9] and [x0, x9]
+	database := open()
+	for _, prefix := range []string{"a", "m", "x"} {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("%s%d", prefix, i)
+			if err := database.Put(Entry{Key: key, Value: []byte(key)}); err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+		}
+		if err := database.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	tables := append([]string(nil), database.Sstables...)
+	database.Close()
+
+	reopened := open()
+	defer reopened.Close()
+	for key, expected := range map[string][]string{
+		"a5": {tables[0]},
+		"m0": {tables[1]},
+		"x9": {tables[2]},
+		"b0": {},
+		"z":  {},
+	} {
+		candidates := reopened.tableCandidates(key)
+		if len(candidates) != len(expected) || (len(expected) == 1 && candidates[0] != expected[0]) {
+			t.Errorf("expected %s to be looked up in %v, got %v", key, expected, candidates)
+		}
+	}
+	if entry, err := reopened.Get("m7"); err != nil || string(entry.Value) != "m7" {
+		t.Fatalf("expected m7, got %+v, %v", entry, err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testExists")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %s", err)
+	}
+	defer database.Close()
+
+	for _, key := range []string{"flushed", "deleted", "memtable"} {
+		if err := database.Put(Entry{Key: key, Value: []byte("value")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		if key == "deleted" {
+			if err := database.Flush(); err != nil {
+				t.Fatalf("Failed to flush: %v", err)
+			}
+		}
+	}
+	if err := database.Delete("deleted"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+
+	for key, expected := range map[string]bool{
+		"flushed":  true,
+		"memtable": true,
+		"deleted":  false,
+		"missing":  false,
+	} {
+		exists, err := database.Exists(key)
+		if err != nil || exists != expected {
+			t.Errorf("expected Exists(%s) to be %v, got %v, %v", key, expected, exists, err)
+		}
+	}
+}
+
+// dirState returns the modification time and checksum of every file in dir.
+func dirState(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading directory: %s", err)
+	}
+	state := make(map[string]string, len(files))
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			t.Fatalf("error reading file info: %s", err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatalf("error reading file: %s", err)
+		}
+		state[file.Name()] = fmt.Sprintf("%d %x", info.ModTime().UnixNano(), sha256.Sum256(data))
+	}
+	return state
+}
+
+func TestReadOnly(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testReadOnly")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if _, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, ReadOnly: true}); err == nil {
+		t.Fatalf("expected an error opening a missing data directory read-only")
+	}
+	if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+		t.Fatalf("expected the data directory not to be created, got: %v", err)
+	}
+
+	writerMgr, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	writer, err := NewDb(Options{
+		MemtableThreshold:      1000,
+		CompactionMinThreshold: 2,
+		SstableMgr:             writerMgr,
+		Logger:                 logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer writer.Close()
+	for table := 0; table < 2; table++ {
+		for i := 0; i < 10; i++ {
+			if err := writer.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", table))}); err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	// Left in the writer's memtable, so not visible to the reader
+	if err := writer.Put(Entry{Key: "unflushed", Value: []byte("value")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	before := dirState(t, dataDir)
+
+	readerMgr, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	reader, err := NewDb(Options{
+		MemtableThreshold: 1,
+		MaxL0Files:        1,
+		SstableMgr:        readerMgr,
+		Logger:            logger,
+		ReadOnly:          true,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	entry, err := reader.Get("key3")
+	if err != nil || string(entry.Value) != "value1" {
+		t.Fatalf("expected value1 for key3, got %q, %v", entry.Value, err)
+	}
+	if _, err := reader.Get("unflushed"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a key the writer did not flush, got: %v", err)
+	}
+	entries, err := reader.Scan("", "", 0)
+	if err != nil || len(entries) != 10 {
+		t.Errorf("expected to scan 10 entries, got %d, %v", len(entries), err)
+	}
+	if err := reader.Ready(); err != nil {
+		t.Errorf("expected a read-only database to be ready, got: %v", err)
+	}
+
+	for name, write := range map[string]func() error{
+		"Put":       func() error { return reader.Put(Entry{Key: "key0", Value: []byte("new")}) },
+		"Delete":    func() error { return reader.Delete("key0") },
+		"PutBatch":  func() error { return reader.PutBatch([]Entry{{Key: "key0", Value: []byte("new")}}) },
+		"Flush":     reader.Flush,
+		"Compact":   reader.Compact,
+		"RunGC":     reader.RunGC,
+		"CAS":       func() error { _, err := reader.CompareAndSwap("key0", []byte("value1"), []byte("new")); return err },
+		"Write":     func() error { return readerMgr.Write("sstable_99.sst", []Entry{{Key: "a"}}) },
+		"Manifest":  func() error { return readerMgr.WriteManifest([][]string{{}}) },
+		"DeleteSST": func() error { return readerMgr.Delete(writer.Sstables[0]) },
+	} {
+		if err := write(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected ErrReadOnly from %s, got: %v", name, err)
+		}
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	after := dirState(t, dataDir)
+	if fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("expected the read-only database to leave the directory alone, before %v, after %v", before, after)
+	}
+
+	// The writer carries on after the reader is gone
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if err := writer.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	entry, err = writer.Get("unflushed")
+	if err != nil || string(entry.Value) != "value" {
+		t.Errorf("expected the writer to keep its writes, got %q, %v", entry.Value, err)
 	}
-	return m.MockSSTableManager.FindKey(fileName, key)
 }
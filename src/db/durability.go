@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DurabilityMode controls whether SSTable and manifest writes are synced to
+// disk.
+type DurabilityMode int
+
+const (
+	// DurabilityStrict syncs every SSTable and manifest before it is renamed
+	// into place, and the data directory after, so a flush that returned
+	// survives a crash.
+	DurabilityStrict DurabilityMode = iota
+	// DurabilityRelaxed is meant to sync a write-ahead log periodically
+	// instead of on every write. Without one, only flushes are synced, and
+	// skipping any of those syncs could leave a manifest listing a table that
+	// did not survive a crash, so SSTables and the manifest are synced as
+	// with DurabilityStrict.
+	DurabilityRelaxed
+	// DurabilityNone never syncs. A crash may lose or truncate recently
+	// written files. It is meant for tests and bulk loads that can be
+	// repeated.
+	DurabilityNone
+)
+
+func (d DurabilityMode) String() string {
+	switch d {
+	case DurabilityStrict:
+		return "strict"
+	case DurabilityRelaxed:
+		return "relaxed"
+	case DurabilityNone:
+		return "none"
+	}
+	return fmt.Sprintf("unknown(%d)", int(d))
+}
+
+// ParseDurability returns the durability named s, in any case: strict,
+// relaxed or none.
+func ParseDurability(s string) (DurabilityMode, error) {
+	switch strings.ToLower(s) {
+	case "strict":
+		return DurabilityStrict, nil
+	case "relaxed":
+		return DurabilityRelaxed, nil
+	case "none":
+		return DurabilityNone, nil
+	}
+	return DurabilityStrict, fmt.Errorf("unknown durability %q", s)
+}
+
+// validDurability reports whether d is one of the modes above.
+func validDurability(d DurabilityMode) bool {
+	return d >= DurabilityStrict && d <= DurabilityNone
+}
+
+// durabilitySetter is implemented by SSTable managers that sync what they
+// write, such as SSTableFileSystemManager.
+type durabilitySetter interface {
+	SetDurability(DurabilityMode)
+}
+
+// SetDurability changes how later writes are synced. NewDb calls it with
+// Options.Durability, before anything is written.
+func (ssm *SSTableFileSystemManager) SetDurability(d DurabilityMode) {
+	ssm.Durability = d
+}
+
+// syncFile syncs file unless Durability is DurabilityNone.
+func (ssm SSTableFileSystemManager) syncFile(file *os.File) error {
+	if ssm.Durability == DurabilityNone {
+		return nil
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if ssm.onSync != nil {
+		ssm.onSync(file.Name())
+	}
+	return nil
+}
+
+// syncDataDir syncs DataDir, so renames into it survive a crash, unless
+// Durability is DurabilityNone.
+func (ssm SSTableFileSystemManager) syncDataDir() error {
+	if ssm.Durability == DurabilityNone {
+		return nil
+	}
+	if err := syncDir(ssm.DataDir); err != nil {
+		return err
+	}
+	if ssm.onSync != nil {
+		ssm.onSync(ssm.DataDir)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DefaultImportBatchSize is the number of records Import writes per SSTable
+// when ImportOptions.BatchSize is zero.
+const DefaultImportBatchSize = 100000
+
+// ErrInvalidImport is returned, wrapped with the line and what is wrong with
+// it, by Import for input that is not a valid ExportRecord.
+var ErrInvalidImport = errors.New("invalid import record")
+
+// ExportRecord is one line of the newline-delimited JSON written by Export and
+// read by Import.
+type ExportRecord struct {
+	Key         string `json:"key"`
+	ValueBase64 string `json:"value_base64"`
+	Seq         uint64 `json:"seq"`
+	// ExpiresAt is set for keys with a TTL, see Entry.ExpiresAt
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// BatchSize is the number of records written to each SSTable, or to each
+	// WriteBatch with UsePuts. Zero means DefaultImportBatchSize.
+	BatchSize int
+	// UsePuts applies the records with WriteBatch, through the memtable like
+	// any other write, instead of writing SSTables directly.
+	UsePuts bool
+}
+
+// Export writes every live key, in key order, as an ExportRecord per line.
+// Only the newest value of each key is written: deleted and expired keys are
+// left out and merge operands are combined. It reads a Snapshot, so writes
+// made meanwhile are not exported and do not hold it up.
+func (db *LSM) Export(w io.Writer) error {
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+	it, err := snapshot.newIterator("", "")
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	encoder.SetEscapeHTML(false)
+	exported := 0
+	for it.Next() {
+		record := ExportRecord{
+			Key:         it.entry.Key,
+			ValueBase64: base64.StdEncoding.EncodeToString(it.entry.Value),
+			Seq:         it.entry.SequenceNumber,
+			ExpiresAt:   it.entry.ExpiresAt,
+		}
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+		exported++
+	}
+	if err := it.Err(); err != nil {
+		db.logger.Errorf("Error in reading entries for export: %v", err)
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	db.logger.Infof("Exported %d keys", exported)
+	return nil
+}
+
+// Import reads records written by Export and stores their keys and values,
+// replacing those already stored. The seq of a record is not kept: records
+// get new sequence numbers as they are read, as with Put, so of two records
+// for the same key the later one wins.
+//
+// Records are read a line at a time and applied in batches of BatchSize. By
+// default each batch is sorted and written as one L0 SSTable, skipping the
+// memtable; see importBatch. Batches applied before a line that is not a valid
+// record, which fails with ErrInvalidImport, stay applied.
+func (db *LSM) Import(r io.Reader, opts ImportOptions) error {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
+	}
+	apply := db.importBatch
+	if opts.UsePuts {
+		apply = db.PutBatch
+	}
+
+	entries := make([]Entry, 0, batchSize)
+	imported := 0
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("failed to read import: %w", readErr)
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			entry, err := db.importRecord(line)
+			if err != nil {
+				return fmt.Errorf("%w: line %d: %v", ErrInvalidImport, lineNumber, err)
+			}
+			entries = append(entries, entry)
+		}
+		if len(entries) == batchSize || (readErr == io.EOF && len(entries) > 0) {
+			if err := apply(entries); err != nil {
+				return err
+			}
+			imported += len(entries)
+			entries = entries[:0]
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	db.logger.Infof("Imported %d records", imported)
+	return nil
+}
+
+// importRecord parses one ExportRecord into the Entry to store.
+func (db *LSM) importRecord(line []byte) (Entry, error) {
+	record := ExportRecord{}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&record); err != nil {
+		return Entry{}, err
+	}
+	if record.Key == "" {
+		return Entry{}, errors.New("key must not be empty")
+	}
+	value, err := base64.StdEncoding.DecodeString(record.ValueBase64)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid value_base64: %w", err)
+	}
+	if err := db.checkSize(record.Key, value); err != nil {
+		return Entry{}, err
+	}
+	return Entry{Key: record.Key, Value: value, ExpiresAt: record.ExpiresAt}, nil
+}
+
+// importBatch sorts entries by key and writes them as a new L0 SSTable, which
+// is newer than every table before it. Of repeated keys the last one is kept.
+// Keys that are also in a memtable are written to the active memtable instead,
+// since memtables are read before SSTables, once the table is live; like Put,
+// each of those may freeze a full memtable.
+//
+// As in flushMemtable, db.mu is released while the table is written, after
+// its sequence numbers are assigned. Records written meanwhile are newer and
+// go to the memtable, which is read first; flushes of them, and later
+// imports, wait for the table to go live so L0 stays ordered by sequence.
+func (db *LSM) importBatch(entries []Entry) error {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	table := make([]Entry, 0, len(entries))
+	var resident []Entry
+	for i, entry := range entries {
+		if i+1 < len(entries) && entries[i+1].Key == entry.Key {
+			continue
+		}
+		db.counters.puts.Add(1)
+		if db.inMemtables(entry.Key) {
+			resident = append(resident, entry)
+			continue
+		}
+		entry.SequenceNumber = db.nextSequence()
+		table = append(table, entry)
+	}
+
+	if len(table) > 0 {
+		filename := db.newSSTableName()
+		db.pinSSTables(filename)
+		defer db.unpinSSTables(filename)
+		if err := db.importTable(filename, table); err != nil {
+			return err
+		}
+	}
+	for _, entry := range resident {
+		// freezeMemtable may release db.mu while it waits for a flush
+		if db.closed {
+			return ErrDBClosed
+		}
+		entry.SequenceNumber = db.nextSequence()
+		db.Memtable.Put(entry)
+		if db.memtableFull() {
+			db.freezeMemtable()
+		}
+	}
+	return nil
+}
+
+// importTable writes table to filename and adds it to L0 once the imports
+// started before it are live. The caller must hold db.mu, which is released
+// while the file is written.
+func (db *LSM) importTable(filename string, table []Entry) error {
+	db.importing = append(db.importing, filename)
+	db.mu.Unlock()
+	err := db.sstableMgr.Write(filename, table)
+	db.mu.Lock()
+
+	for i, importing := range db.importing {
+		if importing == filename {
+			db.waitForImports(append([]string(nil), db.importing[:i]...))
+			break
+		}
+	}
+	defer func() {
+		for i, importing := range db.importing {
+			if importing == filename {
+				db.importing = append(db.importing[:i:i], db.importing[i+1:]...)
+				break
+			}
+		}
+		db.flushDone.Broadcast()
+	}()
+	if err != nil {
+		db.logger.Errorf("Error in writing imported sstable %s: %v", filename, err)
+		return err
+	}
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	sstables := append(db.Sstables[:len(db.Sstables):len(db.Sstables)], filename)
+	if err := db.sstableMgr.WriteManifest(append([][]string{sstables}, db.levels...)); err != nil {
+		db.logger.Errorf("Error in writing manifest: %v", err)
+		return err
+	}
+	db.Sstables = sstables
+	db.tableInfo[filename] = tableInfoOf(table)
+	db.logger.Infof("Imported %d entries to %s", len(table), filename)
+	db.wakeCompactions()
+	return nil
+}
+
+// inMemtables reports whether the active or an immutable memtable holds a
+// record for key. The caller must hold db.mu.
+func (db *LSM) inMemtables(key string) bool {
+	if _, exists := db.Memtable.Get(key); exists {
+		return true
+	}
+	for _, memtable := range db.immutables {
+		if _, exists := memtable.Get(key); exists {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,327 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testExport")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	source, err := NewDb(Options{
+		MemtableThreshold: 100,
+		SstableMgr:        ssm,
+		Logger:            logger,
+		MergeFunc:         AddInt64,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer source.Close()
+
+	// Overwrites, deletes and merges spread over several SSTables
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 500; i++ {
+			value := []byte(fmt.Sprintf("value%d-%d", round, i))
+			if i%50 == 0 {
+				value = []byte{0, 0xff, byte(i)}
+			}
+			if err := source.Put(Entry{Key: fmt.Sprintf("key%04d", i), Value: value}); err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+		}
+	}
+	for i := 0; i < 500; i += 7 {
+		if err := source.Delete(fmt.Sprintf("key%04d", i)); err != nil {
+			t.Fatalf("Failed to delete entry: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := source.Merge("counter", []byte("2")); err != nil {
+			t.Fatalf("Failed to merge: %v", err)
+		}
+		if err := source.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	if len(source.Sstables) < 2 {
+		t.Fatalf("expected several sstables, got: %v", source.Sstables)
+	}
+	expected, err := source.Scan("", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+
+	var exported bytes.Buffer
+	if err := source.Export(&exported); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(exported.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		record := ExportRecord{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode line %d %q: %v", i+1, line, err)
+		}
+		if record.Key != expected[i].Key || record.Seq == 0 {
+			t.Fatalf("expected line %d to hold %s with its seq, got: %s", i+1, expected[i].Key, line)
+		}
+	}
+	if counter := lines[0]; !strings.HasPrefix(counter, `{"key":"counter","value_base64":"Ng==","seq":`) {
+		t.Errorf("expected counter to be exported as 6, got: %s", counter)
+	}
+	source.Close()
+	if err := source.Export(&bytes.Buffer{}); !errors.Is(err, ErrDBClosed) {
+		t.Errorf("expected %v after close, got: %v", ErrDBClosed, err)
+	}
+
+	for _, test := range []struct {
+		name string
+		opts ImportOptions
+	}{
+		{name: "sstables", opts: ImportOptions{BatchSize: 150}},
+		{name: "puts", opts: ImportOptions{BatchSize: 150, UsePuts: true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			importDir := filepath.Join(currentTestDir, ".testImport")
+			defer deleteDirectoryIfExists(importDir)
+			open := func() *LSM {
+				ssm, err := NewFileManager(importDir, logger)
+				if err != nil {
+					t.Fatalf("error creating file manager: %s", err)
+				}
+				database, err := NewDb(Options{MemtableThreshold: 100, SstableMgr: ssm, Logger: logger})
+				if err != nil {
+					t.Fatalf("error creating db: %v", err)
+				}
+				return database
+			}
+			checkContents := func(database *LSM) {
+				t.Helper()
+				entries, err := database.Scan("", "", 0)
+				if err != nil {
+					t.Fatalf("Failed to scan: %v", err)
+				}
+				if len(entries) != len(expected) {
+					t.Fatalf("expected %d entries, got %d", len(expected), len(entries))
+				}
+				for i, entry := range entries {
+					if entry.Key != expected[i].Key || !bytes.Equal(entry.Value, expected[i].Value) {
+						t.Fatalf("expected %s=%q, got %s=%q", expected[i].Key, expected[i].Value, entry.Key, entry.Value)
+					}
+				}
+			}
+
+			database := open()
+			// Records replace what the memtable and SSTables hold
+			for _, key := range []string{"key0001", "key0002"} {
+				if err := database.Put(Entry{Key: key, Value: []byte("stale")}); err != nil {
+					t.Fatalf("Failed to put entry: %v", err)
+				}
+				if key == "key0001" {
+					if err := database.Flush(); err != nil {
+						t.Fatalf("Failed to flush: %v", err)
+					}
+				}
+			}
+			if err := database.Import(bytes.NewReader(exported.Bytes()), test.opts); err != nil {
+				t.Fatalf("Failed to import: %v", err)
+			}
+			checkContents(database)
+			for _, key := range []string{"key0001", "key0002"} {
+				entry, err := database.Get(key)
+				if err != nil || string(entry.Value) == "stale" {
+					t.Errorf("expected %s to be imported, got %q, %v", key, entry.Value, err)
+				}
+			}
+			if !test.opts.UsePuts && len(database.Sstables) < len(expected)/test.opts.BatchSize {
+				t.Errorf("expected a table per batch, got: %v", database.Sstables)
+			}
+
+			if err := database.Close(); err != nil {
+				t.Fatalf("Failed to close: %v", err)
+			}
+			database = open()
+			defer database.Close()
+			checkContents(database)
+		})
+	}
+
+	// The records before a bad line are imported
+	database, err := NewDb(Options{SstableMgr: NewInMemoryManager(logger), Logger: logger})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+	input := strings.NewReader(`{"key":"a","value_base64":"MQ==","seq":1}` + "\n" + `{"key":"b","value_base64":"not base64"}` + "\n")
+	err = database.Import(input, ImportOptions{BatchSize: 1})
+	if !errors.Is(err, ErrInvalidImport) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected %v on line 2, got: %v", ErrInvalidImport, err)
+	}
+	if entry, err := database.Get("a"); err != nil || string(entry.Value) != "1" {
+		t.Errorf("expected a=1 to be imported, got %q, %v", entry.Value, err)
+	}
+}
+
+func TestImportFreezesFullMemtables(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	database, err := NewDb(Options{
+		MemtableMaxBytes: 1024,
+		SstableMgr:       NewInMemoryManager(logger),
+		Logger:           logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// Keys already in the memtable are imported into it, so a single batch
+	// of them can fill it several times over
+	var input strings.Builder
+	value := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("v"), 200))
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		if err := database.Put(Entry{Key: key, Value: []byte("v")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		fmt.Fprintf(&input, "{\"key\":%q,\"value_base64\":%q}\n", key, value)
+	}
+	if err := database.Import(strings.NewReader(input.String()), ImportOptions{BatchSize: 100}); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	waitForFlushes(t, database)
+
+	if size := database.Memtable.Size(); size >= 1024 {
+		t.Errorf("expected full memtables to be frozen, the active one holds %d bytes", size)
+	}
+	if len(database.Sstables) < 3 {
+		t.Errorf("expected the imported batch to be flushed in several sstables, got: %v", database.Sstables)
+	}
+	for i := 0; i < 20; i++ {
+		entry, err := database.Get(fmt.Sprintf("key%02d", i))
+		if err != nil || len(entry.Value) != 200 {
+			t.Errorf("expected key%02d to be imported, got %q, %v", i, entry.Value, err)
+		}
+	}
+}
+
+// ImportBlockingManager holds every Write, which imports use, until release is
+// closed. Flushes use WriteFromIterator and report each table on flushed.
+type ImportBlockingManager struct {
+	SSTableManager
+	writing chan struct{}
+	release chan struct{}
+	flushed chan struct{}
+}
+
+func (m *ImportBlockingManager) Write(fileName string, data []Entry) error {
+	m.writing <- struct{}{}
+	<-m.release
+	return m.SSTableManager.Write(fileName, data)
+}
+
+func (m *ImportBlockingManager) WriteFromIterator(fileName string, it EntryIterator, count int) error {
+	err := m.SSTableManager.WriteFromIterator(fileName, it, count)
+	m.flushed <- struct{}{}
+	return err
+}
+
+func TestImportWritesTableWithoutLock(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mgr := &ImportBlockingManager{
+		SSTableManager: NewInMemoryManager(logger),
+		writing:        make(chan struct{}, 10),
+		release:        make(chan struct{}),
+		flushed:        make(chan struct{}, 10),
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	old := base64.StdEncoding.EncodeToString([]byte("imported"))
+	input := fmt.Sprintf("{\"key\":\"a\",\"value_base64\":%q}\n{\"key\":\"b\",\"value_base64\":%q}\n", old, old)
+	imported := make(chan error)
+	go func() {
+		imported <- database.Import(strings.NewReader(input), ImportOptions{})
+	}()
+	select {
+	case <-mgr.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the import to write an sstable")
+	}
+
+	// The import is stuck in Write; writes and reads must not wait for it
+	done := make(chan error)
+	go func() {
+		if err := database.Put(Entry{Key: "a", Value: []byte("put")}); err != nil {
+			done <- err
+			return
+		}
+		if _, err := database.Get("b"); !errors.Is(err, ErrNotFound) {
+			done <- fmt.Errorf("expected b not to be live before the import, got: %v", err)
+			return
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writes blocked behind the import")
+	}
+
+	// The put is flushed first, but its table must go live after the
+	// imported one, which holds an older record of a
+	select {
+	case <-mgr.flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the put to be flushed")
+	}
+	close(mgr.release)
+	select {
+	case err := <-imported:
+		if err != nil {
+			t.Fatalf("Failed to import: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the import to finish")
+	}
+	waitForFlushes(t, database)
+
+	for key, want := range map[string]string{"a": "put", "b": "imported"} {
+		entry, err := database.Get(key)
+		if err != nil || string(entry.Value) != want {
+			t.Errorf("expected %s=%s, got %q, %v", key, want, entry.Value, err)
+		}
+	}
+}
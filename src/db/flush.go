@@ -0,0 +1,195 @@
+package db
+
+import "time"
+
+// memtableFull reports whether the active memtable reached MemtableThreshold
+// entries or MemtableMaxBytes bytes. The caller must hold db.mu.
+func (db *LSM) memtableFull() bool {
+	return (db.threshold > 0 && db.Memtable.Len() >= db.threshold) ||
+		(db.maxBytes > 0 && db.Memtable.Size() >= db.maxBytes)
+}
+
+// freezeMemtable turns the active memtable into an immutable one and installs a
+// fresh memtable, so writers can continue while the frozen one is flushed in
+// the background. Once more than MaxImmutableMemtables are frozen it waits for
+// the flush to catch up, releasing db.mu meanwhile. The caller must hold db.mu.
+func (db *LSM) freezeMemtable() {
+	if db.Memtable.Len() > 0 {
+		db.immutables = append(db.immutables, db.Memtable)
+		db.Memtable = NewMemtable()
+	}
+	if len(db.immutables) > 0 && !db.flushing {
+		db.flushing = true
+		go db.flushImmutables()
+	}
+	// A failed flush stops with the memtables still queued; they are retried
+	// by the next freeze rather than waited for
+	for db.maxImmutables > 0 && len(db.immutables) > db.maxImmutables && db.flushing {
+		db.flushDone.Wait()
+	}
+}
+
+// flushImmutables writes the immutable memtables to SSTables, oldest first,
+// until none are left. Each one stays visible to readers until its SSTable is
+// live. On error the remaining memtables are kept and retried by the next
+// freeze or Flush.
+func (db *LSM) flushImmutables() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for len(db.immutables) > 0 {
+		memtable := db.immutables[0]
+		filename := db.newSSTableName()
+		// Pinned so RunGC does not take the file for an orphan before it is live
+		db.pinSSTables(filename)
+		db.flushErr = db.flushMemtable(memtable, filename)
+		db.unpinSSTables(filename)
+		if db.flushErr != nil {
+			break
+		}
+		db.immutables = db.immutables[1:]
+		db.flushDone.Broadcast()
+	}
+	db.flushing = false
+	db.flushDone.Broadcast()
+}
+
+// flushIterator walks a memtable for a flush. Like expireEntries it yields
+// expired entries as tombstones, and it collects the TableInfo of the entries
+// it yields.
+type flushIterator struct {
+	*MemtableIterator
+	now  time.Time
+	info TableInfo
+	seen bool
+}
+
+func (it *flushIterator) Entry() Entry {
+	entry := it.MemtableIterator.Entry()
+	if entry.expired(it.now) {
+		entry = Entry{Key: entry.Key, Tombstone: true, SequenceNumber: entry.SequenceNumber}
+	}
+	if !it.seen {
+		it.info = TableInfo{MinKey: entry.Key, MinSequence: entry.SequenceNumber}
+		it.seen = true
+	}
+	it.info.MaxKey = entry.Key
+	if entry.SequenceNumber < it.info.MinSequence {
+		it.info.MinSequence = entry.SequenceNumber
+	}
+	if entry.SequenceNumber > it.info.MaxSequence {
+		it.info.MaxSequence = entry.SequenceNumber
+	}
+	return entry
+}
+
+// flushMemtable writes memtable to filename and adds it to the live SSTables.
+// Entries that expired in the memtable are written as tombstones. The table is
+// streamed from the memtable, which is frozen, without copying its entries.
+// The caller must hold db.mu, which is released while the file is written.
+func (db *LSM) flushMemtable(memtable *Memtable, filename string) error {
+	db.mu.Unlock()
+	var start time.Time
+	if db.metrics != nil {
+		start = time.Now()
+	}
+	it := &flushIterator{MemtableIterator: memtable.Iterator(), now: db.now()}
+	err := db.sstableMgr.WriteFromIterator(filename, it, memtable.Len())
+	if db.metrics != nil && err == nil {
+		db.metrics.ObserveFlush(time.Since(start))
+	}
+	db.mu.Lock()
+	if err != nil {
+		db.logger.Errorf("Error in writing sstable to disk: %v", err)
+		return err
+	}
+	// Tables being imported hold older records than any written to the
+	// memtable meanwhile, so they must go live first
+	db.waitForImports(append([]string(nil), db.importing...))
+
+	// The manifest lists L0 tables oldest first, matching db.Sstables
+	sstables := append(db.Sstables[:len(db.Sstables):len(db.Sstables)], filename)
+	err = db.sstableMgr.WriteManifest(append([][]string{sstables}, db.levels...))
+	if err != nil {
+		db.logger.Errorf("Error in writing manifest: %v", err)
+		return err
+	}
+	db.Sstables = sstables
+	db.tableInfo[filename] = it.info
+	db.counters.flushes.Add(1)
+	db.logger.Infof("Flushed to disk: %s", filename)
+	db.wakeCompactions()
+	return nil
+}
+
+// Flush freezes the active memtable and waits until every immutable memtable
+// has been written to an SSTable. It returns the error of a failed background
+// flush, after retrying it.
+func (db *LSM) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.freezeMemtable()
+	return db.waitForFlushes()
+}
+
+// flushFrozen freezes the active memtable and waits until it and the memtables
+// frozen before it are written to SSTables. Unlike Flush it does not wait for
+// memtables frozen later, so it returns under a steady stream of writes. The
+// caller must hold db.mu.
+func (db *LSM) flushFrozen() error {
+	db.freezeMemtable()
+	if len(db.immutables) == 0 {
+		return nil
+	}
+	last := db.immutables[len(db.immutables)-1]
+	queued := func() bool {
+		for _, memtable := range db.immutables {
+			if memtable == last {
+				return true
+			}
+		}
+		return false
+	}
+	for db.flushing && queued() {
+		db.flushDone.Wait()
+	}
+	if queued() {
+		return db.flushErr
+	}
+	return nil
+}
+
+// waitForImports blocks until none of the SSTables in fileNames is still being
+// written by an import. The caller must hold db.mu.
+func (db *LSM) waitForImports(fileNames []string) {
+	for db.importingAny(fileNames) {
+		db.flushDone.Wait()
+	}
+}
+
+// importingAny reports whether an import is still writing one of fileNames.
+// The caller must hold db.mu.
+func (db *LSM) importingAny(fileNames []string) bool {
+	for _, fileName := range fileNames {
+		for _, importing := range db.importing {
+			if importing == fileName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// waitForFlushes blocks until the background flush stops and returns its
+// error. The caller must hold db.mu.
+func (db *LSM) waitForFlushes() error {
+	for db.flushing {
+		db.flushDone.Wait()
+	}
+	return db.flushErr
+}
@@ -0,0 +1,380 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// BlockingMockSSTableManager holds every Write until release is closed.
+type BlockingMockSSTableManager struct {
+	MockSSTableManager
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (m *BlockingMockSSTableManager) Write(fileName string, data []Entry) error {
+	m.writing <- struct{}{}
+	<-m.release
+	return m.MockSSTableManager.Write(fileName, data)
+}
+
+func (m *BlockingMockSSTableManager) WriteFromIterator(fileName string, it EntryIterator, count int) error {
+	return m.Write(fileName, collectEntries(it))
+}
+
+func TestWritesContinueDuringFlush(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mgr := &BlockingMockSSTableManager{
+		writing: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("flushing%d", i), Value: []byte("frozen")})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	select {
+	case <-mgr.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a background flush to start")
+	}
+
+	// The flush is stuck in Write; writes and reads must not wait for it
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 5; i++ {
+			err := database.Put(Entry{Key: fmt.Sprintf("during%d", i), Value: []byte("active")})
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		_, err := database.Get("flushing0")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("writes blocked behind the flush")
+	}
+
+	close(mgr.release)
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if database.Memtable.Len() != 0 || len(database.immutables) != 0 {
+		t.Fatalf("expected every memtable to be flushed, got %d active entries and %d immutables", database.Memtable.Len(), len(database.immutables))
+	}
+
+	for _, key := range []string{"flushing0", "flushing1", "during0", "during4"} {
+		if _, err := database.Get(key); err != nil {
+			t.Fatalf("expected %s to survive the flush, got: %v", key, err)
+		}
+	}
+}
+
+func TestWritesBlockAtMaxImmutableMemtables(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mgr := &BlockingMockSSTableManager{
+		writing: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold:     2,
+		MaxImmutableMemtables: 1,
+		SstableMgr:            mgr,
+		Logger:                logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("flushing%d", i), Value: []byte("frozen")})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	select {
+	case <-mgr.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a background flush to start")
+	}
+
+	// The first put fits the active memtable; the second fills it while the
+	// one frozen memtable allowed is still being flushed
+	if err := database.Put(Entry{Key: "queued0", Value: []byte("active")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- database.Put(Entry{Key: "queued1", Value: []byte("active")})
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected the put to wait for the flush, got: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The frozen memtable stays readable while the writer waits
+	if entry, err := database.Get("flushing0"); err != nil || string(entry.Value) != "frozen" {
+		t.Fatalf("expected flushing0 to be readable during the flush, got: %v, %v", entry, err)
+	}
+
+	close(mgr.release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the put to finish once the flush did")
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	for _, key := range []string{"flushing0", "flushing1", "queued0", "queued1"} {
+		if _, err := database.Get(key); err != nil {
+			t.Fatalf("expected %s to survive the flush, got: %v", key, err)
+		}
+	}
+}
+
+func TestMemtableMaxBytes(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	sstablemockstore = []Entry{}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		MemtableMaxBytes:  1 << 20,
+		SstableMgr:        &MockSSTableManager{},
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// Three 512KB values are far below the entry threshold but fill 1MB
+	for i := 0; i < 3; i++ {
+		if err := database.Put(Entry{Key: fmt.Sprintf("large%d", i), Value: make([]byte, 512<<10)}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+	stats := database.Stats()
+	if stats.Flushes != 1 || stats.MemtableEntries != 1 {
+		t.Fatalf("expected one flush leaving one entry in the memtable, got: %+v", stats)
+	}
+	if expected := int64(len("large2")+512<<10) + memtableEntryOverhead; stats.MemtableBytes != expected {
+		t.Fatalf("expected the memtable to hold %d bytes, got: %d", expected, stats.MemtableBytes)
+	}
+
+	// With both limits disabled the memtable is never flushed on its own
+	unlimited, err := NewDb(Options{
+		SstableMgr: &MockSSTableManager{},
+		Logger:     logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := unlimited.Put(Entry{Key: fmt.Sprintf("large%d", i), Value: make([]byte, 512<<10)}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	if stats := unlimited.Stats(); stats.Flushes != 0 || stats.MemtableEntries != 10 {
+		t.Fatalf("expected no flush, got: %+v", stats)
+	}
+}
+
+// liveHeapWriter measures the live heap once after bytes have been written
+// through it, which happens partway through writing a table.
+type liveHeapWriter struct {
+	w     io.Writer
+	bytes int
+	live  uint64
+}
+
+func (lw *liveHeapWriter) Write(p []byte) (int, error) {
+	if lw.bytes > 0 && lw.bytes <= len(p) {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		lw.live = stats.HeapAlloc
+	}
+	lw.bytes -= len(p)
+	return lw.w.Write(p)
+}
+
+// liveHeapGrowth runs write with ssm and returns by how much the live heap
+// grew between before the call and halfway through the table it writes.
+func liveHeapGrowth(ssm *SSTableFileSystemManager, write func() error) (uint64, error) {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	base := stats.HeapAlloc
+
+	measure := &liveHeapWriter{bytes: 2 << 20}
+	ssm.wrapWriter = func(w io.Writer) io.Writer {
+		measure.w = w
+		return measure
+	}
+	defer func() { ssm.wrapWriter = nil }()
+	if err := write(); err != nil {
+		return 0, err
+	}
+	if measure.live < base {
+		return 0, nil
+	}
+	return measure.live - base, nil
+}
+
+func TestStreamingFlushMemory(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testStreamingFlushMemory")
+	defer deleteDirectoryIfExists(dataDir)
+
+	// Snappy, which needs no compressor per chunk, keeps the test fast
+	logger := log.New(io.Discard, "", 0)
+	manager, err := NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:          dataDir,
+		Logger:           logger,
+		CompressionCodec: CompressionSnappy,
+	})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	ssm := manager.(*SSTableFileSystemManager)
+
+	const count = 300000
+	memtable := NewMemtable()
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("key%07d", i)
+		memtable.Put(Entry{Key: key, Value: []byte(key), SequenceNumber: uint64(i + 1)})
+	}
+
+	naive, err := liveHeapGrowth(ssm, func() error {
+		return ssm.Write("naive.sst", memtable.Entries())
+	})
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+	streamed, err := liveHeapGrowth(ssm, func() error {
+		return ssm.WriteFromIterator("streamed.sst", memtable.Iterator(), memtable.Len())
+	})
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+	t.Logf("live heap growth while writing: %d bytes copying the memtable, %d bytes streaming it", naive, streamed)
+	if streamed > naive/4 {
+		t.Fatalf("expected streaming to need far less memory than %d bytes, got: %d", naive, streamed)
+	}
+
+	entries, err := ssm.ReadAll("streamed.sst")
+	if err != nil || len(entries) != count {
+		t.Fatalf("expected %d entries, got: %d, %v", count, len(entries), err)
+	}
+	entry, err := ssm.FindKey("streamed.sst", "key0123456")
+	if err != nil || string(entry.Value) != "key0123456" {
+		t.Fatalf("expected key0123456, got: %s, %v", entry.Value, err)
+	}
+}
+
+func TestFlushSyncOrder(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	// Each mode is set either on the file manager or through Options
+	for _, name := range []string{"strict", "relaxed", "none", "relaxed_options", "none_options"} {
+		t.Run(name, func(t *testing.T) {
+			dataDir := filepath.Join(currentTestDir, ".testFlushSyncOrder"+name)
+			defer deleteDirectoryIfExists(dataDir)
+
+			mode, viaOptions := strings.CutSuffix(name, "_options")
+			durability, err := ParseDurability(mode)
+			if err != nil {
+				t.Fatalf("error parsing durability: %s", err)
+			}
+			fileOpts := FileManagerOptions{DataDir: dataDir, Logger: logger}
+			dbOpts := Options{MemtableThreshold: 1000, Logger: logger}
+			if viaOptions {
+				dbOpts.Durability = durability
+			} else {
+				fileOpts.Durability = durability
+			}
+			mgr, err := NewFileManagerWithOptions(fileOpts)
+			if err != nil {
+				t.Fatalf("error creating file manager: %s", err)
+			}
+			synced := []string{}
+			mgr.(*SSTableFileSystemManager).onSync = func(path string) {
+				synced = append(synced, path)
+			}
+			dbOpts.SstableMgr = mgr
+			database, err := NewDb(dbOpts)
+			if err != nil {
+				t.Fatalf("error creating db: %s", err)
+			}
+			defer database.Close()
+
+			if err := database.Put(Entry{Key: "key", Value: []byte("value")}); err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+			if err := database.Flush(); err != nil {
+				t.Fatalf("Failed to flush: %v", err)
+			}
+
+			// The table is synced, then its rename, and only then the
+			// manifest that lists it
+			expected := []string{
+				filepath.Join(dataDir, database.Sstables[0]+".tmp"),
+				dataDir,
+				filepath.Join(dataDir, ManifestFileName+".tmp"),
+				dataDir,
+			}
+			if durability == DurabilityNone {
+				expected = []string{}
+			}
+			if fmt.Sprint(synced) != fmt.Sprint(expected) {
+				t.Errorf("expected syncs %v, got %v", expected, synced)
+			}
+			if entry, err := database.Get("key"); err != nil || string(entry.Value) != "value" {
+				t.Errorf("expected the flushed entry, got %+v, %v", entry, err)
+			}
+		})
+	}
+
+	if _, err := ParseDurability("eventual"); err == nil {
+		t.Errorf("expected an error for an unknown durability")
+	}
+	if _, err := NewDb(Options{SstableMgr: NewInMemoryManager(logger), Durability: DurabilityNone + 1}); err == nil {
+		t.Errorf("expected an error for an unsupported durability")
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// footerVersion is the first version that ends with a footer.
+	footerVersion = 12
+	// footerTrailerSize is the fixed-size end of the footer: the entry count,
+	// the index offset, the offset of the footer itself, the footer checksum
+	// and sstableMagic.
+	footerTrailerSize = 8 + 8 + 8 + 4 + 4
+)
+
+// SSTableStats describes an SSTable without reading its index or blocks.
+type SSTableStats struct {
+	Version     int32
+	MinKey      string
+	MaxKey      string
+	EntryCount  uint64
+	IndexOffset uint64
+	// Size is the size of the file in bytes
+	Size int64
+}
+
+// tableFooter is the footer that closes every file from version 12 on. The
+// two keys come first, each prefixed with its length, followed by a trailer
+// of footerTrailerSize bytes, so the footer is found from the end of the file.
+type tableFooter struct {
+	MinKey      string
+	MaxKey      string
+	EntryCount  uint64
+	IndexOffset uint64
+}
+
+// writeFooter writes footer at the current offset of w.
+func writeFooter(w *offsetWriter, footer tableFooter) error {
+	footerOffset := uint64(w.offset)
+	checksum := crc32.NewIEEE()
+	out := io.MultiWriter(w, checksum)
+	for _, key := range []string{footer.MinKey, footer.MaxKey} {
+		if err := binary.Write(out, binary.BigEndian, uint32(len(key))); err != nil {
+			return fmt.Errorf("failed to write footer: %w", err)
+		}
+		if _, err := io.WriteString(out, key); err != nil {
+			return fmt.Errorf("failed to write footer: %w", err)
+		}
+	}
+	for _, value := range []uint64{footer.EntryCount, footer.IndexOffset, footerOffset} {
+		if err := binary.Write(out, binary.BigEndian, value); err != nil {
+			return fmt.Errorf("failed to write footer: %w", err)
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, checksum.Sum32()); err != nil {
+		return fmt.Errorf("failed to write footer: %w", err)
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(sstableMagic)); err != nil {
+		return fmt.Errorf("failed to write footer: %w", err)
+	}
+	return nil
+}
+
+// readFooter reads the footer at the end of a file of size bytes, checking its
+// magic number, bounds and checksum.
+func readFooter(file *os.File, size int64) (tableFooter, error) {
+	if size < footerTrailerSize {
+		return tableFooter{}, corruptionf("footer", 0, "file of %d bytes is too short for a footer", size)
+	}
+	trailerOffset := size - footerTrailerSize
+	trailer := make([]byte, footerTrailerSize)
+	if _, err := file.ReadAt(trailer, trailerOffset); err != nil {
+		return tableFooter{}, fmt.Errorf("failed to read footer: %w", err)
+	}
+	if binary.BigEndian.Uint32(trailer[28:]) != sstableMagic {
+		return tableFooter{}, corruptionf("footer", trailerOffset, "footer does not end with the sstable magic number")
+	}
+	footerOffset := binary.BigEndian.Uint64(trailer[16:24])
+	if footerOffset > uint64(trailerOffset) {
+		return tableFooter{}, corruptionf("footer", trailerOffset, "footer offset %d out of range", footerOffset)
+	}
+
+	keys := make([]byte, uint64(trailerOffset)-footerOffset)
+	if _, err := file.ReadAt(keys, int64(footerOffset)); err != nil {
+		return tableFooter{}, fmt.Errorf("failed to read footer: %w", err)
+	}
+	checksum := crc32.NewIEEE()
+	checksum.Write(keys)
+	checksum.Write(trailer[:24])
+	if checksum.Sum32() != binary.BigEndian.Uint32(trailer[24:28]) {
+		return tableFooter{}, corruptionf("footer", int64(footerOffset), "footer checksum mismatch")
+	}
+
+	footer := tableFooter{
+		EntryCount:  binary.BigEndian.Uint64(trailer[0:8]),
+		IndexOffset: binary.BigEndian.Uint64(trailer[8:16]),
+	}
+	r := bytes.NewReader(keys)
+	for _, key := range []*string{&footer.MinKey, &footer.MaxKey} {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || int64(length) > int64(r.Len()) {
+			return tableFooter{}, corruptionf("footer", int64(footerOffset), "footer key does not fit in the footer")
+		}
+		data := make([]byte, length)
+		io.ReadFull(r, data)
+		*key = string(data)
+	}
+	return footer, nil
+}
+
+// Stat returns the key range, entry count and index offset of fileName. From
+// version 12 on they are read from the footer alone; older files fall back to
+// their header and index.
+func (ssm SSTableFileSystemManager) Stat(fileName string) (SSTableStats, error) {
+	stats, _, err := ssm.stat(fileName)
+	return stats, withFileName(err, fileName)
+}
+
+// stat is Stat that also returns the header of the file.
+func (ssm SSTableFileSystemManager) stat(fileName string) (SSTableStats, FileHeader, error) {
+	file, err := os.Open(filepath.Join(ssm.DataDir, fileName))
+	if err != nil {
+		ssm.logger().Errorf("Error opening SSTable file %s: %v", fileName, err)
+		return SSTableStats{}, FileHeader{}, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return SSTableStats{}, FileHeader{}, err
+	}
+	header, err := readFileHeader(file)
+	if err != nil {
+		return SSTableStats{}, FileHeader{}, fmt.Errorf("failed to read header: %w", err)
+	}
+	stats := SSTableStats{Version: header.Version, Size: fileInfo.Size()}
+
+	if header.Version >= footerVersion {
+		footer, err := readFooter(file, stats.Size)
+		if err != nil {
+			return SSTableStats{}, FileHeader{}, err
+		}
+		stats.MinKey, stats.MaxKey = footer.MinKey, footer.MaxKey
+		stats.EntryCount, stats.IndexOffset = footer.EntryCount, footer.IndexOffset
+		return stats, header, nil
+	}
+
+	meta, err := ssm.tableMeta(fileName, file)
+	if err != nil {
+		return SSTableStats{}, FileHeader{}, err
+	}
+	if len(meta.index) > 0 {
+		stats.MinKey = meta.index[0].StartKey
+		stats.MaxKey = meta.index[len(meta.index)-1].EndKey
+	}
+	stats.EntryCount, stats.IndexOffset = uint64(header.EntryCount), header.IndexOffset
+	return stats, header, nil
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStat(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testStat")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	mgr, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	ssm := mgr.(*SSTableFileSystemManager)
+
+	data := make([]Entry, 500)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("key%03d", i+100), Value: []byte(fmt.Sprintf("value%d", i))}
+	}
+	fileName := "stat.sst"
+	if err := ssm.Write(fileName, data); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	stats, err := ssm.Stat(fileName)
+	if err != nil {
+		t.Fatalf("error reading stats: %s", err)
+	}
+	file, err := os.Open(filepath.Join(dataDir, fileName))
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	header, err := readFileHeader(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("error reading header: %s", err)
+	}
+	size, err := ssm.Size(fileName)
+	if err != nil {
+		t.Fatalf("error reading size: %s", err)
+	}
+	expected := SSTableStats{
+		Version:     SSTableVersion,
+		MinKey:      "key100",
+		MaxKey:      "key599",
+		EntryCount:  500,
+		IndexOffset: header.IndexOffset,
+		Size:        size,
+	}
+	if stats != expected {
+		t.Fatalf("expected stats %+v, got %+v", expected, stats)
+	}
+	info, err := ssm.TableInfo(fileName)
+	if err != nil || info.MinKey != "key100" || info.MaxKey != "key599" {
+		t.Fatalf("expected table info for [key100, key599], got %+v, %v", info, err)
+	}
+
+	// An empty table has an empty key range
+	if err := ssm.Write("empty.sst", nil); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+	stats, err = ssm.Stat("empty.sst")
+	if err != nil || stats.EntryCount != 0 || stats.MinKey != "" || stats.MaxKey != "" {
+		t.Fatalf("expected empty stats, got %+v, %v", stats, err)
+	}
+
+	// Files from before the footer fall back to the header and index
+	v1 := SSTableFileSystemManager{DataDir: "testdata", Logger: logger}
+	stats, err = v1.Stat("sstable_v1.sst")
+	if err != nil || stats.Version != 1 || stats.EntryCount != 50 || stats.MinKey != "key00" || stats.MaxKey != "key49" {
+		t.Fatalf("expected version 1 stats of key00..key49, got %+v, %v", stats, err)
+	}
+}
+
+func TestCorruptFooter(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testCorruptFooter")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	mgr, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	data := []Entry{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}}
+	for _, offsetFromEnd := range []int64{footerTrailerSize + 1, footerTrailerSize - 2, 1} {
+		fileName := fmt.Sprintf("corrupt%d.sst", offsetFromEnd)
+		if err := mgr.Write(fileName, data); err != nil {
+			t.Fatalf("error writing file: %s", err)
+		}
+		path := filepath.Join(dataDir, fileName)
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("error reading file: %s", err)
+		}
+		contents[int64(len(contents))-offsetFromEnd] ^= 0xFF
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			t.Fatalf("error writing file: %s", err)
+		}
+
+		var corrupt *CorruptionError
+		if _, err := mgr.(*SSTableFileSystemManager).Stat(fileName); !errors.As(err, &corrupt) || corrupt.FileName != fileName {
+			t.Errorf("byte %d from the end: expected a corruption error in %s, got %v", offsetFromEnd, fileName, err)
+		}
+		report, err := mgr.Verify(fileName)
+		if err != nil || report.OK() || !errors.As(report.Footer, &corrupt) {
+			t.Errorf("byte %d from the end: expected verify to report the footer, got %v, %v", offsetFromEnd, report, err)
+		}
+		// The footer is not needed to read the data
+		if entry, err := mgr.FindKey(fileName, "b"); err != nil || string(entry.Value) != "2" {
+			t.Errorf("byte %d from the end: expected to find b, got %+v, %v", offsetFromEnd, entry, err)
+		}
+	}
+}
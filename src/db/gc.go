@@ -0,0 +1,93 @@
+package db
+
+// tempFileRemover is implemented by SSTable managers whose writes can leave
+// temporary files behind when they crash, such as SSTableFileSystemManager.
+type tempFileRemover interface {
+	RemoveTempFiles() error
+}
+
+// RunGC deletes SSTable files in the data directory that no level references
+// any longer, such as inputs of a compaction that crashed before cleaning up.
+// Pinned files belong to an in-flight compaction and are left alone. Temporary
+// files are removed by NewDb instead, as one may belong to a write in flight.
+func (db *LSM) RunGC() error {
+	// The files of a read-only database may belong to a newer writer
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	// List before taking the snapshot: a file being flushed when it is listed
+	// is live by the time the snapshot is taken, and a compaction pins its
+	// output before creating it.
+	fileNames, err := db.sstableMgr.ListFiles()
+	if err != nil {
+		db.logger.Errorf("Error in listing sstables for gc: %v", err)
+		return err
+	}
+
+	db.mu.RLock()
+	live := make(map[string]bool, len(db.tableInfo))
+	for _, fileNames := range append([][]string{db.Sstables}, db.levels...) {
+		for _, fileName := range fileNames {
+			live[fileName] = true
+		}
+	}
+	db.mu.RUnlock()
+
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	removed := 0
+	for _, fileName := range fileNames {
+		if !live[fileName] && db.refs[fileName] == 0 {
+			db.deleteSSTableFile(fileName)
+			removed++
+		}
+	}
+	db.logger.Infof("GC removed %d obsolete sstables", removed)
+	return nil
+}
+
+// pinSSTables keeps fileNames on disk until they are unpinned, even if they
+// stop being live in the meantime.
+func (db *LSM) pinSSTables(fileNames ...string) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	for _, fileName := range fileNames {
+		db.refs[fileName]++
+	}
+}
+
+// unpinSSTables releases pins taken by pinSSTables and deletes any file that
+// became obsolete while pinned.
+func (db *LSM) unpinSSTables(fileNames ...string) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	for _, fileName := range fileNames {
+		db.refs[fileName]--
+		if db.refs[fileName] > 0 {
+			continue
+		}
+		delete(db.refs, fileName)
+		if db.obsolete[fileName] {
+			delete(db.obsolete, fileName)
+			db.deleteSSTableFile(fileName)
+		}
+	}
+}
+
+// removeSSTable deletes a file that is no longer live, deferring the deletion
+// until the last pin on it is released.
+func (db *LSM) removeSSTable(fileName string) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	if db.refs[fileName] > 0 {
+		db.obsolete[fileName] = true
+		return
+	}
+	db.deleteSSTableFile(fileName)
+}
+
+func (db *LSM) deleteSSTableFile(fileName string) {
+	if err := db.sstableMgr.Delete(fileName); err != nil {
+		db.logger.Errorf("Error in deleting obsolete sstable %s: %v", fileName, err)
+	}
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunGC(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testRunGC")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "GC_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		err := database.Put(Entry{Key: fmt.Sprintf("key%d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+		if err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+	live := append([]string{}, database.Sstables...)
+
+	// Leftovers of an earlier crash, one of them still in use by a reader
+	stale := []string{"sstable_97.sst", "sstable_98.sst", "sstable_99.sst"}
+	for _, fileName := range stale {
+		err := ssm.Write(fileName, []Entry{{Key: "stale", Value: []byte("stale")}})
+		if err != nil {
+			t.Fatalf("error writing stale file: %s", err)
+		}
+	}
+	database.pinSSTables("sstable_99.sst")
+
+	err = database.RunGC()
+	if err != nil {
+		t.Fatalf("Failed to run gc: %v", err)
+	}
+
+	for _, fileName := range live {
+		if _, err := os.Stat(filepath.Join(dataDir, fileName)); err != nil {
+			t.Fatalf("expected live file %s to survive, got: %v", fileName, err)
+		}
+	}
+	for _, fileName := range stale[:2] {
+		if _, err := os.Stat(filepath.Join(dataDir, fileName)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be deleted, got: %v", fileName, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "sstable_99.sst")); err != nil {
+		t.Fatalf("expected pinned file to survive, got: %v", err)
+	}
+
+	database.unpinSSTables("sstable_99.sst")
+	err = database.RunGC()
+	if err != nil {
+		t.Fatalf("Failed to run gc: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "sstable_99.sst")); !os.IsNotExist(err) {
+		t.Fatalf("expected sstable_99.sst to be deleted, got: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := database.Get(fmt.Sprintf("key%d", i)); err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+	}
+}
+
+func TestNewDbRemovesTempFiles(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testNewDbRemovesTempFiles")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "GC_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	if err := ssm.Write("sstable_0.sst", []Entry{{Key: "key0", Value: []byte("value0")}}); err != nil {
+		t.Fatalf("error writing sstable: %s", err)
+	}
+	if err := ssm.WriteManifest([][]string{{"sstable_0.sst"}}); err != nil {
+		t.Fatalf("error writing manifest: %s", err)
+	}
+	// Leftovers of a flush and a manifest write that crashed before renaming
+	temps := []string{"sstable_1.sst.tmp", ManifestFileName + ".tmp"}
+	for _, fileName := range temps {
+		if err := os.WriteFile(filepath.Join(dataDir, fileName), []byte("partial"), 0o644); err != nil {
+			t.Fatalf("error writing temporary file: %s", err)
+		}
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	for _, fileName := range temps {
+		if _, err := os.Stat(filepath.Join(dataDir, fileName)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be deleted, got: %v", fileName, err)
+		}
+	}
+	if entry, err := database.Get("key0"); err != nil || string(entry.Value) != "value0" {
+		t.Errorf("expected key0 from the live sstable, got %q, %v", entry.Value, err)
+	}
+}
+
+func TestRemoveSSTableWaitsForReaders(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testRemoveSSTableWaitsForReaders")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "GC_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	fileName := "sstable_5.sst"
+	err = ssm.Write(fileName, []Entry{{Key: "key", Value: []byte("value")}})
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	database.pinSSTables(fileName)
+	database.pinSSTables(fileName)
+	database.removeSSTable(fileName)
+
+	database.unpinSSTables(fileName)
+	if _, err := ssm.FindKey(fileName, "key"); err != nil {
+		t.Fatalf("expected file to stay readable while pinned, got: %v", err)
+	}
+
+	database.unpinSSTables(fileName)
+	if _, err := os.Stat(filepath.Join(dataDir, fileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be deleted after the last unpin, got: %v", fileName, err)
+	}
+}
@@ -0,0 +1,291 @@
+package db
+
+// Iterator streams the live entries of a key range in key order. Unlike Scan
+// it does not hold the whole range in memory: SSTables are read one block at a
+// time as the iterator advances. An Iterator sees the database as of its
+// creation and keeps the SSTables it reads on disk until it is closed. It is
+// not safe for concurrent use.
+//
+//	it, err := database.NewIterator("a", "b")
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+//	return it.Err()
+type Iterator interface {
+	// Next advances to the next entry and reports whether there is one. It
+	// must be called before the first entry is read.
+	Next() bool
+	Key() string
+	Value() []byte
+	// Err returns the error that stopped the iteration, if any.
+	Err() error
+	// Close releases the SSTables of the iterator. Closing a closed iterator
+	// does nothing.
+	Close() error
+}
+
+// blockLister is implemented by SSTable managers that can list the blocks of a
+// table, such as SSTableFileSystemManager. Iterators over other managers read
+// each table with Scan when they are created.
+type blockLister interface {
+	Blocks(fileName string) ([]IndexEntry, error)
+}
+
+// NewIterator returns an Iterator over the live entries with
+// startKey <= key < endKey. An empty endKey iterates to the last key.
+func (db *LSM) NewIterator(startKey string, endKey string) (Iterator, error) {
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Release()
+	return snapshot.NewIterator(startKey, endKey)
+}
+
+// NewIterator returns an Iterator over the live entries with
+// startKey <= key < endKey as of the snapshot. The iterator pins its own
+// SSTables, so it stays usable after the snapshot is released.
+func (s *Snapshot) NewIterator(startKey string, endKey string) (Iterator, error) {
+	it, err := s.newIterator(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// newIterator is NewIterator returning the iterator itself, whose entry holds
+// the whole record it is at.
+func (s *Snapshot) newIterator(startKey string, endKey string) (*dbIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	fileNames := []string{}
+	for _, fileName := range scanOrder(s.l0, s.levels) {
+		if info, ok := s.tableInfo[fileName]; ok && !info.overlaps(startKey, endKey) {
+			continue
+		}
+		fileNames = append(fileNames, fileName)
+	}
+	s.db.pinSSTables(fileNames...)
+	it := &dbIterator{
+		endKey: endKey,
+		merger: s.db.merger(s.now),
+		release: func() {
+			s.db.unpinSSTables(fileNames...)
+		},
+	}
+
+	// Sources are ordered oldest first, like the tables given to mergeEntries
+	for _, fileName := range fileNames {
+		source, err := s.db.tableSource(fileName, startKey, endKey)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.sources = append(it.sources, source)
+	}
+	for i := len(s.memtables) - 1; i >= 0; i-- {
+		memtableIt := s.memtables[i].Iterator()
+		memtableIt.Seek(startKey)
+		it.sources = append(it.sources, memtableIt)
+	}
+	return it, nil
+}
+
+// tableSource returns an EntryIterator over the entries of fileName with
+// startKey <= key < endKey, tombstones included.
+func (db *LSM) tableSource(fileName string, startKey string, endKey string) (EntryIterator, error) {
+	lister, ok := db.sstableMgr.(blockLister)
+	if !ok {
+		entries, err := db.sstableMgr.Scan(fileName, startKey, endKey)
+		if err != nil {
+			db.logger.Errorf("Error in scanning sstable %s: %v", fileName, err)
+			return nil, err
+		}
+		return &sliceIterator{entries: entries}, nil
+	}
+
+	blocks, err := lister.Blocks(fileName)
+	if err != nil {
+		db.logger.Errorf("Error in reading blocks of sstable %s: %v", fileName, err)
+		return nil, err
+	}
+	it := &tableIterator{
+		mgr:      db.sstableMgr,
+		fileName: fileName,
+		blocks:   blocks,
+		startKey: startKey,
+		endKey:   endKey,
+	}
+	it.fill()
+	return it, it.err
+}
+
+// tableIterator walks the entries of one SSTable within a key range, reading a
+// block only once the entries before it are used up.
+type tableIterator struct {
+	mgr      SSTableManager
+	fileName string
+	// blocks are the blocks not read yet
+	blocks   []IndexEntry
+	startKey string
+	endKey   string
+	entries  []Entry
+	err      error
+}
+
+func (it *tableIterator) Valid() bool {
+	return len(it.entries) > 0
+}
+
+func (it *tableIterator) Next() {
+	it.entries = it.entries[1:]
+	it.fill()
+}
+
+func (it *tableIterator) Entry() Entry {
+	return it.entries[0]
+}
+
+// fill reads blocks until it finds one with entries in the range, or runs out.
+func (it *tableIterator) fill() {
+	for len(it.entries) == 0 && len(it.blocks) > 0 && it.err == nil {
+		block := it.blocks[0]
+		it.blocks = it.blocks[1:]
+		if block.EndKey < it.startKey {
+			continue
+		}
+		if it.endKey != "" && block.StartKey >= it.endKey {
+			it.blocks = nil
+			return
+		}
+		entries, err := it.mgr.ReadBlock(it.fileName, block.BlockOffset)
+		if err != nil {
+			it.err = err
+			return
+		}
+		for _, entry := range entries {
+			if entry.Key >= it.startKey && (it.endKey == "" || entry.Key < it.endKey) {
+				it.entries = append(it.entries, entry)
+			}
+		}
+	}
+}
+
+// dbIterator merges sources ordered oldest first, keeping the newest record of
+// every key as mergeEntries does and skipping deleted and expired keys.
+type dbIterator struct {
+	sources []EntryIterator
+	endKey  string
+	merger  merger
+	// records holds the records of the key being read, reused between keys
+	records []Entry
+	entry   Entry
+	err     error
+	release func()
+	closed  bool
+}
+
+func (it *dbIterator) Next() bool {
+	for it.err == nil && !it.closed {
+		key, found := "", false
+		for _, source := range it.sources {
+			if source.Valid() && (!found || source.Entry().Key < key) {
+				key, found = source.Entry().Key, true
+			}
+		}
+		if !found || (it.endKey != "" && key >= it.endKey) {
+			it.entry = Entry{}
+			return false
+		}
+
+		it.records = it.records[:0]
+		for _, source := range it.sources {
+			if source.Valid() && source.Entry().Key == key {
+				it.records = append(it.records, source.Entry())
+				source.Next()
+			}
+			if table, ok := source.(*tableIterator); ok && table.err != nil {
+				it.err = table.err
+			}
+		}
+		it.entry = it.merger.resolve(it.merger.fold(it.records))
+		if it.err == nil && !it.entry.Tombstone && !it.entry.expired(it.merger.now) {
+			return true
+		}
+	}
+	it.entry = Entry{}
+	return false
+}
+
+func (it *dbIterator) Key() string {
+	return it.entry.Key
+}
+
+func (it *dbIterator) Value() []byte {
+	return it.entry.Value
+}
+
+func (it *dbIterator) Err() error {
+	return it.err
+}
+
+func (it *dbIterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	it.sources = nil
+	it.entry = Entry{}
+	it.release()
+	return nil
+}
+
+// Keys returns up to limit live keys beginning with prefix and greater than
+// after, in ascending order. A limit of zero or less returns every matching
+// key. It reads through an Iterator, so at most one block of each SSTable is
+// held in memory.
+func (db *LSM) Keys(prefix string, limit int, after string) ([]string, error) {
+	startKey, endKey := prefix, PrefixEnd(prefix)
+	// The smallest key greater than after
+	if next := after + "\x00"; after != "" && next > startKey {
+		startKey = next
+	}
+	keys := []string{}
+	if endKey != "" && startKey >= endKey {
+		return keys, nil
+	}
+
+	it, err := db.NewIterator(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	for (limit <= 0 || len(keys) < limit) && it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// PrefixEnd returns the smallest key greater than every key beginning with
+// prefix, or "" if there is none.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testIterator")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 100000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// Three overlapping tables and the memtable hold versions of 2000 keys:
+	// every key is written, every third key is overwritten and every fifth
+	// key is deleted
+	expected := make(map[string]string)
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		if err := database.Put(Entry{Key: key, Value: []byte("v1")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		expected[key] = "v1"
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	for i := 0; i < 2000; i += 3 {
+		key := fmt.Sprintf("key%05d", i)
+		if err := database.Put(Entry{Key: key, Value: []byte("v2")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		expected[key] = "v2"
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	for i := 0; i < 2000; i += 5 {
+		key := fmt.Sprintf("key%05d", i)
+		if err := database.Delete(key); err != nil {
+			t.Fatalf("Failed to delete entry: %v", err)
+		}
+		delete(expected, key)
+	}
+	for i := 1; i < 2000; i += 10 {
+		key := fmt.Sprintf("key%05d", i)
+		if err := database.Put(Entry{Key: key, Value: []byte("v3")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		expected[key] = "v3"
+	}
+	blocks, err := ssm.(*SSTableFileSystemManager).Blocks(database.Sstables[0])
+	if err != nil || len(blocks) < 2 {
+		t.Fatalf("expected the first table to span several blocks, got %d, %v", len(blocks), err)
+	}
+
+	it, err := database.NewIterator("key00100", "key01900")
+	if err != nil {
+		t.Fatalf("Failed to create iterator: %v", err)
+	}
+	defer it.Close()
+	// Writes after the iterator is created are not visible to it
+	if err := database.Put(Entry{Key: "key00500", Value: []byte("v4")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+
+	count := 0
+	previous := ""
+	for it.Next() {
+		key := it.Key()
+		if key <= previous {
+			t.Fatalf("expected keys in ascending order without duplicates, got %s after %s", key, previous)
+		}
+		if key < "key00100" || key >= "key01900" {
+			t.Fatalf("expected keys in [key00100, key01900), got %s", key)
+		}
+		if want, ok := expected[key]; !ok || string(it.Value()) != want {
+			t.Fatalf("expected %s=%s, got %s", key, want, it.Value())
+		}
+		previous = key
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Failed to iterate: %v", err)
+	}
+	want := 0
+	for key := range expected {
+		if key >= "key00100" && key < "key01900" {
+			want++
+		}
+	}
+	if count != want {
+		t.Errorf("expected %d entries, got %d", want, count)
+	}
+
+	if err := it.Close(); err != nil {
+		t.Fatalf("Failed to close iterator: %v", err)
+	}
+	if it.Next() {
+		t.Errorf("expected a closed iterator to stop")
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("expected closing twice to do nothing, got %v", err)
+	}
+
+	database.Close()
+	if _, err := database.NewIterator("", ""); !errors.Is(err, ErrDBClosed) {
+		t.Errorf("expected ErrDBClosed, got %v", err)
+	}
+}
+
+func TestIteratorWithoutBlocks(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	// The range mock cannot list blocks, so its tables are read with Scan
+	mgr := NewRangeMockSSTableManager()
+	database, err := NewDb(Options{
+		MemtableThreshold: 2,
+		SstableMgr:        mgr,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := database.Put(Entry{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+
+	it, err := database.NewIterator("b", "e")
+	if err != nil {
+		t.Fatalf("Failed to create iterator: %v", err)
+	}
+	defer it.Close()
+	keys := ""
+	for it.Next() {
+		keys += it.Key()
+	}
+	if it.Err() != nil || keys != "bcd" {
+		t.Errorf("expected bcd, got %s, %v", keys, it.Err())
+	}
+	if calls := mgr.scanCalls[database.Sstables[2]]; calls != 0 {
+		t.Errorf("expected the table of [e, f] not to be read, got %d scans", calls)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testKeys")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// Even users are flushed and odd ones stay in the memtable, along with a
+	// second version of user0 and the deletion of user4
+	put := func(key string) {
+		t.Helper()
+		if err := database.Put(Entry{Key: key, Value: []byte("value")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	for i := 0; i < 10; i += 2 {
+		put(fmt.Sprintf("user%d", i))
+	}
+	put("admin")
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	for i := 1; i < 10; i += 2 {
+		put(fmt.Sprintf("user%d", i))
+	}
+	put("user0")
+	put("vendor")
+	if err := database.Delete("user4"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+
+	pages := []string{}
+	after := ""
+	for {
+		keys, err := database.Keys("user", 3, after)
+		if err != nil {
+			t.Fatalf("Failed to list keys: %v", err)
+		}
+		pages = append(pages, fmt.Sprint(keys))
+		if len(keys) < 3 {
+			break
+		}
+		after = keys[len(keys)-1]
+	}
+	expected := "[[user0 user1 user2] [user3 user5 user6] [user7 user8 user9] []]"
+	if fmt.Sprint(pages) != expected {
+		t.Errorf("expected pages %s, got %v", expected, pages)
+	}
+
+	keys, err := database.Keys("", 0, "user8")
+	if err != nil || fmt.Sprint(keys) != "[user9 vendor]" {
+		t.Errorf("expected the keys after user8, got %v, %v", keys, err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"user":     "uses",
+		"a\xff":    "b",
+		"\xff\xff": "",
+		"":         "",
+	}
+	for prefix, expected := range tests {
+		if got := PrefixEnd(prefix); got != expected {
+			t.Errorf("PrefixEnd(%q): expected %q, got %q", prefix, expected, got)
+		}
+	}
+}
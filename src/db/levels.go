@@ -0,0 +1,421 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultLevelBaseSize is the target size of L1 under leveled compaction.
+	DefaultLevelBaseSize = 10 << 20 // 10MB
+	// DefaultLevelSizeMultiplier is the growth factor between the target sizes
+	// of adjacent levels.
+	DefaultLevelSizeMultiplier = 10
+	// DefaultTargetFileSize is the size of the SSTables written by leveled
+	// compactions.
+	DefaultTargetFileSize = 2 << 20 // 2MB
+	// maxLevels bounds the depth of the tree, counting L0. The last level has
+	// no target size and is never compacted further.
+	maxLevels = 7
+)
+
+// leveling holds the settings of leveled compaction and the state of the
+// background compactor.
+type leveling struct {
+	enabled bool
+	// maxL0Files is MaxL0Files, which only applies without leveled compaction
+	maxL0Files     int
+	baseSize       int64
+	multiplier     int64
+	targetFileSize int64
+	// pointers[level] is the largest key of the table last compacted out of
+	// level, so successive compactions rotate through its key space. It is
+	// guarded by db.compactionMu.
+	pointers [maxLevels]string
+	// wake is signalled after every flush. Closing stop makes the compactor
+	// exit, after which it closes done.
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	errMu    sync.Mutex
+	lastErr  error
+}
+
+func newLeveling(opts Options) leveling {
+	baseSize := opts.LevelBaseSize
+	if baseSize <= 0 {
+		baseSize = DefaultLevelBaseSize
+	}
+	multiplier := int64(opts.LevelSizeMultiplier)
+	if multiplier < 2 {
+		multiplier = DefaultLevelSizeMultiplier
+	}
+	targetFileSize := opts.TargetFileSize
+	if targetFileSize <= 0 {
+		targetFileSize = DefaultTargetFileSize
+	}
+	return leveling{
+		enabled:        opts.LeveledCompaction,
+		maxL0Files:     opts.MaxL0Files,
+		baseSize:       baseSize,
+		multiplier:     multiplier,
+		targetFileSize: targetFileSize,
+	}
+}
+
+// targetSize returns the size in bytes a level may grow to before it is
+// compacted into the next one. level must be at least 1.
+func (l *leveling) targetSize(level int) int64 {
+	size := l.baseSize
+	for i := 1; i < level; i++ {
+		size *= l.multiplier
+	}
+	return size
+}
+
+// startCompactions starts the background compactor. It is woken right away
+// to catch up on levels that were over their targets when the database was
+// opened.
+func (db *LSM) startCompactions() {
+	db.leveling.wake = make(chan struct{}, 1)
+	db.leveling.stop = make(chan struct{})
+	db.leveling.done = make(chan struct{})
+	db.leveling.wake <- struct{}{}
+	go db.runCompactions()
+}
+
+// wakeCompactions tells the background compactor that L0 grew. It never
+// blocks and does nothing when there is no compactor.
+func (db *LSM) wakeCompactions() {
+	select {
+	case db.leveling.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stopCompactions stops the background compactor, waiting for a running
+// compaction to finish.
+func (db *LSM) stopCompactions() {
+	db.leveling.stopOnce.Do(func() {
+		if db.leveling.stop != nil {
+			close(db.leveling.stop)
+			<-db.leveling.done
+		}
+	})
+}
+
+// runCompactions compacts levels each time it is woken until none is over its
+// target, or without leveled compaction until L0 holds at most MaxL0Files
+// tables. A failed compaction is logged and retried after the next flush.
+func (db *LSM) runCompactions() {
+	defer close(db.leveling.done)
+	for {
+		select {
+		case <-db.leveling.stop:
+			return
+		case <-db.leveling.wake:
+		}
+
+		for compacted := true; compacted; {
+			select {
+			case <-db.leveling.stop:
+				return
+			default:
+			}
+			var err error
+			if db.leveling.enabled {
+				compacted, err = db.compactNextLevel()
+			} else {
+				compacted, err = db.compactL0Overflow()
+			}
+			db.leveling.errMu.Lock()
+			db.leveling.lastErr = err
+			db.leveling.errMu.Unlock()
+			if err != nil {
+				db.logger.Errorf("Error in background compaction: %v", err)
+			}
+		}
+	}
+}
+
+// LastCompactionError returns the error of the latest background compaction,
+// or nil if it succeeded.
+func (db *LSM) LastCompactionError() error {
+	db.leveling.errMu.Lock()
+	defer db.leveling.errMu.Unlock()
+	return db.leveling.lastErr
+}
+
+// compactNextLevel runs one round of leveled compaction on the level furthest
+// over its target, if any, with compactLevel. It reports whether anything was
+// compacted.
+func (db *LSM) compactNextLevel() (bool, error) {
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+
+	l0, levels, infos, err := db.levelsSnapshot()
+	if err != nil {
+		return false, err
+	}
+	level, err := db.pickLevel(l0, levels)
+	if err != nil || level < 0 {
+		return false, err
+	}
+	if err := db.mergeLevel(level, l0, levels, infos); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// compactLevel merges level into the next one: all of L0, or one table of a
+// deeper level, picked in turn across its key space. The inputs are merged
+// with the tables of the next level whose key ranges they overlap, and the
+// result replaces those tables as non-overlapping tables of about the target
+// file size. Levels are compacted whether or not they are over their targets;
+// an empty level is left alone.
+//
+// Like Compact, the merge runs without holding db.mu.
+func (db *LSM) compactLevel(level int) error {
+	if level < 0 || level >= maxLevels-1 {
+		return fmt.Errorf("cannot compact level %d", level)
+	}
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+
+	l0, levels, infos, err := db.levelsSnapshot()
+	if err != nil {
+		return err
+	}
+	if (level == 0 && len(l0) == 0) || (level > 0 && (level > len(levels) || len(levels[level-1]) == 0)) {
+		return nil
+	}
+	return db.mergeLevel(level, l0, levels, infos)
+}
+
+// levelsSnapshot returns copies of L0, the deeper levels and the key ranges of
+// their tables. Only compactions change the levels and remove L0 tables, so the
+// copies stay accurate while db.compactionMu is held.
+func (db *LSM) levelsSnapshot() ([]string, [][]string, map[string]TableInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, nil, nil, ErrDBClosed
+	}
+	l0 := append([]string{}, db.Sstables...)
+	levels := make([][]string, len(db.levels))
+	for i := range db.levels {
+		levels[i] = append([]string{}, db.levels[i]...)
+	}
+	infos := make(map[string]TableInfo, len(db.tableInfo))
+	for fileName, info := range db.tableInfo {
+		infos[fileName] = info
+	}
+	return l0, levels, infos, nil
+}
+
+// mergeLevel does the work of compactLevel on a snapshot of the levels. The
+// caller must hold db.compactionMu.
+func (db *LSM) mergeLevel(level int, l0 []string, levels [][]string, infos map[string]TableInfo) error {
+	var inputs []string
+	if level == 0 {
+		inputs = l0
+	} else {
+		inputs = []string{db.pickTable(level, levels[level-1], infos)}
+	}
+	// levels[level] is the output level, level+1
+	for len(levels) <= level {
+		levels = append(levels, []string{})
+	}
+	minKey, maxKey := infos[inputs[0]].MinKey, infos[inputs[0]].MaxKey
+	for _, fileName := range inputs[1:] {
+		if infos[fileName].MinKey < minKey {
+			minKey = infos[fileName].MinKey
+		}
+		if infos[fileName].MaxKey > maxKey {
+			maxKey = infos[fileName].MaxKey
+		}
+	}
+	overlapping := []string{}
+	for _, fileName := range levels[level] {
+		if infos[fileName].MaxKey >= minKey && infos[fileName].MinKey <= maxKey {
+			overlapping = append(overlapping, fileName)
+		}
+	}
+	older := []string{}
+	for _, fileNames := range levels[level+1:] {
+		older = append(older, fileNames...)
+	}
+
+	pinned := append(append(append([]string{}, inputs...), overlapping...), older...)
+	db.pinSSTables(pinned...)
+	defer db.unpinSSTables(pinned...)
+
+	db.logger.Infof("Compacting %d sstables of level %d with %d of level %d", len(inputs), level, len(overlapping), level+1)
+	// The next level holds older data than the inputs
+	tables := make([][]Entry, 0, len(overlapping)+len(inputs))
+	for _, fileName := range append(append([]string{}, overlapping...), inputs...) {
+		entries, err := db.sstableMgr.ReadAll(fileName)
+		if err != nil {
+			db.logger.Errorf("Error in reading sstable %s for compaction: %v", fileName, err)
+			return err
+		}
+		tables = append(tables, entries)
+	}
+
+	merged := []Entry{}
+	m := db.merger(db.now())
+	for _, entry := range expireEntries(m.mergeEntries(tables, false), m.now) {
+		switch {
+		case entry.Tombstone && !db.mayShadowOlderData(older, entry.Key):
+			continue
+		case entry.Merge && !db.mayShadowOlderData(older, entry.Key):
+			// No older record is left for the operand to apply to
+			entry = m.resolve(entry)
+		}
+		merged = append(merged, entry)
+	}
+	chunks := splitEntries(merged, db.leveling.targetFileSize)
+
+	db.mu.Lock()
+	outputs := make([]string, len(chunks))
+	for i := range chunks {
+		outputs[i] = db.newSSTableName()
+	}
+	db.pinSSTables(outputs...)
+	db.mu.Unlock()
+	defer db.unpinSSTables(outputs...)
+
+	outputInfo := make(map[string]TableInfo, len(outputs))
+	for i, chunk := range chunks {
+		if err := db.sstableMgr.Write(outputs[i], chunk); err != nil {
+			db.logger.Errorf("Error in writing compacted sstable %s: %v", outputs[i], err)
+			return err
+		}
+		outputInfo[outputs[i]] = tableInfoOf(chunk)
+	}
+
+	replaced := make(map[string]bool, len(inputs)+len(overlapping))
+	for _, fileName := range append(append([]string{}, inputs...), overlapping...) {
+		replaced[fileName] = true
+	}
+	keep := func(fileNames []string) []string {
+		kept := []string{}
+		for _, fileName := range fileNames {
+			if !replaced[fileName] {
+				kept = append(kept, fileName)
+			}
+		}
+		return kept
+	}
+
+	db.mu.Lock()
+	// Flushes that happened during the merge were appended to L0
+	sstables := keep(db.Sstables)
+	for i := range levels {
+		levels[i] = keep(levels[i])
+	}
+	levels[level] = append(levels[level], outputs...)
+	sort.Slice(levels[level], func(i, j int) bool {
+		return tableInfoFor(levels[level][i], outputInfo, infos).MinKey <
+			tableInfoFor(levels[level][j], outputInfo, infos).MinKey
+	})
+	err := db.sstableMgr.WriteManifest(append([][]string{sstables}, levels...))
+	if err != nil {
+		db.mu.Unlock()
+		db.logger.Errorf("Error in writing manifest: %v", err)
+		return err
+	}
+	db.Sstables = sstables
+	db.levels = levels
+	for fileName := range replaced {
+		delete(db.tableInfo, fileName)
+	}
+	for fileName, info := range outputInfo {
+		db.tableInfo[fileName] = info
+	}
+	db.mu.Unlock()
+
+	for fileName := range replaced {
+		db.removeSSTable(fileName)
+	}
+	db.counters.compactions.Add(1)
+	if db.metrics != nil {
+		db.metrics.AddCompactionBytes(db.tableBytes(append(append([]string{}, inputs...), overlapping...)), db.tableBytes(outputs))
+	}
+	db.logger.Infof("Compacted %d sstables into %d sstables of level %d", len(replaced), len(outputs), level+1)
+	return nil
+}
+
+// pickLevel returns the level to compact next, or -1 if no level is over its
+// target. L0 is measured by its number of tables and deeper levels by their
+// size, each relative to its target.
+func (db *LSM) pickLevel(l0 []string, levels [][]string) (int, error) {
+	best, bestScore := -1, 0.0
+	if len(l0) >= db.compactionMinThreshold {
+		best, bestScore = 0, float64(len(l0))/float64(db.compactionMinThreshold)
+	}
+	for i, fileNames := range levels {
+		level := i + 1
+		if level >= maxLevels-1 {
+			break
+		}
+		var size int64
+		for _, fileName := range fileNames {
+			fileSize, err := db.sstableMgr.Size(fileName)
+			if err != nil {
+				db.logger.Errorf("Error in reading size of sstable %s: %v", fileName, err)
+				return -1, err
+			}
+			size += fileSize
+		}
+		target := db.leveling.targetSize(level)
+		if score := float64(size) / float64(target); size > target && score > bestScore {
+			best, bestScore = level, score
+		}
+	}
+	return best, nil
+}
+
+// pickTable returns the table of level to compact: the first one after the
+// key range compacted last, wrapping around at the end of the level. The
+// caller must hold db.compactionMu.
+func (db *LSM) pickTable(level int, fileNames []string, infos map[string]TableInfo) string {
+	picked := fileNames[0]
+	for _, fileName := range fileNames {
+		if infos[fileName].MinKey > db.leveling.pointers[level] {
+			picked = fileName
+			break
+		}
+	}
+	db.leveling.pointers[level] = infos[picked].MaxKey
+	return picked
+}
+
+// tableInfoFor looks fileName up in the first of infos that has it.
+func tableInfoFor(fileName string, infos ...map[string]TableInfo) TableInfo {
+	for _, m := range infos {
+		if info, ok := m[fileName]; ok {
+			return info
+		}
+	}
+	return TableInfo{}
+}
+
+// splitEntries cuts entries, sorted by key, into consecutive runs holding
+// about targetSize bytes of keys and values each.
+func splitEntries(entries []Entry, targetSize int64) [][]Entry {
+	chunks := [][]Entry{}
+	start := 0
+	var size int64
+	for i, entry := range entries {
+		size += int64(len(entry.Key) + len(entry.Value))
+		if size >= targetSize || i == len(entries)-1 {
+			chunks = append(chunks, entries[start:i+1])
+			start = i + 1
+			size = 0
+		}
+	}
+	return chunks
+}
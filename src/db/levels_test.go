@@ -0,0 +1,398 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newLeveledTestDb opens a database in dataDir whose levels are small enough
+// for a few thousand entries to fill several of them.
+func newLeveledTestDb(t *testing.T, dataDir string, logger *log.Logger) *LSM {
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold:      100,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+		CompactionMinThreshold: 2,
+		LeveledCompaction:      true,
+		LevelBaseSize:          8 << 10,
+		LevelSizeMultiplier:    2,
+		TargetFileSize:         4 << 10,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	return database
+}
+
+// compactAllLevels runs leveled compactions until no level is over its target.
+func compactAllLevels(t *testing.T, database *LSM) {
+	for {
+		compacted, err := database.compactNextLevel()
+		if err != nil {
+			t.Fatalf("compaction failed: %v", err)
+		}
+		if !compacted {
+			return
+		}
+	}
+}
+
+// checkLevelsDoNotOverlap fails unless the tables of every level below L0 are
+// in key order with disjoint key ranges.
+func checkLevelsDoNotOverlap(t *testing.T, database *LSM) {
+	database.mu.RLock()
+	defer database.mu.RUnlock()
+	for i, fileNames := range database.levels {
+		for j := 1; j < len(fileNames); j++ {
+			prev, next := database.tableInfo[fileNames[j-1]], database.tableInfo[fileNames[j]]
+			if prev.MaxKey >= next.MinKey {
+				t.Fatalf("tables %s [%s, %s] and %s [%s, %s] of level %d overlap",
+					fileNames[j-1], prev.MinKey, prev.MaxKey, fileNames[j], next.MinKey, next.MaxKey, i+1)
+			}
+		}
+	}
+}
+
+func TestLeveledCompaction(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testLeveledCompaction")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	database := newLeveledTestDb(t, dataDir, logger)
+
+	// Random values keep the tables from compressing down to nothing, and
+	// overwrites and deletes leave older versions for compactions to drop
+	random := rand.New(rand.NewSource(1))
+	expected := make(map[string]string)
+	for round := 0; round < 3; round++ {
+		for _, i := range random.Perm(1000) {
+			key := fmt.Sprintf("key%04d", i)
+			if round == 2 && i%5 == 0 {
+				if err := database.Delete(key); err != nil {
+					t.Fatalf("Failed to delete entry: %v", err)
+				}
+				delete(expected, key)
+				continue
+			}
+			value := fmt.Sprintf("%d-%x-%x", round, random.Int63(), random.Int63())
+			if err := database.Put(Entry{Key: key, Value: []byte(value)}); err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+			expected[key] = value
+		}
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	compactAllLevels(t, database)
+
+	if len(database.levels) < 3 {
+		t.Fatalf("expected at least 3 levels below L0, got: %d", len(database.levels))
+	}
+	if len(database.Sstables) >= database.compactionMinThreshold {
+		t.Fatalf("expected L0 to be compacted, got: %v", database.Sstables)
+	}
+	checkLevelsDoNotOverlap(t, database)
+
+	checkReads := func(database *LSM) {
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key%04d", i)
+			entry, err := database.Get(key)
+			value, ok := expected[key]
+			if !ok {
+				if err == nil {
+					t.Fatalf("expected key %s to be deleted, got: %s", key, entry.Value)
+				}
+				continue
+			}
+			if err != nil || string(entry.Value) != value {
+				t.Fatalf("expected %s for key %s, got: %s, %v", value, key, entry.Value, err)
+			}
+		}
+		entries, err := database.Scan("", "", 0)
+		if err != nil {
+			t.Fatalf("Failed to scan: %v", err)
+		}
+		if len(entries) != len(expected) {
+			t.Fatalf("expected %d entries from scan, got: %d", len(expected), len(entries))
+		}
+	}
+	checkReads(database)
+	if err := database.LastCompactionError(); err != nil {
+		t.Fatalf("expected no compaction error, got: %v", err)
+	}
+
+	// The levels are restored from the manifest
+	levels := fmt.Sprint(database.levels)
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	reopened := newLeveledTestDb(t, dataDir, logger)
+	defer reopened.Close()
+	reopened.compactionMu.Lock()
+	restored := fmt.Sprint(reopened.levels)
+	reopened.compactionMu.Unlock()
+	if restored != levels {
+		t.Fatalf("expected levels %s after reopening, got: %s", levels, restored)
+	}
+	checkReads(reopened)
+}
+
+func TestBackgroundLeveledCompaction(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testBackgroundLeveledCompaction")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	database := newLeveledTestDb(t, dataDir, logger)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		if err := database.Put(Entry{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+
+	// No compaction is run here; the background compactor drains L0
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		database.mu.RLock()
+		drained := len(database.Sstables) < database.compactionMinThreshold && len(database.levels) > 0
+		database.mu.RUnlock()
+		if drained {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the background compactor to drain L0")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkLevelsDoNotOverlap(t, database)
+
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	select {
+	case <-database.leveling.done:
+	default:
+		t.Fatalf("expected Close to stop the background compactor")
+	}
+	if err := database.LastCompactionError(); err != nil {
+		t.Fatalf("expected no compaction error, got: %v", err)
+	}
+}
+
+func TestCompactLevel(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testCompactLevel")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	// No level ever reaches its target, so the background compactor stays idle
+	database, err := NewDb(Options{
+		MemtableThreshold:      100,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+		CompactionMinThreshold: 1000,
+		LeveledCompaction:      true,
+		TargetFileSize:         1 << 10,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	putRange := func(from, to int, round string) {
+		for i := from; i < to; i++ {
+			key := fmt.Sprintf("key%04d", i)
+			if err := database.Put(Entry{Key: key, Value: []byte(key + round)}); err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+		}
+		if err := database.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	checkReads := func(to int, value func(i int) string) {
+		for i := 0; i < to; i++ {
+			key := fmt.Sprintf("key%04d", i)
+			entry, err := database.Get(key)
+			if err != nil || string(entry.Value) != value(i) {
+				t.Fatalf("expected %s for key %s, got: %s, %v", value(i), key, entry.Value, err)
+			}
+		}
+	}
+
+	putRange(0, 500, "a")
+	if err := database.compactLevel(0); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	if len(database.Sstables) != 0 || len(database.levels) != 1 || len(database.levels[0]) < 2 {
+		t.Fatalf("expected L0 to be merged into several L1 tables, got: %v %v", database.Sstables, database.levels)
+	}
+	checkLevelsDoNotOverlap(t, database)
+
+	// Newer tables overlapping part of L1 are merged with just that part
+	putRange(200, 300, "b")
+	untouched := database.levels[0][0]
+	if err := database.compactLevel(0); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	if database.levels[0][0] != untouched {
+		t.Fatalf("expected the first L1 table %s to be kept, got: %v", untouched, database.levels[0])
+	}
+	checkLevelsDoNotOverlap(t, database)
+
+	l1 := len(database.levels[0])
+	if err := database.compactLevel(1); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	if len(database.levels) != 2 || len(database.levels[0]) != l1-1 || len(database.levels[1]) != 1 {
+		t.Fatalf("expected one L1 table to move to L2, got: %v", database.levels)
+	}
+	checkLevelsDoNotOverlap(t, database)
+
+	checkReads(500, func(i int) string {
+		if i >= 200 && i < 300 {
+			return fmt.Sprintf("key%04db", i)
+		}
+		return fmt.Sprintf("key%04da", i)
+	})
+
+	// Empty levels are left alone, and levels past the last cannot be compacted
+	if err := database.compactLevel(3); err != nil {
+		t.Fatalf("expected compacting an empty level to do nothing, got: %v", err)
+	}
+	if err := database.compactLevel(maxLevels - 1); err == nil {
+		t.Fatalf("expected an error compacting the last level")
+	}
+}
+
+func TestPickLevel(t *testing.T) {
+	database := &LSM{
+		sstableMgr:             &MockSSTableManager{},
+		compactionMinThreshold: 4,
+		leveling:               newLeveling(Options{LevelBaseSize: 10, LevelSizeMultiplier: 10}),
+	}
+	defer func(saved []Entry) { sstablemockstore = saved }(sstablemockstore)
+	sstablemockstore = make([]Entry, 5) // every table is 5 bytes
+
+	tests := []struct {
+		l0     []string
+		levels [][]string
+		want   int
+	}{
+		{[]string{"a", "b", "c"}, nil, -1},
+		{[]string{"a", "b", "c", "d"}, nil, 0},
+		// L1 holds 15 of its 10 bytes
+		{[]string{"a"}, [][]string{{"b", "c", "d"}}, 1},
+		// L2 is further over its target of 100 bytes than L0 over its count
+		{[]string{"a", "b", "c", "d"}, [][]string{{"e"}, make([]string, 30)}, 2},
+	}
+	for _, test := range tests {
+		got, err := database.pickLevel(test.l0, test.levels)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != test.want {
+			t.Errorf("pickLevel(%v, %v) = %d, expected %d", test.l0, test.levels, got, test.want)
+		}
+	}
+}
+
+func TestSSTables(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testSSTables")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	database := newLeveledTestDb(t, dataDir, logger)
+
+	random := rand.New(rand.NewSource(1))
+	const keys = 1000
+	for i := 0; i < keys; i++ {
+		value := make([]byte, 64)
+		random.Read(value)
+		if err := database.Put(Entry{Key: fmt.Sprintf("key%04d", i), Value: value}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	compactAllLevels(t, database)
+	// One more table left in L0
+	if err := database.Put(Entry{Key: "key0000", Value: []byte("new")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	infos, err := database.SSTables()
+	if err != nil {
+		t.Fatalf("Failed to describe sstables: %v", err)
+	}
+	database.mu.RLock()
+	levels := append([][]string{database.Sstables}, database.levels...)
+	database.mu.RUnlock()
+	if len(levels) < 3 {
+		t.Fatalf("expected tables in L0 and several levels, got %v", levels)
+	}
+
+	var entries uint64
+	i := 0
+	for level, fileNames := range levels {
+		for _, fileName := range fileNames {
+			if i >= len(infos) {
+				t.Fatalf("expected %s in the descriptions, got only %d", fileName, len(infos))
+			}
+			info := infos[i]
+			if info.FileName != fileName || info.Level != level {
+				t.Errorf("expected %s in level %d, got %s in level %d", fileName, level, info.FileName, info.Level)
+			}
+			entries += info.EntryCount
+			i++
+		}
+	}
+	if i != len(infos) {
+		t.Errorf("expected %d descriptions, got %d", i, len(infos))
+	}
+	if entries != keys+1 {
+		t.Errorf("expected %d entries across the tables, got %d", keys+1, entries)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if _, err := database.SSTables(); err != ErrDBClosed {
+		t.Errorf("expected %v after close, got %v", ErrDBClosed, err)
+	}
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LockFileName is the file in the data directory that a writer keeps locked.
+const LockFileName = "LOCK"
+
+// ErrDataDirLocked is returned by NewDb, wrapped with the lock file, when
+// another database holds the data directory, in this process or another.
+var ErrDataDirLocked = errors.New("data directory is locked by another database")
+
+// dataDirLocker is implemented by SSTable managers whose files another process
+// could write to, such as SSTableFileSystemManager.
+type dataDirLocker interface {
+	LockDataDir() (func() error, error)
+}
+
+// LockDataDir takes an exclusive lock on the LockFileName file in DataDir and
+// returns a function releasing it, or ErrDataDirLocked if it is already held.
+// The operating system drops the lock when the process exits, so a crashed
+// writer leaves none behind. A ReadOnly manager takes no lock, since it is
+// meant to share the directory with a writer.
+func (ssm SSTableFileSystemManager) LockDataDir() (func() error, error) {
+	if ssm.ReadOnly {
+		return func() error { return nil }, nil
+	}
+	path := filepath.Join(ssm.DataDir, LockFileName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	ssm.logger().Debugf("Locked data directory %s", ssm.DataDir)
+	// Closing the file releases the lock
+	return file.Close, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataDirLock(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testDataDirLock")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	open := func(readOnly bool) (*LSM, error) {
+		ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, ReadOnly: readOnly})
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		return NewDb(Options{SstableMgr: ssm, Logger: logger, ReadOnly: readOnly})
+	}
+
+	first, err := open(false)
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	if err := first.Put(Entry{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, LockFileName)); err != nil {
+		t.Errorf("expected a lock file, got: %v", err)
+	}
+
+	if second, err := open(false); !errors.Is(err, ErrDataDirLocked) {
+		if second != nil {
+			second.Close()
+		}
+		t.Fatalf("expected %v while the first database is open, got: %v", ErrDataDirLocked, err)
+	}
+	// Readers share the directory with the writer
+	reader, err := open(true)
+	if err != nil {
+		t.Fatalf("expected a read-only database to open, got: %v", err)
+	}
+	reader.Close()
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	second, err := open(false)
+	if err != nil {
+		t.Fatalf("expected the database to open once the first is closed, got: %v", err)
+	}
+	defer second.Close()
+	if entry, err := second.Get("key"); err != nil || string(entry.Value) != "value" {
+		t.Errorf("expected value, got %q, %v", entry.Value, err)
+	}
+}
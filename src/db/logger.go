@@ -0,0 +1,95 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log message. The values leave room between the
+// levels like those of log/slog.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// ParseLevel returns the level named s, in any case: debug, info, warn or
+// error.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger writes leveled log messages. Per operation details go to Debug,
+// lifecycle events such as flushes and compactions to Info, and failures to
+// Warn or Error.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// NewLogger adapts l to a Logger that drops messages below level and prefixes
+// the others with their level. A nil l drops every message.
+func NewLogger(l *log.Logger, level Level) Logger {
+	return stdLogger{logger: l, level: level}
+}
+
+// stdLogger is the Logger returned by NewLogger. Messages below level are
+// dropped before they are formatted.
+type stdLogger struct {
+	logger *log.Logger
+	level  Level
+}
+
+func (s stdLogger) Debugf(format string, args ...interface{}) {
+	s.logf(LevelDebug, format, args)
+}
+
+func (s stdLogger) Infof(format string, args ...interface{}) {
+	s.logf(LevelInfo, format, args)
+}
+
+func (s stdLogger) Warnf(format string, args ...interface{}) {
+	s.logf(LevelWarn, format, args)
+}
+
+func (s stdLogger) Errorf(format string, args ...interface{}) {
+	s.logf(LevelError, format, args)
+}
+
+func (s stdLogger) logf(level Level, format string, args []interface{}) {
+	if s.logger == nil || level < s.level {
+		return
+	}
+	// Skip logf and the level method so Lshortfile reports the caller
+	s.logger.Output(3, level.String()+" "+fmt.Sprintf(format, args...))
+}
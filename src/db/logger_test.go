@@ -0,0 +1,43 @@
+package db
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(log.New(&buf, "", log.Lshortfile), LevelInfo)
+
+	logger.Debugf("debug %d", 1)
+	logger.Infof("info %d", 2)
+	logger.Warnf("warn %d", 3)
+	logger.Errorf("error %d", 4)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected the debug message to be dropped, got %q", buf.String())
+	}
+	for i, want := range []string{"INFO info 2", "WARN warn 3", "ERROR error 4"} {
+		if !strings.HasPrefix(lines[i], "logger_test.go:") || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("expected line %d to be %q from logger_test.go, got %q", i, want, lines[i])
+		}
+	}
+
+	// A nil logger drops everything instead of panicking
+	NewLogger(nil, LevelDebug).Errorf("dropped")
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"debug": LevelDebug, "INFO": LevelInfo, "warning": LevelWarn, "Error": LevelError} {
+		level, err := ParseLevel(s)
+		if err != nil || level != want {
+			t.Errorf("expected %s to parse as %s, got %s, %v", s, want, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("expected an error parsing an unknown level")
+	}
+}
@@ -0,0 +1,429 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InMemorySSTableManager is an SSTableManager that keeps every table in
+// memory instead of in a data directory, for tests and for embedding the LSM
+// where nothing should touch the filesystem. Blocks are held as encoded
+// records in the current block format, uncompressed, so reads decode them
+// just as they would from disk and callers never share buffers with a table.
+// Tables, and the manifest, last as long as the manager, so an LSM opened
+// again on the same manager recovers them.
+type InMemorySSTableManager struct {
+	Logger *log.Logger
+	// LogLevel is the lowest level of the messages written to Logger. The
+	// zero value is LevelInfo.
+	LogLevel Level
+	// BloomFalsePositiveRate is the target false positive rate of the Bloom
+	// filter kept for each table. Zero means DefaultBloomFalsePositiveRate.
+	BloomFalsePositiveRate float64
+	// BlockSize is the encoded size in bytes that blocks are filled up to.
+	// Zero means DefaultBlockSize.
+	BlockSize int
+	// BlockEntries, if positive, also caps the number of entries in a block.
+	BlockEntries int
+
+	mu          sync.RWMutex
+	tables      map[string]*memoryTable
+	manifest    [][]string
+	hasManifest bool
+}
+
+// memoryTable is one table of an InMemorySSTableManager.
+type memoryTable struct {
+	blocks  []memoryBlock
+	filter  *bloomFilter
+	info    TableInfo
+	size    int64
+	entries int
+	created time.Time
+}
+
+// memoryBlock is an encoded block along with the key range it holds. Offsets
+// grow with the encoded size of the blocks before it, like file offsets.
+type memoryBlock struct {
+	offset   uint64
+	firstKey string
+	lastKey  string
+	entries  int
+	data     []byte
+}
+
+func NewInMemoryManager(logger *log.Logger) SSTableManager {
+	return &InMemorySSTableManager{
+		Logger: logger,
+		tables: make(map[string]*memoryTable),
+	}
+}
+
+// logger wraps Logger to honor LogLevel. It returns the concrete type so the
+// wrapper needs no allocation.
+func (mm *InMemorySSTableManager) logger() stdLogger {
+	return stdLogger{logger: mm.Logger, level: mm.LogLevel}
+}
+
+func (mm *InMemorySSTableManager) Write(fileName string, data []Entry) error {
+	less := func(i, j int) bool {
+		return data[i].Key < data[j].Key
+	}
+	if !sort.SliceIsSorted(data, less) {
+		sort.Slice(data, less)
+	}
+	return mm.WriteFromIterator(fileName, &sliceIterator{entries: data}, len(data))
+}
+
+// WriteFromIterator encodes the entries yielded by it, which must come in key
+// order, as the table fileName. Like the file manager it replaces an existing
+// table only once the new one is complete.
+func (mm *InMemorySSTableManager) WriteFromIterator(fileName string, it EntryIterator, count int) error {
+	blockSize := mm.BlockSize
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	table := &memoryTable{filter: newBloomFilter(count, mm.BloomFalsePositiveRate), created: time.Now()}
+	var blockEntries []Entry
+	var blockBytes int
+	writeBlock := func() error {
+		var encoded bytes.Buffer
+		if err := writeBlockEntries(&encoded, blockEntries); err != nil {
+			return fmt.Errorf("failed to write block: %w", err)
+		}
+		table.blocks = append(table.blocks, memoryBlock{
+			offset:   uint64(table.size),
+			firstKey: blockEntries[0].Key,
+			lastKey:  blockEntries[len(blockEntries)-1].Key,
+			entries:  len(blockEntries),
+			data:     encoded.Bytes(),
+		})
+		table.size += int64(encoded.Len())
+		blockEntries = blockEntries[:0]
+		blockBytes = 0
+		return nil
+	}
+
+	var entryCount int
+	var lastKey string
+	for ; it.Valid(); it.Next() {
+		item := it.Entry()
+		if entryCount > 0 && item.Key < lastKey {
+			return fmt.Errorf("failed to write block: key %q out of order after %q", item.Key, lastKey)
+		}
+		if entryCount == 0 {
+			table.info.MinKey = item.Key
+			table.info.MinSequence = item.SequenceNumber
+		}
+		if item.SequenceNumber < table.info.MinSequence {
+			table.info.MinSequence = item.SequenceNumber
+		}
+		if item.SequenceNumber > table.info.MaxSequence {
+			table.info.MaxSequence = item.SequenceNumber
+		}
+		table.info.MaxKey = item.Key
+		entryCount++
+		lastKey = item.Key
+		table.filter.add(item.Key)
+
+		size := encodedEntrySize(item)
+		if len(blockEntries) > 0 && blockBytes+size > blockSize {
+			if err := writeBlock(); err != nil {
+				return err
+			}
+		}
+		blockEntries = append(blockEntries, item)
+		blockBytes += size
+
+		if len(blockEntries) == mm.BlockEntries {
+			if err := writeBlock(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(blockEntries) > 0 {
+		if err := writeBlock(); err != nil {
+			return err
+		}
+	}
+	table.size += int64(len(table.filter.bits))
+	table.entries = entryCount
+
+	mm.mu.Lock()
+	mm.tables[fileName] = table
+	mm.mu.Unlock()
+	mm.logger().Debugf("Successfully wrote in-memory SSTable: %s with %d entries", fileName, entryCount)
+	return nil
+}
+
+// table returns the table fileName, or an error wrapping os.ErrNotExist like
+// opening a missing file would.
+func (mm *InMemorySSTableManager) table(fileName string) (*memoryTable, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	table, ok := mm.tables[fileName]
+	if !ok {
+		err := fmt.Errorf("open %s: %w", fileName, os.ErrNotExist)
+		mm.logger().Errorf("Error opening in-memory SSTable %s: %v", fileName, err)
+		return nil, err
+	}
+	return table, nil
+}
+
+func (mm *InMemorySSTableManager) ReadAll(fileName string) ([]Entry, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var results []Entry
+	for _, block := range table.blocks {
+		entries, err := decodeBlock(block.data, SSTableVersion)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, entries...)
+	}
+	return results, nil
+}
+
+func (mm *InMemorySSTableManager) ReadBlock(fileName string, offset uint64) ([]Entry, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(table.blocks), func(i int) bool {
+		return table.blocks[i].offset >= offset
+	})
+	if i == len(table.blocks) || table.blocks[i].offset != offset {
+		return nil, fmt.Errorf("failed to read block: no block at offset %d", offset)
+	}
+	return decodeBlock(table.blocks[i].data, SSTableVersion)
+}
+
+func (mm *InMemorySSTableManager) FindKey(fileName string, searchKey string) (Entry, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return Entry{}, err
+	}
+	if !table.filter.mayContain(searchKey) {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, searchKey)
+	}
+
+	// Only the first block that ends at or after searchKey can hold it
+	blockIdx := sort.Search(len(table.blocks), func(i int) bool {
+		return table.blocks[i].lastKey >= searchKey
+	})
+	if blockIdx == len(table.blocks) || table.blocks[blockIdx].firstKey > searchKey {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, searchKey)
+	}
+	entries, err := decodeBlock(table.blocks[blockIdx].data, SSTableVersion)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to read block: %w", err)
+	}
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Key >= searchKey
+	})
+	if i == len(entries) || entries[i].Key != searchKey {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, searchKey)
+	}
+	return entries[i], nil
+}
+
+func (mm *InMemorySSTableManager) MayContain(fileName string, key string) (bool, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return false, err
+	}
+	return table.filter.mayContain(key), nil
+}
+
+// Scan returns the entries of fileName with startKey <= key < endKey in key
+// order, tombstones included. An empty endKey scans to the end of the table.
+func (mm *InMemorySSTableManager) Scan(fileName string, startKey string, endKey string) ([]Entry, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Entry{}
+	first := sort.Search(len(table.blocks), func(i int) bool {
+		return table.blocks[i].lastKey >= startKey
+	})
+	for _, block := range table.blocks[first:] {
+		if endKey != "" && block.firstKey >= endKey {
+			break
+		}
+		entries, err := decodeBlock(block.data, SSTableVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Key < startKey || (endKey != "" && entry.Key >= endKey) {
+				continue
+			}
+			results = append(results, entry)
+		}
+	}
+	return results, nil
+}
+
+// Blocks returns the key range and offset of every block of fileName, in key
+// order.
+func (mm *InMemorySSTableManager) Blocks(fileName string) ([]IndexEntry, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]IndexEntry, 0, len(table.blocks))
+	for _, block := range table.blocks {
+		blocks = append(blocks, IndexEntry{
+			StartKeyLength: int32(len(block.firstKey)),
+			StartKey:       block.firstKey,
+			EndKeyLength:   int32(len(block.lastKey)),
+			EndKey:         block.lastKey,
+			BlockOffset:    block.offset,
+		})
+	}
+	return blocks, nil
+}
+
+func (mm *InMemorySSTableManager) Delete(fileName string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if _, ok := mm.tables[fileName]; !ok {
+		err := fmt.Errorf("remove %s: %w", fileName, os.ErrNotExist)
+		mm.logger().Errorf("Error deleting in-memory SSTable %s: %v", fileName, err)
+		return err
+	}
+	delete(mm.tables, fileName)
+	mm.logger().Infof("Deleted in-memory SSTable: %s", fileName)
+	return nil
+}
+
+// Size returns the bytes held by the encoded blocks and the Bloom filter of
+// fileName.
+func (mm *InMemorySSTableManager) Size(fileName string) (int64, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return 0, err
+	}
+	return table.size, nil
+}
+
+func (mm *InMemorySSTableManager) ListFiles() ([]string, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	fileNames := make([]string, 0, len(mm.tables))
+	for fileName := range mm.tables {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+func (mm *InMemorySSTableManager) WriteManifest(levels [][]string) error {
+	manifest := make([][]string, len(levels))
+	for i := range levels {
+		manifest[i] = append([]string{}, levels[i]...)
+	}
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.manifest = manifest
+	mm.hasManifest = true
+	return nil
+}
+
+// ReadManifest returns the levels recorded by WriteManifest. Before the first
+// WriteManifest every table is recovered into L0, ordered by the id in its
+// name, like a data directory without a manifest.
+func (mm *InMemorySSTableManager) ReadManifest() ([][]string, error) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+	if !mm.hasManifest {
+		recovered := []string{}
+		for fileName := range mm.tables {
+			if _, ok := sstableID(fileName); ok {
+				recovered = append(recovered, fileName)
+			}
+		}
+		sort.Slice(recovered, func(i, j int) bool {
+			a, _ := sstableID(recovered[i])
+			b, _ := sstableID(recovered[j])
+			return a < b
+		})
+		return [][]string{recovered}, nil
+	}
+
+	levels := make([][]string, len(mm.manifest))
+	for i := range mm.manifest {
+		levels[i] = append([]string{}, mm.manifest[i]...)
+	}
+	if len(levels) == 0 {
+		levels = [][]string{{}}
+	}
+	return levels, nil
+}
+
+func (mm *InMemorySSTableManager) TableInfo(fileName string) (TableInfo, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return TableInfo{}, err
+	}
+	return table.info, nil
+}
+
+// Verify decodes every block of fileName and checks that its records are in
+// key order. Tables held in memory are never partially written, so this only
+// finds problems in the encoding itself.
+// Describe returns the SSTableInfo of fileName. Blocks are kept uncompressed
+// and the version is the one the encoding of their records follows.
+func (mm *InMemorySSTableManager) Describe(fileName string) (SSTableInfo, error) {
+	table, err := mm.table(fileName)
+	if err != nil {
+		return SSTableInfo{}, err
+	}
+	return SSTableInfo{
+		FileName:    fileName,
+		Version:     SSTableVersion,
+		EntryCount:  uint64(table.entries),
+		MinKey:      table.info.MinKey,
+		MaxKey:      table.info.MaxKey,
+		Size:        table.size,
+		CreatedAt:   table.created,
+		Compression: CompressionNone,
+	}, nil
+}
+
+func (mm *InMemorySSTableManager) Verify(fileName string) (VerifyReport, error) {
+	report := VerifyReport{FileName: fileName, Version: SSTableVersion}
+	table, err := mm.table(fileName)
+	if err != nil {
+		return report, err
+	}
+	for _, block := range table.blocks {
+		blockReport := BlockReport{Offset: block.offset, FirstKey: block.firstKey, LastKey: block.lastKey}
+		entries, err := decodeBlock(block.data, SSTableVersion)
+		if err == nil && !sort.SliceIsSorted(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key }) {
+			err = corruptionf("block", int64(block.offset), "records out of order in block at offset %d", block.offset)
+		}
+		if err != nil {
+			blockReport.Err = err
+			report.CorruptBlocks++
+		}
+		blockReport.Entries = len(entries)
+		report.Entries += len(entries)
+		report.Blocks = append(report.Blocks, blockReport)
+	}
+	return report, nil
+}
+
+// RepairTruncate only verifies fileName: an in-memory table is replaced as a
+// whole, so there is never a cut short table to repair.
+func (mm *InMemorySSTableManager) RepairTruncate(fileName string) (VerifyReport, error) {
+	return mm.Verify(fileName)
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestInMemoryManagerReadWrite(t *testing.T) {
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	mgr := NewInMemoryManager(logger).(*InMemorySSTableManager)
+	mgr.BlockEntries = 10
+
+	data := make([]Entry, 35)
+	for i := range data {
+		data[len(data)-1-i] = Entry{Key: fmt.Sprintf("key%02d", i), Value: []byte(fmt.Sprintf("value%02d", i)), SequenceNumber: uint64(i + 1)}
+	}
+	data[31].Tombstone, data[31].Value = true, nil // key03
+	if err := mgr.Write("table.sst", data); err != nil {
+		t.Fatalf("error writing table: %s", err)
+	}
+	// Write sorts its input in place, so data is now in key order too
+	data[0].Value[0] = 'X'
+
+	entries, err := mgr.ReadAll("table.sst")
+	if err != nil {
+		t.Fatalf("error reading table: %s", err)
+	}
+	if len(entries) != 35 || entries[0].Key != "key00" || string(entries[0].Value) != "value00" {
+		t.Fatalf("expected 35 entries from key00 with its original value, got %d starting %+v", len(entries), entries[0])
+	}
+	if !entries[3].Tombstone {
+		t.Errorf("expected key03 to be a tombstone")
+	}
+
+	entry, err := mgr.FindKey("table.sst", "key27")
+	if err != nil || string(entry.Value) != "value27" || entry.SequenceNumber != 28 {
+		t.Errorf("expected key27 with value27 and sequence 28, got %+v, %v", entry, err)
+	}
+	if _, err := mgr.FindKey("table.sst", "key99"); err == nil {
+		t.Errorf("expected an error finding a missing key")
+	}
+	if mayContain, err := mgr.MayContain("table.sst", "key05"); err != nil || !mayContain {
+		t.Errorf("expected the filter to hold key05, got %v, %v", mayContain, err)
+	}
+
+	scanned, err := mgr.Scan("table.sst", "key08", "key22")
+	if err != nil {
+		t.Fatalf("error scanning table: %s", err)
+	}
+	if len(scanned) != 14 || scanned[0].Key != "key08" || scanned[13].Key != "key21" {
+		t.Errorf("expected key08 through key21, got %d entries", len(scanned))
+	}
+
+	block, err := mgr.ReadBlock("table.sst", 0)
+	if err != nil || len(block) != 10 {
+		t.Errorf("expected a first block of 10 entries, got %d, %v", len(block), err)
+	}
+	info, err := mgr.TableInfo("table.sst")
+	if err != nil || info != (TableInfo{MinKey: "key00", MaxKey: "key34", MinSequence: 1, MaxSequence: 35}) {
+		t.Errorf("unexpected table info %+v, %v", info, err)
+	}
+	report, err := mgr.Verify("table.sst")
+	if err != nil || !report.OK() || len(report.Blocks) != 4 || report.Entries != 35 {
+		t.Errorf("expected a clean report of 4 blocks and 35 entries, got %v, %v", report, err)
+	}
+	if size, err := mgr.Size("table.sst"); err != nil || size <= 0 {
+		t.Errorf("expected a positive size, got %d, %v", size, err)
+	}
+
+	if err := mgr.Delete("table.sst"); err != nil {
+		t.Fatalf("error deleting table: %s", err)
+	}
+	if _, err := mgr.ReadAll("table.sst"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist reading a deleted table, got %v", err)
+	}
+	if fileNames, _ := mgr.ListFiles(); len(fileNames) != 0 {
+		t.Errorf("expected no tables left, got %v", fileNames)
+	}
+}
+
+func TestLSMWithInMemoryManager(t *testing.T) {
+	for _, leveled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("leveled=%v", leveled), func(t *testing.T) {
+			logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+			mgr := NewInMemoryManager(logger)
+			options := Options{
+				MemtableThreshold:      50,
+				CompactionMinThreshold: 2,
+				LeveledCompaction:      leveled,
+				LevelBaseSize:          2048,
+				TargetFileSize:         1024,
+				SstableMgr:             mgr,
+				Logger:                 logger,
+			}
+			database, err := NewDb(options)
+			if err != nil {
+				t.Fatalf("error creating db: %v", err)
+			}
+
+			for i := 0; i < 300; i++ {
+				err := database.Put(Entry{Key: fmt.Sprintf("key%03d", i), Value: []byte(fmt.Sprintf("value%d", i))})
+				if err != nil {
+					t.Fatalf("Failed to put entry: %v", err)
+				}
+			}
+			for i := 0; i < 300; i += 3 {
+				if err := database.Delete(fmt.Sprintf("key%03d", i)); err != nil {
+					t.Fatalf("Failed to delete entry: %v", err)
+				}
+			}
+			if err := database.Flush(); err != nil {
+				t.Fatalf("Failed to flush: %v", err)
+			}
+			for {
+				before := database.Stats().Compactions
+				if err := database.Compact(); err != nil {
+					t.Fatalf("Failed to compact: %v", err)
+				}
+				if database.Stats().Compactions == before {
+					break
+				}
+			}
+			if database.Stats().Compactions == 0 {
+				t.Fatalf("expected the tables to be compacted")
+			}
+
+			check := func(database *LSM) {
+				t.Helper()
+				for i := 0; i < 300; i++ {
+					key := fmt.Sprintf("key%03d", i)
+					entry, err := database.Get(key)
+					if i%3 == 0 {
+						if err == nil {
+							t.Fatalf("expected %s to be deleted", key)
+						}
+						continue
+					}
+					if err != nil || string(entry.Value) != fmt.Sprintf("value%d", i) {
+						t.Fatalf("expected value%d for %s, got %+v, %v", i, key, entry, err)
+					}
+				}
+				entries, err := database.Scan("key100", "key110", 0)
+				if err != nil {
+					t.Fatalf("Failed to scan: %v", err)
+				}
+				if len(entries) != 7 {
+					t.Fatalf("expected 7 live keys in [key100, key110), got %d", len(entries))
+				}
+			}
+			check(database)
+			if err := database.Close(); err != nil {
+				t.Fatalf("Failed to close: %v", err)
+			}
+
+			// The tables and the manifest outlive the LSM
+			reopened, err := NewDb(options)
+			if err != nil {
+				t.Fatalf("error reopening db: %v", err)
+			}
+			defer reopened.Close()
+			check(reopened)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package db
+
+import "math/rand"
+
+const (
+	memtableMaxLevel = 16
+	// memtableLevelP is the chance a node is promoted to the next level
+	memtableLevelP = 0.25
+	// memtableEntryOverhead approximates the bytes a memtable spends on each
+	// entry besides its key and value: the Entry, the node and its links.
+	memtableEntryOverhead = 96
+)
+
+// Memtable holds the most recent writes in key order. It is a skip list, so
+// flushes emit entries already sorted and scans can start at any key. A
+// Memtable is not safe for concurrent use; the LSM guards it with its mutex.
+type Memtable struct {
+	head   *memtableNode
+	level  int
+	length int
+	size   int64
+}
+
+type memtableNode struct {
+	entry Entry
+	next  []*memtableNode
+}
+
+func NewMemtable() *Memtable {
+	return &Memtable{
+		head:  &memtableNode{next: make([]*memtableNode, memtableMaxLevel)},
+		level: 1,
+	}
+}
+
+// Put inserts entry, replacing any entry with the same key.
+func (m *Memtable) Put(entry Entry) {
+	var update [memtableMaxLevel]*memtableNode
+	node := m.findPredecessors(entry.Key, &update)
+	if node != nil && node.entry.Key == entry.Key {
+		m.size += int64(len(entry.Value) - len(node.entry.Value))
+		node.entry = entry
+		return
+	}
+
+	level := randomMemtableLevel()
+	if level > m.level {
+		for i := m.level; i < level; i++ {
+			update[i] = m.head
+		}
+		m.level = level
+	}
+	node = &memtableNode{entry: entry, next: make([]*memtableNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	m.length++
+	m.size += int64(len(entry.Key)+len(entry.Value)) + memtableEntryOverhead
+}
+
+// Get returns the entry stored for key, which may be a tombstone.
+func (m *Memtable) Get(key string) (Entry, bool) {
+	node := m.seek(key)
+	if node == nil || node.entry.Key != key {
+		return Entry{}, false
+	}
+	return node.entry, true
+}
+
+// Delete removes key from the memtable. It does not write a tombstone; callers
+// that need to shadow older SSTables should Put one instead.
+func (m *Memtable) Delete(key string) {
+	var update [memtableMaxLevel]*memtableNode
+	node := m.findPredecessors(key, &update)
+	if node == nil || node.entry.Key != key {
+		return
+	}
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+	for m.level > 1 && m.head.next[m.level-1] == nil {
+		m.level--
+	}
+	m.length--
+	m.size -= int64(len(node.entry.Key)+len(node.entry.Value)) + memtableEntryOverhead
+}
+
+// Len returns the number of keys in the memtable.
+func (m *Memtable) Len() int {
+	return m.length
+}
+
+// Size returns the approximate number of bytes the memtable holds: its keys
+// and values plus a fixed overhead per entry.
+func (m *Memtable) Size() int64 {
+	return m.size
+}
+
+// Iterator returns an iterator positioned at the smallest key.
+func (m *Memtable) Iterator() *MemtableIterator {
+	return &MemtableIterator{memtable: m, node: m.head.next[0]}
+}
+
+// Entries returns every entry in key order.
+func (m *Memtable) Entries() []Entry {
+	entries := make([]Entry, 0, m.length)
+	for it := m.Iterator(); it.Valid(); it.Next() {
+		entries = append(entries, it.Entry())
+	}
+	return entries
+}
+
+// findPredecessors records in update the last node before key on every level
+// and returns the first node whose key is >= key.
+func (m *Memtable) findPredecessors(key string, update *[memtableMaxLevel]*memtableNode) *memtableNode {
+	node := m.head
+	for i := m.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].entry.Key < key {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	return node.next[0]
+}
+
+// seek returns the first node whose key is >= key.
+func (m *Memtable) seek(key string) *memtableNode {
+	node := m.head
+	for i := m.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].entry.Key < key {
+			node = node.next[i]
+		}
+	}
+	return node.next[0]
+}
+
+func randomMemtableLevel() int {
+	level := 1
+	for level < memtableMaxLevel && rand.Float64() < memtableLevelP {
+		level++
+	}
+	return level
+}
+
+// MemtableIterator walks a Memtable in ascending key order. It is invalidated
+// by writes to the memtable.
+type MemtableIterator struct {
+	memtable *Memtable
+	node     *memtableNode
+}
+
+// Seek positions the iterator at the first key >= key.
+func (it *MemtableIterator) Seek(key string) {
+	it.node = it.memtable.seek(key)
+}
+
+// Valid reports whether the iterator is positioned at an entry.
+func (it *MemtableIterator) Valid() bool {
+	return it.node != nil
+}
+
+func (it *MemtableIterator) Next() {
+	it.node = it.node.next[0]
+}
+
+func (it *MemtableIterator) Entry() Entry {
+	return it.node.entry
+}
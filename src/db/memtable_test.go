@@ -0,0 +1,137 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestMemtablePutGetDelete(t *testing.T) {
+	memtable := NewMemtable()
+	memtable.Put(Entry{Key: "b", Value: []byte("1")})
+	memtable.Put(Entry{Key: "a", Value: []byte("2")})
+	memtable.Put(Entry{Key: "b", Value: []byte("3")})
+
+	if memtable.Len() != 2 {
+		t.Fatalf("expected %d, got: %d", 2, memtable.Len())
+	}
+	entry, exists := memtable.Get("b")
+	if !exists || string(entry.Value) != "3" {
+		t.Fatalf("expected value 3 for key b, got: %v", entry)
+	}
+	if _, exists := memtable.Get("c"); exists {
+		t.Fatalf("expected key c to be missing")
+	}
+
+	memtable.Delete("a")
+	memtable.Delete("c")
+	if memtable.Len() != 1 {
+		t.Fatalf("expected %d, got: %d", 1, memtable.Len())
+	}
+	if _, exists := memtable.Get("a"); exists {
+		t.Fatalf("expected key a to be deleted")
+	}
+}
+
+func TestMemtableIteratesInKeyOrder(t *testing.T) {
+	memtable := NewMemtable()
+	var keys []string
+	for _, i := range rand.Perm(500) {
+		key := fmt.Sprintf("key%03d", i)
+		keys = append(keys, key)
+		memtable.Put(Entry{Key: key, Value: []byte(key)})
+	}
+	sort.Strings(keys)
+
+	entries := memtable.Entries()
+	if len(entries) != len(keys) {
+		t.Fatalf("expected %d entries, got: %d", len(keys), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Key != keys[i] {
+			t.Fatalf("expected key %s at index %d, got: %s", keys[i], i, entry.Key)
+		}
+	}
+}
+
+func TestMemtableIteratorSeek(t *testing.T) {
+	memtable := NewMemtable()
+	for i := 0; i < 10; i += 2 {
+		memtable.Put(Entry{Key: fmt.Sprintf("key%d", i)})
+	}
+
+	it := memtable.Iterator()
+	it.Seek("key3")
+	var got []string
+	for ; it.Valid(); it.Next() {
+		got = append(got, it.Entry().Key)
+	}
+	expected := []string{"key4", "key6", "key8"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got: %v", expected, got)
+	}
+
+	it.Seek("key9")
+	if it.Valid() {
+		t.Fatalf("expected iterator to be exhausted, got key %s", it.Entry().Key)
+	}
+}
+
+// BenchmarkMemtableFlushOrder compares collecting a flush from the skip list,
+// which is already sorted, with collecting and sorting a map as flushes used to.
+func BenchmarkMemtableFlushOrder(b *testing.B) {
+	entries := make([]Entry, 10000)
+	for i, j := range rand.Perm(len(entries)) {
+		entries[i] = Entry{Key: fmt.Sprintf("key%05d", j), Value: []byte("value")}
+	}
+
+	b.Run("map", func(b *testing.B) {
+		memtable := make(map[string]Entry)
+		for _, entry := range entries {
+			memtable[entry.Key] = entry
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			data := []Entry{}
+			for _, entry := range memtable {
+				data = append(data, entry)
+			}
+			sort.Slice(data, func(i, j int) bool {
+				return data[i].Key < data[j].Key
+			})
+		}
+	})
+
+	b.Run("skiplist", func(b *testing.B) {
+		memtable := NewMemtable()
+		for _, entry := range entries {
+			memtable.Put(entry)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			memtable.Entries()
+		}
+	})
+}
+
+func TestMemtableSize(t *testing.T) {
+	memtable := NewMemtable()
+	memtable.Put(Entry{Key: "a", Value: make([]byte, 100)})
+	memtable.Put(Entry{Key: "bb", Value: make([]byte, 10)})
+	if expected := int64(113 + 2*memtableEntryOverhead); memtable.Size() != expected {
+		t.Fatalf("expected %d, got: %d", expected, memtable.Size())
+	}
+
+	// Replacing a value only changes the size by the difference
+	memtable.Put(Entry{Key: "a", Value: make([]byte, 50)})
+	if expected := int64(63 + 2*memtableEntryOverhead); memtable.Size() != expected {
+		t.Fatalf("expected %d, got: %d", expected, memtable.Size())
+	}
+
+	memtable.Delete("a")
+	memtable.Delete("bb")
+	if memtable.Size() != 0 {
+		t.Fatalf("expected an empty memtable to have size 0, got: %d", memtable.Size())
+	}
+}
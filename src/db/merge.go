@@ -0,0 +1,123 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MergeFunc combines a merge operand with the value it applies to and returns
+// the new value. existing is nil when the key has no live value. Operands of a
+// key may be combined with each other before the value they apply to is read,
+// by passing the older one as existing, so a MergeFunc must be associative:
+// merging a and then b into a value must give the same result as merging the
+// merge of a and b.
+type MergeFunc func(key string, existing []byte, operand []byte) []byte
+
+// AddInt64 is a MergeFunc for counters stored as decimal integers: it adds the
+// operand to the existing value. A missing value, or one that is not an
+// integer, counts as zero.
+func AddInt64(key string, existing []byte, operand []byte) []byte {
+	current, _ := strconv.ParseInt(string(existing), 10, 64)
+	delta, _ := strconv.ParseInt(string(operand), 10, 64)
+	return []byte(strconv.FormatInt(current+delta, 10))
+}
+
+// Merge writes operand as a merge record for key, which reads and compactions
+// combine with the value of key using Options.MergeFunc. Unlike a Get followed
+// by a Put or CompareAndSwap it does not read the value, so merges of the same
+// key never conflict. An operand written over a record in the active memtable
+// is combined with it straight away, since the memtable keeps one record per
+// key.
+func (db *LSM) Merge(key string, operand []byte) error {
+	if db.mergeFunc == nil {
+		return ErrNoMergeFunc
+	}
+	if err := db.checkSize(key, operand); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.counters.puts.Add(1)
+	entry := Entry{Key: key, Value: operand, Merge: true, SequenceNumber: db.nextSequence()}
+	if existing, exists := db.Memtable.Get(key); exists {
+		entry = db.merger(db.now()).combine(existing, entry)
+	}
+	db.Memtable.Put(entry)
+	db.logger.Debugf("Added merge operand for key: %s to memtable", key)
+	if db.memtableFull() {
+		db.freezeMemtable()
+	}
+	return nil
+}
+
+// merger combines merge records with the records of their key before them,
+// checking those for expiry against now.
+type merger struct {
+	fn  MergeFunc
+	now time.Time
+}
+
+func (db *LSM) merger(now time.Time) merger {
+	return merger{fn: db.mergeFunc, now: now}
+}
+
+// combine returns the record a read sees for a key whose newest records are
+// older followed by newer. An operand applied to a live value gives a value
+// with the same expiry, applied to a deleted or expired one a new value, and
+// applied to another operand an operand standing for both.
+func (m merger) combine(older Entry, newer Entry) Entry {
+	if !newer.Merge {
+		return newer
+	}
+	combined := Entry{Key: newer.Key, SequenceNumber: newer.SequenceNumber}
+	switch {
+	case older.Merge:
+		combined.Value = m.apply(newer.Key, older.Value, newer.Value)
+		combined.Merge = true
+	case older.Tombstone || older.expired(m.now):
+		combined.Value = m.apply(newer.Key, nil, newer.Value)
+	default:
+		combined.Value = m.apply(newer.Key, older.Value, newer.Value)
+		combined.ExpiresAt = older.ExpiresAt
+	}
+	return combined
+}
+
+// resolve turns an operand no older record is left for into the value it
+// gives on its own.
+func (m merger) resolve(entry Entry) Entry {
+	if !entry.Merge {
+		return entry
+	}
+	return Entry{Key: entry.Key, Value: m.apply(entry.Key, nil, entry.Value), SequenceNumber: entry.SequenceNumber}
+}
+
+// fold combines records of one key, ordered oldest first when their sequence
+// numbers are equal, into the one record a read sees. It sorts records.
+func (m merger) fold(records []Entry) Entry {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].SequenceNumber < records[j].SequenceNumber
+	})
+	folded := records[0]
+	for _, entry := range records[1:] {
+		folded = m.combine(folded, entry)
+	}
+	return folded
+}
+
+// apply runs the MergeFunc. Without one, as when operands are read by a
+// database opened without the MergeFunc they were written for, the newest
+// operand wins like a Put.
+func (m merger) apply(key string, existing []byte, operand []byte) []byte {
+	if m.fn == nil {
+		return operand
+	}
+	return m.fn(key, existing, operand)
+}
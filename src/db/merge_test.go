@@ -0,0 +1,269 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestAddInt64(t *testing.T) {
+	tests := []struct {
+		existing []byte
+		operand  string
+		expected string
+	}{
+		{existing: nil, operand: "5", expected: "5"},
+		{existing: []byte("10"), operand: "-3", expected: "7"},
+		{existing: []byte("not a number"), operand: "2", expected: "2"},
+	}
+	for _, test := range tests {
+		if result := string(AddInt64("key", test.existing, []byte(test.operand))); result != test.expected {
+			t.Errorf("expected %q + %s to be %s, got %s", test.existing, test.operand, test.expected, result)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testMerge")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	open := func() *LSM {
+		ssm, err := NewFileManager(dataDir, logger)
+		if err != nil {
+			t.Fatalf("error creating file manager: %s", err)
+		}
+		database, err := NewDb(Options{
+			MemtableThreshold: 1000,
+			SstableMgr:        ssm,
+			Logger:            logger,
+			MergeFunc:         AddInt64,
+		})
+		if err != nil {
+			t.Fatalf("error creating db: %v", err)
+		}
+		return database
+	}
+	database := open()
+	defer func() {
+		database.Close()
+	}()
+
+	incr := func(key string, by int) {
+		if err := database.Merge(key, []byte(strconv.Itoa(by))); err != nil {
+			t.Fatalf("Failed to merge %s: %v", key, err)
+		}
+	}
+	flush := func() {
+		if err := database.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	expected := map[string]string{"balance": "75", "hits": "10", "plain": "x", "reset": "2"}
+	checkReads := func() {
+		t.Helper()
+		for key, value := range expected {
+			if entry, err := database.Get(key); err != nil || string(entry.Value) != value {
+				t.Errorf("expected %s for %s, got %q, %v", value, key, entry.Value, err)
+			}
+		}
+
+		keys := []string{}
+		for key := range expected {
+			keys = append(keys, key)
+		}
+		found, err := database.GetMany(keys)
+		if err != nil {
+			t.Fatalf("Failed to get keys: %v", err)
+		}
+		entries, err := database.Scan("", "", 0)
+		if err != nil {
+			t.Fatalf("Failed to scan: %v", err)
+		}
+		scanned := map[string]Entry{}
+		for _, entry := range entries {
+			scanned[entry.Key] = entry
+		}
+		iterated := map[string]string{}
+		it, err := database.NewIterator("", "")
+		if err != nil {
+			t.Fatalf("Failed to create iterator: %v", err)
+		}
+		for it.Next() {
+			iterated[it.Key()] = string(it.Value())
+		}
+		it.Close()
+		snapshot, err := database.Snapshot()
+		if err != nil {
+			t.Fatalf("Failed to take snapshot: %v", err)
+		}
+		defer snapshot.Release()
+		for key, value := range expected {
+			if entry, ok := found[key]; !ok || string(entry.Value) != value {
+				t.Errorf("expected %s for %s from GetMany, got %q", value, key, entry.Value)
+			}
+			if entry, ok := scanned[key]; !ok || string(entry.Value) != value {
+				t.Errorf("expected %s for %s from Scan, got %q", value, key, entry.Value)
+			}
+			if iterated[key] != value {
+				t.Errorf("expected %s for %s from an iterator, got %q", value, key, iterated[key])
+			}
+			if entry, err := snapshot.Get(key); err != nil || string(entry.Value) != value {
+				t.Errorf("expected %s for %s from a snapshot, got %q, %v", value, key, entry.Value, err)
+			}
+		}
+		if len(scanned) != len(expected) || len(iterated) != len(expected) {
+			t.Errorf("expected %d keys, scanned %d and iterated %d", len(expected), len(scanned), len(iterated))
+		}
+	}
+
+	// Operands only, spread over three SSTables and the memtable
+	incr("hits", 1)
+	flush()
+	incr("hits", 2)
+	flush()
+	incr("hits", 1)
+	incr("hits", 2)
+	flush()
+	incr("hits", 4)
+	// Operands over a flushed value
+	if err := database.Put(Entry{Key: "balance", Value: []byte("100")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	flush()
+	incr("balance", -30)
+	incr("balance", 5)
+	// Operands over a deleted value start again from zero
+	incr("reset", 7)
+	flush()
+	if err := database.Delete("reset"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	flush()
+	incr("reset", 2)
+	// A value written over operands replaces them
+	incr("plain", 5)
+	flush()
+	if err := database.Put(Entry{Key: "plain", Value: []byte("x")}); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	checkReads()
+	flush()
+	checkReads()
+
+	// The oldest SSTable holds an operand of hits, so compacting the others
+	// has to keep the rest of them as one operand
+	database.compactionMu.Lock()
+	err = database.compactRange(1, len(database.Sstables))
+	database.compactionMu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if len(database.Sstables) != 2 {
+		t.Fatalf("expected 2 sstables, got: %v", database.Sstables)
+	}
+	if entry, err := database.sstableMgr.FindKey(database.Sstables[1], "hits"); err != nil || !entry.Merge || string(entry.Value) != "9" {
+		t.Errorf("expected a merge operand of 9 for hits, got %+v, %v", entry, err)
+	}
+	checkReads()
+
+	// With nothing older left every operand becomes a value
+	database.compactionMu.Lock()
+	err = database.compactRange(0, len(database.Sstables))
+	database.compactionMu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	entries, err := database.sstableMgr.ReadAll(database.Sstables[0])
+	if err != nil {
+		t.Fatalf("Failed to read sstable: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Merge {
+			t.Errorf("expected no merge operands after a full compaction, got: %+v", entry)
+		}
+	}
+	checkReads()
+
+	// Counters survive reopening
+	incr("hits", 5)
+	expected["hits"] = "15"
+	if err := database.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	database = open()
+	checkReads()
+
+	withoutMergeFunc, err := NewDb(Options{SstableMgr: NewInMemoryManager(logger), Logger: logger})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer withoutMergeFunc.Close()
+	if err := withoutMergeFunc.Merge("hits", []byte("1")); err != ErrNoMergeFunc {
+		t.Errorf("expected %v, got %v", ErrNoMergeFunc, err)
+	}
+}
+
+func TestMergeLeveledCompaction(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testMergeLeveledCompaction")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "COMPACTION_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold:      100,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+		CompactionMinThreshold: 2,
+		LeveledCompaction:      true,
+		LevelBaseSize:          1 << 10,
+		LevelSizeMultiplier:    2,
+		TargetFileSize:         1 << 10,
+		MergeFunc:              AddInt64,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	// Every flush adds one operand per counter, which compactions carry down
+	// the levels
+	for round := 1; round <= 10; round++ {
+		for i := 0; i < 50; i++ {
+			if err := database.Merge(fmt.Sprintf("counter%02d", i), []byte(strconv.Itoa(round))); err != nil {
+				t.Fatalf("Failed to merge: %v", err)
+			}
+		}
+		if err := database.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	compactAllLevels(t, database)
+	if len(database.levels) == 0 {
+		t.Fatalf("expected tables below L0")
+	}
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("counter%02d", i)
+		if entry, err := database.Get(key); err != nil || string(entry.Value) != "55" {
+			t.Errorf("expected 55 for %s, got %q, %v", key, entry.Value, err)
+		}
+	}
+	if err := database.LastCompactionError(); err != nil {
+		t.Fatalf("expected no compaction error, got: %v", err)
+	}
+}
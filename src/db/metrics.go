@@ -0,0 +1,33 @@
+package db
+
+import "time"
+
+// Metrics receives measurements from the LSM and the SSTable manager, for
+// export to a monitoring system. Implementations must be safe for concurrent
+// use. Without one nothing is measured, not even the time a measurement
+// would take.
+type Metrics interface {
+	// ObserveFlush records how long writing a memtable to an SSTable took.
+	ObserveFlush(d time.Duration)
+	// ObserveSSTableRead records how long reading, verifying and
+	// decompressing a block or chunk from disk took.
+	ObserveSSTableRead(d time.Duration)
+	// AddCompactionBytes records the bytes of SSTables a compaction read and
+	// wrote.
+	AddCompactionBytes(read int64, written int64)
+}
+
+// tableBytes sums the sizes of fileNames for Metrics.AddCompactionBytes.
+// Tables whose size cannot be read are left out.
+func (db *LSM) tableBytes(fileNames []string) int64 {
+	var total int64
+	for _, fileName := range fileNames {
+		size, err := db.sstableMgr.Size(fileName)
+		if err != nil {
+			db.logger.Errorf("Error in reading size of sstable %s: %v", fileName, err)
+			continue
+		}
+		total += size
+	}
+	return total
+}
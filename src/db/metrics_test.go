@@ -0,0 +1,96 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingMetrics counts the measurements it receives.
+type countingMetrics struct {
+	flushes      atomic.Int64
+	reads        atomic.Int64
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+}
+
+func (m *countingMetrics) ObserveFlush(d time.Duration) {
+	m.flushes.Add(1)
+}
+
+func (m *countingMetrics) ObserveSSTableRead(d time.Duration) {
+	m.reads.Add(1)
+}
+
+func (m *countingMetrics) AddCompactionBytes(read int64, written int64) {
+	m.bytesRead.Add(read)
+	m.bytesWritten.Add(written)
+}
+
+func TestMetrics(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testMetrics")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	metrics := &countingMetrics{}
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, Metrics: metrics})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold:      1000,
+		CompactionMinThreshold: 2,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+		Metrics:                metrics,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for table := 0; table < 2; table++ {
+		for i := 0; i < 10; i++ {
+			err := database.Put(Entry{Key: fmt.Sprintf("key%02d", i), Value: []byte(fmt.Sprintf("value%d", table))})
+			if err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+		}
+		if err := database.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	if got := metrics.flushes.Load(); got != 2 {
+		t.Errorf("expected 2 flushes observed, got %d", got)
+	}
+
+	sizes := database.Stats().SSTableBytes
+	if err := database.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if got := metrics.bytesRead.Load(); got != sizes {
+		t.Errorf("expected compaction to read %d bytes, got %d", sizes, got)
+	}
+	if got, want := metrics.bytesWritten.Load(), database.Stats().SSTableBytes; got != want || got == 0 {
+		t.Errorf("expected compaction to write %d bytes, got %d", want, got)
+	}
+
+	// The compaction read both tables; a lookup in the new table reads again
+	reads := metrics.reads.Load()
+	if reads == 0 {
+		t.Errorf("expected the compaction reads to be observed")
+	}
+	if _, err := database.Get("key05"); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if got := metrics.reads.Load(); got <= reads {
+		t.Errorf("expected a cold lookup to observe a read, still at %d", got)
+	}
+}
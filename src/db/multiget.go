@@ -0,0 +1,183 @@
+package db
+
+import (
+	"errors"
+	"sort"
+)
+
+// GetMany returns the newest live record of every key in keys that has one,
+// by key. Keys that were never written, were deleted or have expired are left
+// out of the map. Keys are looked up in sorted order, first in the memtables
+// and then in the SSTables that may hold them, newest first. Within an SSTable
+// the keys still missing are grouped by the block they fall in, so each block
+// is read and decompressed at most once. Like Get, the SSTables are searched
+// after db.mu is released.
+func (db *LSM) GetMany(keys []string) (map[string]Entry, error) {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	found := make(map[string]Entry, len(sorted))
+
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrDBClosed
+	}
+	now := db.now()
+	m := db.merger(now)
+	// tableKeys[fileName] are the keys fileName may hold, in order
+	tableKeys := map[string][]string{}
+	// operands holds the merge operands of keys still to be combined with
+	// older records
+	operands := map[string]Entry{}
+	for i, key := range sorted {
+		if i > 0 && key == sorted[i-1] {
+			continue
+		}
+		db.counters.gets.Add(1)
+		if entry, exists := db.getFromMemtables(key); exists {
+			if !entry.Merge {
+				if live, err := liveEntry(entry, now); err == nil {
+					found[key] = live
+				}
+				continue
+			}
+			operands[key] = entry
+		}
+		for _, fileName := range db.tableCandidates(key) {
+			tableKeys[fileName] = append(tableKeys[fileName], key)
+		}
+	}
+	// The tables in the order tableCandidates lists them, newest first
+	fileNames := []string{}
+	for i := len(db.Sstables) - 1; i >= 0; i-- {
+		if _, ok := tableKeys[db.Sstables[i]]; ok {
+			fileNames = append(fileNames, db.Sstables[i])
+		}
+	}
+	for _, levelFiles := range db.levels {
+		for _, fileName := range levelFiles {
+			if _, ok := tableKeys[fileName]; ok {
+				fileNames = append(fileNames, fileName)
+			}
+		}
+	}
+	db.pinSSTables(fileNames...)
+	db.mu.RUnlock()
+	defer db.unpinSSTables(fileNames...)
+
+	// resolved holds the keys whose newest record other than a merge operand
+	// was found, live or not
+	resolved := map[string]bool{}
+	for _, fileName := range fileNames {
+		pending := []string{}
+		for _, key := range tableKeys[fileName] {
+			if !resolved[key] {
+				pending = append(pending, key)
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		entries, err := db.findKeys(fileName, pending)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if newer, ok := operands[entry.Key]; ok {
+				entry = m.combine(entry, newer)
+			}
+			if entry.Merge {
+				operands[entry.Key] = entry
+				continue
+			}
+			delete(operands, entry.Key)
+			resolved[entry.Key] = true
+			if live, err := liveEntry(entry, now); err == nil {
+				found[entry.Key] = live
+			}
+		}
+	}
+	// Operands with no older record left
+	for key, entry := range operands {
+		if live, err := liveEntry(m.resolve(entry), now); err == nil {
+			found[key] = live
+		}
+	}
+	return found, nil
+}
+
+// findKeys returns the records fileName holds for keys, which must be sorted.
+// Keys its Bloom filter rules out are skipped and the others are grouped by
+// the block their key range falls in, so each block is read once. Tables of
+// managers that cannot list blocks are searched a key at a time.
+func (db *LSM) findKeys(fileName string, keys []string) ([]Entry, error) {
+	candidates := make([]string, 0, len(keys))
+	for _, key := range keys {
+		mayContain, err := db.sstableMgr.MayContain(fileName, key)
+		if err != nil {
+			db.logger.Errorf("Error in reading bloom filter of sstable %s: %v", fileName, err)
+		} else if !mayContain {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	entries := []Entry{}
+	lister, ok := db.sstableMgr.(blockLister)
+	if !ok {
+		for _, key := range candidates {
+			entry, err := db.sstableMgr.FindKey(fileName, key)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				db.logger.Errorf("Error in reading sstable %s: %v", fileName, err)
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	}
+
+	blocks, err := lister.Blocks(fileName)
+	if err != nil {
+		db.logger.Errorf("Error in reading blocks of sstable %s: %v", fileName, err)
+		return nil, err
+	}
+	for len(candidates) > 0 {
+		// Only the first block ending at or after a key can hold it
+		i := sort.Search(len(blocks), func(i int) bool {
+			return blocks[i].EndKey >= candidates[0]
+		})
+		if i == len(blocks) {
+			break
+		}
+		block := blocks[i]
+		n := sort.Search(len(candidates), func(n int) bool {
+			return candidates[n] > block.EndKey
+		})
+		inBlock := candidates[:n]
+		candidates = candidates[n:]
+		if inBlock[len(inBlock)-1] < block.StartKey {
+			continue
+		}
+
+		blockEntries, err := db.sstableMgr.ReadBlock(fileName, block.BlockOffset)
+		if err != nil {
+			db.logger.Errorf("Error in reading sstable %s: %v", fileName, err)
+			return nil, err
+		}
+		for _, key := range inBlock {
+			j := sort.Search(len(blockEntries), func(j int) bool {
+				return blockEntries[j].Key >= key
+			})
+			if j < len(blockEntries) && blockEntries[j].Key == key {
+				entries = append(entries, blockEntries[j])
+			}
+		}
+	}
+	return entries, nil
+}
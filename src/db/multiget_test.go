@@ -0,0 +1,190 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// BlockCountingManager records how many times each block is read.
+type BlockCountingManager struct {
+	SSTableManager
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (m *BlockCountingManager) ReadBlock(fileName string, offset uint64) ([]Entry, error) {
+	m.mu.Lock()
+	m.reads[fmt.Sprintf("%s@%d", fileName, offset)]++
+	m.mu.Unlock()
+	return m.SSTableManager.ReadBlock(fileName, offset)
+}
+
+func (m *BlockCountingManager) Blocks(fileName string) ([]IndexEntry, error) {
+	return m.SSTableManager.(blockLister).Blocks(fileName)
+}
+
+func TestGetMany(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	// Blocks of a few entries each, so the keys of a table span several
+	fileDir := filepath.Join(currentTestDir, ".testGetMany")
+	defer deleteDirectoryIfExists(fileDir)
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: fileDir, Logger: logger, BlockSize: 256})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	counting := &BlockCountingManager{SSTableManager: ssm, reads: map[string]int{}}
+
+	for _, test := range []struct {
+		name string
+		mgr  SSTableManager
+	}{
+		{name: "blocks", mgr: counting},
+		{name: "without_blocks", mgr: NewInMemoryManager(logger)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			database, err := NewDb(Options{
+				MemtableThreshold: 1000,
+				SstableMgr:        test.mgr,
+				Logger:            logger,
+			})
+			if err != nil {
+				t.Fatalf("error creating db: %v", err)
+			}
+			defer database.Close()
+
+			put := func(key string, value string) {
+				if err := database.Put(Entry{Key: key, Value: []byte(value)}); err != nil {
+					t.Fatalf("Failed to put entry: %v", err)
+				}
+			}
+			flush := func() {
+				if err := database.Flush(); err != nil {
+					t.Fatalf("Failed to flush: %v", err)
+				}
+			}
+			// An older table with key000 to key099, a newer one overwriting
+			// key050 to key079 and deleting key010, and the memtable
+			for i := 0; i < 100; i++ {
+				put(fmt.Sprintf("key%03d", i), "old")
+			}
+			flush()
+			for i := 50; i < 80; i++ {
+				put(fmt.Sprintf("key%03d", i), "new")
+			}
+			if err := database.Delete("key010"); err != nil {
+				t.Fatalf("Failed to delete entry: %v", err)
+			}
+			flush()
+			put("key005", "memtable")
+			put("key200", "memtable")
+
+			found, err := database.GetMany([]string{
+				"key200", "key099", "key060", "key022", "key021", "key020", "key020",
+				"key010", "key005", "key150", "aaa",
+			})
+			if err != nil {
+				t.Fatalf("Failed to get keys: %v", err)
+			}
+			expected := map[string]string{
+				"key005": "memtable",
+				"key020": "old",
+				"key021": "old",
+				"key022": "old",
+				"key060": "new",
+				"key099": "old",
+				"key200": "memtable",
+			}
+			if len(found) != len(expected) {
+				t.Errorf("expected %d keys, got %d: %v", len(expected), len(found), found)
+			}
+			for key, value := range expected {
+				if entry, ok := found[key]; !ok || string(entry.Value) != value {
+					t.Errorf("expected %s for %s, got %q (found %v)", value, key, entry.Value, ok)
+				}
+			}
+
+			for block, reads := range counting.reads {
+				if reads > 1 {
+					t.Errorf("expected block %s to be read at most once, read %d times", block, reads)
+				}
+			}
+
+			if err := database.Close(); err != nil {
+				t.Fatalf("Failed to close: %v", err)
+			}
+			if _, err := database.GetMany([]string{"key005"}); err != ErrDBClosed {
+				t.Errorf("expected %v after close, got %v", ErrDBClosed, err)
+			}
+		})
+	}
+	if len(counting.reads) == 0 {
+		t.Errorf("expected the SSTables to be read a block at a time")
+	}
+}
+
+func BenchmarkGetManyVsGet(b *testing.B) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		b.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".benchmarkGetMany")
+	defer deleteDirectoryIfExists(dataDir)
+	logger := log.New(io.Discard, "", 0)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		b.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		b.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+	for i := 0; i < 10000; i++ {
+		if err := database.Put(Entry{Key: fmt.Sprintf("key%05d", i), Value: []byte(fmt.Sprintf("value%05d", i))}); err != nil {
+			b.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	if err := database.Flush(); err != nil {
+		b.Fatalf("Failed to flush: %v", err)
+	}
+
+	// Every seventh key, a few to each block
+	keys := []string{}
+	for i := 0; i < 10000; i += 7 {
+		keys = append(keys, fmt.Sprintf("key%05d", i))
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				if _, err := database.Get(key); err != nil {
+					b.Fatalf("Failed to get %s: %v", key, err)
+				}
+			}
+		}
+	})
+
+	b.Run("GetMany", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			found, err := database.GetMany(keys)
+			if err != nil || len(found) != len(keys) {
+				b.Fatalf("Failed to get keys: %d found, %v", len(found), err)
+			}
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSnapshotReleased is returned by reads from a Snapshot after Release.
+var ErrSnapshotReleased = errors.New("snapshot is released")
+
+// Snapshot is a read-only view of the database as of one sequence number.
+// Writes, flushes and compactions that happen after it is taken are not
+// visible through it. A Snapshot pins the SSTables it reads, so it should be
+// released once it is no longer needed. It is safe for concurrent use.
+type Snapshot struct {
+	db *LSM
+	// sequence is the sequence number of the last write the snapshot sees
+	sequence uint64
+	// memtables are a copy of the active memtable followed by the immutable
+	// ones, newest first. None of them is written to again.
+	memtables []*Memtable
+	l0        []string
+	levels    [][]string
+	tableInfo map[string]TableInfo
+	// now is when the snapshot was taken; entries are checked for expiry
+	// against it so the view does not change as time passes either
+	now time.Time
+
+	mu       sync.RWMutex
+	released bool
+}
+
+// Snapshot captures the current memtables and SSTables. The active memtable is
+// copied because later writes replace its entries in place; the immutable
+// memtables and the SSTables never change, so they are shared and pinned.
+// It returns ErrDBClosed once the database is closed.
+func (db *LSM) Snapshot() (*Snapshot, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	active := NewMemtable()
+	for it := db.Memtable.Iterator(); it.Valid(); it.Next() {
+		active.Put(it.Entry())
+	}
+	memtables := []*Memtable{active}
+	for i := len(db.immutables) - 1; i >= 0; i-- {
+		memtables = append(memtables, db.immutables[i])
+	}
+
+	l0 := append([]string(nil), db.Sstables...)
+	levels := make([][]string, len(db.levels))
+	fileNames := append([]string(nil), l0...)
+	for i, levelFiles := range db.levels {
+		levels[i] = append([]string(nil), levelFiles...)
+		fileNames = append(fileNames, levelFiles...)
+	}
+	tableInfo := make(map[string]TableInfo, len(fileNames))
+	for _, fileName := range fileNames {
+		if info, ok := db.tableInfo[fileName]; ok {
+			tableInfo[fileName] = info
+		}
+	}
+	db.pinSSTables(fileNames...)
+
+	return &Snapshot{
+		db:        db,
+		sequence:  db.lastSequence,
+		memtables: memtables,
+		l0:        l0,
+		levels:    levels,
+		tableInfo: tableInfo,
+		now:       db.now(),
+	}, nil
+}
+
+// Sequence returns the sequence number of the last write the snapshot sees.
+func (s *Snapshot) Sequence() uint64 {
+	return s.sequence
+}
+
+// Get returns the newest live record for key as of the snapshot.
+func (s *Snapshot) Get(key string) (Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return Entry{}, ErrSnapshotReleased
+	}
+
+	m := s.db.merger(s.now)
+	var found Entry
+	for _, memtable := range s.memtables {
+		if entry, exists := memtable.Get(key); exists && entry.SequenceNumber <= s.sequence {
+			if found.Merge {
+				entry = m.combine(entry, found)
+			}
+			found = entry
+			if !found.Merge {
+				return liveEntry(found, s.now)
+			}
+		}
+	}
+	fileNames := candidateTables(s.l0, s.levels, s.tableInfo, key)
+	return s.db.getFromSSTables(context.Background(), fileNames, key, found, s.now)
+}
+
+// Scan returns the live entries with startKey <= key < endKey as of the
+// snapshot, with the same arguments and ordering as LSM.Scan.
+func (s *Snapshot) Scan(startKey string, endKey string, limit int) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	memtables := make([]*Memtable, len(s.memtables))
+	for i, memtable := range s.memtables {
+		memtables[len(memtables)-1-i] = memtable
+	}
+	sources, err := s.db.scanSources(context.Background(), scanOrder(s.l0, s.levels), s.tableInfo, memtables, startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	for i, entries := range sources[len(sources)-len(memtables):] {
+		visible := entries[:0:0]
+		for _, entry := range entries {
+			if entry.SequenceNumber <= s.sequence {
+				visible = append(visible, entry)
+			}
+		}
+		sources[len(sources)-len(memtables)+i] = visible
+	}
+	return s.db.merger(s.now).liveEntries(sources, limit), nil
+}
+
+// Release unpins the SSTables of the snapshot, letting compactions delete the
+// ones they replaced. Later reads return ErrSnapshotReleased. Releasing a
+// released snapshot does nothing.
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.memtables = nil
+	for _, levelFiles := range append([][]string{s.l0}, s.levels...) {
+		s.db.unpinSSTables(levelFiles...)
+	}
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testSnapshot")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold:      1000,
+		CompactionMinThreshold: 2,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	put := func(key, value string) {
+		t.Helper()
+		if err := database.Put(Entry{Key: key, Value: []byte(value)}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	// key0 and key1 end up in an SSTable, key2 and key3 stay in the memtable
+	put("key0", "old0")
+	put("key1", "old1")
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	put("key2", "old2")
+	put("key3", "old3")
+
+	snapshot, err := database.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	if snapshot.Sequence() != 4 {
+		t.Errorf("expected the snapshot to see 4 writes, got %d", snapshot.Sequence())
+	}
+
+	put("key0", "new0")
+	put("key2", "new2")
+	put("key4", "new4")
+	if err := database.Delete("key3"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if err := database.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if len(database.Sstables) != 1 {
+		t.Fatalf("expected the flushed tables to be compacted into one, got %v", database.Sstables)
+	}
+
+	for i, want := range []string{"old0", "old1", "old2", "old3"} {
+		key := fmt.Sprintf("key%d", i)
+		entry, err := snapshot.Get(key)
+		if err != nil || string(entry.Value) != want {
+			t.Errorf("expected the snapshot to return %s for %s, got %+v, %v", want, key, entry, err)
+		}
+	}
+	if _, err := snapshot.Get("key4"); err == nil {
+		t.Errorf("expected key4, written after the snapshot, to be missing from it")
+	}
+	if entry, err := database.Get("key0"); err != nil || string(entry.Value) != "new0" {
+		t.Errorf("expected the database to return new0 for key0, got %+v, %v", entry, err)
+	}
+	if _, err := database.Get("key3"); err == nil {
+		t.Errorf("expected key3 to be deleted from the database")
+	}
+
+	// The table flushed before the snapshot outlives the compaction that
+	// replaced it until the release
+	fileNames, err := ssm.ListFiles()
+	if err != nil {
+		t.Fatalf("Failed to list sstables: %v", err)
+	}
+	if len(fileNames) != 2 {
+		t.Errorf("expected the table in the snapshot to be kept, got %v", fileNames)
+	}
+	snapshot.Release()
+	snapshot.Release()
+	if _, err := snapshot.Get("key0"); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("expected ErrSnapshotReleased after release, got %v", err)
+	}
+	fileNames, err = ssm.ListFiles()
+	if err != nil {
+		t.Fatalf("Failed to list sstables: %v", err)
+	}
+	if len(fileNames) != 1 {
+		t.Errorf("expected only the compacted table after the release, got %v", fileNames)
+	}
+
+	database.Close()
+	if _, err := database.Snapshot(); !errors.Is(err, ErrDBClosed) {
+		t.Errorf("expected ErrDBClosed after close, got %v", err)
+	}
+}
+
+func TestSnapshotScan(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testSnapshotScan")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold:      1000,
+		CompactionMinThreshold: 2,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	put := func(key, value string) {
+		t.Helper()
+		if err := database.Put(Entry{Key: key, Value: []byte(value)}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	keys := func(entries []Entry) string {
+		s := ""
+		for _, entry := range entries {
+			s += entry.Key + "=" + string(entry.Value) + " "
+		}
+		return s
+	}
+	put("a", "1")
+	put("b", "1")
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	put("c", "1")
+	put("d", "1")
+
+	snapshot, err := database.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	defer snapshot.Release()
+
+	put("a", "2")
+	put("bb", "2")
+	if err := database.Delete("c"); err != nil {
+		t.Fatalf("Failed to delete entry: %v", err)
+	}
+	if err := database.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if err := database.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	put("d", "2")
+
+	entries, err := snapshot.Scan("a", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to scan snapshot: %v", err)
+	}
+	if got := keys(entries); got != "a=1 b=1 c=1 d=1 " {
+		t.Errorf("expected the snapshot to scan the old values, got %s", got)
+	}
+	entries, err = snapshot.Scan("b", "d", 1)
+	if err != nil || keys(entries) != "b=1 " {
+		t.Errorf("expected a limited scan of the snapshot to return b=1, got %s, %v", keys(entries), err)
+	}
+	entries, err = database.Scan("a", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to scan database: %v", err)
+	}
+	if got := keys(entries); got != "a=2 b=1 bb=2 d=2 " {
+		t.Errorf("expected the database to scan the new values, got %s", got)
+	}
+
+	snapshot.Release()
+	if _, err := snapshot.Scan("a", "", 0); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("expected ErrSnapshotReleased after release, got %v", err)
+	}
+}
@@ -3,23 +3,36 @@ package db
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
-	"encoding/base64"
 	"encoding/binary"
-	"encoding/json"
 	"fmt"
 	"hash/crc32"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Entry struct {
 	Key   string
 	Value []byte
+	// Tombstone marks a deleted key. It is persisted with the entry so that
+	// it shadows older values for the same key in earlier SSTables.
+	Tombstone bool
+	// ExpiresAt is the unix time in nanoseconds from which the entry reads as
+	// deleted. Zero means the entry never expires.
+	ExpiresAt int64
+	// SequenceNumber orders writes: of two records for a key, the one with
+	// the higher number is newer. It is assigned by the LSM and is zero for
+	// entries written before sequence numbers existed.
+	SequenceNumber uint64
+	// Merge marks an operand written by LSM.Merge. Reads and compactions
+	// combine it with the older records of the key using Options.MergeFunc.
+	Merge bool
 }
 
 // FileHeader represents the fixed-size header at the beginning of each SSTable file
@@ -29,9 +42,31 @@ type FileHeader struct {
 	EntryCount        int32
 	IndexOffset       uint64
 	BlockSize         int32
+	// Fields below were added after version 1 and are only present in files
+	// whose Version is high enough to carry them.
+	BloomFilterOffset uint64           // version 2
+	Compression       CompressionCodec // version 5
+	// MinSequence and MaxSequence bound the sequence numbers in the file.
+	MinSequence uint64 // version 9
+	MaxSequence uint64 // version 9
+	// HeaderChecksum is the CRC32 of every header byte before it. It is
+	// computed when the header is written and verified when it is read.
+	HeaderChecksum uint32 // version 6
 }
 
-// BlockHeader represents the header for each data block
+// fileHeaderV1 is the on-disk layout shared by every header version. From
+// version 11 on it is preceded by sstableMagic.
+type fileHeaderV1 struct {
+	Version           int32
+	CreationTimestamp int64
+	EntryCount        int32
+	IndexOffset       uint64
+	BlockSize         int32
+}
+
+// BlockHeader represents the header for each data block. From version 8 on,
+// CompressedSize covers the in-block index and chunks, and Checksum is the
+// CRC32 of the in-block index.
 type BlockHeader struct {
 	EntryCount      int32
 	CompressedSize  int32
@@ -51,237 +86,597 @@ type IndexEntry struct {
 const (
 	BlockHeaderSize   = 20 // 4 + 4 + 4 + 8 bytes
 	MinIndexEntrySize = 12 // 4 (KeyLength) + 8 (BlockOffset) bytes, not including key
+	ManifestFileName  = "MANIFEST"
+	// SSTableVersion is the format version written by this package. Version 2
+	// added a Bloom filter after the index, version 3 length-prefixed block
+	// records so keys may hold arbitrary bytes, and version 4 replaced the
+	// base64 JSON record payload with a binary encoding. Version 5 records the
+	// block compression codec in the header; earlier files are always gzip.
+	// Version 6 added CRC32 checksums over the header and the index, and
+	// version 7 an optional expiry time on block records. Version 8 splits
+	// blocks into separately compressed chunks listed in an in-block index.
+	// Version 9 stores sequence numbers on records and their range in the
+	// header, and version 10 flags chunks that are stored uncompressed.
+	// Version 11 starts the file with sstableMagic, and version 12 ends it
+	// with a footer holding the key range, entry count and index offset.
+	SSTableVersion = 12
+	// sstableMagic opens every file from version 11 on. Read as the version
+	// of an older file it would be far out of range, so the two layouts
+	// cannot be confused.
+	sstableMagic = 0x474F4154 // "GOAT"
+	// magicVersion is the first version written with sstableMagic.
+	magicVersion = 11
+	// DefaultBlockSize is the encoded size in bytes, before compression, that
+	// blocks are filled up to.
+	DefaultBlockSize = 4096
 )
 
 // Modified interface to support the new format
 type SSTableManager interface {
 	Write(fileName string, data []Entry) error
+	WriteFromIterator(fileName string, it EntryIterator, count int) error
 	ReadAll(fileName string) ([]Entry, error)
 	ReadBlock(fileName string, offset uint64) ([]Entry, error)
 	FindKey(fileName string, key string) (Entry, error)
+	MayContain(fileName string, key string) (bool, error)
+	Scan(fileName string, startKey string, endKey string) ([]Entry, error)
+	Delete(fileName string) error
+	Size(fileName string) (int64, error)
+	ListFiles() ([]string, error)
+	WriteManifest(levels [][]string) error
+	ReadManifest() ([][]string, error)
+	TableInfo(fileName string) (TableInfo, error)
+	Describe(fileName string) (SSTableInfo, error)
+	Verify(fileName string) (VerifyReport, error)
+	RepairTruncate(fileName string) (VerifyReport, error)
 }
 
 type SSTableFileSystemManager struct {
 	DataDir string
 	Logger  *log.Logger
+	// LogLevel is the lowest level of the messages written to Logger. The
+	// zero value is LevelInfo.
+	LogLevel Level
+	// BloomFalsePositiveRate is the target false positive rate of the Bloom
+	// filter written to each SSTable. Zero means DefaultBloomFalsePositiveRate.
+	BloomFalsePositiveRate float64
+	// CompressionCodec is the codec used for blocks of newly written SSTables.
+	// Existing files are read with the codec recorded in their header.
+	CompressionCodec CompressionCodec
+	// CompressionLevel is the gzip level, from gzip.HuffmanOnly to
+	// gzip.BestCompression, used when CompressionCodec is CompressionGzip.
+	// Zero means gzip.DefaultCompression; use CompressionNone to store blocks
+	// uncompressed.
+	CompressionLevel int
+	// BlockSize is the encoded size in bytes, before compression, that blocks
+	// are filled up to. A block only exceeds it when a single record does.
+	// Zero means DefaultBlockSize.
+	BlockSize int
+	// BlockEntries, if positive, also caps the number of entries in a block.
+	BlockEntries int
+	// MinCompressSize is the encoded size in bytes below which chunks of a
+	// block are stored uncompressed. Zero means DefaultMinCompressSize.
+	MinCompressSize int
+	// Metrics receives the time taken by block reads from disk. Nil records
+	// nothing.
+	Metrics Metrics
+	// Durability controls whether SSTables and the manifest are synced to
+	// disk. The zero value is DurabilityStrict.
+	Durability DurabilityMode
+	// ReadOnly makes every method that would change the data directory,
+	// such as Write, Delete and WriteManifest, return ErrReadOnly. Files are
+	// only ever opened with O_RDONLY.
+	ReadOnly bool
+	// wrapWriter, if set, wraps the file every SSTable is written to. Tests
+	// use it to inject write failures.
+	wrapWriter func(io.Writer) io.Writer
+	// onSync, if set, is called with the path of every file and directory
+	// synced. Tests use it to check the order of syncs.
+	onSync  func(path string)
+	filters *bloomFilterCache
+	tables  *tableCache
+	blocks  *blockCache
+}
+
+type FileManagerOptions struct {
+	DataDir                string
+	Logger                 *log.Logger
+	LogLevel               Level
+	BloomFalsePositiveRate float64
+	CompressionCodec       CompressionCodec
+	CompressionLevel       int
+	BlockSize              int
+	BlockEntries           int
+	MinCompressSize        int
+	// TableCacheSize is the number of SSTable headers and indexes kept in
+	// memory. Zero means DefaultTableCacheSize and a negative value disables
+	// the cache.
+	TableCacheSize int
+	// BlockCacheSize is the number of bytes of decoded blocks kept in memory.
+	// Zero means DefaultBlockCacheSize and a negative value disables the cache.
+	BlockCacheSize int64
+	Metrics        Metrics
+	// Durability is the DurabilityMode of the manager, unless NewDb is given
+	// another one in Options.Durability.
+	Durability DurabilityMode
+	// ReadOnly opens an existing data directory without creating it and
+	// without ever changing it; see SSTableFileSystemManager.ReadOnly.
+	ReadOnly bool
+}
+
+// bloomFilterCache keeps the Bloom filter of each SSTable in memory once it has
+// been read, so lookups do not reload it from disk.
+type bloomFilterCache struct {
+	mu      sync.RWMutex
+	filters map[string]*bloomFilter
 }
 
 func NewFileManager(dataDir string, logger *log.Logger) (SSTableManager, error) {
-	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
+	return NewFileManagerWithOptions(FileManagerOptions{
+		DataDir: dataDir,
+		Logger:  logger,
+	})
+}
+
+func NewFileManagerWithOptions(opts FileManagerOptions) (SSTableManager, error) {
+	dataDir := opts.DataDir
+	logger := NewLogger(opts.Logger, opts.LogLevel)
+	if opts.CompressionCodec > CompressionSnappy {
+		return &SSTableFileSystemManager{}, fmt.Errorf("unsupported compression codec %s", opts.CompressionCodec)
+	}
+	if !validGzipLevel(opts.CompressionLevel) {
+		return &SSTableFileSystemManager{}, fmt.Errorf("invalid compression level %d", opts.CompressionLevel)
+	}
+	if !validDurability(opts.Durability) {
+		return &SSTableFileSystemManager{}, fmt.Errorf("unsupported durability %s", opts.Durability)
+	}
+	tableCacheSize := opts.TableCacheSize
+	if tableCacheSize == 0 {
+		tableCacheSize = DefaultTableCacheSize
+	}
+	blockCacheSize := opts.BlockCacheSize
+	if blockCacheSize == 0 {
+		blockCacheSize = DefaultBlockCacheSize
+	}
+	if _, err := os.Stat(dataDir); os.IsNotExist(err) && opts.ReadOnly {
+		return &SSTableFileSystemManager{}, fmt.Errorf("data directory %s does not exist", dataDir)
+	} else if os.IsNotExist(err) {
 		err = os.MkdirAll(dataDir, os.ModePerm)
 		if err != nil {
-			logger.Printf("Error creating directory: %v", err)
+			logger.Errorf("Error creating directory: %v", err)
 			return &SSTableFileSystemManager{}, fmt.Errorf("error creating directory: %w", err)
 		}
-		logger.Printf("Directory created: %s", dataDir)
+		logger.Infof("Directory created: %s", dataDir)
 	} else {
-		logger.Printf("Directory already exists: %s", dataDir)
+		logger.Debugf("Directory already exists: %s", dataDir)
 	}
 	return &SSTableFileSystemManager{
-		DataDir: dataDir,
-		Logger:  logger,
+		DataDir:                dataDir,
+		Logger:                 opts.Logger,
+		LogLevel:               opts.LogLevel,
+		BloomFalsePositiveRate: opts.BloomFalsePositiveRate,
+		CompressionCodec:       opts.CompressionCodec,
+		CompressionLevel:       opts.CompressionLevel,
+		BlockSize:              opts.BlockSize,
+		BlockEntries:           opts.BlockEntries,
+		MinCompressSize:        opts.MinCompressSize,
+		Metrics:                opts.Metrics,
+		Durability:             opts.Durability,
+		ReadOnly:               opts.ReadOnly,
+		filters:                &bloomFilterCache{filters: make(map[string]*bloomFilter)},
+		tables:                 newTableCache(tableCacheSize),
+		blocks:                 newBlockCache(blockCacheSize),
 	}, nil
 }
 
+// logger wraps Logger to honor LogLevel. It returns the concrete type so the
+// wrapper needs no allocation.
+func (ssm SSTableFileSystemManager) logger() stdLogger {
+	return stdLogger{logger: ssm.Logger, level: ssm.LogLevel}
+}
+
+// EntryIterator yields entries one at a time. MemtableIterator implements it.
+type EntryIterator interface {
+	Valid() bool
+	Next()
+	Entry() Entry
+}
+
+// sliceIterator is an EntryIterator over a slice of entries.
+type sliceIterator struct {
+	entries []Entry
+}
+
+func (it *sliceIterator) Valid() bool {
+	return len(it.entries) > 0
+}
+
+func (it *sliceIterator) Next() {
+	it.entries = it.entries[1:]
+}
+
+func (it *sliceIterator) Entry() Entry {
+	return it.entries[0]
+}
+
+// Write writes data, sorting it by key first if needed, as the SSTable
+// fileName with WriteFromIterator.
 func (ssm SSTableFileSystemManager) Write(fileName string, data []Entry) error {
-	sort.Slice(data, func(i, j int) bool {
+	// Flushes and compactions already hand over sorted entries
+	less := func(i, j int) bool {
 		return data[i].Key < data[j].Key
-	})
+	}
+	if !sort.SliceIsSorted(data, less) {
+		sort.Slice(data, less)
+	}
+	return ssm.WriteFromIterator(fileName, &sliceIterator{entries: data}, len(data))
+}
+
+// WriteFromIterator writes the entries yielded by it, which must come in key
+// order, as the SSTable fileName. count is the expected number of entries and
+// sizes the Bloom filter. Blocks are encoded and written one at a time, so only
+// one block of entries is held in memory besides the filter and the index.
+//
+// The table is streamed to a temporary file that is synced and renamed into
+// place once complete, so a crash or an error never leaves a partially written
+// table under fileName.
+func (ssm SSTableFileSystemManager) WriteFromIterator(fileName string, it EntryIterator, count int) error {
+	if ssm.ReadOnly {
+		return ErrReadOnly
+	}
 	fullFilePath := filepath.Join(ssm.DataDir, fileName)
-	file, err := os.Create(fullFilePath)
+	tmpPath := fullFilePath + ".tmp"
+	file, err := os.Create(tmpPath)
 	if err != nil {
-		ssm.Logger.Printf("Error creating SSTable file %s: %v", fileName, err)
+		ssm.logger().Errorf("Error creating SSTable file %s: %v", fileName, err)
+		return err
+	}
+	if err := ssm.writeTable(file, it, count); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close SSTable file: %w", err)
+	}
+	if err := os.Rename(tmpPath, fullFilePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename SSTable file: %w", err)
+	}
+	ssm.filters.remove(fileName)
+	ssm.tables.remove(fileName)
+	ssm.blocks.removeFile(fileName)
+	// The file must be durable before a manifest can list it
+	if err := ssm.syncDataDir(); err != nil {
 		return err
 	}
-	defer file.Close()
+
+	ssm.logger().Debugf("Successfully wrote to SSTable file: %s", fileName)
+	return nil
+}
+
+// offsetWriter counts the bytes written through it, which gives the offset in
+// the file of everything written next.
+type offsetWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.Write(p)
+	ow.offset += int64(n)
+	return n, err
+}
+
+// writeTable writes the header, blocks, index, Bloom filter and footer of the
+// entries of it to file through a buffer, then rewrites the header with the entry
+// count, sequence number range and offsets of the index and the filter and
+// syncs the file.
+func (ssm SSTableFileSystemManager) writeTable(file *os.File, it EntryIterator, count int) error {
+	var out io.Writer = file
+	if ssm.wrapWriter != nil {
+		out = ssm.wrapWriter(file)
+	}
+	buffered := bufio.NewWriter(out)
+	w := &offsetWriter{w: buffered}
+
+	blockSize := ssm.BlockSize
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
 
 	// Write file header
 	header := FileHeader{
-		Version:           1,
+		Version:           SSTableVersion,
 		CreationTimestamp: time.Now().Unix(),
-		EntryCount:        int32(len(data)),
-		BlockSize:         4096, // 4KB blocks
+		BlockSize:         int32(blockSize),
+		Compression:       ssm.CompressionCodec,
 	}
 
-	if err := binary.Write(file, binary.BigEndian, &header); err != nil {
+	if err := writeFileHeader(w, header); err != nil {
 		return fmt.Errorf("failed to write header: %w", err)
 	}
 
+	filter := newBloomFilter(count, ssm.BloomFalsePositiveRate)
+
 	// Initialize index
 	var index []IndexEntry
-	currentOffset, _ := file.Seek(0, 1)
+	currentOffset := w.offset
 
 	// Write data blocks
-	blockSize := 100
-	if blockSize > len(data) {
-		blockSize = len(data)
+	minCompressSize := ssm.MinCompressSize
+	if minCompressSize <= 0 {
+		minCompressSize = DefaultMinCompressSize
 	}
-	blockEntries := make([]string, 0, blockSize)
-	for idx, item := range data {
-		serializedEntry, err := serializeToBase64(item)
+	var blockEntries []Entry
+	var blockBytes int
+	writeBlock := func() error {
+		// Encode and compress block data. The block header checksum
+		// covers the in-block index, which holds the checksum of each chunk.
+		body, checksum, err := encodeBlock(blockEntries, header.Compression, ssm.CompressionLevel, minCompressSize)
 		if err != nil {
-			return fmt.Errorf("failed to serialize entry: %w", err)
+			return fmt.Errorf("failed to write block: %w", err)
 		}
-		blockEntries = append(blockEntries, fmt.Sprintf("%s,%s", item.Key, serializedEntry))
 
-		if len(blockEntries) == 100 || item.Key == data[len(data)-1].Key {
-			// Compress block data
-			var compressed bytes.Buffer
-			compressor := gzip.NewWriter(&compressed)
-			for _, entry := range blockEntries {
-				compressor.Write([]byte(entry + "\n"))
-			}
-			compressor.Close()
+		// Write block header
+		blockHeader := BlockHeader{
+			EntryCount:      int32(len(blockEntries)),
+			CompressedSize:  int32(len(body)),
+			Checksum:        checksum,
+			NextBlockOffset: uint64(currentOffset + int64(len(body)) + BlockHeaderSize),
+		}
 
-			// Calculate checksum
-			checksum := crc32.ChecksumIEEE(compressed.Bytes())
+		if err := binary.Write(w, binary.BigEndian, &blockHeader); err != nil {
+			return fmt.Errorf("failed to write block header: %w", err)
+		}
+		if _, err := w.Write(body); err != nil {
+			return fmt.Errorf("failed to write block: %w", err)
+		}
 
-			// Write block header
-			blockHeader := BlockHeader{
-				EntryCount:      int32(len(blockEntries)),
-				CompressedSize:  int32(compressed.Len()),
-				Checksum:        checksum,
-				NextBlockOffset: uint64(currentOffset + int64(compressed.Len()) + 20), // 20 is block header size
-			}
+		// Add first key of block to index
+		lastKey := blockEntries[len(blockEntries)-1].Key
+		index = append(index, IndexEntry{
+			StartKeyLength: int32(len(blockEntries[0].Key)),
+			StartKey:       blockEntries[0].Key,
+			EndKeyLength:   int32(len(lastKey)),
+			EndKey:         lastKey,
+			BlockOffset:    uint64(currentOffset),
+		})
 
-			binary.Write(file, binary.BigEndian, &blockHeader)
-			file.Write(compressed.Bytes())
+		currentOffset = int64(blockHeader.NextBlockOffset)
+		blockEntries = blockEntries[:0]
+		blockBytes = 0
+		return nil
+	}
 
-			// Add first key of block to index
-			index = append(index, IndexEntry{
-				StartKeyLength: int32(len(data[idx-blockSize+1].Key)),
-				StartKey:       data[idx-blockSize+1].Key,
-				EndKeyLength:   int32(len(data[idx].Key)),
-				EndKey:         data[idx].Key,
-				BlockOffset:    uint64(currentOffset),
-			})
+	var entryCount int32
+	var firstKey, lastKey string
+	for ; it.Valid(); it.Next() {
+		item := it.Entry()
+		if entryCount > 0 && item.Key < lastKey {
+			return fmt.Errorf("failed to write block: key %q out of order after %q", item.Key, lastKey)
+		}
+		if entryCount == 0 || item.SequenceNumber < header.MinSequence {
+			header.MinSequence = item.SequenceNumber
+		}
+		if item.SequenceNumber > header.MaxSequence {
+			header.MaxSequence = item.SequenceNumber
+		}
+		if entryCount == 0 {
+			firstKey = item.Key
+		}
+		entryCount++
+		lastKey = item.Key
+		filter.add(item.Key)
+
+		// Start a new block rather than let this record take the current one
+		// past blockSize
+		size := encodedEntrySize(item)
+		if len(blockEntries) > 0 && blockBytes+size > blockSize {
+			if err := writeBlock(); err != nil {
+				return err
+			}
+		}
+		blockEntries = append(blockEntries, item)
+		blockBytes += size
 
-			currentOffset = int64(blockHeader.NextBlockOffset)
-			blockEntries = blockEntries[:0]
+		if len(blockEntries) == ssm.BlockEntries {
+			if err := writeBlock(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(blockEntries) > 0 {
+		if err := writeBlock(); err != nil {
+			return err
 		}
 	}
 
 	// Write index
-	indexOffset, _ := file.Seek(0, 1)
+	indexOffset := w.offset
+	ssm.logger().Debugf("index offset: %d", indexOffset)
+
+	// The index is checksummed as it is written and the checksum follows it
+	indexChecksum := crc32.NewIEEE()
+	indexWriter := io.MultiWriter(w, indexChecksum)
 
 	// First write the number of index entries
 	indexCount := uint32(len(index))
-	if err := binary.Write(file, binary.BigEndian, indexCount); err != nil {
+	if err := binary.Write(indexWriter, binary.BigEndian, indexCount); err != nil {
 		return fmt.Errorf("failed to write index count: %w", err)
 	}
 
 	// Then write each index entry
 	for _, entry := range index {
-		indexOffset, _ := file.Seek(0, 1)
-		ssm.Logger.Printf("index offset start key len: %d", indexOffset)
-		if err := binary.Write(file, binary.BigEndian, entry.StartKeyLength); err != nil {
+		if err := binary.Write(indexWriter, binary.BigEndian, entry.StartKeyLength); err != nil {
 			return fmt.Errorf("failed to write key length: %w", err)
 		}
-		indexOffset, _ = file.Seek(0, 1)
-		ssm.Logger.Printf("index offset start key: %d", indexOffset)
-		if _, err := file.Write([]byte(entry.StartKey)); err != nil {
+		if _, err := indexWriter.Write([]byte(entry.StartKey)); err != nil {
 			return fmt.Errorf("failed to write key: %w", err)
 		}
-		indexOffset, _ = file.Seek(0, 1)
-		ssm.Logger.Printf("index offset end key len: %d", indexOffset)
-		if err := binary.Write(file, binary.BigEndian, entry.EndKeyLength); err != nil {
+		if err := binary.Write(indexWriter, binary.BigEndian, entry.EndKeyLength); err != nil {
 			return fmt.Errorf("failed to write key length: %w", err)
 		}
-		indexOffset, _ = file.Seek(0, 1)
-		ssm.Logger.Printf("index offset end key: %d", indexOffset)
-		if _, err := file.Write([]byte(entry.EndKey)); err != nil {
+		if _, err := indexWriter.Write([]byte(entry.EndKey)); err != nil {
 			return fmt.Errorf("failed to write key: %w", err)
 		}
-		indexOffset, _ = file.Seek(0, 1)
-		ssm.Logger.Printf("index block offset: %d", indexOffset)
-		if err := binary.Write(file, binary.BigEndian, entry.BlockOffset); err != nil {
+		if err := binary.Write(indexWriter, binary.BigEndian, entry.BlockOffset); err != nil {
 			return fmt.Errorf("failed to write block offset: %w", err)
 		}
 	}
+	if err := binary.Write(w, binary.BigEndian, indexChecksum.Sum32()); err != nil {
+		return fmt.Errorf("failed to write index checksum: %w", err)
+	}
 
-	// Update header with index offset
-	file.Seek(0, 0)
-	header.IndexOffset = uint64(indexOffset)
-	binary.Write(file, binary.BigEndian, &header)
+	// Write the Bloom filter after the index
+	bloomFilterOffset := w.offset
+	if err := filter.writeTo(w); err != nil {
+		return err
+	}
+	footer := tableFooter{
+		MinKey:      firstKey,
+		MaxKey:      lastKey,
+		EntryCount:  uint64(entryCount),
+		IndexOffset: uint64(indexOffset),
+	}
+	if err := writeFooter(w, footer); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write SSTable file: %w", err)
+	}
 
-	ssm.Logger.Printf("Successfully wrote to SSTable file: %s", fileName)
+	// Update header with the entry count and the index and Bloom filter
+	// offsets. The header has the same size as the one written first.
+	header.EntryCount = entryCount
+	header.IndexOffset = uint64(indexOffset)
+	header.BloomFilterOffset = uint64(bloomFilterOffset)
+	var headerBytes bytes.Buffer
+	if err := writeFileHeader(&headerBytes, header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if _, err := file.WriteAt(headerBytes.Bytes(), 0); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if err := ssm.syncFile(file); err != nil {
+		return fmt.Errorf("failed to sync SSTable file: %w", err)
+	}
 	return nil
 }
 
-func (ssm SSTableFileSystemManager) ReadAll(fileName string) ([]Entry, error) {
+func (ssm SSTableFileSystemManager) ReadAll(fileName string) (entries []Entry, err error) {
+	defer func() { err = withFileName(err, fileName) }()
 	fullFilePath := filepath.Join(ssm.DataDir, fileName)
 	file, err := os.Open(fullFilePath)
 	if err != nil {
-		ssm.Logger.Printf("Error opening SSTable file %s: %v", fileName, err)
+		ssm.logger().Errorf("Error opening SSTable file %s: %v", fileName, err)
 		return nil, err
 	}
 	defer file.Close()
 
-	// Read file header
-	var header FileHeader
-	if err := binary.Read(file, binary.BigEndian, &header); err != nil {
-		return nil, fmt.Errorf("failed to read header: %w", err)
+	// Loading the table verifies the index checksum before IndexOffset is trusted
+	meta, err := ssm.tableMeta(fileName, file)
+	if err != nil {
+		return nil, err
 	}
+	header := meta.header
 
 	var results []Entry
-	currentOffset := int64(binary.Size(header))
+	currentOffset := fileHeaderSize(header.Version)
 
-	// Read all blocks until we reach the index
+	// Read all blocks until we reach the index. Whole-file reads, mostly from
+	// compaction, bypass the block cache so they do not evict hot blocks.
 	for currentOffset < int64(header.IndexOffset) {
-		blockData, err := ssm.readBlockAt(file, uint64(currentOffset))
+		blockData, err := ssm.readBlockAt(file, uint64(currentOffset), header)
 		if err != nil {
 			return nil, err
 		}
+		results = append(results, blockData...)
 
-		for _, entry := range blockData {
-			entryParts := strings.Split(entry, ",")
-			decodedEntry, err := deserializeFromBase64(entryParts[1])
-			if err != nil {
-				return nil, fmt.Errorf("failed to deserialize entry: %w", err)
-			}
-			results = append(results, decodedEntry)
+		next, err := nextBlockOffset(file, uint64(currentOffset), header)
+		if err != nil {
+			return nil, err
 		}
-
-		// Move to next block
-		var blockHeader BlockHeader
-		file.Seek(currentOffset, 0)
-		binary.Read(file, binary.BigEndian, &blockHeader)
-		currentOffset = int64(blockHeader.NextBlockOffset)
+		currentOffset = int64(next)
 	}
 
-	ssm.Logger.Printf("Successfully read SSTable file: %s", fileName)
+	ssm.logger().Debugf("Successfully read SSTable file: %s", fileName)
 	return results, nil
 }
 
-func (ssm SSTableFileSystemManager) ReadBlock(fileName string, offset uint64) ([]Entry, error) {
+// nextBlockOffset reads the header of the block at offset and returns the
+// offset of the block after it, which must lie past offset and no further than
+// the index, so a corrupt header cannot send a walk over the blocks backwards
+// or round in circles.
+func nextBlockOffset(file *os.File, offset uint64, header FileHeader) (uint64, error) {
+	var blockHeader BlockHeader
+	if _, err := file.Seek(int64(offset), 0); err != nil {
+		return 0, fmt.Errorf("failed to seek to block: %w", err)
+	}
+	if err := binary.Read(file, binary.BigEndian, &blockHeader); err != nil {
+		return 0, corruptionf("block", int64(offset), "failed to read block header: %w", err)
+	}
+	next := blockHeader.NextBlockOffset
+	if next <= offset || next > header.IndexOffset {
+		return 0, corruptionf("block", int64(offset), "next block offset %d out of range", next)
+	}
+	return next, nil
+}
+
+func (ssm SSTableFileSystemManager) ReadBlock(fileName string, offset uint64) (entries []Entry, err error) {
+	defer func() { err = withFileName(err, fileName) }()
 	fullFilePath := filepath.Join(ssm.DataDir, fileName)
 	file, err := os.Open(fullFilePath)
 	if err != nil {
-		ssm.Logger.Printf("Error opening SSTable file %s: %v", fileName, err)
+		ssm.logger().Errorf("Error opening SSTable file %s: %v", fileName, err)
 		return nil, err
 	}
 	defer file.Close()
 
-	blockData, err := ssm.readBlockAt(file, uint64(offset))
+	header, err := readFileHeader(file)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read header: %w", err)
 	}
 
-	var results []Entry
+	return ssm.cachedBlockAt(fileName, file, uint64(offset), header)
+}
+
+// cachedBlockAt is readBlockAt served through the block cache.
+func (ssm SSTableFileSystemManager) cachedBlockAt(fileName string, file *os.File, offset uint64, header FileHeader) ([]Entry, error) {
+	if entries, ok := ssm.blocks.get(fileName, offset); ok {
+		return entries, nil
+	}
+	entries, err := ssm.readBlockAt(file, offset, header)
+	if err != nil {
+		return nil, err
+	}
+	ssm.blocks.put(fileName, offset, entries)
+	return entries, nil
+}
 
-	for _, entry := range blockData {
-		decodedEntry, err := deserializeFromBase64(entry)
+// Helper function to read a single block using the format version and codec
+// recorded in the file's header
+func (ssm SSTableFileSystemManager) readBlockAt(file *os.File, offset uint64, header FileHeader) ([]Entry, error) {
+	if ssm.Metrics != nil {
+		defer ssm.observeRead(time.Now())
+	}
+	if header.Version >= 8 {
+		chunks, err := readBlockChunks(file, offset, header.Version)
 		if err != nil {
-			return nil, fmt.Errorf("failed to deserialize entry: %w", err)
+			return nil, err
 		}
-		results = append(results, decodedEntry)
+		var entries []Entry
+		for _, chunk := range chunks {
+			chunkEntries, err := readChunkAt(file, chunk, header)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, chunkEntries...)
+		}
+		return entries, nil
 	}
 
-	return results, nil
-}
-
-// Helper function to read a single block
-func (ssm SSTableFileSystemManager) readBlockAt(file *os.File, offset uint64) ([]string, error) {
 	// Read block header
 	var blockHeader BlockHeader
 	file.Seek(int64(offset), 0)
@@ -297,171 +692,733 @@ func (ssm SSTableFileSystemManager) readBlockAt(file *os.File, offset uint64) ([
 
 	// Verify checksum
 	if crc32.ChecksumIEEE(compressedData) != blockHeader.Checksum {
-		return nil, fmt.Errorf("block checksum mismatch at offset %d", offset)
+		return nil, corruptionf("block", int64(offset), "block checksum mismatch at offset %d", offset)
 	}
 
 	// Decompress data
-	reader, err := gzip.NewReader(bytes.NewReader(compressedData))
+	data, err := decompressBlock(header.Compression, compressedData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		return nil, fmt.Errorf("failed to decompress block: %w", err)
 	}
-	defer reader.Close()
+	return decodeBlock(data, header.Version)
+}
 
-	// Read decompressed data
-	scanner := bufio.NewScanner(reader)
-	var results []string
-	for scanner.Scan() {
-		results = append(results, scanner.Text())
+// observeRead reports a block or chunk read that started at start to
+// ssm.Metrics, which must be set.
+func (ssm SSTableFileSystemManager) observeRead(start time.Time) {
+	ssm.Metrics.ObserveSSTableRead(time.Since(start))
+}
+
+// readBlockChunks reads the in-block index of the block at offset, in a file of
+// version 8 or later, and verifies it against the checksum in the block header.
+func readBlockChunks(file *os.File, offset uint64, version int32) ([]blockChunk, error) {
+	var blockHeader BlockHeader
+	file.Seek(int64(offset), 0)
+	if err := binary.Read(file, binary.BigEndian, &blockHeader); err != nil {
+		return nil, fmt.Errorf("failed to read block header: %w", err)
+	}
+	var indexSize uint32
+	if err := binary.Read(file, binary.BigEndian, &indexSize); err != nil {
+		return nil, fmt.Errorf("failed to read block index size: %w", err)
+	}
+	if int64(indexSize)+4 > int64(blockHeader.CompressedSize) {
+		return nil, corruptionf("block", int64(offset), "block checksum mismatch at offset %d", offset)
+	}
+	index := make([]byte, indexSize)
+	if _, err := io.ReadFull(file, index); err != nil {
+		return nil, fmt.Errorf("failed to read block index: %w", err)
+	}
+	if crc32.ChecksumIEEE(index) != blockHeader.Checksum {
+		return nil, corruptionf("block", int64(offset), "block checksum mismatch at offset %d", offset)
 	}
+	return decodeBlockIndex(index, offset+BlockHeaderSize+4+uint64(indexSize), version)
+}
 
-	return results, nil
+// readChunkAt reads, verifies and decodes one chunk of a version 8 block.
+func readChunkAt(file *os.File, chunk blockChunk, header FileHeader) ([]Entry, error) {
+	compressedData := make([]byte, chunk.size)
+	if _, err := file.ReadAt(compressedData, int64(chunk.offset)); err != nil {
+		return nil, fmt.Errorf("failed to read compressed data: %w", err)
+	}
+	if crc32.ChecksumIEEE(compressedData) != chunk.checksum {
+		return nil, corruptionf("block", int64(chunk.offset), "block checksum mismatch at offset %d", chunk.offset)
+	}
+	if chunk.uncompressed {
+		return decodeBlock(compressedData, header.Version)
+	}
+	data, err := decompressBlock(header.Compression, compressedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress block: %w", err)
+	}
+	return decodeBlock(data, header.Version)
+}
+
+// cachedChunkAt is readChunkAt served through the block cache. Chunks are
+// cached under their own offset, which no block starts at.
+func (ssm SSTableFileSystemManager) cachedChunkAt(fileName string, file *os.File, chunk blockChunk, header FileHeader) ([]Entry, error) {
+	if entries, ok := ssm.blocks.get(fileName, chunk.offset); ok {
+		return entries, nil
+	}
+	if ssm.Metrics != nil {
+		defer ssm.observeRead(time.Now())
+	}
+	entries, err := readChunkAt(file, chunk, header)
+	if err != nil {
+		return nil, err
+	}
+	ssm.blocks.put(fileName, chunk.offset, entries)
+	return entries, nil
 }
 
-func (ssm SSTableFileSystemManager) FindKey(fileName string, searchKey string) (Entry, error) {
+func (ssm SSTableFileSystemManager) FindKey(fileName string, searchKey string) (entry Entry, err error) {
+	defer func() { err = withFileName(err, fileName) }()
 	fullFilePath := filepath.Join(ssm.DataDir, fileName)
 	file, err := os.Open(fullFilePath)
 	if err != nil {
-		ssm.Logger.Printf("Error opening SSTable file %s: %v", fileName, err)
+		ssm.logger().Errorf("Error opening SSTable file %s: %v", fileName, err)
 		return Entry{}, err
 	}
 	defer file.Close()
 
-	// Read file header
-	var header FileHeader
-	if err := binary.Read(file, binary.BigEndian, &header); err != nil {
-		return Entry{}, fmt.Errorf("failed to read header: %w", err)
+	meta, err := ssm.tableMeta(fileName, file)
+	if err != nil {
+		return Entry{}, err
 	}
+	header, index := meta.header, meta.index
 
-	// Jump to index and read index count
-	file.Seek(int64(header.IndexOffset), 0)
-	var indexCount uint32
-	if err := binary.Read(file, binary.BigEndian, &indexCount); err != nil {
-		return Entry{}, fmt.Errorf("failed to read index count: %w", err)
+	// Files from version 2 on carry a Bloom filter that rules out most misses
+	if header.Version >= 2 {
+		filter, err := ssm.bloomFilter(fileName, file, header)
+		if err != nil {
+			return Entry{}, err
+		}
+		if !filter.mayContain(searchKey) {
+			return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, searchKey)
+		}
 	}
 
-	ssm.Logger.Printf("index count = %d", indexCount)
-	// Binary search through the index
-	left, right := int32(0), int32(indexCount-1)
-	var targetOffset uint64
+	// Find the first block that ends at or after searchKey; it is the only
+	// block whose key range can hold it
+	blockIdx := sort.Search(len(index), func(i int) bool {
+		return index[i].EndKey >= searchKey
+	})
+	if blockIdx == len(index) || index[blockIdx].StartKey > searchKey {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, searchKey)
+	}
+	targetOffset := index[blockIdx].BlockOffset
 
-	for left <= right {
-		mid := (left + right) / 2
+	// Read the target block, or from version 8 on only the chunk of it that
+	// can hold searchKey
+	var entries []Entry
+	if header.Version >= 8 {
+		chunks, err := meta.blockChunks(file, targetOffset)
+		if err != nil {
+			return Entry{}, fmt.Errorf("failed to read block: %w", err)
+		}
+		chunkIdx := sort.Search(len(chunks), func(i int) bool {
+			return chunks[i].firstKey > searchKey
+		}) - 1
+		if chunkIdx < 0 {
+			return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, searchKey)
+		}
+		entries, err = ssm.cachedChunkAt(fileName, file, chunks[chunkIdx], header)
+		if err != nil {
+			return Entry{}, fmt.Errorf("failed to read block: %w", err)
+		}
+	} else {
+		entries, err = ssm.cachedBlockAt(fileName, file, targetOffset, header)
+		if err != nil {
+			return Entry{}, fmt.Errorf("failed to read block: %w", err)
+		}
+	}
 
-		// Calculate position of middle entry
-		entryPos := int64(header.IndexOffset) + 4 // Skip index count
+	// Binary search within the block
+	blockLeft, blockRight := 0, len(entries)-1
+	for blockLeft <= blockRight {
+		blockMid := (blockLeft + blockRight) / 2
+		if entries[blockMid].Key == searchKey {
+			return entries[blockMid], nil
+		} else if entries[blockMid].Key < searchKey {
+			blockLeft = blockMid + 1
+		} else {
+			blockRight = blockMid - 1
+		}
+	}
 
-		// Skip to the middle entry
-		for i := int32(0); i < mid; i++ {
-			// Read key length
-			var startKeyLength uint32
-			file.Seek(entryPos, 0)
-			indexOffset, _ := file.Seek(0, 1)
-			ssm.Logger.Printf("read index offset start key len: %d", indexOffset)
-			binary.Read(file, binary.BigEndian, &startKeyLength)
-			file.Seek(entryPos+int64(4)+int64(startKeyLength), 0)
-			indexOffset, _ = file.Seek(0, 1)
-			ssm.Logger.Printf("read index offset end key len: %d", indexOffset)
-			var endKeyLength uint32
-			binary.Read(file, binary.BigEndian, &endKeyLength)
-			// Skip key and block offset
-			entryPos += int64(4 + startKeyLength + 4 + endKeyLength + 8)
+	return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, searchKey)
+}
+
+// Scan returns the entries of fileName with startKey <= key < endKey in key
+// order, tombstones included. An empty endKey scans to the end of the file. The
+// index is used to skip blocks that end before startKey, and reading stops at
+// the first block that starts at or after endKey.
+func (ssm SSTableFileSystemManager) Scan(fileName string, startKey string, endKey string) (entries []Entry, err error) {
+	defer func() { err = withFileName(err, fileName) }()
+	fullFilePath := filepath.Join(ssm.DataDir, fileName)
+	file, err := os.Open(fullFilePath)
+	if err != nil {
+		ssm.logger().Errorf("Error opening SSTable file %s: %v", fileName, err)
+		return nil, err
+	}
+	defer file.Close()
+
+	meta, err := ssm.tableMeta(fileName, file)
+	if err != nil {
+		return nil, err
+	}
+	header, index := meta.header, meta.index
+
+	results := []Entry{}
+	first := sort.Search(len(index), func(i int) bool {
+		return index[i].EndKey >= startKey
+	})
+	for _, block := range index[first:] {
+		if endKey != "" && block.StartKey >= endKey {
+			break
 		}
 
-		// Read middle entry
-		var startKeyLength uint32
-		file.Seek(entryPos, 0)
-		if err := binary.Read(file, binary.BigEndian, &startKeyLength); err != nil {
-			return Entry{}, fmt.Errorf("failed to read key length at index: %w", err)
+		entries, err := ssm.cachedBlockAt(fileName, file, block.BlockOffset, header)
+		if err != nil {
+			return nil, err
 		}
+		for _, entry := range entries {
+			if entry.Key < startKey || (endKey != "" && entry.Key >= endKey) {
+				continue
+			}
+			results = append(results, entry)
+		}
+	}
+
+	return results, nil
+}
 
-		keyBytes := make([]byte, startKeyLength)
-		if _, err := file.Read(keyBytes); err != nil {
-			return Entry{}, fmt.Errorf("failed to read key at index: %w", err)
+// Blocks returns the index of fileName: the key range and offset of every
+// block, in key order.
+func (ssm SSTableFileSystemManager) Blocks(fileName string) (blocks []IndexEntry, err error) {
+	defer func() { err = withFileName(err, fileName) }()
+	file, err := os.Open(filepath.Join(ssm.DataDir, fileName))
+	if err != nil {
+		ssm.logger().Errorf("Error opening SSTable file %s: %v", fileName, err)
+		return nil, err
+	}
+	defer file.Close()
+
+	meta, err := ssm.tableMeta(fileName, file)
+	if err != nil {
+		return nil, err
+	}
+	return append([]IndexEntry(nil), meta.index...), nil
+}
+
+// readIndex loads every index entry of the file into memory. From version 6
+// on the index checksum is verified as well. Counts and lengths that do not fit
+// in the file, as well as a truncated index, are reported as corruption before
+// anything is allocated for them.
+func readIndex(file *os.File, header FileHeader) ([]IndexEntry, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	indexOffset := int64(header.IndexOffset)
+	remaining := info.Size() - indexOffset
+	if indexOffset < fileHeaderSize(header.Version) || remaining < 4 {
+		return nil, corruptionf("index", indexOffset, "index offset %d out of range", indexOffset)
+	}
+	if _, err := file.Seek(indexOffset, 0); err != nil {
+		return nil, fmt.Errorf("failed to seek to index: %w", err)
+	}
+	bufferedReader := bufio.NewReader(file)
+	indexChecksum := crc32.NewIEEE()
+	reader := io.TeeReader(bufferedReader, indexChecksum)
+
+	var indexCount uint32
+	if err := binary.Read(reader, binary.BigEndian, &indexCount); err != nil {
+		return nil, corruptionf("index", indexOffset, "failed to read index count: %w", err)
+	}
+	// Every entry holds at least two key lengths and a block offset
+	if int64(indexCount)*16 > remaining {
+		return nil, corruptionf("index", indexOffset, "index count %d out of range", indexCount)
+	}
+
+	readKey := func(length *int32) (string, error) {
+		if err := binary.Read(reader, binary.BigEndian, length); err != nil {
+			return "", fmt.Errorf("failed to read key length at index: %w", err)
+		}
+		if *length < 0 || int64(*length) > remaining {
+			return "", fmt.Errorf("key length %d out of range", *length)
+		}
+		keyBytes := make([]byte, *length)
+		if _, err := io.ReadFull(reader, keyBytes); err != nil {
+			return "", fmt.Errorf("failed to read key at index: %w", err)
 		}
-		startIndexKey := string(keyBytes)
-		ssm.Logger.Printf("index key: %s", startIndexKey)
+		return string(keyBytes), nil
+	}
 
-		var endKeyLength uint32
-		if err := binary.Read(file, binary.BigEndian, &endKeyLength); err != nil {
-			return Entry{}, fmt.Errorf("failed to read key length at index: %w", err)
+	index := make([]IndexEntry, 0, indexCount)
+	for i := uint32(0); i < indexCount; i++ {
+		var entry IndexEntry
+		if entry.StartKey, err = readKey(&entry.StartKeyLength); err != nil {
+			return nil, corruptionf("index", indexOffset, "%w", err)
+		}
+		if entry.EndKey, err = readKey(&entry.EndKeyLength); err != nil {
+			return nil, corruptionf("index", indexOffset, "%w", err)
 		}
-		keyBytes = make([]byte, endKeyLength)
-		if _, err := file.Read(keyBytes); err != nil {
-			return Entry{}, fmt.Errorf("failed to read key at index: %w", err)
+		if err := binary.Read(reader, binary.BigEndian, &entry.BlockOffset); err != nil {
+			return nil, corruptionf("index", indexOffset, "failed to read block offset at index: %w", err)
 		}
-		endIndexKey := string(keyBytes)
-		ssm.Logger.Printf("index key: %s", endIndexKey)
+		index = append(index, entry)
+	}
 
-		var blockOffset uint64
-		if err := binary.Read(file, binary.BigEndian, &blockOffset); err != nil {
-			return Entry{}, fmt.Errorf("failed to read block offset at index: %w", err)
+	if header.Version >= 6 {
+		var checksum uint32
+		if err := binary.Read(bufferedReader, binary.BigEndian, &checksum); err != nil {
+			return nil, fmt.Errorf("failed to read index checksum: %w", err)
 		}
+		if checksum != indexChecksum.Sum32() {
+			return nil, corruptionf("index", int64(header.IndexOffset), "index checksum mismatch")
+		}
+	}
+	return index, nil
+}
 
-		// Compare and adjust search range
-		if startIndexKey == searchKey || endIndexKey == searchKey || (startIndexKey < searchKey && endIndexKey > searchKey) {
-			targetOffset = blockOffset
-			break
-		} else if endIndexKey < searchKey {
-			targetOffset = blockOffset // Remember this offset as it might contain our key
-			left = mid + 1
-		} else {
-			right = mid - 1
+// MayContain reports whether fileName might hold key, using the file's Bloom
+// filter. A false result is definite; files without a filter always return true.
+func (ssm SSTableFileSystemManager) MayContain(fileName string, key string) (mayContain bool, err error) {
+	defer func() { err = withFileName(err, fileName) }()
+	if filter, ok := ssm.filters.get(fileName); ok {
+		return filter.mayContain(key), nil
+	}
+
+	fullFilePath := filepath.Join(ssm.DataDir, fileName)
+	file, err := os.Open(fullFilePath)
+	if err != nil {
+		ssm.logger().Errorf("Error opening SSTable file %s: %v", fileName, err)
+		return false, err
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read header: %w", err)
+	}
+	if header.Version < 2 {
+		return true, nil
+	}
+
+	filter, err := ssm.bloomFilter(fileName, file, header)
+	if err != nil {
+		return false, err
+	}
+	return filter.mayContain(key), nil
+}
+
+// Delete removes an SSTable file and forgets anything cached about it.
+func (ssm SSTableFileSystemManager) Delete(fileName string) error {
+	if ssm.ReadOnly {
+		return ErrReadOnly
+	}
+	ssm.filters.remove(fileName)
+	ssm.tables.remove(fileName)
+	ssm.blocks.removeFile(fileName)
+	err := os.Remove(filepath.Join(ssm.DataDir, fileName))
+	if err != nil {
+		ssm.logger().Errorf("Error deleting SSTable file %s: %v", fileName, err)
+		return err
+	}
+	ssm.logger().Infof("Deleted SSTable file: %s", fileName)
+	return nil
+}
+
+// Size returns the size of an SSTable file in bytes.
+func (ssm SSTableFileSystemManager) Size(fileName string) (int64, error) {
+	info, err := os.Stat(filepath.Join(ssm.DataDir, fileName))
+	if err != nil {
+		ssm.logger().Errorf("Error reading size of SSTable file %s: %v", fileName, err)
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// CheckWritable creates and removes a temporary file in DataDir to check that
+// new SSTables can be written there.
+func (ssm SSTableFileSystemManager) CheckWritable() error {
+	if ssm.ReadOnly {
+		return ErrReadOnly
+	}
+	file, err := os.CreateTemp(ssm.DataDir, ".writable-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// ListFiles returns the names of all SSTable files in the data directory,
+// whether or not they are still referenced by the manifest.
+func (ssm SSTableFileSystemManager) ListFiles() ([]string, error) {
+	dirEntries, err := os.ReadDir(ssm.DataDir)
+	if err != nil {
+		ssm.logger().Errorf("Error listing data directory %s: %v", ssm.DataDir, err)
+		return nil, err
+	}
+
+	fileNames := []string{}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Type().IsRegular() && strings.HasSuffix(dirEntry.Name(), ".sst") {
+			fileNames = append(fileNames, dirEntry.Name())
 		}
 	}
+	return fileNames, nil
+}
 
-	if targetOffset == 0 {
-		return Entry{}, fmt.Errorf("key not found: %s", searchKey)
+// RemoveTempFiles deletes the .tmp files in the data directory, which an
+// SSTable or manifest write that crashed left behind before renaming them into
+// place. The manifest never lists them. It must not run while a write is in
+// flight, so NewDb calls it only once the data directory is locked.
+func (ssm SSTableFileSystemManager) RemoveTempFiles() error {
+	if ssm.ReadOnly {
+		return ErrReadOnly
+	}
+	dirEntries, err := os.ReadDir(ssm.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to list data directory: %w", err)
 	}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() || !strings.HasSuffix(dirEntry.Name(), ".tmp") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(ssm.DataDir, dirEntry.Name())); err != nil {
+			return fmt.Errorf("failed to remove temporary file %s: %w", dirEntry.Name(), err)
+		}
+		ssm.logger().Infof("Removed temporary file %s", dirEntry.Name())
+	}
+	return nil
+}
 
-	// Read the target block
-	entries, err := ssm.readBlockAt(file, targetOffset)
+// bloomFilter returns the Bloom filter of fileName, reading it from file on the
+// first request and serving it from memory afterwards.
+func (ssm SSTableFileSystemManager) bloomFilter(fileName string, file *os.File, header FileHeader) (*bloomFilter, error) {
+	if filter, ok := ssm.filters.get(fileName); ok {
+		return filter, nil
+	}
+
+	if _, err := file.Seek(int64(header.BloomFilterOffset), 0); err != nil {
+		return nil, fmt.Errorf("failed to seek to bloom filter: %w", err)
+	}
+	filter, err := readBloomFilter(bufio.NewReader(file))
 	if err != nil {
-		return Entry{}, fmt.Errorf("failed to read block: %w", err)
+		return nil, err
 	}
+	ssm.filters.put(fileName, filter)
+	return filter, nil
+}
 
-	// Binary search within the block
-	blockLeft, blockRight := 0, len(entries)-1
-	for blockLeft <= blockRight {
-		blockMid := (blockLeft + blockRight) / 2
-		blockMidParts := strings.Split(entries[blockMid], ",")
-		if blockMidParts[0] == searchKey {
-			return deserializeFromBase64(blockMidParts[1])
-		} else if entries[blockMid] < searchKey {
-			blockLeft = blockMid + 1
-		} else {
-			blockRight = blockMid - 1
+// The cache may be nil when the manager was not built by NewFileManager, in
+// which case filters are read from disk on every lookup.
+func (c *bloomFilterCache) get(fileName string) (*bloomFilter, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	filter, ok := c.filters[fileName]
+	return filter, ok
+}
+
+func (c *bloomFilterCache) put(fileName string, filter *bloomFilter) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filters[fileName] = filter
+}
+
+func (c *bloomFilterCache) remove(fileName string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.filters, fileName)
+}
+
+// writeFileHeader writes the fields of header that exist in header.Version,
+// followed by the header checksum from version 6 on.
+func writeFileHeader(w io.Writer, header FileHeader) error {
+	headerChecksum := crc32.NewIEEE()
+	out := w
+	w = io.MultiWriter(out, headerChecksum)
+
+	if header.Version >= magicVersion {
+		if err := binary.Write(w, binary.BigEndian, uint32(sstableMagic)); err != nil {
+			return err
+		}
+	}
+	v1 := fileHeaderV1{
+		Version:           header.Version,
+		CreationTimestamp: header.CreationTimestamp,
+		EntryCount:        header.EntryCount,
+		IndexOffset:       header.IndexOffset,
+		BlockSize:         header.BlockSize,
+	}
+	if err := binary.Write(w, binary.BigEndian, &v1); err != nil {
+		return err
+	}
+	if header.Version >= 2 {
+		if err := binary.Write(w, binary.BigEndian, header.BloomFilterOffset); err != nil {
+			return err
+		}
+	}
+	if header.Version >= 5 {
+		if err := binary.Write(w, binary.BigEndian, header.Compression); err != nil {
+			return err
+		}
+	}
+	if header.Version >= 9 {
+		if err := binary.Write(w, binary.BigEndian, header.MinSequence); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, header.MaxSequence); err != nil {
+			return err
+		}
+	}
+	if header.Version >= 6 {
+		if err := binary.Write(out, binary.BigEndian, headerChecksum.Sum32()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFileHeader reads a header of any supported version. Fields that do not
+// exist in the file's version are left zero. Headers from version 6 on are
+// rejected if their checksum does not match. A file that starts with neither
+// sstableMagic nor an older version fails with ErrNotSSTable, and one from a
+// newer version than SSTableVersion with ErrUnsupportedSSTableVersion.
+func readFileHeader(in io.Reader) (FileHeader, error) {
+	headerChecksum := crc32.NewIEEE()
+	r := io.TeeReader(in, headerChecksum)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return FileHeader{}, ErrNotSSTable
+		}
+		return FileHeader{}, err
+	}
+	var v1 fileHeaderV1
+	if binary.BigEndian.Uint32(magic[:]) == sstableMagic {
+		if err := binary.Read(r, binary.BigEndian, &v1); err != nil {
+			return FileHeader{}, err
+		}
+		if v1.Version < magicVersion || v1.Version > SSTableVersion {
+			return FileHeader{}, fmt.Errorf("%w %d", ErrUnsupportedSSTableVersion, v1.Version)
+		}
+	} else {
+		// Files from before the magic number start with their version
+		if err := binary.Read(io.MultiReader(bytes.NewReader(magic[:]), r), binary.BigEndian, &v1); err != nil {
+			return FileHeader{}, err
+		}
+		if v1.Version < 1 || v1.Version >= magicVersion {
+			return FileHeader{}, ErrNotSSTable
+		}
+	}
+	header := FileHeader{
+		Version:           v1.Version,
+		CreationTimestamp: v1.CreationTimestamp,
+		EntryCount:        v1.EntryCount,
+		IndexOffset:       v1.IndexOffset,
+		BlockSize:         v1.BlockSize,
+	}
+	if header.Version >= 2 {
+		if err := binary.Read(r, binary.BigEndian, &header.BloomFilterOffset); err != nil {
+			return FileHeader{}, err
+		}
+	}
+	if header.Version >= 5 {
+		if err := binary.Read(r, binary.BigEndian, &header.Compression); err != nil {
+			return FileHeader{}, err
+		}
+	}
+	if header.Version >= 9 {
+		if err := binary.Read(r, binary.BigEndian, &header.MinSequence); err != nil {
+			return FileHeader{}, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &header.MaxSequence); err != nil {
+			return FileHeader{}, err
+		}
+	}
+	if header.Version >= 6 {
+		if err := binary.Read(in, binary.BigEndian, &header.HeaderChecksum); err != nil {
+			return FileHeader{}, err
+		}
+		if header.HeaderChecksum != headerChecksum.Sum32() {
+			return FileHeader{}, corruptionf("header", 0, "header checksum mismatch")
 		}
 	}
+	return header, nil
+}
 
-	return Entry{}, fmt.Errorf("key not found: %s", searchKey)
+// fileHeaderSize is the number of bytes a header of the given version occupies,
+// i.e. the offset of the first data block.
+func fileHeaderSize(version int32) int64 {
+	size := int64(binary.Size(fileHeaderV1{}))
+	if version >= magicVersion {
+		size += 4 // sstableMagic
+	}
+	if version >= 2 {
+		size += 8 // BloomFilterOffset
+	}
+	if version >= 5 {
+		size += 1 // Compression
+	}
+	if version >= 9 {
+		size += 16 // MinSequence and MaxSequence
+	}
+	if version >= 6 {
+		size += 4 // HeaderChecksum
+	}
+	return size
 }
 
-func serializeToBase64(entry Entry) (string, error) {
-	// Marshal the Entry struct to JSON
-	jsonBytes, err := json.Marshal(entry)
+// WriteManifest records the live SSTables of every level, one per line as the
+// level number followed by the file name. levels[0] lists the L0 tables oldest
+// first and deeper levels list theirs in key order. The manifest is written
+// to a temporary file and renamed into place so a crash never leaves a
+// partially written manifest behind. Flushes and compactions
+// write it only once their new SSTables are synced, and delete superseded
+// files only after it is updated.
+func (ssm SSTableFileSystemManager) WriteManifest(levels [][]string) error {
+	if ssm.ReadOnly {
+		return ErrReadOnly
+	}
+	manifestPath := filepath.Join(ssm.DataDir, ManifestFileName)
+	tmpPath := manifestPath + ".tmp"
+	file, err := os.Create(tmpPath)
 	if err != nil {
-		return "", err
+		ssm.logger().Errorf("Error creating manifest file: %v", err)
+		return err
 	}
 
-	// Encode the JSON bytes to base64
-	base64Str := base64.StdEncoding.EncodeToString(jsonBytes)
+	writer := bufio.NewWriter(file)
+	for level, fileNames := range levels {
+		for _, fileName := range fileNames {
+			fmt.Fprintf(writer, "%d %s\n", level, fileName)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := ssm.syncFile(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, manifestPath); err != nil {
+		return fmt.Errorf("failed to rename manifest: %w", err)
+	}
+	return ssm.syncDataDir()
+}
 
-	return base64Str, nil
+// syncDir syncs the directory dirPath so renames into it survive a crash.
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to open data directory: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync data directory: %w", err)
+	}
+	return nil
 }
 
-func deserializeFromBase64(base64Str string) (Entry, error) {
-	// Decode the base64-encoded string
-	jsonBytes, err := base64.StdEncoding.DecodeString(base64Str)
+// ReadManifest returns the SSTables of each level recorded by WriteManifest.
+// Lines holding only a file name, written before levels existed, belong to L0.
+// For a data directory without a manifest every SSTable file in it is
+// recovered into L0.
+func (ssm SSTableFileSystemManager) ReadManifest() ([][]string, error) {
+	file, err := os.Open(filepath.Join(ssm.DataDir, ManifestFileName))
+	if os.IsNotExist(err) {
+		return ssm.recoverSSTables()
+	}
 	if err != nil {
-		return Entry{}, err
+		ssm.logger().Errorf("Error opening manifest file: %v", err)
+		return nil, err
 	}
+	defer file.Close()
+
+	levels := [][]string{{}}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		level, fileName := 0, line
+		if prefix, rest, found := strings.Cut(line, " "); found {
+			level, err = strconv.Atoi(prefix)
+			if err != nil || level < 0 {
+				return nil, fmt.Errorf("failed to read manifest: invalid level in line %q", line)
+			}
+			fileName = rest
+		}
+		for len(levels) <= level {
+			levels = append(levels, []string{})
+		}
+		levels[level] = append(levels[level], fileName)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return levels, nil
+}
 
-	// Unmarshal the JSON bytes into an Entry struct
-	var entry Entry
-	err = json.Unmarshal(jsonBytes, &entry)
+// recoverSSTables rebuilds the SSTable list of a data directory written before
+// the manifest existed, ordering the files by the id in their names, which
+// grows with every flush. Files whose header or index cannot be read, such as
+// one a crash left half written, are skipped.
+func (ssm SSTableFileSystemManager) recoverSSTables() ([][]string, error) {
+	fileNames, err := ssm.ListFiles()
 	if err != nil {
-		return Entry{}, err
+		return nil, err
 	}
 
-	return entry, nil
+	recovered := []string{}
+	for _, fileName := range fileNames {
+		if _, ok := sstableID(fileName); !ok {
+			ssm.logger().Warnf("Skipping unrecognized file %s during recovery", fileName)
+			continue
+		}
+		if err := ssm.verifyTable(fileName); err != nil {
+			ssm.logger().Warnf("Skipping unreadable SSTable %s during recovery: %v", fileName, err)
+			continue
+		}
+		recovered = append(recovered, fileName)
+	}
+	sort.Slice(recovered, func(i, j int) bool {
+		a, _ := sstableID(recovered[i])
+		b, _ := sstableID(recovered[j])
+		return a < b
+	})
+	if len(recovered) > 0 {
+		ssm.logger().Infof("Recovered %d SSTables without a manifest", len(recovered))
+	}
+	return [][]string{recovered}, nil
+}
+
+// verifyTable checks that the header and index of fileName can be read and
+// match their checksums.
+func (ssm SSTableFileSystemManager) verifyTable(fileName string) error {
+	file, err := os.Open(filepath.Join(ssm.DataDir, fileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = ssm.tableMeta(fileName, file)
+	return err
 }
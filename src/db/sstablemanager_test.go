@@ -2,11 +2,17 @@ package db
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestReadAfterWrite(t *testing.T) {
@@ -97,6 +103,70 @@ func TestWriteStringsError(t *testing.T) {
 	}
 }
 
+// failingWriter fails every write once limit bytes have gone through it.
+type failingWriter struct {
+	w     io.Writer
+	limit int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.limit {
+		n, _ := fw.w.Write(p[:fw.limit])
+		fw.limit = 0
+		return n, errors.New("injected write failure")
+	}
+	fw.limit -= len(p)
+	return fw.w.Write(p)
+}
+
+func TestWriteFailureLeavesNoPartialFile(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testWriteFailureLeavesNoPartialFile")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	manager, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	ssm := manager.(*SSTableFileSystemManager)
+
+	data := make([]Entry, 1000)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%04d", i), Value: []byte(fmt.Sprintf("value_%04d", i))}
+	}
+	if err := ssm.Write("existing.sst", data[:10]); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	// Fail partway through the blocks, both for a new file and for one that
+	// replaces an existing file
+	ssm.wrapWriter = func(w io.Writer) io.Writer {
+		return &failingWriter{w: w, limit: 8 << 10}
+	}
+	for _, fileName := range []string{"new.sst", "existing.sst"} {
+		err := ssm.Write(fileName, data)
+		if err == nil || !strings.Contains(err.Error(), "injected write failure") {
+			t.Fatalf("expected the injected write failure, got: %v", err)
+		}
+	}
+
+	dirEntries, err := os.ReadDir(dataDir)
+	if err != nil {
+		t.Fatalf("error reading data directory: %s", err)
+	}
+	if len(dirEntries) != 1 || dirEntries[0].Name() != "existing.sst" {
+		t.Fatalf("expected only existing.sst in the data directory, got: %v", dirEntries)
+	}
+	entries, err := ssm.ReadAll("existing.sst")
+	if err != nil || len(entries) != 10 {
+		t.Fatalf("expected existing.sst to keep its 10 entries, got: %d, %v", len(entries), err)
+	}
+}
+
 func TestReadAllError(t *testing.T) {
 	currentTestDir, err := os.Getwd()
 	if err != nil {
@@ -157,7 +227,7 @@ func TestLargeFileWriteAndRead(t *testing.T) {
 
 	for i, item := range readData {
 		if item.Key != largeData[i].Key || !bytes.Equal(item.Value, largeData[i].Value) {
-			t.Fatalf("mismatch at index %d: expected %s, got %s", i, largeData[i], item)
+			t.Fatalf("mismatch at index %d: expected %v, got %v", i, largeData[i], item)
 		}
 	}
 }
@@ -195,7 +265,7 @@ func TestFileWriteAndFindKey(t *testing.T) {
 	}
 
 	if returnedValue.Key != "data_100" || !bytes.Equal(returnedValue.Value, []byte("value_100")) {
-		t.Fatalf("expected %s, got %s", "data_100", returnedValue)
+		t.Fatalf("expected %s, got %v", "data_100", returnedValue)
 	}
 }
 
@@ -231,8 +301,8 @@ func TestFileWriteAndFindKeyForNonExistingKey(t *testing.T) {
 		t.Fatalf("expecting error!")
 	}
 
-	if err.Error() != "key not found: asdf" {
-		t.Fatalf("expecting error: key not found: asdf, got: %s", err)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expecting ErrNotFound, got: %s", err)
 	}
 }
 
@@ -243,3 +313,960 @@ func deleteDirectoryIfExists(dirPath string) error {
 	}
 	return nil
 }
+
+func TestFindKeyUsesBloomFilter(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testFindKeyUsesBloomFilter")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:                dataDir,
+		Logger:                 logger,
+		BloomFalsePositiveRate: 0.0001,
+	})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	data := make([]Entry, 1000)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%d", i), Value: []byte(fmt.Sprintf("value_%d", i))}
+	}
+
+	fileName := "bloom.sst"
+	err = ssm.Write(fileName, data)
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dataDir, fileName), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file)
+	if err != nil {
+		t.Fatalf("error reading header: %s", err)
+	}
+	if header.Version != SSTableVersion {
+		t.Fatalf("expected version %d, got: %d", SSTableVersion, header.Version)
+	}
+	if header.BloomFilterOffset <= header.IndexOffset {
+		t.Fatalf("expected bloom filter after index at %d, got: %d", header.IndexOffset, header.BloomFilterOffset)
+	}
+
+	for _, entry := range data {
+		if _, err := ssm.FindKey(fileName, entry.Key); err != nil {
+			t.Fatalf("error finding key %s: %s", entry.Key, err)
+		}
+	}
+
+	// Clobber the index. A miss must now be answered by the filter alone.
+	_, err = file.WriteAt([]byte{0xFF, 0xFF, 0xFF, 0xFF}, int64(header.IndexOffset))
+	if err != nil {
+		t.Fatalf("error corrupting index: %s", err)
+	}
+
+	_, err = ssm.FindKey(fileName, "asdf")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expecting ErrNotFound for asdf, got: %v", err)
+	}
+}
+
+func TestReadVersion1File(t *testing.T) {
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	// testdata/sstable_v1.sst was written before Bloom filters were added and
+	// holds key00..key49 with values value00..value49.
+	ssm := SSTableFileSystemManager{DataDir: "testdata", Logger: logger}
+	fileName := "sstable_v1.sst"
+
+	dataRead, err := ssm.ReadAll(fileName)
+	if err != nil {
+		t.Fatalf("error reading file: %s", err)
+	}
+	if len(dataRead) != 50 {
+		t.Fatalf("expected data length %d, got: %d", 50, len(dataRead))
+	}
+
+	returnedValue, err := ssm.FindKey(fileName, "key25")
+	if err != nil {
+		t.Fatalf("error finding key: %s", err)
+	}
+	if !bytes.Equal(returnedValue.Value, []byte("value25")) {
+		t.Fatalf("expected %s, got %s", "value25", returnedValue.Value)
+	}
+
+	_, err = ssm.FindKey(fileName, "asdf")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expecting ErrNotFound for asdf, got: %v", err)
+	}
+}
+
+func TestFileHeaderMagicAndVersion(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testFileHeaderMagicAndVersion")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	var future bytes.Buffer
+	if err := writeFileHeader(&future, FileHeader{Version: SSTableVersion + 1}); err != nil {
+		t.Fatalf("error writing header: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		contents      []byte
+		expected      error
+		expectedError string
+	}{
+		{"random", random, ErrNotSSTable, "not a goatdb sstable"},
+		{"empty", []byte{}, ErrNotSSTable, "not a goatdb sstable"},
+		{"future", future.Bytes(), ErrUnsupportedSSTableVersion, fmt.Sprintf("unsupported sstable version %d", SSTableVersion+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := tt.name + ".sst"
+			if err := os.WriteFile(filepath.Join(dataDir, fileName), tt.contents, 0644); err != nil {
+				t.Fatalf("error writing file: %s", err)
+			}
+			_, err := ssm.ReadAll(fileName)
+			if !errors.Is(err, tt.expected) || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Fatalf("expected %q, got: %v", tt.expectedError, err)
+			}
+			_, err = ssm.FindKey(fileName, "key")
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got: %v", tt.expected, err)
+			}
+		})
+	}
+
+	// Files from before the magic number are still read by their version
+	file, err := os.Open(filepath.Join("testdata", "sstable_v1.sst"))
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	defer file.Close()
+	header, err := readFileHeader(file)
+	if err != nil {
+		t.Fatalf("error reading header: %s", err)
+	}
+	if header.Version != 1 || header.EntryCount != 50 {
+		t.Fatalf("expected a version 1 header of 50 entries, got: %+v", header)
+	}
+}
+
+func TestKeysWithSeparators(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testKeysWithSeparators")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	// Sorted so the expected order matches what Write produces
+	data := []Entry{
+		{Key: "\nleading newline", Value: []byte("value1")},
+		{Key: ",leading comma", Value: []byte("value2")},
+		{Key: "a,b", Value: []byte("value3")},
+		{Key: "a,b,c", Value: []byte("value4")},
+		{Key: "line\nbreak", Value: []byte("value5")},
+		{Key: "plain", Value: []byte("value6")},
+		{Key: "trailing,", Value: []byte("value7")},
+	}
+
+	fileName := "separators.sst"
+	err = ssm.Write(fileName, append([]Entry{}, data...))
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	dataRead, err := ssm.ReadAll(fileName)
+	if err != nil {
+		t.Fatalf("error reading file: %s", err)
+	}
+	if len(dataRead) != len(data) {
+		t.Fatalf("expected data length %d, got: %d", len(data), len(dataRead))
+	}
+	for i, item := range dataRead {
+		if item.Key != data[i].Key || !bytes.Equal(item.Value, data[i].Value) {
+			t.Fatalf("mismatch at index %d: expected %v, got %v", i, data[i], item)
+		}
+	}
+
+	blockData, err := ssm.ReadBlock(fileName, uint64(fileHeaderSize(SSTableVersion)))
+	if err != nil {
+		t.Fatalf("error reading block: %s", err)
+	}
+	if len(blockData) != len(data) {
+		t.Fatalf("expected block length %d, got: %d", len(data), len(blockData))
+	}
+
+	for _, entry := range data {
+		returnedValue, err := ssm.FindKey(fileName, entry.Key)
+		if err != nil {
+			t.Fatalf("error finding key %q: %s", entry.Key, err)
+		}
+		if !bytes.Equal(returnedValue.Value, entry.Value) {
+			t.Fatalf("expected %s for key %q, got %s", entry.Value, entry.Key, returnedValue.Value)
+		}
+	}
+
+	_, err = ssm.FindKey(fileName, "a")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expecting ErrNotFound for a, got: %v", err)
+	}
+}
+
+func TestMayContain(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testMayContain")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:                dataDir,
+		Logger:                 logger,
+		BloomFalsePositiveRate: 0.0001,
+	})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	data := make([]Entry, 1000)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%d", i), Value: []byte(fmt.Sprintf("value_%d", i))}
+	}
+
+	fileName := "maycontain.sst"
+	err = ssm.Write(fileName, data)
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	for _, entry := range data {
+		mayContain, err := ssm.MayContain(fileName, entry.Key)
+		if err != nil {
+			t.Fatalf("error checking key %s: %s", entry.Key, err)
+		}
+		if !mayContain {
+			t.Fatalf("expected file to contain key %s", entry.Key)
+		}
+	}
+
+	mayContain, err := ssm.MayContain(fileName, "asdf")
+	if err != nil {
+		t.Fatalf("error checking key asdf: %s", err)
+	}
+	if mayContain {
+		t.Fatalf("expected file not to contain key asdf")
+	}
+
+	// Files without a filter cannot rule anything out
+	v1 := SSTableFileSystemManager{DataDir: "testdata", Logger: logger}
+	mayContain, err = v1.MayContain("sstable_v1.sst", "asdf")
+	if err != nil {
+		t.Fatalf("error checking key asdf: %s", err)
+	}
+	if !mayContain {
+		t.Fatalf("expected version 1 file to report it may contain any key")
+	}
+}
+
+func TestScan(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testScan")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	mgr, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	ssm := mgr.(*SSTableFileSystemManager)
+
+	// 1000 entries make 10 blocks of 100
+	data := make([]Entry, 1000)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%04d", i), Value: []byte(fmt.Sprintf("value_%d", i))}
+	}
+
+	fileName := "scan.sst"
+	err = ssm.Write(fileName, data)
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	// Starts in the middle of the second block and ends in the fifth
+	entries, err := ssm.Scan(fileName, "data_0150", "data_0420")
+	if err != nil {
+		t.Fatalf("error scanning file: %s", err)
+	}
+	if len(entries) != 270 {
+		t.Fatalf("expected %d entries, got: %d", 270, len(entries))
+	}
+	for i, entry := range entries {
+		expected := data[150+i]
+		if entry.Key != expected.Key || !bytes.Equal(entry.Value, expected.Value) {
+			t.Fatalf("mismatch at index %d: expected %v, got %v", i, expected, entry)
+		}
+	}
+
+	// Keys that are not stored still bound the range
+	entries, err = ssm.Scan(fileName, "data_0099a", "data_0101a")
+	if err != nil {
+		t.Fatalf("error scanning file: %s", err)
+	}
+	if len(entries) != 2 || entries[0].Key != "data_0100" || entries[1].Key != "data_0101" {
+		t.Fatalf("expected data_0100 and data_0101, got: %v", entries)
+	}
+
+	// An empty end key scans to the end of the file
+	entries, err = ssm.Scan(fileName, "data_0990", "")
+	if err != nil {
+		t.Fatalf("error scanning file: %s", err)
+	}
+	if len(entries) != 10 {
+		t.Fatalf("expected %d entries, got: %d", 10, len(entries))
+	}
+
+	for _, r := range [][2]string{{"data_0500", "data_0500"}, {"a", "b"}, {"x", "y"}, {"data_0600", "data_0500"}} {
+		entries, err = ssm.Scan(fileName, r[0], r[1])
+		if err != nil {
+			t.Fatalf("error scanning file: %s", err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("expected empty range [%s, %s), got %d entries", r[0], r[1], len(entries))
+		}
+	}
+}
+
+func TestBinaryKeysAndValues(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testBinaryKeysAndValues")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	// binaryTestEntries is sorted by key
+	data := binaryTestEntries()
+	fileName := "binary.sst"
+	err = ssm.Write(fileName, append([]Entry{}, data...))
+	if err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	dataRead, err := ssm.ReadAll(fileName)
+	if err != nil {
+		t.Fatalf("error reading file: %s", err)
+	}
+	if len(dataRead) != len(data) {
+		t.Fatalf("expected data length %d, got: %d", len(data), len(dataRead))
+	}
+	for i, item := range dataRead {
+		if item.Key != data[i].Key || !bytes.Equal(item.Value, data[i].Value) || item.Tombstone != data[i].Tombstone {
+			t.Fatalf("mismatch at index %d: expected %v, got %v", i, data[i], item)
+		}
+	}
+
+	for _, entry := range data {
+		returnedValue, err := ssm.FindKey(fileName, entry.Key)
+		if err != nil {
+			t.Fatalf("error finding key %q: %s", entry.Key, err)
+		}
+		if !bytes.Equal(returnedValue.Value, entry.Value) || returnedValue.Tombstone != entry.Tombstone {
+			t.Fatalf("expected %v for key %q, got %v", entry, entry.Key, returnedValue)
+		}
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testChecksumMismatch")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	data := make([]Entry, 250)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%03d", i), Value: []byte(fmt.Sprintf("value_%03d", i))}
+	}
+
+	// flipByte writes a fresh file and inverts the byte at the offset returned by offsetOf
+	flipByte := func(t *testing.T, fileName string, offsetOf func(header FileHeader) int64) {
+		if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+			t.Fatalf("error writing file: %s", err)
+		}
+		file, err := os.OpenFile(filepath.Join(dataDir, fileName), os.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("error opening file: %s", err)
+		}
+		defer file.Close()
+		header, err := readFileHeader(file)
+		if err != nil {
+			t.Fatalf("error reading header: %s", err)
+		}
+		offset := offsetOf(header)
+		b := make([]byte, 1)
+		if _, err := file.ReadAt(b, offset); err != nil {
+			t.Fatalf("error reading byte: %s", err)
+		}
+		b[0] ^= 0xFF
+		if _, err := file.WriteAt(b, offset); err != nil {
+			t.Fatalf("error corrupting file: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name            string
+		offsetOf        func(header FileHeader) int64
+		expectedSection string
+		expectedError   string
+	}{
+		// Byte 8 is the first byte of CreationTimestamp, after the magic and version
+		{"header", func(header FileHeader) int64 { return 8 }, "header", "header checksum mismatch"},
+		// The first index entry's start key follows the count and key length
+		{"index", func(header FileHeader) int64 { return int64(header.IndexOffset) + 8 }, "index", "index checksum mismatch"},
+		// A mangled index count must not be trusted to size the index
+		{"index_count", func(header FileHeader) int64 { return int64(header.IndexOffset) }, "index", "index count"},
+		// A mangled key length must not be trusted to size the key
+		{"index_key_length", func(header FileHeader) int64 { return int64(header.IndexOffset) + 4 }, "index", "key length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := tt.name + ".sst"
+			flipByte(t, fileName, tt.offsetOf)
+
+			_, err := ssm.ReadAll(fileName)
+			checkCorruption(t, err, tt.expectedSection, tt.expectedError)
+
+			_, err = ssm.FindKey(fileName, "data_000")
+			checkCorruption(t, err, tt.expectedSection, tt.expectedError)
+
+			_, err = ssm.ReadBlock(fileName, uint64(fileHeaderSize(SSTableVersion)))
+			if tt.expectedSection == "header" {
+				checkCorruption(t, err, tt.expectedSection, tt.expectedError)
+			}
+		})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		offset := fileHeaderSize(SSTableVersion)
+		// The first byte of the block's in-block index count
+		flipByte(t, "block.sst", func(header FileHeader) int64 { return offset + BlockHeaderSize + 4 })
+
+		_, err := ssm.ReadAll("block.sst")
+		checkCorruption(t, err, "block", "block checksum mismatch")
+		_, err = ssm.ReadBlock("block.sst", uint64(offset))
+		checkCorruption(t, err, "block", "block checksum mismatch")
+	})
+
+	// The offset of the next block is not covered by a checksum, but must
+	// neither lead back to the same block nor past the index
+	for name, nextOf := range map[string]func(offset int64, header FileHeader) uint64{
+		"next_block_loop":     func(offset int64, header FileHeader) uint64 { return uint64(offset) },
+		"next_block_past_end": func(offset int64, header FileHeader) uint64 { return header.IndexOffset + 1 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			fileName := name + ".sst"
+			offset := fileHeaderSize(SSTableVersion)
+			if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+				t.Fatalf("error writing file: %s", err)
+			}
+			file, err := os.OpenFile(filepath.Join(dataDir, fileName), os.O_RDWR, 0)
+			if err != nil {
+				t.Fatalf("error opening file: %s", err)
+			}
+			defer file.Close()
+			header, err := readFileHeader(file)
+			if err != nil {
+				t.Fatalf("error reading header: %s", err)
+			}
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, nextOf(offset, header))
+			// NextBlockOffset follows the entry count, size and checksum
+			if _, err := file.WriteAt(b, offset+12); err != nil {
+				t.Fatalf("error corrupting file: %s", err)
+			}
+
+			_, err = ssm.ReadAll(fileName)
+			checkCorruption(t, err, "block", "next block offset")
+		})
+	}
+}
+
+// checkCorruption fails unless err is a CorruptionError for section whose
+// message contains expectedError.
+func checkCorruption(t *testing.T, err error, section string, expectedError string) {
+	t.Helper()
+	if !errors.Is(err, ErrCorruptSSTable) {
+		t.Fatalf("expected %v, got: %v", ErrCorruptSSTable, err)
+	}
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) || corruption.Section != section {
+		t.Fatalf("expected corruption of the %s, got: %v", section, err)
+	}
+	if !strings.Contains(err.Error(), expectedError) {
+		t.Fatalf("expected error: %s, got: %v", expectedError, err)
+	}
+}
+
+func TestFindKeyAtFileBoundaries(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testFindKeyAtFileBoundaries")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	for _, count := range []int{1, 100, 300} {
+		data := make([]Entry, count)
+		for i := range data {
+			data[i] = Entry{Key: fmt.Sprintf("data_%04d", i+1), Value: []byte(fmt.Sprintf("value_%04d", i+1))}
+		}
+		fileName := fmt.Sprintf("boundaries_%d.sst", count)
+		if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+			t.Fatalf("error writing file: %s", err)
+		}
+
+		for _, entry := range []Entry{data[0], data[len(data)-1]} {
+			returnedValue, err := ssm.FindKey(fileName, entry.Key)
+			if err != nil {
+				t.Fatalf("error finding key %s in %s: %s", entry.Key, fileName, err)
+			}
+			if !bytes.Equal(returnedValue.Value, entry.Value) {
+				t.Fatalf("expected %s, got: %s", entry.Value, returnedValue.Value)
+			}
+		}
+
+		// Keys sorting before and after every stored key are reported as missing
+		for _, key := range []string{"data_0000", "a", "data_9999", "z"} {
+			_, err := ssm.FindKey(fileName, key)
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound for %s, got: %v", key, err)
+			}
+		}
+	}
+}
+
+func TestFindKeyInEveryBlock(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testFindKeyInEveryBlock")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	// 350 entries make three full blocks and a partial one
+	data := make([]Entry, 350)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%04d", i), Value: []byte(fmt.Sprintf("value_%04d", i))}
+	}
+	fileName := "blocks.sst"
+	if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	for _, entry := range data {
+		returnedValue, err := ssm.FindKey(fileName, entry.Key)
+		if err != nil {
+			t.Fatalf("error finding key %s: %s", entry.Key, err)
+		}
+		if !bytes.Equal(returnedValue.Value, entry.Value) {
+			t.Fatalf("expected %s for key %s, got: %s", entry.Value, entry.Key, returnedValue.Value)
+		}
+	}
+
+	// Keys falling between stored keys are missing, whichever block they land in
+	for _, key := range []string{"data_0049a", "data_0099a", "data_0199a", "data_0349a"} {
+		_, err := ssm.FindKey(fileName, key)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for %s, got: %v", key, err)
+		}
+	}
+}
+
+func TestIndexOfPartialTailBlock(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testIndexOfPartialTailBlock")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, BlockEntries: 100})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	// One full block and a 50-entry tail
+	data := make([]Entry, 150)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%04d", i), Value: []byte(fmt.Sprintf("value_%04d", i))}
+	}
+	fileName := "tail.sst"
+	if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	file, err := os.Open(filepath.Join(dataDir, fileName))
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	defer file.Close()
+	header, err := readFileHeader(file)
+	if err != nil {
+		t.Fatalf("error reading header: %s", err)
+	}
+	index, err := readIndex(file, header)
+	if err != nil {
+		t.Fatalf("error reading index: %s", err)
+	}
+	if len(index) != 2 {
+		t.Fatalf("expected %d index entries, got: %d", 2, len(index))
+	}
+	if index[1].StartKey != data[100].Key || index[1].EndKey != data[149].Key {
+		t.Fatalf("expected tail block range [%s, %s], got: [%s, %s]", data[100].Key, data[149].Key, index[1].StartKey, index[1].EndKey)
+	}
+
+	for _, entry := range data[100:] {
+		returnedValue, err := ssm.FindKey(fileName, entry.Key)
+		if err != nil {
+			t.Fatalf("error finding key %s: %s", entry.Key, err)
+		}
+		if !bytes.Equal(returnedValue.Value, entry.Value) {
+			t.Fatalf("expected %s for key %s, got: %s", entry.Value, entry.Key, returnedValue.Value)
+		}
+	}
+}
+
+func TestFindKeyInLargeBlock(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testFindKeyInLargeBlock")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:        dataDir,
+		Logger:         logger,
+		BlockSize:      1 << 20,
+		BlockEntries:   10000,
+		BlockCacheSize: -1,
+	})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	data := make([]Entry, 10000)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%05d", i), Value: []byte(fmt.Sprintf("value_%05d", i))}
+	}
+	fileName := "large_block.sst"
+	if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	for i := 0; i < len(data); i += 7 {
+		entry, err := ssm.FindKey(fileName, data[i].Key)
+		if err != nil {
+			t.Fatalf("error finding key %s: %s", data[i].Key, err)
+		}
+		if !bytes.Equal(entry.Value, data[i].Value) {
+			t.Fatalf("expected %s, got: %s", data[i].Value, entry.Value)
+		}
+	}
+	for _, key := range []string{"data", "data_00000a", "data_99999"} {
+		if _, err := ssm.FindKey(fileName, key); err == nil {
+			t.Fatalf("expected key %s to be missing", key)
+		}
+	}
+
+	// The file holds one block, and a lookup only reads the chunk of it that
+	// can hold the key: corrupting the last chunk leaves the first readable
+	file, err := os.OpenFile(filepath.Join(dataDir, fileName), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	defer file.Close()
+	chunks, err := readBlockChunks(file, uint64(fileHeaderSize(SSTableVersion)), SSTableVersion)
+	if err != nil {
+		t.Fatalf("error reading block index: %s", err)
+	}
+	if len(chunks) != len(data)/blockIndexInterval+1 {
+		t.Fatalf("expected %d chunks, got: %d", len(data)/blockIndexInterval+1, len(chunks))
+	}
+	last := chunks[len(chunks)-1]
+	if _, err := file.WriteAt([]byte{0xFF, 0xFF}, int64(last.offset)); err != nil {
+		t.Fatalf("error corrupting chunk: %s", err)
+	}
+
+	if _, err := ssm.FindKey(fileName, data[0].Key); err != nil {
+		t.Fatalf("expected the first chunk to be readable, got: %s", err)
+	}
+	_, err = ssm.FindKey(fileName, data[len(data)-1].Key)
+	if err == nil || !strings.Contains(err.Error(), "block checksum mismatch") {
+		t.Fatalf("expected block checksum mismatch, got: %v", err)
+	}
+}
+
+func TestManifestLevels(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testManifestLevels")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	levels := [][]string{{"sstable_4.sst", "sstable_5.sst"}, {}, {"sstable_1.sst", "sstable_2.sst"}}
+	if err := ssm.WriteManifest(levels); err != nil {
+		t.Fatalf("error writing manifest: %v", err)
+	}
+	manifest, err := ssm.ReadManifest()
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	if fmt.Sprint(manifest) != fmt.Sprint(levels) {
+		t.Fatalf("expected manifest %v, got: %v", levels, manifest)
+	}
+
+	// Manifests written before levels existed list L0 file names only
+	err = os.WriteFile(filepath.Join(dataDir, ManifestFileName), []byte("sstable_1.sst\nsstable_2.sst\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing manifest: %v", err)
+	}
+	manifest, err = ssm.ReadManifest()
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	if len(manifest) != 1 || fmt.Sprint(manifest[0]) != "[sstable_1.sst sstable_2.sst]" {
+		t.Fatalf("expected the old manifest to be read as L0, got: %v", manifest)
+	}
+}
+
+func TestBlockSizeHonored(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testBlockSizeHonored")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	const blockSize = 2048
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, BlockSize: blockSize})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	// Values from empty to a quarter of a block, and one record larger than a
+	// whole block, which has to get a block of its own
+	random := rand.New(rand.NewSource(1))
+	data := make([]Entry, 500)
+	maxRecord := 0
+	for i := range data {
+		value := make([]byte, random.Intn(blockSize/4))
+		random.Read(value)
+		data[i] = Entry{Key: fmt.Sprintf("data_%04d", i), Value: value, SequenceNumber: uint64(i + 1)}
+		if size := encodedEntrySize(data[i]); size > maxRecord {
+			maxRecord = size
+		}
+	}
+	data[250].Value = make([]byte, 3*blockSize)
+	fileName := "block_size.sst"
+	if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	file, err := os.Open(filepath.Join(dataDir, fileName))
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	defer file.Close()
+	header, err := readFileHeader(file)
+	if err != nil {
+		t.Fatalf("error reading header: %s", err)
+	}
+	if header.BlockSize != blockSize {
+		t.Fatalf("expected the header to record a block size of %d, got: %d", blockSize, header.BlockSize)
+	}
+	index, err := readIndex(file, header)
+	if err != nil {
+		t.Fatalf("error reading index: %s", err)
+	}
+
+	total := 0
+	for i, block := range index {
+		var blockHeader BlockHeader
+		if _, err := file.Seek(int64(block.BlockOffset), io.SeekStart); err != nil {
+			t.Fatalf("error seeking to block: %s", err)
+		}
+		if err := binary.Read(file, binary.BigEndian, &blockHeader); err != nil {
+			t.Fatalf("error reading block header: %s", err)
+		}
+		entries, err := ssm.ReadBlock(fileName, block.BlockOffset)
+		if err != nil {
+			t.Fatalf("error reading block %d: %s", i, err)
+		}
+		if int(blockHeader.EntryCount) != len(entries) {
+			t.Fatalf("block %d: header counts %d entries, holds %d", i, blockHeader.EntryCount, len(entries))
+		}
+		total += len(entries)
+
+		size := 0
+		for _, entry := range entries {
+			size += encodedEntrySize(entry)
+		}
+		if len(entries) == 1 && entries[0].Key == data[250].Key {
+			continue
+		}
+		if size > blockSize {
+			t.Fatalf("block %d: %d encoded bytes exceed the block size of %d", i, size, blockSize)
+		}
+		// A block is only cut early when the next record would not fit, or
+		// when the next record is the oversized one
+		last := i == len(index)-1 || index[i+1].StartKey == data[250].Key
+		if !last && size < blockSize-maxRecord {
+			t.Fatalf("block %d: %d encoded bytes, expected at least %d", i, size, blockSize-maxRecord)
+		}
+	}
+	if total != len(data) {
+		t.Fatalf("expected %d entries across the blocks, got: %d", len(data), total)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testDescribe")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, BlockSize: 1024, CompressionCodec: CompressionGzip})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	data := make([]Entry, 200)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%04d", i), Value: []byte(fmt.Sprintf("value_%04d", i)), SequenceNumber: uint64(i + 1)}
+	}
+	fileName := "describe.sst"
+	before := time.Now().Truncate(time.Second)
+	if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+
+	blocks, err := ssm.(blockLister).Blocks(fileName)
+	if err != nil {
+		t.Fatalf("error reading blocks: %s", err)
+	}
+	if len(blocks) < 2 {
+		t.Fatalf("expected several blocks, got %d", len(blocks))
+	}
+	size, err := ssm.Size(fileName)
+	if err != nil {
+		t.Fatalf("error reading size: %s", err)
+	}
+
+	info, err := ssm.Describe(fileName)
+	if err != nil {
+		t.Fatalf("error describing file: %s", err)
+	}
+	expected := SSTableInfo{
+		FileName:    fileName,
+		Version:     SSTableVersion,
+		EntryCount:  uint64(len(data)),
+		MinKey:      data[0].Key,
+		MaxKey:      data[len(data)-1].Key,
+		Size:        size,
+		CreatedAt:   info.CreatedAt,
+		Compression: CompressionGzip,
+	}
+	if info != expected {
+		t.Errorf("expected %+v, got %+v", expected, info)
+	}
+	if info.CreatedAt.Before(before) || info.CreatedAt.After(time.Now()) {
+		t.Errorf("expected a creation time after %v, got %v", before, info.CreatedAt)
+	}
+
+	if _, err := ssm.Describe("missing.sst"); err == nil {
+		t.Errorf("expected an error describing a missing file")
+	}
+}
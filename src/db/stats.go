@@ -0,0 +1,122 @@
+package db
+
+import "sync/atomic"
+
+// Stats is a snapshot of the state of the database and of the operations it
+// served since it was opened. SSTables counts the tables of every level and
+// LevelSSTables breaks it down by level, L0 first. The cache hit ratios are
+// zero when the SSTable manager has no caches or none were used yet.
+type Stats struct {
+	MemtableEntries    int
+	MemtableBytes      int64
+	ImmutableMemtables int
+	SSTables           int
+	LevelSSTables      []int
+	SSTableBytes       int64
+	Puts               uint64
+	Gets               uint64
+	Deletes            uint64
+	Flushes            uint64
+	Compactions        uint64
+	BlockCacheHitRatio float64
+	TableCacheHitRatio float64
+}
+
+// counters are updated atomically so recording an operation never waits on
+// db.mu.
+type counters struct {
+	puts        atomic.Uint64
+	gets        atomic.Uint64
+	deletes     atomic.Uint64
+	flushes     atomic.Uint64
+	compactions atomic.Uint64
+}
+
+// cacheStatsReporter is implemented by SSTable managers that cache blocks and
+// table indexes, such as SSTableFileSystemManager.
+type cacheStatsReporter interface {
+	BlockCacheStats() (hits uint64, misses uint64)
+	TableCacheStats() (hits uint64, misses uint64)
+}
+
+// Stats returns the current memtable and SSTable counts along with the
+// cumulative operation counters. The SSTable sizes are read after db.mu is
+// released, with the tables pinned so compactions cannot delete them.
+func (db *LSM) Stats() Stats {
+	db.mu.RLock()
+	stats := Stats{
+		MemtableEntries:    db.Memtable.Len(),
+		MemtableBytes:      db.Memtable.Size(),
+		ImmutableMemtables: len(db.immutables),
+		LevelSSTables:      []int{len(db.Sstables)},
+	}
+	fileNames := append([]string{}, db.Sstables...)
+	for _, levelFiles := range db.levels {
+		stats.LevelSSTables = append(stats.LevelSSTables, len(levelFiles))
+		fileNames = append(fileNames, levelFiles...)
+	}
+	db.pinSSTables(fileNames...)
+	db.mu.RUnlock()
+	defer db.unpinSSTables(fileNames...)
+
+	stats.SSTables = len(fileNames)
+	for _, fileName := range fileNames {
+		size, err := db.sstableMgr.Size(fileName)
+		if err != nil {
+			db.logger.Errorf("Error in reading size of sstable %s: %v", fileName, err)
+			continue
+		}
+		stats.SSTableBytes += size
+	}
+
+	stats.Puts = db.counters.puts.Load()
+	stats.Gets = db.counters.gets.Load()
+	stats.Deletes = db.counters.deletes.Load()
+	stats.Flushes = db.counters.flushes.Load()
+	stats.Compactions = db.counters.compactions.Load()
+	if caches, ok := db.sstableMgr.(cacheStatsReporter); ok {
+		stats.BlockCacheHitRatio = hitRatio(caches.BlockCacheStats())
+		stats.TableCacheHitRatio = hitRatio(caches.TableCacheStats())
+	}
+	return stats
+}
+
+// SSTables describes every live SSTable, L0 first with the oldest table
+// first, followed by the tables of each level in key order. Like Stats it
+// reads the tables after db.mu is released, with them pinned.
+func (db *LSM) SSTables() ([]SSTableInfo, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrDBClosed
+	}
+	levels := append([][]string{db.Sstables}, db.levels...)
+	fileNames := []string{}
+	for _, levelFiles := range levels {
+		fileNames = append(fileNames, levelFiles...)
+	}
+	db.pinSSTables(fileNames...)
+	db.mu.RUnlock()
+	defer db.unpinSSTables(fileNames...)
+
+	infos := make([]SSTableInfo, 0, len(fileNames))
+	for level, levelFiles := range levels {
+		for _, fileName := range levelFiles {
+			info, err := db.sstableMgr.Describe(fileName)
+			if err != nil {
+				db.logger.Errorf("Error in describing sstable %s: %v", fileName, err)
+				return nil, err
+			}
+			info.Level = level
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+func hitRatio(hits uint64, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
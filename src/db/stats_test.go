@@ -0,0 +1,87 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testStats")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold:      1000,
+		CompactionMinThreshold: 2,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	for table := 0; table < 2; table++ {
+		for i := 0; i < 10; i++ {
+			err := database.Put(Entry{Key: fmt.Sprintf("key%02d", i), Value: []byte(fmt.Sprintf("value%d", table))})
+			if err != nil {
+				t.Fatalf("Failed to put entry: %v", err)
+			}
+		}
+		if err := database.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	if err := database.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := database.Get("key05"); err != nil {
+			t.Fatalf("Failed to get key: %v", err)
+		}
+	}
+
+	if err := database.Delete("key05"); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	if err := database.Delete("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v, got: %v", ErrNotFound, err)
+	}
+
+	stats := database.Stats()
+	if stats.SSTables != 1 || len(stats.LevelSSTables) != 1 || stats.LevelSSTables[0] != 1 {
+		t.Fatalf("expected a single L0 sstable after compaction, got %+v", stats)
+	}
+	size, err := ssm.Size(database.Sstables[0])
+	if err != nil {
+		t.Fatalf("Failed to read sstable size: %v", err)
+	}
+	if stats.SSTableBytes != size {
+		t.Errorf("expected %d sstable bytes, got %d", size, stats.SSTableBytes)
+	}
+	if stats.Puts != 20 || stats.Gets != 2 || stats.Flushes != 2 || stats.Compactions != 1 {
+		t.Errorf("expected 20 puts, 2 gets, 2 flushes and 1 compaction, got %+v", stats)
+	}
+	// A delete of a missing key writes no tombstone and is not counted
+	if stats.Deletes != 1 {
+		t.Errorf("expected 1 delete, got %+v", stats)
+	}
+	// The second get finds the block and the table index already cached
+	if stats.BlockCacheHitRatio <= 0 || stats.BlockCacheHitRatio >= 1 {
+		t.Errorf("expected a block cache hit ratio between 0 and 1, got %f", stats.BlockCacheHitRatio)
+	}
+	if stats.TableCacheHitRatio <= 0 || stats.TableCacheHitRatio >= 1 {
+		t.Errorf("expected a table cache hit ratio between 0 and 1, got %f", stats.TableCacheHitRatio)
+	}
+}
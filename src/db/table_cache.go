@@ -0,0 +1,151 @@
+package db
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultTableCacheSize = 256
+
+// tableMeta is the parsed header and index of one SSTable, along with the
+// in-block indexes of the blocks read so far.
+type tableMeta struct {
+	header FileHeader
+	index  []IndexEntry
+	mu     sync.Mutex
+	chunks map[uint64][]blockChunk
+}
+
+// tableCache keeps the header and index of recently used SSTables in memory so
+// lookups do not parse them from disk every time. It holds at most capacity
+// tables and evicts the least recently used one when full.
+type tableCache struct {
+	mu       sync.Mutex
+	capacity int
+	tables   map[string]*list.Element
+	lru      *list.List
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+}
+
+type tableCacheEntry struct {
+	fileName string
+	meta     *tableMeta
+}
+
+// newTableCache returns a cache for capacity tables, or nil when capacity is
+// not positive, which disables caching.
+func newTableCache(capacity int) *tableCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &tableCache{
+		capacity: capacity,
+		tables:   make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Like bloomFilterCache, a nil cache is valid and never holds anything.
+func (c *tableCache) get(fileName string) (*tableMeta, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.tables[fileName]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*tableCacheEntry).meta, true
+}
+
+func (c *tableCache) put(fileName string, meta *tableMeta) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.tables[fileName]; ok {
+		elem.Value.(*tableCacheEntry).meta = meta
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.tables[fileName] = c.lru.PushFront(&tableCacheEntry{fileName: fileName, meta: meta})
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.tables, oldest.Value.(*tableCacheEntry).fileName)
+	}
+}
+
+func (c *tableCache) remove(fileName string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.tables[fileName]; ok {
+		c.lru.Remove(elem)
+		delete(c.tables, fileName)
+	}
+}
+
+// TableCacheStats reports how many table lookups found the header and index in
+// the table cache and how many had to parse them from disk.
+func (ssm SSTableFileSystemManager) TableCacheStats() (hits uint64, misses uint64) {
+	if ssm.tables == nil {
+		return 0, 0
+	}
+	return ssm.tables.hits.Load(), ssm.tables.misses.Load()
+}
+
+// tableMeta returns the header and index of fileName, parsing them from file
+// and verifying their checksums on a cache miss.
+func (ssm SSTableFileSystemManager) tableMeta(fileName string, file *os.File) (*tableMeta, error) {
+	if meta, ok := ssm.tables.get(fileName); ok {
+		return meta, nil
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("failed to seek to header: %w", err)
+	}
+	header, err := readFileHeader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	index, err := readIndex(file, header)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &tableMeta{header: header, index: index, chunks: make(map[uint64][]blockChunk)}
+	ssm.tables.put(fileName, meta)
+	return meta, nil
+}
+
+// blockChunks returns the in-block index of the block at offset, in a file of
+// version 8 or later, reading it from file the first time the block is used.
+func (meta *tableMeta) blockChunks(file *os.File, offset uint64) ([]blockChunk, error) {
+	meta.mu.Lock()
+	chunks, ok := meta.chunks[offset]
+	meta.mu.Unlock()
+	if ok {
+		return chunks, nil
+	}
+
+	chunks, err := readBlockChunks(file, offset, meta.header.Version)
+	if err != nil {
+		return nil, err
+	}
+	meta.mu.Lock()
+	meta.chunks[offset] = chunks
+	meta.mu.Unlock()
+	return chunks, nil
+}
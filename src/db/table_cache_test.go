@@ -0,0 +1,181 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestTableCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTableCache(2)
+	cache.put("a", &tableMeta{})
+	cache.put("b", &tableMeta{})
+	if _, ok := cache.get("a"); !ok {
+		t.Fatalf("expected a to be cached")
+	}
+
+	// b is now the least recently used table
+	cache.put("c", &tableMeta{})
+	if _, ok := cache.get("b"); ok {
+		t.Fatalf("expected b to be evicted")
+	}
+	for _, fileName := range []string{"a", "c"} {
+		if _, ok := cache.get(fileName); !ok {
+			t.Fatalf("expected %s to be cached", fileName)
+		}
+	}
+
+	cache.remove("a")
+	if _, ok := cache.get("a"); ok {
+		t.Fatalf("expected a to be removed")
+	}
+}
+
+func TestTableCacheInvalidatedOnRewrite(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testTableCacheInvalidatedOnRewrite")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	fileName := "rewrite.sst"
+	for i, value := range []string{"first", "second"} {
+		data := make([]Entry, 150)
+		for j := range data {
+			data[j] = Entry{Key: fmt.Sprintf("%s_%03d", value, j), Value: []byte(value)}
+		}
+		if err := ssm.Write(fileName, data); err != nil {
+			t.Fatalf("error writing file: %s", err)
+		}
+
+		entry, err := ssm.FindKey(fileName, value+"_120")
+		if err != nil {
+			t.Fatalf("write %d: error finding key: %s", i, err)
+		}
+		if string(entry.Value) != value {
+			t.Fatalf("write %d: expected %s, got: %s", i, value, entry.Value)
+		}
+	}
+
+	if err := ssm.Delete(fileName); err != nil {
+		t.Fatalf("error deleting file: %s", err)
+	}
+	if _, ok := ssm.(*SSTableFileSystemManager).tables.get(fileName); ok {
+		t.Fatalf("expected the deleted file to leave the cache")
+	}
+}
+
+func TestConcurrentFindKey(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testConcurrentFindKey")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(io.Discard, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+		DataDir:        dataDir,
+		Logger:         logger,
+		TableCacheSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	// Two files sharing a one-table cache keep evicting each other
+	fileNames := []string{"concurrent_0.sst", "concurrent_1.sst"}
+	for _, fileName := range fileNames {
+		data := make([]Entry, 500)
+		for i := range data {
+			data[i] = Entry{Key: fmt.Sprintf("data_%03d", i), Value: []byte(fileName)}
+		}
+		if err := ssm.Write(fileName, data); err != nil {
+			t.Fatalf("error writing file: %s", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				fileName := fileNames[(g+i)%len(fileNames)]
+				entry, err := ssm.FindKey(fileName, fmt.Sprintf("data_%03d", (g*37+i)%500))
+				if err == nil && string(entry.Value) != fileName {
+					err = fmt.Errorf("expected %s, got: %s", fileName, entry.Value)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent FindKey failed: %s", err)
+	}
+}
+
+func BenchmarkFindKey(b *testing.B) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		b.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".benchmarkFindKey")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(io.Discard, "", 0)
+
+	data := make([]Entry, 10000)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%05d", i), Value: []byte(fmt.Sprintf("value_%05d", i))}
+	}
+
+	for _, bm := range []struct {
+		name           string
+		tableCacheSize int
+	}{
+		{"uncached", -1},
+		{"cached", DefaultTableCacheSize},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			ssm, err := NewFileManagerWithOptions(FileManagerOptions{
+				DataDir:        dataDir,
+				Logger:         logger,
+				TableCacheSize: bm.tableCacheSize,
+			})
+			if err != nil {
+				b.Fatalf("error creating file manager: %s", err)
+			}
+			fileName := "benchmark.sst"
+			if err := ssm.Write(fileName, append([]Entry{}, data...)); err != nil {
+				b.Fatalf("error writing file: %s", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ssm.FindKey(fileName, data[i%len(data)].Key); err != nil {
+					b.Fatalf("error finding key: %s", err)
+				}
+			}
+		})
+	}
+}
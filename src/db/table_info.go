@@ -0,0 +1,103 @@
+package db
+
+import "time"
+
+// TableInfo summarizes an SSTable without reading its data blocks.
+type TableInfo struct {
+	MinKey      string
+	MaxKey      string
+	MinSequence uint64
+	MaxSequence uint64
+}
+
+// TableInfo returns the key and sequence number ranges of fileName, from the
+// footer and header of the file. Files written before version 9 report zero
+// sequence numbers.
+func (ssm SSTableFileSystemManager) TableInfo(fileName string) (TableInfo, error) {
+	stats, header, err := ssm.stat(fileName)
+	if err != nil {
+		return TableInfo{}, withFileName(err, fileName)
+	}
+	return TableInfo{
+		MinKey:      stats.MinKey,
+		MaxKey:      stats.MaxKey,
+		MinSequence: header.MinSequence,
+		MaxSequence: header.MaxSequence,
+	}, nil
+}
+
+// SSTableInfo describes an SSTable for operators. Level is only known to the
+// database and is left zero by SSTableManager.Describe.
+type SSTableInfo struct {
+	FileName    string
+	Level       int
+	Version     int32
+	EntryCount  uint64
+	MinKey      string
+	MaxKey      string
+	Size        int64
+	CreatedAt   time.Time
+	Compression CompressionCodec
+}
+
+// Describe returns the SSTableInfo of fileName from its header and footer, or
+// its header and index for files written before version 12. No data block is
+// read.
+func (ssm SSTableFileSystemManager) Describe(fileName string) (SSTableInfo, error) {
+	stats, header, err := ssm.stat(fileName)
+	if err != nil {
+		return SSTableInfo{}, withFileName(err, fileName)
+	}
+	return SSTableInfo{
+		FileName:    fileName,
+		Version:     stats.Version,
+		EntryCount:  stats.EntryCount,
+		MinKey:      stats.MinKey,
+		MaxKey:      stats.MaxKey,
+		Size:        stats.Size,
+		CreatedAt:   time.Unix(header.CreationTimestamp, 0),
+		Compression: header.Compression,
+	}, nil
+}
+
+// tableInfoOf returns the TableInfo of a table holding entries, which must be
+// sorted by key.
+func tableInfoOf(entries []Entry) TableInfo {
+	if len(entries) == 0 {
+		return TableInfo{}
+	}
+	info := TableInfo{
+		MinKey: entries[0].Key,
+		MaxKey: entries[len(entries)-1].Key,
+	}
+	info.MinSequence, info.MaxSequence = sequenceRange(entries)
+	return info
+}
+
+// covers reports whether key lies within the key range of the table.
+func (info TableInfo) covers(key string) bool {
+	return key >= info.MinKey && key <= info.MaxKey
+}
+
+// overlaps reports whether the key range of the table meets
+// [startKey, endKey). An empty endKey has no upper bound.
+func (info TableInfo) overlaps(startKey string, endKey string) bool {
+	return info.MaxKey >= startKey && (endKey == "" || info.MinKey < endKey)
+}
+
+// sequenceRange returns the lowest and highest sequence number of entries.
+func sequenceRange(entries []Entry) (uint64, uint64) {
+	if len(entries) == 0 {
+		return 0, 0
+	}
+	min, max := entries[0].SequenceNumber, entries[0].SequenceNumber
+	for _, entry := range entries[1:] {
+		if entry.SequenceNumber < min {
+			min = entry.SequenceNumber
+		}
+		if entry.SequenceNumber > max {
+			max = entry.SequenceNumber
+		}
+	}
+	return min, max
+}
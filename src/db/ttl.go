@@ -0,0 +1,25 @@
+package db
+
+import "time"
+
+// ExpiresIn returns the ExpiresAt value for an entry that should expire ttl
+// after now.
+func ExpiresIn(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// expired reports whether the entry has an expiry that is not after now.
+func (e Entry) expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && e.ExpiresAt <= now.UnixNano()
+}
+
+// expireEntries replaces the expired entries in place with tombstones, which
+// still shadow older values of the key but no longer carry the value.
+func expireEntries(entries []Entry, now time.Time) []Entry {
+	for i, entry := range entries {
+		if entry.expired(now) {
+			entries[i] = Entry{Key: entry.Key, Tombstone: true, SequenceNumber: entry.SequenceNumber}
+		}
+	}
+	return entries
+}
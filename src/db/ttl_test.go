@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEntryExpiresInMemtable(t *testing.T) {
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	now := time.Now()
+	database, err := NewDb(Options{
+		MemtableThreshold: 1000,
+		SstableMgr:        &MockSSTableManager{},
+		Logger:            logger,
+		Clock:             func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	err = database.Put(Entry{Key: "key1", Value: []byte("value1"), ExpiresAt: now.Add(time.Minute).UnixNano()})
+	if err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if _, err := database.Get("key1"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := database.Get("key1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+	entries, err := database.Scan("", "", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no entries, got: %v", entries)
+	}
+}
+
+func TestEntryExpiresAfterFlush(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testEntryExpiresAfterFlush")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	now := time.Now()
+	database, err := NewDb(Options{
+		MemtableThreshold:      1000,
+		SstableMgr:             ssm,
+		Logger:                 logger,
+		CompactionMinThreshold: 2,
+		Clock:                  func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+
+	// An older value the expiring one shadows, and the expiring value itself
+	for _, entry := range []Entry{
+		{Key: "key1", Value: []byte("old")},
+		{Key: "key1", Value: []byte("new"), ExpiresAt: now.Add(time.Minute).UnixNano()},
+	} {
+		if err := database.Put(entry); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+		if err := database.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+
+	entry, err := database.Get("key1")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if string(entry.Value) != "new" {
+		t.Fatalf("expected new, got: %s", string(entry.Value))
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := database.Get("key1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	// Compaction drops the expired value along with the value it shadowed
+	if err := database.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	if len(database.Sstables) != 0 {
+		t.Fatalf("expected %d, got: %d", 0, len(database.Sstables))
+	}
+	if _, err := database.Get("key1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestFlushWritesExpiredEntriesAsTombstones(t *testing.T) {
+	now := time.Now()
+	entries := expireEntries([]Entry{
+		{Key: "expired", Value: []byte("value"), ExpiresAt: now.UnixNano()},
+		{Key: "live", Value: []byte("value"), ExpiresAt: now.Add(time.Second).UnixNano()},
+		{Key: "permanent", Value: []byte("value")},
+	}, now)
+
+	if !entries[0].Tombstone || entries[0].Value != nil {
+		t.Fatalf("expected a tombstone for the expired entry, got: %v", entries[0])
+	}
+	if entries[1].Tombstone || entries[2].Tombstone {
+		t.Fatalf("expected live entries to be kept, got: %v", entries[1:])
+	}
+}
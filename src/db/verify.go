@@ -0,0 +1,292 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BlockReport is the result of checking one data block of an SSTable.
+type BlockReport struct {
+	Offset   uint64
+	Entries  int
+	FirstKey string
+	LastKey  string
+	// Err is the problem found with the block, or nil if it is valid
+	Err error
+}
+
+// VerifyReport is the result of checking an SSTable with Verify. Header, Index
+// and Footer hold the first problem found with those sections. Blocks lists every
+// block reachable from the start of the file, in file order.
+type VerifyReport struct {
+	FileName      string
+	Version       int32
+	Header        error
+	Index         error
+	Footer        error
+	Blocks        []BlockReport
+	Entries       int
+	CorruptBlocks int
+}
+
+// OK reports whether no problem was found.
+func (r VerifyReport) OK() bool {
+	return r.Header == nil && r.Index == nil && r.Footer == nil && r.CorruptBlocks == 0
+}
+
+func (r VerifyReport) String() string {
+	if r.Header != nil {
+		return fmt.Sprintf("%s: %v", r.FileName, r.Header)
+	}
+	summary := fmt.Sprintf("%s: version %d, %d blocks, %d entries, %d corrupt blocks",
+		r.FileName, r.Version, len(r.Blocks), r.Entries, r.CorruptBlocks)
+	if r.Index != nil {
+		summary += fmt.Sprintf(", %v", r.Index)
+	}
+	if r.Footer != nil {
+		summary += fmt.Sprintf(", %v", r.Footer)
+	}
+	return summary
+}
+
+// Verify checks the whole of fileName: the header, the checksum and record
+// order of every block, that the index lists exactly the blocks of the file
+// with their key ranges, sorted and without overlaps, and that the footer
+// agrees with the header and the blocks. Problems are
+// recorded in the report; the error is only set when the file cannot be read
+// at all. Verify bypasses the caches so it always sees what is on disk.
+func (ssm SSTableFileSystemManager) Verify(fileName string) (VerifyReport, error) {
+	report := VerifyReport{FileName: fileName}
+	file, err := os.Open(filepath.Join(ssm.DataDir, fileName))
+	if err != nil {
+		ssm.logger().Errorf("Error opening SSTable file %s: %v", fileName, err)
+		return report, err
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file)
+	if err != nil {
+		report.Header = withFileName(err, fileName)
+		return report, nil
+	}
+	report.Version = header.Version
+	report.Blocks = ssm.walkBlocks(file, header)
+
+	var lastKey string
+	for i := range report.Blocks {
+		block := &report.Blocks[i]
+		if block.Err == nil && i > 0 && block.Entries > 0 && block.FirstKey <= lastKey {
+			block.Err = corruptionf("block", int64(block.Offset), "block starts with key %q, not after %q", block.FirstKey, lastKey)
+		}
+		if block.Err != nil {
+			report.CorruptBlocks++
+			continue
+		}
+		report.Entries += block.Entries
+		if block.Entries > 0 {
+			lastKey = block.LastKey
+		}
+	}
+
+	index, err := readIndex(file, header)
+	if err != nil {
+		report.Index = err
+	} else {
+		report.Index = checkIndex(index, report.Blocks, header.IndexOffset)
+	}
+	if header.Version >= footerVersion {
+		report.Footer = checkFooter(file, header, report)
+	}
+	report.Index = withFileName(report.Index, fileName)
+	report.Footer = withFileName(report.Footer, fileName)
+	for i := range report.Blocks {
+		report.Blocks[i].Err = withFileName(report.Blocks[i].Err, fileName)
+	}
+	return report, nil
+}
+
+// checkFooter returns an error describing the first way the footer of file
+// disagrees with its header or, when every block is valid, its blocks.
+func checkFooter(file *os.File, header FileHeader, report VerifyReport) error {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	footer, err := readFooter(file, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+	offset := fileInfo.Size() - footerTrailerSize
+	if footer.IndexOffset != header.IndexOffset || footer.EntryCount != uint64(header.EntryCount) {
+		return corruptionf("footer", offset, "footer records %d entries and index offset %d, header %d and %d",
+			footer.EntryCount, footer.IndexOffset, header.EntryCount, header.IndexOffset)
+	}
+	if report.CorruptBlocks > 0 {
+		return nil
+	}
+	var minKey, maxKey string
+	if len(report.Blocks) > 0 {
+		minKey, maxKey = report.Blocks[0].FirstKey, report.Blocks[len(report.Blocks)-1].LastKey
+	}
+	if footer.EntryCount != uint64(report.Entries) || footer.MinKey != minKey || footer.MaxKey != maxKey {
+		return corruptionf("footer", offset, "footer records %d entries in [%q, %q], blocks hold %d in [%q, %q]",
+			footer.EntryCount, footer.MinKey, footer.MaxKey, report.Entries, minKey, maxKey)
+	}
+	return nil
+}
+
+// walkBlocks reads every block from the end of the header to the index,
+// following the offset of the next block stored in each block header. The
+// walk stops at a block header that cannot be read or points nowhere valid.
+func (ssm SSTableFileSystemManager) walkBlocks(file *os.File, header FileHeader) []BlockReport {
+	blocks := []BlockReport{}
+	offset := uint64(fileHeaderSize(header.Version))
+	for offset < header.IndexOffset {
+		block := BlockReport{Offset: offset}
+		var blockHeader BlockHeader
+		if _, err := file.Seek(int64(offset), 0); err != nil {
+			block.Err = err
+			return append(blocks, block)
+		}
+		if err := binary.Read(file, binary.BigEndian, &blockHeader); err != nil {
+			block.Err = corruptionf("block", int64(offset), "failed to read block header: %w", err)
+			return append(blocks, block)
+		}
+
+		entries, err := ssm.readBlockAt(file, offset, header)
+		if err != nil {
+			block.Err = err
+		} else {
+			block.Entries = len(entries)
+			if len(entries) > 0 {
+				block.FirstKey, block.LastKey = entries[0].Key, entries[len(entries)-1].Key
+			}
+			for i := 1; i < len(entries); i++ {
+				if entries[i].Key <= entries[i-1].Key {
+					block.Err = corruptionf("block", int64(offset), "key %q out of order after %q", entries[i].Key, entries[i-1].Key)
+					break
+				}
+			}
+		}
+		blocks = append(blocks, block)
+
+		next := blockHeader.NextBlockOffset
+		if next <= offset || next > header.IndexOffset {
+			blocks[len(blocks)-1].Err = corruptionf("block", int64(offset), "next block offset %d out of range", next)
+			return blocks
+		}
+		offset = next
+	}
+	return blocks
+}
+
+// checkIndex returns an error describing the first way index disagrees with
+// the blocks found in the file, or nil if it lists each of them in order with
+// its key range.
+func checkIndex(index []IndexEntry, blocks []BlockReport, indexOffset uint64) error {
+	if len(index) != len(blocks) {
+		return corruptionf("index", int64(indexOffset), "index lists %d blocks, file holds %d", len(index), len(blocks))
+	}
+	for i, entry := range index {
+		block := blocks[i]
+		if entry.BlockOffset != block.Offset {
+			return corruptionf("index", int64(indexOffset), "index entry %d points at offset %d, block is at %d", i, entry.BlockOffset, block.Offset)
+		}
+		if entry.StartKey > entry.EndKey {
+			return corruptionf("index", int64(indexOffset), "index entry %d has start key %q after end key %q", i, entry.StartKey, entry.EndKey)
+		}
+		if i > 0 && entry.StartKey <= index[i-1].EndKey {
+			return corruptionf("index", int64(indexOffset), "index entry %d overlaps the previous one", i)
+		}
+		if block.Err == nil && block.Entries > 0 && (entry.StartKey != block.FirstKey || entry.EndKey != block.LastKey) {
+			return corruptionf("index", int64(indexOffset), "index entry %d has range [%q, %q], block holds [%q, %q]",
+				i, entry.StartKey, entry.EndKey, block.FirstKey, block.LastKey)
+		}
+	}
+	return nil
+}
+
+// RepairTruncate rewrites fileName with only the blocks before the first
+// corrupt one, rebuilding the index, Bloom filter and header, as after a write
+// that was cut short. The rewrite goes to a temporary file that replaces
+// fileName once complete. It returns the report of the file before the repair
+// and does nothing if the file verifies cleanly or its header is unreadable.
+func (ssm SSTableFileSystemManager) RepairTruncate(fileName string) (VerifyReport, error) {
+	if ssm.ReadOnly {
+		return VerifyReport{}, ErrReadOnly
+	}
+	report, err := ssm.Verify(fileName)
+	if err != nil {
+		return report, err
+	}
+	if report.Header != nil {
+		return report, fmt.Errorf("failed to repair %s: %w", fileName, report.Header)
+	}
+	if report.OK() {
+		return report, nil
+	}
+
+	file, err := os.Open(filepath.Join(ssm.DataDir, fileName))
+	if err != nil {
+		return report, err
+	}
+	header, err := readFileHeader(file)
+	if err != nil {
+		file.Close()
+		return report, fmt.Errorf("failed to read header: %w", err)
+	}
+	var entries []Entry
+	for _, block := range report.Blocks {
+		if block.Err != nil {
+			break
+		}
+		blockEntries, err := ssm.readBlockAt(file, block.Offset, header)
+		if err != nil {
+			file.Close()
+			return report, err
+		}
+		entries = append(entries, blockEntries...)
+	}
+	file.Close()
+
+	// Write replaces the file only once the rewrite is complete
+	if err := ssm.Write(fileName, entries); err != nil {
+		return report, fmt.Errorf("failed to write repaired %s: %w", fileName, err)
+	}
+	ssm.logger().Infof("Repaired SSTable file %s, keeping %d entries", fileName, len(entries))
+	return report, nil
+}
+
+// VerifyAll verifies every live SSTable, keeping each one on disk while it is
+// checked. It returns one report per table, L0 first, and stops at the first
+// table that cannot be read at all.
+func (db *LSM) VerifyAll() ([]VerifyReport, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrDBClosed
+	}
+	fileNames := append([]string{}, db.Sstables...)
+	for _, level := range db.levels {
+		fileNames = append(fileNames, level...)
+	}
+	db.pinSSTables(fileNames...)
+	db.mu.RUnlock()
+	defer db.unpinSSTables(fileNames...)
+
+	reports := make([]VerifyReport, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		report, err := db.sstableMgr.Verify(fileName)
+		if err != nil {
+			db.logger.Errorf("Error in verifying sstable %s: %v", fileName, err)
+			return reports, err
+		}
+		if !report.OK() {
+			db.logger.Warnf("Verification of sstable failed: %s", report)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
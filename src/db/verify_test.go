@@ -0,0 +1,237 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeVerifyTestFile writes 250 entries to fileName, which makes three
+// blocks with BlockEntries set to 100, and returns the clean report of the
+// file.
+func writeVerifyTestFile(t *testing.T, ssm SSTableManager, fileName string) VerifyReport {
+	data := make([]Entry, 250)
+	for i := range data {
+		data[i] = Entry{Key: fmt.Sprintf("data_%03d", i), Value: []byte(fmt.Sprintf("value_%03d", i))}
+	}
+	if err := ssm.Write(fileName, data); err != nil {
+		t.Fatalf("error writing file: %s", err)
+	}
+	report, err := ssm.Verify(fileName)
+	if err != nil {
+		t.Fatalf("error verifying file: %s", err)
+	}
+	if !report.OK() || len(report.Blocks) != 3 || report.Entries != len(data) {
+		t.Fatalf("expected a clean report of 3 blocks and %d entries, got: %s", len(data), report)
+	}
+	return report
+}
+
+// flipByteAt inverts the byte at offset in the file at path.
+func flipByteAt(t *testing.T, path string, offset int64) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("error opening file: %s", err)
+	}
+	defer file.Close()
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		t.Fatalf("error reading byte: %s", err)
+	}
+	b[0] ^= 0xFF
+	if _, err := file.WriteAt(b, offset); err != nil {
+		t.Fatalf("error corrupting file: %s", err)
+	}
+}
+
+func TestVerifyPinpointsCorruption(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testVerifyPinpointsCorruption")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, BlockEntries: 100})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	t.Run("block", func(t *testing.T) {
+		clean := writeVerifyTestFile(t, ssm, "block.sst")
+		// The last byte of the second block belongs to its last chunk
+		flipByteAt(t, filepath.Join(dataDir, "block.sst"), int64(clean.Blocks[2].Offset)-1)
+
+		report, err := ssm.Verify("block.sst")
+		if err != nil {
+			t.Fatalf("error verifying file: %s", err)
+		}
+		if report.OK() || report.CorruptBlocks != 1 || report.Index != nil {
+			t.Fatalf("expected exactly one corrupt block, got: %s", report)
+		}
+		for i, block := range report.Blocks {
+			if (i == 1) != (block.Err != nil) {
+				t.Fatalf("expected only block 1 to be corrupt, block %d has error: %v", i, block.Err)
+			}
+		}
+		if !errors.Is(report.Blocks[1].Err, ErrCorruptSSTable) {
+			t.Fatalf("expected %v, got: %v", ErrCorruptSSTable, report.Blocks[1].Err)
+		}
+	})
+
+	t.Run("index", func(t *testing.T) {
+		writeVerifyTestFile(t, ssm, "index.sst")
+		file, err := os.Open(filepath.Join(dataDir, "index.sst"))
+		if err != nil {
+			t.Fatalf("error opening file: %s", err)
+		}
+		header, err := readFileHeader(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("error reading header: %s", err)
+		}
+		flipByteAt(t, filepath.Join(dataDir, "index.sst"), int64(header.IndexOffset)+8)
+
+		report, err := ssm.Verify("index.sst")
+		if err != nil {
+			t.Fatalf("error verifying file: %s", err)
+		}
+		if !errors.Is(report.Index, ErrCorruptSSTable) || report.CorruptBlocks != 0 {
+			t.Fatalf("expected only the index to be corrupt, got: %s", report)
+		}
+	})
+
+	t.Run("header", func(t *testing.T) {
+		writeVerifyTestFile(t, ssm, "header.sst")
+		flipByteAt(t, filepath.Join(dataDir, "header.sst"), 8)
+
+		report, err := ssm.Verify("header.sst")
+		if err != nil {
+			t.Fatalf("error verifying file: %s", err)
+		}
+		if !errors.Is(report.Header, ErrCorruptSSTable) || len(report.Blocks) != 0 {
+			t.Fatalf("expected only the header to be reported, got: %s", report)
+		}
+	})
+}
+
+func TestRepairTruncate(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testRepairTruncate")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "SSTABLE_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManagerWithOptions(FileManagerOptions{DataDir: dataDir, Logger: logger, BlockEntries: 100})
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		damage   func(path string, clean VerifyReport)
+		expected int
+	}{
+		{"corrupt_block", func(path string, clean VerifyReport) {
+			flipByteAt(t, path, int64(clean.Blocks[2].Offset)-1)
+		}, 100},
+		// A write cut short in the last block loses the index too
+		{"partial_write", func(path string, clean VerifyReport) {
+			if err := os.Truncate(path, int64(clean.Blocks[2].Offset)+30); err != nil {
+				t.Fatalf("error truncating file: %s", err)
+			}
+		}, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := tt.name + ".sst"
+			clean := writeVerifyTestFile(t, ssm, fileName)
+			tt.damage(filepath.Join(dataDir, fileName), clean)
+
+			before, err := ssm.RepairTruncate(fileName)
+			if err != nil {
+				t.Fatalf("error repairing file: %s", err)
+			}
+			if before.OK() {
+				t.Fatalf("expected the damaged file to fail verification")
+			}
+
+			after, err := ssm.Verify(fileName)
+			if err != nil {
+				t.Fatalf("error verifying file: %s", err)
+			}
+			if !after.OK() || after.Entries != tt.expected {
+				t.Fatalf("expected a clean file of %d entries after repair, got: %s", tt.expected, after)
+			}
+			entries, err := ssm.ReadAll(fileName)
+			if err != nil || len(entries) != tt.expected {
+				t.Fatalf("expected to read %d entries, got: %d, %v", tt.expected, len(entries), err)
+			}
+			if _, err := ssm.FindKey(fileName, "data_000"); err != nil {
+				t.Fatalf("expected to find the first key, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	currentTestDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting current test directory: %s", err)
+	}
+	dataDir := filepath.Join(currentTestDir, ".testVerifyAll")
+	defer deleteDirectoryIfExists(dataDir)
+
+	logger := log.New(os.Stdout, "DB_TEST: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ssm, err := NewFileManager(dataDir, logger)
+	if err != nil {
+		t.Fatalf("error creating file manager: %s", err)
+	}
+	database, err := NewDb(Options{
+		MemtableThreshold: 10,
+		SstableMgr:        ssm,
+		Logger:            logger,
+	})
+	if err != nil {
+		t.Fatalf("error creating db: %v", err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 30; i++ {
+		if err := database.Put(Entry{Key: fmt.Sprintf("key%02d", i), Value: []byte("value")}); err != nil {
+			t.Fatalf("Failed to put entry: %v", err)
+		}
+	}
+	waitForFlushes(t, database)
+
+	reports, err := database.VerifyAll()
+	if err != nil {
+		t.Fatalf("error verifying database: %v", err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("expected 3 reports, got: %d", len(reports))
+	}
+	for _, report := range reports {
+		if !report.OK() {
+			t.Fatalf("expected a clean report, got: %s", report)
+		}
+	}
+
+	corrupted := reports[1]
+	flipByteAt(t, filepath.Join(dataDir, corrupted.FileName), int64(corrupted.Blocks[0].Offset)+BlockHeaderSize+4)
+	reports, err = database.VerifyAll()
+	if err != nil {
+		t.Fatalf("error verifying database: %v", err)
+	}
+	for _, report := range reports {
+		if report.OK() != (report.FileName != corrupted.FileName) {
+			t.Fatalf("expected only %s to fail verification, got: %s", corrupted.FileName, report)
+		}
+	}
+}
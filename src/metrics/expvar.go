@@ -0,0 +1,161 @@
+// Package metrics exports the measurements of the database and its HTTP API
+// through expvar variables, without any dependency outside the standard
+// library.
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+var DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Expvar records measurements in expvar variables. It implements db.Metrics
+// and api.RequestMetrics, and Handler serves every variable as one JSON
+// object. The variables are not published to the expvar package, so several
+// instances can coexist.
+type Expvar struct {
+	vars                   *expvar.Map
+	mu                     sync.Mutex
+	requests               map[string]*Histogram
+	requestVars            *expvar.Map
+	flushes                *Histogram
+	sstableReads           *Histogram
+	compactionBytesRead    *expvar.Int
+	compactionBytesWritten *expvar.Int
+}
+
+func NewExpvar() *Expvar {
+	m := &Expvar{
+		vars:                   new(expvar.Map).Init(),
+		requests:               make(map[string]*Histogram),
+		requestVars:            new(expvar.Map).Init(),
+		flushes:                NewHistogram(DefaultBuckets),
+		sstableReads:           NewHistogram(DefaultBuckets),
+		compactionBytesRead:    new(expvar.Int),
+		compactionBytesWritten: new(expvar.Int),
+	}
+	m.vars.Set("http_request_duration_seconds", m.requestVars)
+	m.vars.Set("memtable_flush_duration_seconds", m.flushes)
+	m.vars.Set("sstable_read_duration_seconds", m.sstableReads)
+	m.vars.Set("compaction_bytes_read", m.compactionBytesRead)
+	m.vars.Set("compaction_bytes_written", m.compactionBytesWritten)
+	return m
+}
+
+// ObserveRequest records the latency of a request served by handler.
+func (m *Expvar) ObserveRequest(handler string, d time.Duration) {
+	m.Request(handler).Observe(d)
+}
+
+func (m *Expvar) ObserveFlush(d time.Duration) {
+	m.flushes.Observe(d)
+}
+
+func (m *Expvar) ObserveSSTableRead(d time.Duration) {
+	m.sstableReads.Observe(d)
+}
+
+func (m *Expvar) AddCompactionBytes(read int64, written int64) {
+	m.compactionBytesRead.Add(read)
+	m.compactionBytesWritten.Add(written)
+}
+
+// Request returns the latency histogram of handler, creating it on first use.
+func (m *Expvar) Request(handler string) *Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.requests[handler]
+	if !ok {
+		h = NewHistogram(DefaultBuckets)
+		m.requests[handler] = h
+		m.requestVars.Set(handler, h)
+	}
+	return h
+}
+
+// Flushes returns the histogram of memtable flush durations.
+func (m *Expvar) Flushes() *Histogram {
+	return m.flushes
+}
+
+// SSTableReads returns the histogram of SSTable block read durations.
+func (m *Expvar) SSTableReads() *Histogram {
+	return m.sstableReads
+}
+
+// CompactionBytes returns the bytes read and written by compactions so far.
+func (m *Expvar) CompactionBytes() (read int64, written int64) {
+	return m.compactionBytesRead.Value(), m.compactionBytesWritten.Value()
+}
+
+// Handler serves the variables as JSON, in the format of the expvar handler.
+func (m *Expvar) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, m.vars.String())
+	})
+}
+
+// Histogram counts observations in buckets with fixed upper bounds, in
+// seconds, like a Prometheus histogram. It implements expvar.Var.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	// counts[i] counts observations of at most bounds[i] and above the bound
+	// before it; the last count is for observations above every bound
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram returns a histogram with the given bucket bounds, which are
+// sorted if they are not already.
+func NewHistogram(bounds []float64) *Histogram {
+	sorted := append([]float64{}, bounds...)
+	sort.Float64s(sorted)
+	return &Histogram{bounds: sorted, counts: make([]uint64, len(sorted)+1)}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(h.bounds, seconds)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += seconds
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// String renders the count, the sum in seconds and the cumulative count of
+// every bucket keyed by its upper bound.
+func (h *Histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make(map[string]uint64, len(h.counts))
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		buckets[fmt.Sprint(bound)] = cumulative
+	}
+	buckets["+Inf"] = h.count
+	out, _ := json.Marshal(struct {
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+		Buckets map[string]uint64 `json:"buckets"`
+	}{h.count, h.sum, buckets})
+	return string(out)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{0.01, 0.001, 0.1})
+	for _, d := range []time.Duration{500 * time.Microsecond, 5 * time.Millisecond, 50 * time.Millisecond, time.Second} {
+		h.Observe(d)
+	}
+	if h.Count() != 4 {
+		t.Fatalf("expected 4 observations, got %d", h.Count())
+	}
+
+	var rendered struct {
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+		Buckets map[string]uint64 `json:"buckets"`
+	}
+	if err := json.Unmarshal([]byte(h.String()), &rendered); err != nil {
+		t.Fatalf("failed to decode histogram %s: %v", h.String(), err)
+	}
+	want := map[string]uint64{"0.001": 1, "0.01": 2, "0.1": 3, "+Inf": 4}
+	for bound, count := range want {
+		if rendered.Buckets[bound] != count {
+			t.Errorf("expected %d observations up to %s, got %d", count, bound, rendered.Buckets[bound])
+		}
+	}
+	if rendered.Sum < 1.05 || rendered.Sum > 1.06 {
+		t.Errorf("expected a sum of about 1.0555 seconds, got %f", rendered.Sum)
+	}
+}
+
+func TestExpvarHandler(t *testing.T) {
+	m := NewExpvar()
+	m.ObserveRequest("get", time.Millisecond)
+	m.ObserveRequest("get", time.Millisecond)
+	m.ObserveFlush(time.Millisecond)
+	m.ObserveSSTableRead(time.Millisecond)
+	m.AddCompactionBytes(100, 40)
+	m.AddCompactionBytes(50, 20)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	m.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var vars struct {
+		Requests map[string]struct {
+			Count uint64 `json:"count"`
+		} `json:"http_request_duration_seconds"`
+		Flushes struct {
+			Count uint64 `json:"count"`
+		} `json:"memtable_flush_duration_seconds"`
+		Reads struct {
+			Count uint64 `json:"count"`
+		} `json:"sstable_read_duration_seconds"`
+		BytesRead    int64 `json:"compaction_bytes_read"`
+		BytesWritten int64 `json:"compaction_bytes_written"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("failed to decode metrics %s: %v", w.Body.String(), err)
+	}
+	if vars.Requests["get"].Count != 2 || vars.Flushes.Count != 1 || vars.Reads.Count != 1 {
+		t.Errorf("unexpected histogram counts in %s", w.Body.String())
+	}
+	if vars.BytesRead != 150 || vars.BytesWritten != 60 {
+		t.Errorf("expected 150 bytes read and 60 written, got %d and %d", vars.BytesRead, vars.BytesWritten)
+	}
+}